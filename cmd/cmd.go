@@ -1,7 +1,11 @@
 package cmd
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"strings"
 	"text/tabwriter"
@@ -9,6 +13,7 @@ import (
 	_ "embed"
 
 	"github.com/IacopoMelani/vortex/core/app"
+	"github.com/IacopoMelani/vortex/core/network"
 	"github.com/fatih/color"
 )
 
@@ -18,6 +23,18 @@ const (
 	VortexCLIVersion = "0.0.1"
 )
 
+// defines the exit codes of the CLI, see ExitCode
+const (
+	ExitCodeSuccess = 0
+	ExitCodeFailure = 1
+	// the command has been invoked without the args or flags it needs, only with the JSON output
+	ExitCodeInvalidUsage = 2
+	// the node has been stopped before the in-flight requests completed
+	ExitCodeForcedShutdown = 3
+	// the node has been stopped without flushing its state to disk
+	ExitCodeStateNotFlushed = 4
+)
+
 var (
 	appCLI AppCLI
 
@@ -31,12 +48,18 @@ var (
 type AppCLI struct {
 	app.AppStandard
 	availableCommands []Command
+	// output format selected by the global --output flag, see OutputText and OutputJSON
+	output string
+	// writer the results are rendered to
+	out io.Writer
 }
 
 func NewAppCLI() *AppCLI {
 	return &AppCLI{
 		availableCommands: make([]Command, 0),
 		AppStandard:       *app.NewApp("app-cli", VortexCLIVersion, app.VortexModeCLI),
+		output:            OutputText,
+		out:               os.Stdout,
 	}
 }
 
@@ -46,13 +69,25 @@ func (ac *AppCLI) resetCommands() {
 	ac.availableCommands = []Command{
 		*NewJoinTokenCmd(),
 		*NewDeployCmd(),
+		*NewJoinCmd(),
+		*NewIdentityCmd(),
+		*NewCaCmd(),
+		*NewPutCmd(),
+		*NewGetCmd(),
+		*NewConfigCmd(),
+		*NewStatusCmd(),
+		*NewPeersCmd(),
 	}
 }
 
 // Command - Defines a generic interface for a command
 type Command interface {
-	// CommandExec - Execs the command
-	CommandExec() error
+	// CommandExec - Execs the command, the result is rendered in the output format selected, see Result
+	CommandExec() (Result, error)
+	// GetCommandArgByName - Returns the Arg interface implemented by the Command
+	GetCommandArgByName(name string) (Arg, bool)
+	// GetCommandArgs - Returns the positional args of the Command
+	GetCommandArgs() []Arg
 	// GetCommandDescription - Returns a description of command
 	GetCommandDescription() string
 	// GetCommandName - Returns the command name
@@ -91,17 +126,44 @@ type Flag interface {
 	SetFlagValue(value string)
 }
 
+// Result - Defines the result of a command, rendered as text or encoded as the result of a JSON document.
+// The JSON encoding of a result is part of the CLI interface, its fields are not renamed or removed
+type Result interface {
+	// WriteText - Writes the result for humans
+	WriteText(out io.Writer) error
+}
+
+// Arg - Defines a generic interface for positional args command
+type Arg interface {
+	// GetArgDescription - Returns the arg description
+	GetArgDescription() string
+	// GetArgName - Returns the arg's name
+	GetArgName() string
+	// GetArgValue - Returns the arg value passed, empty string if not passed
+	GetArgValue() string
+	// SetArgValue - Set the value passed for the arg
+	SetArgValue(value string)
+}
+
 func init() {
 	appCLI = *NewAppCLI()
 	appCLI.resetCommands()
 }
 
-// Parse - Parse the args in the command line
+// Parse - Parse the args in the command line, execs the command and renders its result.
+// The global flag --output=json renders the result, or the error, as a JSON document, see ResultDocument
 func Parse() error {
 
+	osArgs, output, err := parseOutputFlag(os.Args)
+	if err != nil {
+		return err
+	}
+
+	appCLI.output = output
+
 	var selectedCommand Command = nil
 
-	for _, arg := range os.Args {
+	for _, arg := range osArgs {
 
 		if selectedCommand != nil {
 			break
@@ -117,33 +179,50 @@ func Parse() error {
 	}
 
 	if selectedCommand == nil {
+
+		if output == OutputJSON {
+			return appCLI.render("", nil, NewUsageError("No command provided!"))
+		}
+
 		ShowHelp(false)
 		return nil
 	}
 
-	if len(os.Args) > 2 {
+	if len(osArgs) > 2 {
+
+		args := selectedCommand.GetCommandArgs()
+		skipNext := false
+
+		for index, argFlag := range osArgs[2:] {
 
-		for index, argFlag := range os.Args[2:] {
+			if skipNext {
+				skipNext = false
+				continue
+			}
+
+			isFlag := false
 
 			for _, flag := range selectedCommand.GetCommandFlags() {
 
 				if flag.GetFlagShortVersion() != "" && argFlag == flag.GetFlagShortVersion() {
 
+					isFlag = true
 					flag.SetFlagPresent(true)
 
-					if flag.FlagNeedValue() && len(os.Args) > index+2+1 && flag.GetFlagValue() == "" {
-
-						flag.SetFlagValue(os.Args[index+2+1])
+					if flag.FlagNeedValue() && len(osArgs) > index+2+1 && flag.GetFlagValue() == "" {
 
+						flag.SetFlagValue(osArgs[index+2+1])
+						skipNext = true
 					}
 
 				} else if flag.GetFlagVerboseVersion() != "" && strings.Contains(argFlag, flag.GetFlagVerboseVersion()) {
 
+					isFlag = true
 					flag.SetFlagPresent(true)
 
 					if flag.FlagNeedValue() && flag.GetFlagValue() == "" {
 
-						splittedArgFlag := strings.Split(argFlag, "=")
+						splittedArgFlag := strings.SplitN(argFlag, "=", 2)
 
 						if len(splittedArgFlag) > 1 {
 							flag.SetFlagValue(splittedArgFlag[1])
@@ -151,10 +230,18 @@ func Parse() error {
 					}
 				}
 			}
+
+			// positional args are assigned in order, unknown flags are ignored
+			if !isFlag && !strings.HasPrefix(argFlag, "-") && len(args) > 0 {
+				args[0].SetArgValue(argFlag)
+				args = args[1:]
+			}
 		}
 	}
 
-	return selectedCommand.CommandExec()
+	result, err := selectedCommand.CommandExec()
+
+	return appCLI.render(selectedCommand.GetCommandName(), result, err)
 }
 
 // ShowBanner - Shows the banner
@@ -165,31 +252,54 @@ func ShowBanner() {
 
 // ShowCommandHelp -  Shows the help for current command
 func ShowCommandHelp(command Command, withUsage bool) {
+	WriteCommandHelp(os.Stdout, command, withUsage)
+}
+
+// WriteCommandHelp - Writes the help for current command to out
+func WriteCommandHelp(out io.Writer, command Command, withUsage bool) {
 
 	if withUsage {
 
-		fmt.Printf("%s command\n\n", command.GetCommandName())
+		fmt.Fprintf(out, "%s command\n\n", command.GetCommandName())
 
 	} else {
 
-		w := tabwriter.NewWriter(os.Stdout, 20, 8, 1, ' ', tabwriter.TabIndent)
+		w := tabwriter.NewWriter(out, 20, 8, 1, ' ', tabwriter.TabIndent)
 		fmt.Fprintf(w, "    %s\t%s\n", command.GetCommandName(), command.GetCommandDescription())
 		w.Flush()
 	}
 
 	if withUsage {
+		for _, arg := range command.GetCommandArgs() {
+			WriteArgHelp(out, arg)
+		}
 		for _, flag := range command.GetCommandFlags() {
-			ShowFlagHelp(flag, withUsage)
+			WriteFlagHelp(out, flag, withUsage)
 		}
 	}
 }
 
+// ShowArgHelp - Shows the help for current positional arg
+func ShowArgHelp(arg Arg) {
+	WriteArgHelp(os.Stdout, arg)
+}
+
+// WriteArgHelp - Writes the help for current positional arg to out
+func WriteArgHelp(out io.Writer, arg Arg) {
+	fmt.Fprintf(out, "\t<%s>\t%s\n\n", arg.GetArgName(), arg.GetArgDescription())
+}
+
 // ShowFlagHelp - Shows the help for current flag
 func ShowFlagHelp(flag Flag, withUsage bool) {
+	WriteFlagHelp(os.Stdout, flag, withUsage)
+}
+
+// WriteFlagHelp - Writes the help for current flag to out
+func WriteFlagHelp(out io.Writer, flag Flag, withUsage bool) {
 	if withUsage {
-		fmt.Printf("\t%s\t%s\n\tUsage:\t\t%s\n\n", flag.GetFlagShortVersion()+" "+flag.GetFlagVerboseVersion(), flag.GetFlagDescription(), flag.GetFlagUsage())
+		fmt.Fprintf(out, "\t%s\t%s\n\tUsage:\t\t%s\n\n", flag.GetFlagShortVersion()+" "+flag.GetFlagVerboseVersion(), flag.GetFlagDescription(), flag.GetFlagUsage())
 	} else {
-		fmt.Printf("%s\t%s\n", flag.GetFlagName(), flag.GetFlagDescription())
+		fmt.Fprintf(out, "%s\t%s\n", flag.GetFlagName(), flag.GetFlagDescription())
 	}
 }
 
@@ -207,7 +317,18 @@ func ShowHelp(withUsage bool) {
 // ShowError - show an error on CLI
 func ShowError(message string) {
 	c := color.New(color.FgRed)
-	c.Println(message)
+	c.Fprintln(messageOutput(), message)
+}
+
+// ShowInfo - show a progress message on CLI
+func ShowInfo(message string) {
+	fmt.Fprintln(messageOutput(), message)
+}
+
+// ShowWarning - show a warning on CLI
+func ShowWarning(message string) {
+	c := color.New(color.FgYellow)
+	c.Fprintln(messageOutput(), message)
 }
 
 // MARK: StandardCmd & Command implementation
@@ -216,10 +337,28 @@ func ShowError(message string) {
 type StandardCmd struct {
 	Name        string
 	Description string
+	Args        []Arg
 	Flags       []Flag
 	Usage       string
 }
 
+// GetCommandArgByName - Returns the Arg interface implemented by the Command
+func (s StandardCmd) GetCommandArgByName(name string) (Arg, bool) {
+	for _, arg := range s.Args {
+
+		if arg.GetArgName() == name {
+			return arg, true
+		}
+	}
+
+	return nil, false
+}
+
+// GetCommandArgs - Returns the positional args of the Command
+func (s StandardCmd) GetCommandArgs() []Arg {
+	return s.Args
+}
+
 // GetCommandDescription - Returns a description of command
 func (s StandardCmd) GetCommandDescription() string {
 	return s.Description
@@ -327,14 +466,50 @@ func (s *StandardCmdFlag) SetFlagValue(value string) {
 	s.Value = value
 }
 
+// MARK: StandardCmdArg & Arg implementation
+
+// StandardCmdArg - Defines the generic struct for Arg implementation
+type StandardCmdArg struct {
+	Name        string
+	Description string
+	Value       string
+}
+
+// GetArgDescription - Returns the arg description
+func (s StandardCmdArg) GetArgDescription() string {
+	return s.Description
+}
+
+// GetArgName - Returns the arg's name
+func (s StandardCmdArg) GetArgName() string {
+	return s.Name
+}
+
+// GetArgValue - Returns the arg value passed, empty string if not passed
+func (s StandardCmdArg) GetArgValue() string {
+	return s.Value
+}
+
+// SetArgValue - Set the value passed for the arg
+func (s *StandardCmdArg) SetArgValue(value string) {
+	s.Value = value
+}
+
 // MARK: Info commands consts
 
 const (
 	CommandBase = "vortex"
 
+	CommandCA               = "ca"
+	CommandConfig           = "config"
 	CommandDeployNode       = "deploy"
+	CommandGet              = "get"
+	CommandIdentity         = "identity"
 	CommndGenerateJoinToken = "join-token"
 	CommandJoinToNode       = "join"
+	CommandPeers            = "peers"
+	CommandPut              = "put"
+	CommandStatus           = "status"
 )
 
 // MARK: Info commands Exported
@@ -353,3 +528,356 @@ func GetCommandGenerateJoinToken() string {
 func GetCommandJoinToNode() string {
 	return fmt.Sprintf("%s %s", CommandBase, CommandJoinToNode)
 }
+
+// MARK: Output utils
+
+// defines the output formats selected by the global --output flag
+const (
+	OutputText = "text"
+	OutputJSON = "json"
+
+	// version of the JSON documents rendered with OutputJSON, raised on breaking changes
+	OutputJSONVersion = 1
+
+	outputFlagVerbose = "--output"
+)
+
+// ResultDocument - Defines the JSON document rendering the result of a command, or its error if OK is false
+type ResultDocument struct {
+	Version int            `json:"version"`
+	Command string         `json:"command"`
+	OK      bool           `json:"ok"`
+	Result  interface{}    `json:"result,omitempty"`
+	Error   *ErrorDocument `json:"error,omitempty"`
+}
+
+// ErrorDocument - Defines the error of a command in a ResultDocument, see ErrorCode and ExitCode
+type ErrorDocument struct {
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+	ExitCode int    `json:"exit_code"`
+}
+
+// NewResultDocument - Returns the document rendering the result, or the error, of the command
+func NewResultDocument(command string, result Result, err error) ResultDocument {
+
+	doc := ResultDocument{Version: OutputJSONVersion, Command: command, OK: err == nil}
+
+	if err != nil {
+		doc.Error = &ErrorDocument{Code: ErrorCode(err), Message: err.Error(), ExitCode: ExitCode(err)}
+		return doc
+	}
+
+	if result != nil {
+		doc.Result = result
+	}
+
+	return doc
+}
+
+// render - Renders the result of the command in the output format selected, see Parse.
+// Usage errors are shown with the help in text and reported with ExitCodeInvalidUsage in JSON
+func (ac *AppCLI) render(command string, result Result, err error) error {
+
+	var usageErr *UsageError
+	isUsageErr := errors.As(err, &usageErr)
+
+	if isUsageErr && ac.output == OutputJSON {
+		err = NewExitError(ExitCodeInvalidUsage, err)
+	}
+
+	if ac.output == OutputJSON {
+
+		if werr := writeJSON(ac.out, NewResultDocument(command, result, err)); werr != nil {
+			return werr
+		}
+
+		return err
+	}
+
+	if isUsageErr {
+		usageErr.show()
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	return result.WriteText(ac.out)
+}
+
+// messageOutput - Returns the writer of the messages shown on CLI, the results alone are written to stdout with OutputJSON
+func messageOutput() io.Writer {
+
+	if appCLI.output == OutputJSON {
+		return os.Stderr
+	}
+
+	return color.Output
+}
+
+// newTableWriter - Returns the tabwriter aligning the columns of the tables printed by the commands
+func newTableWriter(out io.Writer) *tabwriter.Writer {
+	return tabwriter.NewWriter(out, 0, 8, 2, ' ', tabwriter.TabIndent)
+}
+
+// parseOutputFlag - Returns the args without the global --output flag and the output format selected, OutputText by default
+func parseOutputFlag(args []string) ([]string, string, error) {
+
+	output := OutputText
+	rest := make([]string, 0, len(args))
+
+	for i := 0; i < len(args); i++ {
+
+		switch arg := args[i]; {
+		case strings.HasPrefix(arg, outputFlagVerbose+"="):
+			output = strings.TrimPrefix(arg, outputFlagVerbose+"=")
+		case arg == outputFlagVerbose && i+1 < len(args):
+			output = args[i+1]
+			i++
+		default:
+			rest = append(rest, arg)
+		}
+	}
+
+	if output != OutputText && output != OutputJSON {
+		return nil, "", NewInvalidOutputFormatError(output)
+	}
+
+	return rest, output, nil
+}
+
+// writeJSON - Writes the value as indented JSON, the usage placeholders like <file> are not escaped
+func writeJSON(out io.Writer, value interface{}) error {
+
+	encoder := json.NewEncoder(out)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(value)
+}
+
+// MARK: HelpResult
+
+// HelpResult - Defines the result of a command invoked with -h or --help
+type HelpResult struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Usage       string     `json:"usage"`
+	Args        []HelpArg  `json:"args"`
+	Flags       []HelpFlag `json:"flags"`
+	command     Command
+}
+
+// HelpArg - Defines a positional arg of the command in HelpResult
+type HelpArg struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// HelpFlag - Defines a flag of the command in HelpResult
+type HelpFlag struct {
+	Name        string `json:"name"`
+	Short       string `json:"short,omitempty"`
+	Verbose     string `json:"verbose,omitempty"`
+	Description string `json:"description"`
+	Usage       string `json:"usage"`
+	NeedValue   bool   `json:"need_value"`
+}
+
+// NewHelpResult - Returns the help of the command
+func NewHelpResult(command Command) *HelpResult {
+
+	help := &HelpResult{
+		Name:        command.GetCommandName(),
+		Description: command.GetCommandDescription(),
+		Usage:       command.GetCommandUsage(),
+		Args:        make([]HelpArg, 0),
+		Flags:       make([]HelpFlag, 0),
+		command:     command,
+	}
+
+	for _, arg := range command.GetCommandArgs() {
+		help.Args = append(help.Args, HelpArg{Name: arg.GetArgName(), Description: arg.GetArgDescription()})
+	}
+
+	for _, flag := range command.GetCommandFlags() {
+		help.Flags = append(help.Flags, HelpFlag{
+			Name:        flag.GetFlagName(),
+			Short:       flag.GetFlagShortVersion(),
+			Verbose:     flag.GetFlagVerboseVersion(),
+			Description: flag.GetFlagDescription(),
+			Usage:       flag.GetFlagUsage(),
+			NeedValue:   flag.FlagNeedValue(),
+		})
+	}
+
+	return help
+}
+
+// WriteText - Implements Result interface
+func (r *HelpResult) WriteText(out io.Writer) error {
+	WriteCommandHelp(out, r.command, true)
+	return nil
+}
+
+// MARK: ExitError
+
+// ExitError - Defines error terminating the CLI with a specific exit code
+type ExitError struct {
+	Code int
+	err  error
+}
+
+// NewExitError - Returns a new instance of ExitError
+func NewExitError(code int, err error) error {
+	return &ExitError{Code: code, err: err}
+}
+
+// Error - Implements error interface
+func (e *ExitError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap - Returns the error terminating the CLI
+func (e *ExitError) Unwrap() error {
+	return e.err
+}
+
+// ExitCode - Returns the exit code of the CLI for the error returned by Parse, ExitCodeFailure if no code is specified
+func ExitCode(err error) int {
+
+	if err == nil {
+		return ExitCodeSuccess
+	}
+
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.Code
+	}
+
+	return ExitCodeFailure
+}
+
+// MARK: Error codes
+
+// defines the codes of the errors reported by the JSON output, see ErrorCode
+const (
+	ErrorCodeFailure         = "failure"
+	ErrorCodeForcedShutdown  = "forced_shutdown"
+	ErrorCodeInvalidConfig   = "invalid_config"
+	ErrorCodeInvalidToken    = "invalid_token"
+	ErrorCodeInvalidUsage    = "invalid_usage"
+	ErrorCodeNodeNotRunning  = "node_not_running"
+	ErrorCodeNotFound        = "not_found"
+	ErrorCodeRemote          = "remote_error"
+	ErrorCodeStateNotFlushed = "state_not_flushed"
+)
+
+// ErrorCode - Returns the code identifying the kind of the error returned by a command, ErrorCodeFailure if not known
+func ErrorCode(err error) string {
+
+	switch ExitCode(err) {
+	case ExitCodeSuccess:
+		return ""
+	case ExitCodeForcedShutdown:
+		return ErrorCodeForcedShutdown
+	case ExitCodeStateNotFlushed:
+		return ErrorCodeStateNotFlushed
+	}
+
+	var (
+		usageErr         *UsageError
+		settingsErr      *app.InvalidAppNodeSettingsError
+		notRunningErr    *app.NodeNotRunningError
+		notDiscoveredErr *NodeNotDiscoveredError
+		controlErr       *app.ControlError
+		remoteErr        *network.RPCRemoteError
+		expiredErr       *network.ExpiredJoinTokenError
+		invalidErr       *network.InvalidJoinTokenError
+		malformedErr     *network.MalformedJoinTokenError
+	)
+
+	switch {
+	case errors.As(err, &usageErr):
+		return ErrorCodeInvalidUsage
+	case errors.As(err, &settingsErr):
+		return ErrorCodeInvalidConfig
+	case errors.As(err, &notRunningErr):
+		return ErrorCodeNodeNotRunning
+	case errors.As(err, &notDiscoveredErr), errors.Is(err, fs.ErrNotExist):
+		return ErrorCodeNotFound
+	case errors.As(err, &expiredErr), errors.As(err, &invalidErr), errors.As(err, &malformedErr):
+		return ErrorCodeInvalidToken
+	case errors.As(err, &controlErr), errors.As(err, &remoteErr):
+		return ErrorCodeRemote
+	}
+
+	return ErrorCodeFailure
+}
+
+// MARK: UsageError
+
+// UsageError - Defines error for a command invoked without the args or flags it needs.
+// The text output shows the message with the help of the flag or arg, if any, and does not fail
+type UsageError struct {
+	message string
+	flag    Flag
+	arg     Arg
+}
+
+// NewUsageError - Returns a new instance of UsageError
+func NewUsageError(message string) error {
+	return &UsageError{message: message}
+}
+
+// NewArgUsageError - Returns a new instance of UsageError showing the help of the arg
+func NewArgUsageError(message string, arg Arg) error {
+	return &UsageError{message: message, arg: arg}
+}
+
+// NewFlagUsageError - Returns a new instance of UsageError showing the help of the flag
+func NewFlagUsageError(message string, flag Flag) error {
+	return &UsageError{message: message, flag: flag}
+}
+
+// Error - Implements error interface
+func (e *UsageError) Error() string {
+	return e.message
+}
+
+// show - Shows the message and the help of the flag or arg
+func (e *UsageError) show() {
+
+	ShowError(e.message)
+
+	if e.flag != nil {
+		ShowFlagHelp(e.flag, true)
+	}
+
+	if e.arg != nil {
+		ShowArgHelp(e.arg)
+	}
+}
+
+// MARK: InvalidOutputFormatError
+
+// InvalidOutputFormatError - Defines error for an unknown output format passed to --output
+type InvalidOutputFormatError struct {
+	format string
+}
+
+// NewInvalidOutputFormatError - Returns a new instance of InvalidOutputFormatError
+func NewInvalidOutputFormatError(format string) error {
+	return &InvalidOutputFormatError{format: format}
+}
+
+// Error - Implements error interface
+func (e *InvalidOutputFormatError) Error() string {
+	return fmt.Sprintf("Invalid output format %q, expected %s or %s", e.format, OutputText, OutputJSON)
+}
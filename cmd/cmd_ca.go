@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/IacopoMelani/vortex/core/app"
+	"github.com/IacopoMelani/vortex/core/network"
+)
+
+const (
+	CaCmdArgAction = "action"
+
+	CaCmdActionRotate = "rotate"
+
+	CaCmdFlagHelp    = "Help"
+	CaCmdFlagDataDir = "DataDir"
+	CaCmdFlagHost    = "Host"
+
+	// address of the node deployed on current host
+	DefaultLocalNodeHost = "127.0.0.1" + network.DefaultRPCPort
+)
+
+// CaCmd - Defines the command to manage the cluster CA held by the node deployed on current host
+type CaCmd struct {
+	StandardCmd
+}
+
+// NewCaCmd - Returns a new instance of CaCmd
+func NewCaCmd() *CaCmd {
+	return &CaCmd{
+		StandardCmd: StandardCmd{
+			Name:        CommandCA,
+			Description: "Manage the cluster CA held by the node deployed on current host",
+			Usage:       "vortex ca rotate",
+			Args: []Arg{
+				&StandardCmdArg{
+					Name:        CaCmdArgAction,
+					Description: "The action to perform, rotate replaces the CA key and the members renew their certificates",
+				},
+			},
+			Flags: []Flag{
+				&StandardCmdFlag{
+					Name:           CaCmdFlagHelp,
+					Description:    "Show this message",
+					Usage:          "ca -h | ca --help",
+					ShortVersion:   "-h",
+					VerboseVersion: "--help",
+					Present:        false,
+					NeedValue:      false,
+				},
+				&StandardCmdFlag{
+					Name:           CaCmdFlagDataDir,
+					Description:    "Used for specify the node data directory",
+					Usage:          "ca rotate -d <dir> | ca rotate --data-dir=<dir>",
+					ShortVersion:   "-d",
+					VerboseVersion: "--data-dir",
+					Present:        false,
+					NeedValue:      true,
+				},
+				&StandardCmdFlag{
+					Name:           CaCmdFlagHost,
+					Description:    "Used for specify the RPC address of the node deployed on current host",
+					Usage:          "ca rotate -H <host:port> | ca rotate --host=<host:port>",
+					ShortVersion:   "-H",
+					VerboseVersion: "--host",
+					Present:        false,
+					NeedValue:      true,
+				},
+			},
+		},
+	}
+}
+
+// CommandExec - Execs the command
+func (c CaCmd) CommandExec() (Result, error) {
+
+	_, okHelp := c.IsCommandFlagUsed(CaCmdFlagHelp)
+
+	action, _ := c.GetCommandArgByName(CaCmdArgAction)
+
+	if okHelp || action.GetArgValue() == "" {
+		return NewHelpResult(c), nil
+	}
+
+	if action.GetArgValue() != CaCmdActionRotate {
+		return nil, NewArgUsageError(fmt.Sprintf("Unknown action %s!", action.GetArgValue()), action)
+	}
+
+	dataDir := app.DefaultDataDir()
+	if dataDirFlag, ok := c.IsCommandFlagUsed(CaCmdFlagDataDir); ok && dataDirFlag.GetFlagValue() != "" {
+		dataDir = dataDirFlag.GetFlagValue()
+	}
+
+	host := DefaultLocalNodeHost
+	if hostFlag, ok := c.IsCommandFlagUsed(CaCmdFlagHost); ok && hostFlag.GetFlagValue() != "" {
+		host = hostFlag.GetFlagValue()
+	}
+
+	// the node authorizes the rotation only to its own identity
+	node, err := network.NewWithConfig(network.NodeConfig{DataDir: dataDir})
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := node.DialRPC(network.RPCAddress(host), node.ID())
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	res, err := client.RotateCA()
+	if err != nil {
+		return nil, err
+	}
+
+	return &CARotateResult{Fingerprint: res.Fingerprint}, nil
+}
+
+// MARK: CARotateResult
+
+// CARotateResult - Defines the result of the ca rotate command
+type CARotateResult struct {
+	Fingerprint string `json:"fingerprint"`
+}
+
+// WriteText - Implements Result interface
+func (r *CARotateResult) WriteText(out io.Writer) error {
+	_, err := fmt.Fprintf(out, "\nCluster CA rotated, new CA fingerprint %s\n\n", r.Fingerprint)
+	return err
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"os"
+	"testing"
+)
+
+func TestCmdCaHelp(t *testing.T) {
+
+	oldArgs := os.Args
+	defer func() {
+		os.Args = oldArgs
+		appCLI.resetCommands()
+	}()
+
+	// vortex ca
+
+	os.Args = []string{CommandBase, CommandCA}
+
+	if err := Parse(); err != nil {
+		t.Fatal(err)
+	}
+
+	appCLI.resetCommands()
+
+	// vortex ca -h
+
+	os.Args = []string{CommandBase, CommandCA, "-h"}
+
+	if err := Parse(); err != nil {
+		t.Fatal(err)
+	}
+
+	appCLI.resetCommands()
+
+	// vortex ca unknown
+
+	os.Args = []string{CommandBase, CommandCA, "unknown"}
+
+	if err := Parse(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCmdCaRotateNoNode(t *testing.T) {
+
+	oldArgs := os.Args
+	defer func() {
+		os.Args = oldArgs
+		appCLI.resetCommands()
+	}()
+
+	// vortex ca rotate -d <dir> --host=<host>
+
+	os.Args = []string{CommandBase, CommandCA, CaCmdActionRotate, "-d", t.TempDir(), "--host=127.0.0.1:1"}
+
+	if err := Parse(); err == nil {
+		t.Fatal("Expected error rotating the CA without a node deployed")
+	}
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/IacopoMelani/vortex/core/app"
+)
+
+const (
+	ConfigCmdArgAction = "action"
+
+	ConfigCmdActionPrint = "print"
+
+	ConfigCmdFlagHelp = "Help"
+)
+
+// ConfigCmd - Defines the command to show the configuration of the node deployed on current host
+type ConfigCmd struct {
+	StandardCmd
+}
+
+// NewConfigCmd - Returns a new instance of ConfigCmd
+func NewConfigCmd() *ConfigCmd {
+	return &ConfigCmd{
+		StandardCmd: StandardCmd{
+			Name:        CommandConfig,
+			Description: "Show the configuration of the node deployed on current host",
+			Usage:       "vortex config print [--config=<file>]",
+			Args: []Arg{
+				&StandardCmdArg{
+					Name:        ConfigCmdArgAction,
+					Description: "The action to perform, print shows the effective configuration merging flags, environment, config file and defaults",
+				},
+			},
+			Flags: append([]Flag{
+				&StandardCmdFlag{
+					Name:           ConfigCmdFlagHelp,
+					Description:    "Show this message",
+					Usage:          "config -h | config --help",
+					ShortVersion:   "-h",
+					VerboseVersion: "--help",
+					Present:        false,
+					NeedValue:      false,
+				},
+			}, nodeSettingsFlags(CommandConfig+" print")...),
+		},
+	}
+}
+
+// CommandExec - Execs the command
+func (c ConfigCmd) CommandExec() (Result, error) {
+
+	_, okHelp := c.IsCommandFlagUsed(ConfigCmdFlagHelp)
+
+	action, _ := c.GetCommandArgByName(ConfigCmdArgAction)
+
+	if okHelp || action.GetArgValue() == "" {
+		return NewHelpResult(c), nil
+	}
+
+	if action.GetArgValue() != ConfigCmdActionPrint {
+		return nil, NewArgUsageError(fmt.Sprintf("Unknown action %s!", action.GetArgValue()), action)
+	}
+
+	settings, err := resolveNodeSettings(c)
+	if err != nil {
+		return nil, err
+	}
+
+	settings = app.DefaultAppNodeSettings().Merge(settings)
+
+	// the settings are printed only if the node can be deployed with them
+	if _, err := settings.Config(); err != nil {
+		return nil, err
+	}
+
+	return &ConfigResult{AppNodeSettings: settings}, nil
+}
+
+// MARK: ConfigResult
+
+// ConfigResult - Defines the result of the config print command, encoded as the effective settings
+type ConfigResult struct {
+	app.AppNodeSettings
+}
+
+// WriteText - Implements Result interface, the settings are written as JSON like the config file
+func (r *ConfigResult) WriteText(out io.Writer) error {
+	return writeJSON(out, r.AppNodeSettings)
+}
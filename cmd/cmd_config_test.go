@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCmdConfigHelp(t *testing.T) {
+
+	oldArgs := os.Args
+	defer func() {
+		os.Args = oldArgs
+		appCLI.resetCommands()
+	}()
+
+	for _, args := range [][]string{{}, {"-h"}, {"unknown"}} {
+
+		os.Args = append([]string{CommandBase, CommandConfig}, args...)
+
+		if err := Parse(); err != nil {
+			t.Fatal(err)
+		}
+
+		appCLI.resetCommands()
+	}
+}
+
+func TestCmdConfigPrint(t *testing.T) {
+
+	oldArgs := os.Args
+	defer func() {
+		os.Args = oldArgs
+		appCLI.resetCommands()
+	}()
+
+	configFile := filepath.Join(t.TempDir(), "vortex.json")
+	if err := os.WriteFile(configFile, []byte(`{"name": "node-1"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// vortex config print --config=<file> --listen=:7000
+
+	os.Args = []string{CommandBase, CommandConfig, ConfigCmdActionPrint, "--config=" + configFile, "--listen=:7000"}
+
+	if err := Parse(); err != nil {
+		t.Fatal(err)
+	}
+
+	appCLI.resetCommands()
+
+	// vortex config print --capacity=lots
+
+	os.Args = []string{CommandBase, CommandConfig, ConfigCmdActionPrint, "--capacity=lots"}
+
+	if err := Parse(); err == nil {
+		t.Fatal("Expected error printing an invalid configuration")
+	}
+}
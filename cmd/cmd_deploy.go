@@ -1,7 +1,46 @@
 package cmd
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
 	"github.com/IacopoMelani/vortex/core/app"
+	"github.com/IacopoMelani/vortex/core/network"
+)
+
+// defines the environment variables read by the deployed node, see resolveNodeSettings
+const (
+	EnvName            = "VORTEX_NAME"
+	EnvListen          = "VORTEX_LISTEN"
+	EnvAdvertiseAddr   = "VORTEX_ADVERTISE_ADDR"
+	EnvInterface       = "VORTEX_INTERFACE"
+	EnvCIDR            = "VORTEX_CIDR"
+	EnvIPFamily        = "VORTEX_IP_FAMILY"
+	EnvDataDir         = "VORTEX_DATA_DIR"
+	EnvCapacity        = "VORTEX_CAPACITY"
+	EnvShutdownTimeout = "VORTEX_SHUTDOWN_TIMEOUT"
+	EnvDiscovery       = "VORTEX_DISCOVERY"
+	EnvConfig          = "VORTEX_CONFIG"
+)
+
+// defines the flags shared by the commands deploying a node, see nodeSettingsFlags
+const (
+	NodeCmdFlagName          = "Name"
+	NodeCmdFlagListen        = "Listen"
+	NodeCmdFlagAdvertiseAddr = "AdvertiseAddr"
+	NodeCmdFlagInterface     = "Interface"
+	NodeCmdFlagCIDR          = "CIDR"
+	NodeCmdFlagIPFamily      = "IPFamily"
+	NodeCmdFlagDataDir       = "DataDir"
+	NodeCmdFlagCapacity      = "Capacity"
+	NodeCmdFlagAnnounce      = "Announce"
+	NodeCmdFlagConfig        = "Config"
+
+	DeployCmdFlagHelp = "Help"
 )
 
 //DeployCmd - Defines command to deploy current host as Vortex node
@@ -15,18 +54,287 @@ func NewDeployCmd() *DeployCmd {
 		StandardCmd{
 			Name:        CommandDeployNode,
 			Description: "Deploy current host as node of Vortex network",
-			Usage:       "vortex deploy",
-			Flags:       []Flag{},
+			Usage:       "vortex deploy [--config=<file>] [--name=<name>] [--listen=<host:port>] [--advertise-addr=<host:port>]",
+			Flags: append([]Flag{
+				&StandardCmdFlag{
+					Name:           DeployCmdFlagHelp,
+					Description:    "Show this message",
+					Usage:          "deploy -h | deploy --help",
+					ShortVersion:   "-h",
+					VerboseVersion: "--help",
+					Present:        false,
+					NeedValue:      false,
+				},
+			}, nodeSettingsFlags(CommandDeployNode)...),
 		},
 	}
 }
 
 // CommandExec - Execs the command
-func (j DeployCmd) CommandExec() error {
+func (j DeployCmd) CommandExec() (Result, error) {
+
+	if _, okHelp := j.IsCommandFlagUsed(DeployCmdFlagHelp); okHelp {
+		return NewHelpResult(j), nil
+	}
+
+	config, err := deployConfig(j)
+	if err != nil {
+		return nil, err
+	}
+
+	appNode, err := app.NewAppNodeWithConfig("node", config)
+	if err != nil {
+		return nil, err
+	}
+
+	if appNode.Node().NeedsJoin() {
+		ShowWarning(fmt.Sprintf("The cluster credentials of the node are expired, join the cluster again with %s --token=<token>", GetCommandJoinToNode()))
+	}
+
+	return nil, runAppNode(appNode, j)
+}
+
+// MARK: deploy utils unexported
+
+// deployConfig - Returns the config of the node deployed by the command, see resolveNodeSettings
+func deployConfig(command Command) (app.AppNodeConfig, error) {
+
+	settings, err := resolveNodeSettings(command)
+	if err != nil {
+		return app.AppNodeConfig{}, err
+	}
+
+	return settings.Config()
+}
+
+// dialNode - Returns a client of the control socket of the node running with the data directory of the command
+func dialNode(command Command) (*app.ControlClient, error) {
+
+	settings, err := resolveNodeSettings(command)
+	if err != nil {
+		return nil, err
+	}
+
+	return app.DialControl(app.DefaultAppNodeSettings().Merge(settings).DataDir)
+}
+
+// nodeControlFlags - Returns the flags locating the running node the command talks to, see dialNode
+func nodeControlFlags(command string) []Flag {
+
+	flags := make([]Flag, 0, 2)
+	for _, flag := range nodeSettingsFlags(command) {
+		if name := flag.GetFlagName(); name == NodeCmdFlagDataDir || name == NodeCmdFlagConfig {
+			flags = append(flags, flag)
+		}
+	}
+
+	return flags
+}
+
+// nodeSettingsFlags - Returns the flags setting the node deployed by the command
+func nodeSettingsFlags(command string) []Flag {
+	return []Flag{
+		&StandardCmdFlag{
+			Name:           NodeCmdFlagName,
+			Description:    "Used for specify the node name, the hostname by default",
+			Usage:          command + " -n <name> | " + command + " --name=<name>",
+			ShortVersion:   "-n",
+			VerboseVersion: "--name",
+			Present:        false,
+			NeedValue:      true,
+		},
+		&StandardCmdFlag{
+			Name:           NodeCmdFlagListen,
+			Description:    "Used for specify the address the node listens on, " + network.DefaultRPCPort + " by default",
+			Usage:          command + " -l <host:port> | " + command + " --listen=<host:port>",
+			ShortVersion:   "-l",
+			VerboseVersion: "--listen",
+			Present:        false,
+			NeedValue:      true,
+		},
+		&StandardCmdFlag{
+			Name:           NodeCmdFlagAdvertiseAddr,
+			Description:    "Used for specify the address advertised to the other nodes, detected by default",
+			Usage:          command + " -a <host:port> | " + command + " --advertise-addr=<host:port>",
+			ShortVersion:   "-a",
+			VerboseVersion: "--advertise-addr",
+			Present:        false,
+			NeedValue:      true,
+		},
+		&StandardCmdFlag{
+			Name:           NodeCmdFlagInterface,
+			Description:    "Used for specify the interface the advertised address is detected on",
+			Usage:          command + " --interface=<name>",
+			VerboseVersion: "--interface",
+			Present:        false,
+			NeedValue:      true,
+		},
+		&StandardCmdFlag{
+			Name:           NodeCmdFlagCIDR,
+			Description:    "Used for specify the network the advertised address is detected in, e.g. 10.0.0.0/8",
+			Usage:          command + " --cidr=<cidr>",
+			VerboseVersion: "--cidr",
+			Present:        false,
+			NeedValue:      true,
+		},
+		&StandardCmdFlag{
+			Name:           NodeCmdFlagIPFamily,
+			Description:    "Used for specify the IP family preferred on dual-stack hosts, ipv4 or ipv6",
+			Usage:          command + " --ip-family=<ipv4|ipv6>",
+			VerboseVersion: "--ip-family",
+			Present:        false,
+			NeedValue:      true,
+		},
+		&StandardCmdFlag{
+			Name:           NodeCmdFlagDataDir,
+			Description:    "Used for specify the node data directory",
+			Usage:          command + " -d <dir> | " + command + " --data-dir=<dir>",
+			ShortVersion:   "-d",
+			VerboseVersion: "--data-dir",
+			Present:        false,
+			NeedValue:      true,
+		},
+		&StandardCmdFlag{
+			Name:           NodeCmdFlagCapacity,
+			Description:    "Used for specify the capacity of the chunk store, e.g. 10GiB",
+			Usage:          command + " --capacity=<size>",
+			VerboseVersion: "--capacity",
+			Present:        false,
+			NeedValue:      true,
+		},
+		&StandardCmdFlag{
+			Name:           NodeCmdFlagAnnounce,
+			Description:    "Used for announce the node on the local network, see join --discover",
+			Usage:          command + " --announce",
+			VerboseVersion: "--announce",
+			Present:        false,
+			NeedValue:      false,
+		},
+		&StandardCmdFlag{
+			Name:           NodeCmdFlagConfig,
+			Description:    "Used for specify the JSON config file of the node, environment variables and flags override it",
+			Usage:          command + " -c <file> | " + command + " --config=<file>",
+			ShortVersion:   "-c",
+			VerboseVersion: "--config",
+			Present:        false,
+			NeedValue:      true,
+		},
+	}
+}
+
+// resolveNodeSettings - Returns the node settings of the command layered by precedence:
+// flags, environment variables, config file. Settings left empty get the node defaults
+func resolveNodeSettings(command Command) (app.AppNodeSettings, error) {
+
+	flagValue := func(name string) string {
+		if flag, ok := command.IsCommandFlagUsed(name); ok {
+			return flag.GetFlagValue()
+		}
+		return ""
+	}
+
+	flags := app.AppNodeSettings{
+		Name:          flagValue(NodeCmdFlagName),
+		Listen:        flagValue(NodeCmdFlagListen),
+		AdvertiseAddr: flagValue(NodeCmdFlagAdvertiseAddr),
+		Interface:     flagValue(NodeCmdFlagInterface),
+		CIDR:          flagValue(NodeCmdFlagCIDR),
+		IPFamily:      flagValue(NodeCmdFlagIPFamily),
+		DataDir:       flagValue(NodeCmdFlagDataDir),
+		Capacity:      flagValue(NodeCmdFlagCapacity),
+	}
+
+	if _, ok := command.IsCommandFlagUsed(NodeCmdFlagAnnounce); ok {
+		flags.Discovery = "true"
+	}
+
+	env := app.AppNodeSettings{
+		Name:            os.Getenv(EnvName),
+		Listen:          os.Getenv(EnvListen),
+		AdvertiseAddr:   os.Getenv(EnvAdvertiseAddr),
+		Interface:       os.Getenv(EnvInterface),
+		CIDR:            os.Getenv(EnvCIDR),
+		IPFamily:        os.Getenv(EnvIPFamily),
+		DataDir:         os.Getenv(EnvDataDir),
+		Capacity:        os.Getenv(EnvCapacity),
+		ShutdownTimeout: os.Getenv(EnvShutdownTimeout),
+		Discovery:       os.Getenv(EnvDiscovery),
+	}
+
+	configFile := flagValue(NodeCmdFlagConfig)
+	if configFile == "" {
+		configFile = os.Getenv(EnvConfig)
+	}
+
+	var file app.AppNodeSettings
+	if configFile != "" {
 
-	println("deploy")
+		var err error
+		if file, err = app.LoadAppNodeSettings(configFile); err != nil {
+			return file, err
+		}
+	}
+
+	return file.Merge(env).Merge(flags), nil
+}
+
+// runAppNode - Runs the node until SIGINT or SIGTERM is received, SIGHUP reloads the settings of the command.
+// The returned error carries the exit code of the shutdown, see ExitCode
+func runAppNode(appNode *app.AppNode, command Command) error {
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				if err := reloadAppNode(appNode, command); err != nil {
+					ShowError(err.Error())
+					continue
+				}
+				ShowWarning("Config reloaded")
+			}
+		}
+	}()
+
+	return shutdownExitError(appNode.Start(ctx))
+}
+
+// reloadAppNode - Resolves the settings of the command again and applies them to the running node
+func reloadAppNode(appNode *app.AppNode, command Command) error {
 
-	appNode := app.NewAppNode("node")
+	config, err := deployConfig(command)
+	if err != nil {
+		return err
+	}
+
+	return appNode.Reload(config)
+}
+
+// shutdownExitError - Maps the error of a node shutdown to its exit code
+func shutdownExitError(err error) error {
+
+	if err == nil {
+		return nil
+	}
+
+	var shutdownErr *app.ShutdownError
+	if errors.As(err, &shutdownErr) {
+
+		switch shutdownErr.Stage {
+		case app.ShutdownStageRPC:
+			return NewExitError(ExitCodeForcedShutdown, err)
+		case app.ShutdownStageFlush:
+			return NewExitError(ExitCodeStateNotFlushed, err)
+		}
+	}
 
-	return appNode.Start()
+	return NewExitError(ExitCodeFailure, err)
 }
@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/IacopoMelani/vortex/core/app"
+)
+
+func TestCmdDeployConfig(t *testing.T) {
+
+	for _, env := range []string{EnvName, EnvListen, EnvCapacity, EnvConfig} {
+		defer os.Unsetenv(env)
+	}
+
+	configFile := filepath.Join(t.TempDir(), "vortex.json")
+
+	settings := `{"name": "from-file", "listen": "127.0.0.1:7000", "capacity": "1GiB", "shutdown_timeout": "5s"}`
+	if err := os.WriteFile(configFile, []byte(settings), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv(EnvConfig, configFile)
+	os.Setenv(EnvName, "from-env")
+	os.Setenv(EnvCapacity, "2GiB")
+
+	command := NewDeployCmd()
+	setTestFlag(t, command, NodeCmdFlagCapacity, "3GiB")
+
+	// flags > env > file
+	config, err := deployConfig(command)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Node.Name != "from-env" || config.Capacity != 3<<30 || config.ShutdownTimeout != 5*time.Second {
+		t.Fatalf("Unexpected config %+v", config)
+	}
+
+	if config.Node.ListenAddr != "127.0.0.1:7000" || config.Node.IP != "127.0.0.1" || config.Node.RPCPort != ":7000" {
+		t.Fatalf("Expected the listen address advertised, got %+v", config.Node)
+	}
+
+	setTestFlag(t, command, NodeCmdFlagAdvertiseAddr, "node.example.com")
+
+	if config, err = deployConfig(command); err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Node.IP != "node.example.com" || config.Node.RPCPort != ":7000" {
+		t.Fatalf("Expected the advertised host with the listen port, got %+v", config.Node)
+	}
+
+	setTestFlag(t, command, NodeCmdFlagAdvertiseAddr, "[2001:db8::1]")
+
+	if config, err = deployConfig(command); err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Node.IP != "2001:db8::1" {
+		t.Fatalf("Expected the advertised IPv6 address without brackets, got %s", config.Node.IP)
+	}
+
+	setTestFlag(t, command, NodeCmdFlagIPFamily, "ipv5")
+
+	if _, err := deployConfig(command); err == nil {
+		t.Fatal("Expected error for an unknown IP family")
+	}
+
+	setTestFlag(t, command, NodeCmdFlagIPFamily, "ipv6")
+	os.Setenv(EnvListen, "7000")
+
+	if _, err := deployConfig(command); err == nil {
+		t.Fatal("Expected error for a listen address without port")
+	}
+
+	os.Unsetenv(EnvListen)
+
+	if err := os.WriteFile(configFile, []byte(`{"capacty": "1GiB"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := deployConfig(command); err == nil {
+		t.Fatal("Expected error for an unknown setting in the config file")
+	}
+}
+
+func TestCmdDeployAnnounce(t *testing.T) {
+
+	defer os.Unsetenv(EnvDiscovery)
+
+	command := NewDeployCmd()
+
+	config, err := deployConfig(command)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Discovery {
+		t.Fatal("Expected discovery disabled by default")
+	}
+
+	os.Setenv(EnvDiscovery, "maybe")
+
+	if _, err := deployConfig(command); err == nil {
+		t.Fatal("Expected error for an invalid discovery setting")
+	}
+
+	// the flag overrides the environment
+	os.Setenv(EnvDiscovery, "false")
+	setTestFlag(t, command, NodeCmdFlagAnnounce, "")
+
+	if config, err = deployConfig(command); err != nil {
+		t.Fatal(err)
+	}
+
+	if !config.Discovery {
+		t.Fatal("Expected discovery enabled by --announce")
+	}
+}
+
+// setTestFlag - Sets the flag of the command as passed on the command line
+func setTestFlag(t *testing.T, command Command, name, value string) {
+
+	flag, ok := command.GetCommandFlagByName(name)
+	if !ok {
+		t.Fatalf("Unknown flag %s", name)
+	}
+
+	flag.SetFlagPresent(true)
+	flag.SetFlagValue(value)
+}
+
+func TestCmdExitCode(t *testing.T) {
+
+	cases := []struct {
+		err  error
+		code int
+	}{
+		{nil, ExitCodeSuccess},
+		{errors.New("failed"), ExitCodeFailure},
+		{shutdownExitError(errors.New("failed")), ExitCodeFailure},
+		{shutdownExitError(app.NewShutdownError(app.ShutdownStageRPC, errors.New("deadline"))), ExitCodeForcedShutdown},
+		{shutdownExitError(app.NewShutdownError(app.ShutdownStageFlush, errors.New("disk full"))), ExitCodeStateNotFlushed},
+	}
+
+	for _, c := range cases {
+		if code := ExitCode(c.err); code != c.code {
+			t.Fatalf("Expected exit code %d for %v, got %d", c.code, c.err, code)
+		}
+	}
+
+	if shutdownExitError(nil) != nil {
+		t.Fatal("Expected no error for a clean shutdown")
+	}
+}
@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/IacopoMelani/vortex/core/app"
+	"github.com/IacopoMelani/vortex/core/storage"
+)
+
+const (
+	GetCmdArgFileID = "file-id"
+
+	GetCmdFlagHelp           = "Help"
+	GetCmdFlagHost           = "Host"
+	GetCmdFlagKeyfile        = "Keyfile"
+	GetCmdFlagOut            = "Out"
+	GetCmdFlagPassphraseFile = "PassphraseFile"
+)
+
+// GetCmd - Defines the command to retrieve a file stored on the Vortex network
+type GetCmd struct {
+	StandardCmd
+}
+
+// NewGetCmd - Returns a new instance of GetCmd
+func NewGetCmd() *GetCmd {
+	return &GetCmd{
+		StandardCmd: StandardCmd{
+			Name:        CommandGet,
+			Description: "Retrieve a file stored on the vortex network, the file is verified before being written",
+			Usage:       "vortex get <file-id> [-o <path>] [-k <keyfile> | --passphrase-file=<file>]",
+			Args: []Arg{
+				&StandardCmdArg{
+					Name:        GetCmdArgFileID,
+					Description: "The file ID printed by put",
+				},
+			},
+			Flags: []Flag{
+				&StandardCmdFlag{
+					Name:           GetCmdFlagHelp,
+					Description:    "Show this message",
+					Usage:          "get -h | get --help",
+					ShortVersion:   "-h",
+					VerboseVersion: "--help",
+					Present:        false,
+					NeedValue:      false,
+				},
+				&StandardCmdFlag{
+					Name:           GetCmdFlagHost,
+					Description:    "Used for specify the RPC address of the node used to discover the network, the local node by default",
+					Usage:          "get <file-id> -H <host:port> | get <file-id> --host=<host:port>",
+					ShortVersion:   "-H",
+					VerboseVersion: "--host",
+					Present:        false,
+					NeedValue:      true,
+				},
+				&StandardCmdFlag{
+					Name:           GetCmdFlagOut,
+					Description:    "Used for specify the path of the retrieved file, the stored file name in the working directory by default",
+					Usage:          "get <file-id> -o <path> | get <file-id> --out=<path>",
+					ShortVersion:   "-o",
+					VerboseVersion: "--out",
+					Present:        false,
+					NeedValue:      true,
+				},
+				&StandardCmdFlag{
+					Name:           GetCmdFlagKeyfile,
+					Description:    "Used for specify the keyfile the file has been encrypted with",
+					Usage:          "get <file-id> -k <keyfile> | get <file-id> --keyfile=<keyfile>",
+					ShortVersion:   "-k",
+					VerboseVersion: "--keyfile",
+					Present:        false,
+					NeedValue:      true,
+				},
+				&StandardCmdFlag{
+					Name:           GetCmdFlagPassphraseFile,
+					Description:    fmt.Sprintf("Used for specify the file holding the passphrase the file has been encrypted with, %s is used if no key is provided", EnvPassphrase),
+					Usage:          "get <file-id> --passphrase-file=<file>",
+					VerboseVersion: "--passphrase-file",
+					Present:        false,
+					NeedValue:      true,
+				},
+			},
+		},
+	}
+}
+
+// CommandExec - Execs the command
+func (g GetCmd) CommandExec() (Result, error) {
+
+	_, okHelp := g.IsCommandFlagUsed(GetCmdFlagHelp)
+
+	if okHelp {
+		return NewHelpResult(g), nil
+	}
+
+	idArg, _ := g.GetCommandArgByName(GetCmdArgFileID)
+	if idArg.GetArgValue() == "" {
+		return nil, NewArgUsageError("No file ID provided!", idArg)
+	}
+
+	id, err := storage.ParseHash(idArg.GetArgValue())
+	if err != nil {
+		return nil, err
+	}
+
+	host := DefaultLocalNodeHost
+	if hostFlag, ok := g.IsCommandFlagUsed(GetCmdFlagHost); ok && hostFlag.GetFlagValue() != "" {
+		host = hostFlag.GetFlagValue()
+	}
+
+	consumer, err := app.NewAppConsumer("consumer")
+	if err != nil {
+		return nil, err
+	}
+	defer consumer.Close()
+
+	if err := consumer.Connect(host); err != nil {
+		return nil, err
+	}
+
+	manifest, err := consumer.GetManifest(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileKey []byte
+	if manifest.Encryption != nil {
+
+		key, err := userKeyFromFlags(g, GetCmdFlagKeyfile, GetCmdFlagPassphraseFile)
+		if err != nil {
+			return nil, err
+		}
+
+		if key == nil {
+			return nil, NewUsageError(fmt.Sprintf("The file is encrypted, no key provided! Use --keyfile, --passphrase-file or %s", EnvPassphrase))
+		}
+
+		// a wrong key or tampered manifest fails here, nothing is written
+		if manifest, fileKey, err = storage.OpenManifest(manifest, key); err != nil {
+			return nil, err
+		}
+	}
+
+	out := filepath.Base(manifest.Name)
+	if outFlag, ok := g.IsCommandFlagUsed(GetCmdFlagOut); ok && outFlag.GetFlagValue() != "" {
+		out = outFlag.GetFlagValue()
+	}
+
+	if out == "" || out == "." || out == string(filepath.Separator) {
+		out = id.String()
+	}
+
+	// the file is written to a temp file and renamed once verified
+	tmp, err := os.CreateTemp(filepath.Dir(out), filepath.Base(out)+".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := consumer.Get(manifest, fileKey, tmp); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+
+	if err := os.Rename(tmp.Name(), out); err != nil {
+		return nil, err
+	}
+
+	return &GetResult{FileID: id.String(), Path: out, Size: manifest.Size}, nil
+}
+
+// MARK: GetResult
+
+// GetResult - Defines the result of the get command, Path is where the verified file has been written
+type GetResult struct {
+	FileID string `json:"file_id"`
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+}
+
+// WriteText - Implements Result interface
+func (r *GetResult) WriteText(out io.Writer) error {
+	_, err := fmt.Fprintf(out, "\nRetrieved %s, %d bytes verified\n\n", r.Path, r.Size)
+	return err
+}
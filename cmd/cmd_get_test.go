@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCmdGetHelp(t *testing.T) {
+
+	oldArgs := os.Args
+	defer func() {
+		os.Args = oldArgs
+		appCLI.resetCommands()
+	}()
+
+	// vortex get -h
+
+	os.Args = []string{CommandBase, CommandGet, "-h"}
+
+	if err := Parse(); err != nil {
+		t.Fatal(err)
+	}
+
+	appCLI.resetCommands()
+
+	// vortex get
+
+	os.Args = []string{CommandBase, CommandGet}
+
+	if err := Parse(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCmdGetErrors(t *testing.T) {
+
+	oldArgs := os.Args
+	defer func() {
+		os.Args = oldArgs
+		appCLI.resetCommands()
+	}()
+
+	// vortex get <invalid id>
+
+	os.Args = []string{CommandBase, CommandGet, "invalid"}
+
+	if err := Parse(); err == nil {
+		t.Fatal("Expected error with an invalid file ID")
+	}
+
+	appCLI.resetCommands()
+
+	// vortex get <id> -H <host> -o <path>
+
+	os.Args = []string{CommandBase, CommandGet, strings.Repeat("ab", 32), "-H", "127.0.0.1:1", "-o", t.TempDir()}
+
+	if err := Parse(); err == nil {
+		t.Fatal("Expected error retrieving a file without a node")
+	}
+}
@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/IacopoMelani/vortex/core/app"
+	"github.com/IacopoMelani/vortex/core/network"
+)
+
+const (
+	IdentityCmdFlagHelp    = "Help"
+	IdentityCmdFlagDataDir = "DataDir"
+	IdentityCmdFlagExport  = "Export"
+)
+
+// IdentityCmd - Defines the command to show and export the public identity of the node
+type IdentityCmd struct {
+	StandardCmd
+}
+
+// NewIdentityCmd - Returns a new instance of IdentityCmd
+func NewIdentityCmd() *IdentityCmd {
+	return &IdentityCmd{
+		StandardCmd: StandardCmd{
+			Name:        CommandIdentity,
+			Description: "Show or export the public identity of the node deployed on current host",
+			Usage:       "vortex identity",
+			Flags: []Flag{
+				&StandardCmdFlag{
+					Name:           IdentityCmdFlagHelp,
+					Description:    "Show this message",
+					Usage:          "identity -h | identity --help",
+					ShortVersion:   "-h",
+					VerboseVersion: "--help",
+					Present:        false,
+					NeedValue:      false,
+				},
+				&StandardCmdFlag{
+					Name:           IdentityCmdFlagDataDir,
+					Description:    "Used for specify the node data directory",
+					Usage:          "identity -d <dir> | identity --data-dir=<dir>",
+					ShortVersion:   "-d",
+					VerboseVersion: "--data-dir",
+					Present:        false,
+					NeedValue:      true,
+				},
+				&StandardCmdFlag{
+					Name:           IdentityCmdFlagExport,
+					Description:    "Used for export the PEM encoded public key to a file",
+					Usage:          "identity -e <file> | identity --export=<file>",
+					ShortVersion:   "-e",
+					VerboseVersion: "--export",
+					Present:        false,
+					NeedValue:      true,
+				},
+			},
+		},
+	}
+}
+
+// CommandExec - Execs the command
+func (i IdentityCmd) CommandExec() (Result, error) {
+
+	_, okHelp := i.IsCommandFlagUsed(IdentityCmdFlagHelp)
+
+	if okHelp {
+		return NewHelpResult(i), nil
+	}
+
+	dataDir := app.DefaultDataDir()
+	if dataDirFlag, ok := i.IsCommandFlagUsed(IdentityCmdFlagDataDir); ok && dataDirFlag.GetFlagValue() != "" {
+		dataDir = dataDirFlag.GetFlagValue()
+	}
+
+	identity, err := network.LoadIdentity(dataDir)
+	if err != nil {
+
+		if os.IsNotExist(err) {
+			return nil, NewUsageError(fmt.Sprintf("No identity found in %s, deploy the node first with %s", dataDir, GetCommandDeployNode()))
+		}
+
+		return nil, err
+	}
+
+	pub := identity.Public().(ed25519.PublicKey)
+
+	pubPEM, err := network.MarshalPublicIdentity(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &IdentityResult{NodeID: network.NodeIDFromPublicKey(pub), PublicKey: string(pubPEM)}
+
+	exportFlag, ok := i.IsCommandFlagUsed(IdentityCmdFlagExport)
+	if !ok {
+		return result, nil
+	}
+
+	if exportFlag.GetFlagValue() == "" {
+		return nil, NewFlagUsageError("No file provided!", exportFlag)
+	}
+
+	if err := os.WriteFile(exportFlag.GetFlagValue(), pubPEM, 0644); err != nil {
+		return nil, err
+	}
+
+	result.ExportedTo = exportFlag.GetFlagValue()
+
+	return result, nil
+}
+
+// MARK: IdentityResult
+
+// IdentityResult - Defines the result of the identity command, PublicKey is PEM encoded.
+// ExportedTo is the file the public key has been exported to, if any
+type IdentityResult struct {
+	NodeID     string `json:"node_id"`
+	PublicKey  string `json:"public_key"`
+	ExportedTo string `json:"exported_to,omitempty"`
+}
+
+// WriteText - Implements Result interface
+func (r *IdentityResult) WriteText(out io.Writer) error {
+
+	if r.ExportedTo != "" {
+		_, err := fmt.Fprintf(out, "\nPublic identity exported to %s\n\n", r.ExportedTo)
+		return err
+	}
+
+	_, err := fmt.Fprintf(out, "\nNode ID:\t%s\n\n%s\n", r.NodeID, r.PublicKey)
+	return err
+}
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/IacopoMelani/vortex/core/network"
+)
+
+func TestCmdIdentity(t *testing.T) {
+
+	oldArgs := os.Args
+	defer func() {
+		os.Args = oldArgs
+		appCLI.resetCommands()
+	}()
+
+	dataDir := t.TempDir()
+
+	// vortex identity --data-dir=<dir>, no identity yet
+
+	os.Args = []string{CommandBase, CommandIdentity, "--data-dir=" + dataDir}
+
+	if err := Parse(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := network.LoadOrCreateIdentity(dataDir); err != nil {
+		t.Fatal(err)
+	}
+
+	appCLI.resetCommands()
+
+	// vortex identity -d <dir>
+
+	os.Args = []string{CommandBase, CommandIdentity, "-d", dataDir}
+
+	if err := Parse(); err != nil {
+		t.Fatal(err)
+	}
+
+	appCLI.resetCommands()
+
+	// vortex identity -d <dir> --export=<file>
+
+	exported := filepath.Join(t.TempDir(), "identity.pub")
+
+	os.Args = []string{CommandBase, CommandIdentity, "-d", dataDir, "--export=" + exported}
+
+	if err := Parse(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(exported); err != nil {
+		t.Fatal(err)
+	}
+}
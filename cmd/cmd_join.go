@@ -0,0 +1,265 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/IacopoMelani/vortex/core/app"
+	"github.com/IacopoMelani/vortex/core/network"
+)
+
+const (
+	JoinCmdFlagDiscover = "Discover"
+	JoinCmdFlagHelp     = "Help"
+	JoinCmdFlagHost     = "Host"
+	JoinCmdFlagToken    = "Token"
+)
+
+// JoinCmd - Defines the command to join the current host to a Vortex network node
+type JoinCmd struct {
+	StandardCmd
+}
+
+// NewJoinCmd - Returns a new instance of JoinCmd
+func NewJoinCmd() *JoinCmd {
+	return &JoinCmd{
+		StandardCmd: StandardCmd{
+			Name:        CommandJoinToNode,
+			Description: "Deploy current host as node and join the vortex network with a join token",
+			Usage:       "vortex join --token=<token> [--discover] | vortex join --discover",
+			Flags: append([]Flag{
+				&StandardCmdFlag{
+					Name:           JoinCmdFlagDiscover,
+					Description:    "Used for list the clusters announced on the local network, with a token the node to join is looked up among them",
+					Usage:          "join --discover | join --token=<token> --discover",
+					VerboseVersion: "--discover",
+					Present:        false,
+					NeedValue:      false,
+				},
+				&StandardCmdFlag{
+					Name:           JoinCmdFlagHelp,
+					Description:    "Show this message",
+					Usage:          "join -h | join --help",
+					ShortVersion:   "-h",
+					VerboseVersion: "--help",
+					Present:        false,
+					NeedValue:      false,
+				},
+				&StandardCmdFlag{
+					Name:           JoinCmdFlagHost,
+					Description:    "Used for override the address of the node to join encoded in the token",
+					Usage:          "join -H <host> | join --host=<host>",
+					ShortVersion:   "-H",
+					VerboseVersion: "--host",
+					Present:        false,
+					NeedValue:      true,
+				},
+				&StandardCmdFlag{
+					Name:           JoinCmdFlagToken,
+					Description:    "Used for specify the join token generated by the node to join",
+					Usage:          "join -t <token> | join --token=<token>",
+					ShortVersion:   "-t",
+					VerboseVersion: "--token",
+					Present:        false,
+					NeedValue:      true,
+				},
+			}, nodeSettingsFlags(CommandJoinToNode)...),
+		},
+	}
+}
+
+// CommandExec - Execs the command
+func (j JoinCmd) CommandExec() (Result, error) {
+
+	_, okHelp := j.IsCommandFlagUsed(JoinCmdFlagHelp)
+
+	if okHelp {
+		return NewHelpResult(j), nil
+	}
+
+	_, okDiscover := j.IsCommandFlagUsed(JoinCmdFlagDiscover)
+
+	if tokenFlag, ok := j.IsCommandFlagUsed(JoinCmdFlagToken); okDiscover && (!ok || tokenFlag.GetFlagValue() == "") {
+		return j.discoveredClusters()
+	}
+
+	token, err := j.requiredFlagValue(JoinCmdFlagToken, "No token provided!")
+	if err != nil {
+		return nil, err
+	}
+
+	host := ""
+	if hostFlag, ok := j.IsCommandFlagUsed(JoinCmdFlagHost); ok {
+
+		host = hostFlag.GetFlagValue()
+		if host == "" {
+			return nil, NewFlagUsageError("No host provided!", hostFlag)
+		}
+	}
+
+	jt, err := network.ParseJoinToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if okDiscover && host == "" {
+		if host, err = discoverJoinAddress(jt); err != nil {
+			return nil, err
+		}
+	}
+
+	config, err := deployConfig(j)
+	if err != nil {
+		return nil, err
+	}
+
+	appNode, err := app.NewAppNodeWithConfig("node", config)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := appNode.Join(token, host); err != nil {
+		return nil, err
+	}
+
+	ShowInfo("\nJoined the vortex network\n")
+
+	return nil, runAppNode(appNode, j)
+}
+
+// discoveredClusters - Returns the nodes of the clusters announced on the local network
+func (j JoinCmd) discoveredClusters() (Result, error) {
+
+	clusters, err := discoverClusters()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &DiscoveredClustersResult{Nodes: make([]DiscoveredNode, 0)}
+
+	for _, cluster := range clusters {
+		for _, info := range cluster.Nodes {
+			result.Nodes = append(result.Nodes, DiscoveredNode{
+				Cluster: cluster.ID,
+				ID:      info.ID,
+				Name:    info.Name,
+				Address: network.NewNodeFromInfo(info).Address(),
+			})
+		}
+	}
+
+	return result, nil
+}
+
+// requiredFlagValue - Returns the value of the flag, a UsageError with the message if missing
+func (j JoinCmd) requiredFlagValue(name string, message string) (string, error) {
+
+	flag, ok := j.IsCommandFlagUsed(name)
+	if ok && flag.GetFlagValue() != "" {
+		return flag.GetFlagValue(), nil
+	}
+
+	if flag, ok := j.GetCommandFlagByName(name); ok {
+		return "", NewFlagUsageError(message, flag)
+	}
+
+	return "", NewUsageError(message)
+}
+
+// MARK: DiscoveredClustersResult
+
+// DiscoveredNode - Defines a node announced on the local network in DiscoveredClustersResult
+type DiscoveredNode struct {
+	Cluster string `json:"cluster"`
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Address string `json:"address"`
+}
+
+// DiscoveredClustersResult - Defines the result of the join --discover command without a token, the nodes are sorted by cluster
+type DiscoveredClustersResult struct {
+	Nodes []DiscoveredNode `json:"nodes"`
+}
+
+// WriteText - Implements Result interface, the nodes are written as a table
+func (r *DiscoveredClustersResult) WriteText(out io.Writer) error {
+
+	if len(r.Nodes) == 0 {
+		ShowWarning("No cluster discovered on the local network")
+		return nil
+	}
+
+	w := newTableWriter(out)
+	fmt.Fprintf(w, "CLUSTER\tNODE\tNAME\tADDRESS\n")
+
+	for _, node := range r.Nodes {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", shortID(node.Cluster), shortID(node.ID), node.Name, node.Address)
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(out, "\nAsk a member of the cluster for a join token: vortex join-token\n\n")
+	return err
+}
+
+// MARK: join utils unexported
+
+// discoverClusters - Returns the clusters announced on the local network within the default browse timeout
+func discoverClusters() ([]network.DiscoveredCluster, error) {
+
+	discovery, err := network.ListenDiscovery(network.DefaultDiscoveryGroup)
+	if err != nil {
+		return nil, err
+	}
+	defer discovery.Close()
+
+	return discovery.Browse(network.DefaultDiscoveryBrowseTimeout)
+}
+
+// discoverJoinAddress - Returns the address announced on the local network by the node that issued the token
+func discoverJoinAddress(jt *network.JoinToken) (string, error) {
+
+	clusters, err := discoverClusters()
+	if err != nil {
+		return "", err
+	}
+
+	for _, cluster := range clusters {
+		for _, info := range cluster.Nodes {
+			if info.ID == jt.Fingerprint() {
+				return network.NewNodeFromInfo(info).Address(), nil
+			}
+		}
+	}
+
+	return "", NewNodeNotDiscoveredError(jt.Fingerprint())
+}
+
+// shortID - Returns the first characters of a node ID, enough to tell the nodes apart
+func shortID(id string) string {
+
+	if len(id) > 12 {
+		return id[:12]
+	}
+
+	return id
+}
+
+// MARK: NodeNotDiscoveredError
+
+// NodeNotDiscoveredError - Defines error for a node to join not announced on the local network
+type NodeNotDiscoveredError struct {
+	nodeID string
+}
+
+// NewNodeNotDiscoveredError - Returns a new instance of NodeNotDiscoveredError
+func NewNodeNotDiscoveredError(nodeID string) error {
+	return &NodeNotDiscoveredError{nodeID: nodeID}
+}
+
+// Error - Implements error interface
+func (e *NodeNotDiscoveredError) Error() string {
+	return fmt.Sprintf("Node %s not discovered on the local network, is it deployed with --announce?", shortID(e.nodeID))
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"os"
+	"testing"
+)
+
+func TestCmdJoinHelp(t *testing.T) {
+
+	oldArgs := os.Args
+	defer func() {
+		os.Args = oldArgs
+		appCLI.resetCommands()
+	}()
+
+	// vortex join -h
+
+	os.Args = []string{CommandBase, CommandJoinToNode, "-h"}
+
+	if err := Parse(); err != nil {
+		t.Fatal(err)
+	}
+
+	appCLI.resetCommands()
+
+	// vortex join --help
+
+	os.Args = []string{CommandBase, CommandJoinToNode, "--help"}
+
+	if err := Parse(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCmdJoinMissingFlags(t *testing.T) {
+
+	oldArgs := os.Args
+	defer func() {
+		os.Args = oldArgs
+		appCLI.resetCommands()
+	}()
+
+	// vortex join
+
+	os.Args = []string{CommandBase, CommandJoinToNode}
+
+	if err := Parse(); err != nil {
+		t.Fatal(err)
+	}
+
+	appCLI.resetCommands()
+
+	// vortex join --host=<host>
+
+	os.Args = []string{CommandBase, CommandJoinToNode, "--host=127.0.0.1"}
+
+	if err := Parse(); err != nil {
+		t.Fatal(err)
+	}
+
+}
+
+func TestCmdJoinInvalidToken(t *testing.T) {
+
+	oldArgs := os.Args
+	defer func() {
+		os.Args = oldArgs
+		appCLI.resetCommands()
+	}()
+
+	// vortex join -t <token>
+
+	os.Args = []string{CommandBase, CommandJoinToNode, "-t", "token"}
+
+	if err := Parse(); err == nil {
+		t.Fatal("Expected error joining with a malformed token")
+	}
+
+	appCLI.resetCommands()
+
+	// vortex join --host=<host> --token=<token>
+
+	os.Args = []string{CommandBase, CommandJoinToNode, "--host=127.0.0.1:1", "--token=token"}
+
+	if err := Parse(); err == nil {
+		t.Fatal("Expected error joining with a malformed token")
+	}
+}
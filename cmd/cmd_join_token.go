@@ -2,7 +2,12 @@ package cmd
 
 import (
 	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
 
+	"github.com/IacopoMelani/vortex/core/app"
 	"github.com/IacopoMelani/vortex/core/network"
 )
 
@@ -21,9 +26,9 @@ func NewJoinTokenCmd() *JoinTokenCmd {
 	return &JoinTokenCmd{
 		StandardCmd: StandardCmd{
 			Name:        CommndGenerateJoinToken,
-			Description: "Generates a single-use join token to the vortex network",
-			Usage:       "vortex join-token",
-			Flags: []Flag{
+			Description: "Generates a single-use join token to the vortex network, registered in the running node",
+			Usage:       "vortex join-token [--data-dir=<dir>] [--host=<host[:port]>]",
+			Flags: append([]Flag{
 				&StandardCmdFlag{
 					Name:           JoinTokenCmdFlagHelp,
 					Description:    "Show this message",
@@ -35,30 +40,28 @@ func NewJoinTokenCmd() *JoinTokenCmd {
 				},
 				&StandardCmdFlag{
 					Name:           JoinTokenCmdFlagHost,
-					Description:    "Used for specify the host for join token",
-					Usage:          "join-token -H <host> | join-token --host=<host>",
+					Description:    "Used for specify the address advertised in the join token, host or host:port",
+					Usage:          "join-token -H <host[:port]> | join-token --host=<host[:port]>",
 					VerboseVersion: "--host",
 					ShortVersion:   "-H",
 					Present:        false,
 					NeedValue:      true,
 				},
-			},
+			}, nodeControlFlags(CommndGenerateJoinToken)...),
 		},
 	}
 }
 
 // CommandExec - Execs the command
-func (j JoinTokenCmd) CommandExec() error {
+func (j JoinTokenCmd) CommandExec() (Result, error) {
 
 	_, okHelp := j.IsCommandFlagUsed(JoinTokenCmdFlagHelp)
 
 	if okHelp {
-
-		ShowCommandHelp(j, true)
-		return nil
+		return NewHelpResult(j), nil
 	}
 
-	jtConfig := network.JoinTokenConfig{}
+	var req app.JoinTokenRequest
 
 	hostFlag, ok := j.IsCommandFlagUsed(JoinTokenCmdFlagHost)
 
@@ -66,30 +69,68 @@ func (j JoinTokenCmd) CommandExec() error {
 
 		host := hostFlag.GetFlagValue()
 		if host == "" {
-			ShowError("No host provided!")
+			return nil, NewFlagUsageError("No host provided!", hostFlag)
+		}
 
-			hostFlag, ok := j.GetCommandFlagByName(JoinTokenCmdFlagHost)
-			if ok {
-				ShowFlagHelp(hostFlag, true)
-			}
-			return nil
+		// the host may pin the port advertised in the token too
+		if ip, port, err := net.SplitHostPort(host); err == nil {
+			req.Host, req.RPCPort = ip, ":"+port
+		} else {
+			req.Host = host
 		}
+	}
 
-		jtConfig.Host = host
+	// the token is issued by the running node, the only one able to accept it
+	client, err := dialNode(j)
+	if err != nil {
+		return nil, err
 	}
+	defer client.Close()
 
-	joinToken, err := network.NewJoinTokenWithConfig(jtConfig)
+	res, err := client.JoinToken(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	fmt.Println(j.JoinCommandSample(joinToken))
+	joinToken, err := network.ParseJoinToken(res.Token)
+	if err != nil {
+		return nil, err
+	}
 
-	return nil
+	return NewJoinTokenResult(joinToken), nil
 }
 
 // JoinCommand - Returns the complete command to join a node
 func (j JoinTokenCmd) JoinCommandSample(jt *network.JoinToken) string {
 
-	return fmt.Sprintf("\n%s --host=%s --token=%s\n", GetCommandJoinToNode(), jt.Host(), jt.Value())
+	return fmt.Sprintf("\n%s --token=%s\n", GetCommandJoinToNode(), jt.String())
+}
+
+// MARK: JoinTokenResult
+
+// JoinTokenResult - Defines the result of the join-token command, Address is the node the token joins to
+type JoinTokenResult struct {
+	Token       string    `json:"token"`
+	ID          string    `json:"id"`
+	Address     string    `json:"address"`
+	Expires     time.Time `json:"expires"`
+	JoinCommand string    `json:"join_command"`
+}
+
+// NewJoinTokenResult - Returns the result of the join-token command for the JoinToken
+func NewJoinTokenResult(jt *network.JoinToken) *JoinTokenResult {
+
+	return &JoinTokenResult{
+		Token:       jt.String(),
+		ID:          jt.ID(),
+		Address:     jt.Address(),
+		Expires:     jt.Exp(),
+		JoinCommand: strings.TrimSpace(JoinTokenCmd{}.JoinCommandSample(jt)),
+	}
+}
+
+// WriteText - Implements Result interface
+func (r *JoinTokenResult) WriteText(out io.Writer) error {
+	_, err := fmt.Fprintf(out, "\n%s\n\n", r.JoinCommand)
+	return err
 }
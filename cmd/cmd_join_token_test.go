@@ -1,10 +1,53 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"os"
 	"testing"
+	"time"
+
+	"github.com/IacopoMelani/vortex/core/app"
+	"github.com/IacopoMelani/vortex/core/network"
 )
 
+// startTestAppNode - Starts a node with a temporary data directory, the commands find it through EnvDataDir
+func startTestAppNode(t *testing.T) *app.AppNode {
+
+	dataDir := t.TempDir()
+
+	appNode, err := app.NewAppNodeWithConfig("node", app.AppNodeConfig{
+		Node:            network.NodeConfig{IP: "127.0.0.1", ListenAddr: "127.0.0.1:0", DataDir: dataDir},
+		ShutdownTimeout: time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	started := make(chan error)
+	go func() { started <- appNode.Start(ctx) }()
+
+	t.Cleanup(func() {
+		cancel()
+		<-started
+	})
+
+	for i := 0; i < 50; i++ {
+		if client, err := app.DialControl(dataDir); err == nil {
+			client.Close()
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	os.Setenv(EnvDataDir, dataDir)
+	t.Cleanup(func() { os.Unsetenv(EnvDataDir) })
+
+	return appNode
+}
+
 func TestCmdJoinToken(t *testing.T) {
 
 	oldArgs := os.Args
@@ -13,13 +56,31 @@ func TestCmdJoinToken(t *testing.T) {
 		appCLI.resetCommands()
 	}()
 
-	// vortex join-token
+	// vortex join-token, without a running node
+
+	os.Setenv(EnvDataDir, t.TempDir())
+	defer os.Unsetenv(EnvDataDir)
 
 	os.Args = []string{CommandBase, CommndGenerateJoinToken}
 
+	var notRunning *app.NodeNotRunningError
+	if err := Parse(); !errors.As(err, &notRunning) {
+		t.Fatalf("Expected NodeNotRunningError, got %v", err)
+	}
+
+	appCLI.resetCommands()
+
+	// vortex join-token, the token is registered in the running node
+
+	appNode := startTestAppNode(t)
+
 	if err := Parse(); err != nil {
 		t.Fatal(err)
 	}
+
+	if tokens := appNode.Node().JoinTokens(); len(tokens) != 1 {
+		t.Fatalf("Expected 1 token registered in the running node, got %d", len(tokens))
+	}
 }
 
 func TestCmdJoinTokenHelp(t *testing.T) {
@@ -57,6 +118,8 @@ func TestCmdJoinTokenHost(t *testing.T) {
 		appCLI.resetCommands()
 	}()
 
+	startTestAppNode(t)
+
 	// vortex join-token -H
 
 	os.Args = []string{CommandBase, CommndGenerateJoinToken, "-H"}
@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/IacopoMelani/vortex/core/app"
+	"github.com/IacopoMelani/vortex/utils"
+)
+
+const (
+	PeersCmdFlagHelp = "Help"
+)
+
+// PeersCmd - Defines the command to list the neighbors of the node running on current host
+type PeersCmd struct {
+	StandardCmd
+}
+
+// NewPeersCmd - Returns a new instance of PeersCmd
+func NewPeersCmd() *PeersCmd {
+	return &PeersCmd{
+		StandardCmd: StandardCmd{
+			Name:        CommandPeers,
+			Description: "List the neighbors of the node running on current host",
+			Usage:       "vortex peers [--data-dir=<dir>]",
+			Flags: append([]Flag{
+				&StandardCmdFlag{
+					Name:           PeersCmdFlagHelp,
+					Description:    "Show this message",
+					Usage:          "peers -h | peers --help",
+					ShortVersion:   "-h",
+					VerboseVersion: "--help",
+					Present:        false,
+					NeedValue:      false,
+				},
+			}, nodeControlFlags(CommandPeers)...),
+		},
+	}
+}
+
+// CommandExec - Execs the command
+func (p PeersCmd) CommandExec() (Result, error) {
+
+	if _, okHelp := p.IsCommandFlagUsed(PeersCmdFlagHelp); okHelp {
+		return NewHelpResult(p), nil
+	}
+
+	client, err := dialNode(p)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	peers, err := client.Peers()
+	if err != nil {
+		return nil, err
+	}
+
+	return &PeersResult{Peers: peers}, nil
+}
+
+// MARK: PeersResult
+
+// PeersResult - Defines the result of the peers command
+type PeersResult struct {
+	Peers []app.PeerStatus `json:"peers"`
+}
+
+// WriteText - Implements Result interface
+func (r *PeersResult) WriteText(out io.Writer) error {
+	return writePeers(out, r.Peers, time.Now())
+}
+
+// MARK: peers utils unexported
+
+// writePeers - Writes the peers as a table, one peer per row. Values the peer did not answer are shown as -
+func writePeers(out io.Writer, peers []app.PeerStatus, now time.Time) error {
+
+	w := newTableWriter(out)
+
+	fmt.Fprintf(w, "ID\tNAME\tADDRESS\tSTATE\tLAST SEEN\tRTT\tSTORED\n")
+
+	for _, peer := range peers {
+
+		lastSeen, rtt, stored := "-", "-", "-"
+
+		if peer.LastSeen != nil {
+			lastSeen = now.Sub(*peer.LastSeen).Truncate(time.Second).String() + " ago"
+		}
+		if peer.RTTMillis != nil {
+			rtt = fmt.Sprintf("%.1fms", *peer.RTTMillis)
+		}
+		if peer.StoredBytes != nil {
+			stored = utils.FormatByteSize(*peer.StoredBytes)
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", shortID(peer.ID), peer.Name, peer.Address, peer.State, lastSeen, rtt, stored)
+	}
+
+	return w.Flush()
+}
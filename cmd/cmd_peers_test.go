@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IacopoMelani/vortex/core/app"
+	"github.com/IacopoMelani/vortex/core/network"
+)
+
+func TestCmdPeers(t *testing.T) {
+
+	oldArgs := os.Args
+	defer func() {
+		os.Args = oldArgs
+		appCLI.resetCommands()
+	}()
+
+	appNode := startTestAppNode(t)
+
+	neighbor, err := network.NewNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an unreachable neighbor is listed without the values it did not answer
+	if err := appNode.Node().AddNeighbor(neighbor); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]string{{"-h"}, {}, {"--output=json"}} {
+
+		os.Args = append([]string{CommandBase, CommandPeers}, args...)
+
+		if err := Parse(); err != nil {
+			t.Fatal(err)
+		}
+
+		appCLI.resetCommands()
+	}
+}
+
+func TestCmdPeersWrite(t *testing.T) {
+
+	var buf bytes.Buffer
+
+	now := time.Now()
+	lastSeen := now.Add(-3 * time.Second)
+	rtt := 1.25
+	stored := int64(2 << 20)
+
+	peers := []app.PeerStatus{
+		{ID: "0123456789abcdef", Name: "node-1", Address: "10.0.0.1:6000", State: network.MemberAlive, LastSeen: &lastSeen, RTTMillis: &rtt, StoredBytes: &stored},
+		{ID: "fedcba9876543210", Name: "node-2", Address: "10.0.0.2:6000", State: app.PeerStateUnknown},
+	}
+
+	if err := writePeers(&buf, peers, now); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected header and 2 peers, got\n%s", buf.String())
+	}
+
+	for _, expected := range []string{"0123456789ab", "alive", "3s ago", "1.2ms", "2.0 MiB"} {
+		if !strings.Contains(lines[1], expected) {
+			t.Fatalf("Expected %q in the first peer, got %s", expected, lines[1])
+		}
+	}
+
+	if fields := strings.Fields(lines[2]); fields[len(fields)-1] != "-" || fields[3] != app.PeerStateUnknown {
+		t.Fatalf("Expected the values not answered shown as -, got %s", lines[2])
+	}
+}
@@ -0,0 +1,340 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/IacopoMelani/vortex/core/app"
+	"github.com/IacopoMelani/vortex/core/storage"
+)
+
+const (
+	PutCmdArgFile = "file"
+
+	PutCmdFlagAllowDegraded    = "AllowDegraded"
+	PutCmdFlagConvergent       = "Convergent"
+	PutCmdFlagErasure          = "Erasure"
+	PutCmdFlagHelp             = "Help"
+	PutCmdFlagHost             = "Host"
+	PutCmdFlagKeyfile          = "Keyfile"
+	PutCmdFlagPassphraseFile   = "PassphraseFile"
+	PutCmdFlagReplicas         = "Replicas"
+	PutCmdFlagTenantSecretFile = "TenantSecretFile"
+
+	// environment variable holding the passphrase encrypting the files
+	EnvPassphrase = "VORTEX_PASSPHRASE"
+	// environment variable holding the tenant secret of convergent encryption
+	EnvTenantSecret = "VORTEX_TENANT_SECRET"
+)
+
+// PutCmd - Defines the command to store a local file on the Vortex network
+type PutCmd struct {
+	StandardCmd
+}
+
+// NewPutCmd - Returns a new instance of PutCmd
+func NewPutCmd() *PutCmd {
+	return &PutCmd{
+		StandardCmd: StandardCmd{
+			Name:        CommandPut,
+			Description: "Store a local file on the vortex network encrypted with a passphrase or keyfile, prints the file ID to use with get",
+			Usage:       "vortex put <file> [-r <replicas> | -e <data>+<parity>] [-k <keyfile> | --passphrase-file=<file>] [--convergent [--tenant-secret-file=<file>]]",
+			Args: []Arg{
+				&StandardCmdArg{
+					Name:        PutCmdArgFile,
+					Description: "The local file to store",
+				},
+			},
+			Flags: []Flag{
+				&StandardCmdFlag{
+					Name:           PutCmdFlagHelp,
+					Description:    "Show this message",
+					Usage:          "put -h | put --help",
+					ShortVersion:   "-h",
+					VerboseVersion: "--help",
+					Present:        false,
+					NeedValue:      false,
+				},
+				&StandardCmdFlag{
+					Name:           PutCmdFlagHost,
+					Description:    "Used for specify the RPC address of the node used to discover the network, the local node by default",
+					Usage:          "put <file> -H <host:port> | put <file> --host=<host:port>",
+					ShortVersion:   "-H",
+					VerboseVersion: "--host",
+					Present:        false,
+					NeedValue:      true,
+				},
+				&StandardCmdFlag{
+					Name:           PutCmdFlagReplicas,
+					Description:    fmt.Sprintf("Used for specify the number of nodes storing every chunk, %d by default", storage.DefaultReplicas),
+					Usage:          "put <file> -r <replicas> | put <file> --replicas=<replicas>",
+					ShortVersion:   "-r",
+					VerboseVersion: "--replicas",
+					Present:        false,
+					NeedValue:      true,
+				},
+				&StandardCmdFlag{
+					Name: PutCmdFlagErasure,
+					Description: fmt.Sprintf("Used for erasure coding every chunk in data and parity shards stored on distinct nodes instead of replicating it, any <data> shards rebuild the chunk, e.g. %d+%d",
+						storage.DefaultErasureDataShards, storage.DefaultErasureParityShards),
+					Usage:          "put <file> -e <data>+<parity> | put <file> --erasure=<data>+<parity>",
+					ShortVersion:   "-e",
+					VerboseVersion: "--erasure",
+					Present:        false,
+					NeedValue:      true,
+				},
+				&StandardCmdFlag{
+					Name:           PutCmdFlagKeyfile,
+					Description:    fmt.Sprintf("Used for specify the keyfile encrypting the file, at least %d random bytes", storage.MinKeyfileSize),
+					Usage:          "put <file> -k <keyfile> | put <file> --keyfile=<keyfile>",
+					ShortVersion:   "-k",
+					VerboseVersion: "--keyfile",
+					Present:        false,
+					NeedValue:      true,
+				},
+				&StandardCmdFlag{
+					Name:           PutCmdFlagPassphraseFile,
+					Description:    fmt.Sprintf("Used for specify the file holding the passphrase encrypting the file, %s is used if no key is provided", EnvPassphrase),
+					Usage:          "put <file> --passphrase-file=<file>",
+					VerboseVersion: "--passphrase-file",
+					Present:        false,
+					NeedValue:      true,
+				},
+				&StandardCmdFlag{
+					Name: PutCmdFlagConvergent,
+					Description: "Encrypt every chunk with a key derived from its content, so that identical chunks are stored once across files and users. " +
+						"Tradeoff: whoever can derive the keys can confirm that a known file is stored, and guess the unknown parts of an almost known one " +
+						"(confirmation-of-file attack): derivation only needs the content and the tenant secret, keep the secret to the users trusted with each other's files. " +
+						"The manifest stays encrypted with the passphrase or keyfile",
+					Usage:          "put <file> --convergent",
+					VerboseVersion: "--convergent",
+					Present:        false,
+					NeedValue:      false,
+				},
+				&StandardCmdFlag{
+					Name:           PutCmdFlagTenantSecretFile,
+					Description:    fmt.Sprintf("Used for specify the file holding the tenant secret mixed in the convergent keys, %s is used if not provided. Without a secret anyone can run the confirmation-of-file attack", EnvTenantSecret),
+					Usage:          "put <file> --convergent --tenant-secret-file=<file>",
+					VerboseVersion: "--tenant-secret-file",
+					Present:        false,
+					NeedValue:      true,
+				},
+				&StandardCmdFlag{
+					Name:           PutCmdFlagAllowDegraded,
+					Description:    "Store the file even if the network has fewer nodes than the replicas",
+					Usage:          "put <file> --allow-degraded",
+					VerboseVersion: "--allow-degraded",
+					Present:        false,
+					NeedValue:      false,
+				},
+			},
+		},
+	}
+}
+
+// CommandExec - Execs the command
+func (p PutCmd) CommandExec() (Result, error) {
+
+	_, okHelp := p.IsCommandFlagUsed(PutCmdFlagHelp)
+
+	if okHelp {
+		return NewHelpResult(p), nil
+	}
+
+	fileArg, _ := p.GetCommandArgByName(PutCmdArgFile)
+	if fileArg.GetArgValue() == "" {
+		return nil, NewArgUsageError("No file provided!", fileArg)
+	}
+
+	host := DefaultLocalNodeHost
+	if hostFlag, ok := p.IsCommandFlagUsed(PutCmdFlagHost); ok && hostFlag.GetFlagValue() != "" {
+		host = hostFlag.GetFlagValue()
+	}
+
+	config := defaultPutConfig()
+
+	if replicasFlag, ok := p.IsCommandFlagUsed(PutCmdFlagReplicas); ok {
+
+		replicas, err := strconv.Atoi(replicasFlag.GetFlagValue())
+		if err != nil || replicas <= 0 {
+			return nil, NewFlagUsageError("Invalid replicas provided!", replicasFlag)
+		}
+
+		config.Placement.Replicas = replicas
+	}
+
+	if erasureFlag, ok := p.IsCommandFlagUsed(PutCmdFlagErasure); ok {
+
+		erasure, err := parseErasureConfig(erasureFlag.GetFlagValue())
+		if err != nil {
+			return nil, NewFlagUsageError(err.Error(), erasureFlag)
+		}
+
+		config.Erasure = erasure
+		config.Placement.Replicas = erasure.Data + erasure.Parity
+	}
+
+	_, config.Placement.AllowDegraded = p.IsCommandFlagUsed(PutCmdFlagAllowDegraded)
+
+	// chunks never leave the host in plaintext
+	key, err := userKeyFromFlags(p, PutCmdFlagKeyfile, PutCmdFlagPassphraseFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if key == nil {
+		return nil, NewUsageError(fmt.Sprintf("No key provided! Use --keyfile, --passphrase-file or %s", EnvPassphrase))
+	}
+
+	config.Key = key
+
+	if _, ok := p.IsCommandFlagUsed(PutCmdFlagConvergent); ok {
+
+		secret, err := tenantSecretFromFlags(p, PutCmdFlagTenantSecretFile)
+		if err != nil {
+			return nil, err
+		}
+
+		if secret == nil {
+			ShowWarning(fmt.Sprintf("No tenant secret provided, anyone holding a file can confirm it is stored! Use --tenant-secret-file or %s", EnvTenantSecret))
+		}
+
+		config.Convergent = true
+		config.TenantSecret = secret
+	}
+
+	file, err := os.Open(fileArg.GetArgValue())
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	consumer, err := app.NewAppConsumer("consumer")
+	if err != nil {
+		return nil, err
+	}
+	defer consumer.Close()
+
+	if err := consumer.Connect(host); err != nil {
+		return nil, err
+	}
+
+	placement, err := storage.NewPlacementWithConfig(config.Placement)
+	if err != nil {
+		return nil, err
+	}
+
+	if nodes := len(consumer.Nodes()); placement.Degraded(nodes) {
+
+		if !config.Placement.AllowDegraded {
+			return nil, NewUsageError(fmt.Sprintf("The network has %d nodes, fewer than the %d required! Use --allow-degraded to store the file anyway", nodes, placement.Replicas()))
+		}
+
+		ShowWarning(fmt.Sprintf("The network has %d nodes, every chunk is spread over %d nodes instead of %d", nodes, nodes, placement.Replicas()))
+	}
+
+	id, manifest, err := consumer.Put(file, filepath.Base(file.Name()), config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PutResult{FileID: id.String(), Name: manifest.Name, Size: manifest.Size, Chunks: len(manifest.Chunks)}, nil
+}
+
+// MARK: PutResult
+
+// PutResult - Defines the result of the put command, FileID is the ID to pass to get
+type PutResult struct {
+	FileID string `json:"file_id"`
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	Chunks int    `json:"chunks"`
+}
+
+// WriteText - Implements Result interface
+func (r *PutResult) WriteText(out io.Writer) error {
+	_, err := fmt.Fprintf(out, "\nStored %s, %d bytes in %d chunks\n\nFile ID:\t%s\n\n", r.Name, r.Size, r.Chunks, r.FileID)
+	return err
+}
+
+// defaultPutConfig - Returns the upload config used by put
+func defaultPutConfig() app.PutConfig {
+	return app.PutConfig{
+		// content-defined chunks let an edited file share most of its chunks with the previous upload
+		Chunker:   storage.ChunkerConfig{Mode: storage.ChunkerModeCDC},
+		Placement: storage.PlacementConfig{Replicas: storage.DefaultReplicas},
+	}
+}
+
+// parseErasureConfig - Returns the erasure config in <data>+<parity> form
+func parseErasureConfig(value string) (*storage.ErasureConfig, error) {
+
+	parts := strings.SplitN(value, "+", 2)
+	if len(parts) != 2 {
+		return nil, storage.NewInvalidErasureConfigError("expected <data>+<parity>")
+	}
+
+	data, derr := strconv.Atoi(parts[0])
+	parity, perr := strconv.Atoi(parts[1])
+	if derr != nil || perr != nil {
+		return nil, storage.NewInvalidErasureConfigError("expected <data>+<parity>")
+	}
+
+	config := &storage.ErasureConfig{Data: data, Parity: parity}
+
+	if _, err := storage.NewErasureWithConfig(*config); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// userKeyFromFlags - Returns the user key from the keyfile or passphrase file flags, or EnvPassphrase. Nil if none is provided
+func userKeyFromFlags(command Command, keyfileFlagName, passphraseFlagName string) (*storage.UserKey, error) {
+
+	if keyfileFlag, ok := command.IsCommandFlagUsed(keyfileFlagName); ok && keyfileFlag.GetFlagValue() != "" {
+		return storage.LoadKeyfile(keyfileFlag.GetFlagValue())
+	}
+
+	passphrase := os.Getenv(EnvPassphrase)
+
+	if passphraseFlag, ok := command.IsCommandFlagUsed(passphraseFlagName); ok && passphraseFlag.GetFlagValue() != "" {
+
+		b, err := os.ReadFile(passphraseFlag.GetFlagValue())
+		if err != nil {
+			return nil, err
+		}
+
+		passphrase = strings.TrimRight(string(b), "\r\n")
+	}
+
+	if passphrase == "" {
+		return nil, nil
+	}
+
+	return storage.NewPassphraseKey(passphrase), nil
+}
+
+// tenantSecretFromFlags - Returns the tenant secret from the tenant secret file flag, or EnvTenantSecret. Nil if none is provided
+func tenantSecretFromFlags(command Command, secretFlagName string) ([]byte, error) {
+
+	if secretFlag, ok := command.IsCommandFlagUsed(secretFlagName); ok && secretFlag.GetFlagValue() != "" {
+
+		b, err := os.ReadFile(secretFlag.GetFlagValue())
+		if err != nil {
+			return nil, err
+		}
+
+		return []byte(strings.TrimRight(string(b), "\r\n")), nil
+	}
+
+	if secret := os.Getenv(EnvTenantSecret); secret != "" {
+		return []byte(secret), nil
+	}
+
+	return nil, nil
+}
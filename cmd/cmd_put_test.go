@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCmdPutHelp(t *testing.T) {
+
+	oldArgs := os.Args
+	defer func() {
+		os.Args = oldArgs
+		appCLI.resetCommands()
+	}()
+
+	// vortex put -h
+
+	os.Args = []string{CommandBase, CommandPut, "-h"}
+
+	if err := Parse(); err != nil {
+		t.Fatal(err)
+	}
+
+	appCLI.resetCommands()
+
+	// vortex put
+
+	os.Args = []string{CommandBase, CommandPut}
+
+	if err := Parse(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCmdPutErrors(t *testing.T) {
+
+	oldArgs := os.Args
+	defer func() {
+		os.Args = oldArgs
+		appCLI.resetCommands()
+	}()
+
+	dir := t.TempDir()
+
+	filename := filepath.Join(dir, "file")
+	if err := os.WriteFile(filename, []byte("vortex"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	passphraseFile := filepath.Join(dir, "passphrase")
+	if err := os.WriteFile(passphraseFile, []byte("correct horse battery staple\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// vortex put <file> -k <short keyfile>
+
+	os.Args = []string{CommandBase, CommandPut, filename, "-k", passphraseFile}
+
+	if err := Parse(); err == nil {
+		t.Fatal("Expected error with a keyfile too short")
+	}
+
+	appCLI.resetCommands()
+
+	// vortex put <missing file> --passphrase-file=<file>
+
+	os.Args = []string{CommandBase, CommandPut, filepath.Join(dir, "missing"), "--passphrase-file=" + passphraseFile}
+
+	if err := Parse(); err == nil {
+		t.Fatal("Expected error storing a missing file")
+	}
+
+	appCLI.resetCommands()
+
+	// vortex put <file> --host=<host> --passphrase-file=<file>
+
+	os.Args = []string{CommandBase, CommandPut, filename, "--host=127.0.0.1:1", "--passphrase-file=" + passphraseFile}
+
+	if err := Parse(); err == nil {
+		t.Fatal("Expected error storing a file without a node")
+	}
+
+	appCLI.resetCommands()
+
+	// vortex put <file> --passphrase-file=<file> --convergent --tenant-secret-file=<missing file>
+
+	os.Args = []string{CommandBase, CommandPut, filename, "--passphrase-file=" + passphraseFile, "--convergent", "--tenant-secret-file=" + filepath.Join(dir, "missing")}
+
+	if err := Parse(); err == nil {
+		t.Fatal("Expected error with a missing tenant secret file")
+	}
+}
+
+func TestParseErasureConfig(t *testing.T) {
+
+	config, err := parseErasureConfig("10+4")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Data != 10 || config.Parity != 4 {
+		t.Fatalf("Unexpected erasure config %+v", config)
+	}
+
+	for _, value := range []string{"", "10", "10+", "a+4", "0+4", "10+0"} {
+		if _, err := parseErasureConfig(value); err == nil {
+			t.Fatalf("Expected error parsing %q", value)
+		}
+	}
+}
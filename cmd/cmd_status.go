@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/IacopoMelani/vortex/core/app"
+	"github.com/IacopoMelani/vortex/utils"
+)
+
+const (
+	StatusCmdFlagHelp = "Help"
+)
+
+// StatusCmd - Defines the command to show the status of the node running on current host
+type StatusCmd struct {
+	StandardCmd
+}
+
+// NewStatusCmd - Returns a new instance of StatusCmd
+func NewStatusCmd() *StatusCmd {
+	return &StatusCmd{
+		StandardCmd: StandardCmd{
+			Name:        CommandStatus,
+			Description: "Show the status of the node running on current host",
+			Usage:       "vortex status [--data-dir=<dir>]",
+			Flags: append([]Flag{
+				&StandardCmdFlag{
+					Name:           StatusCmdFlagHelp,
+					Description:    "Show this message",
+					Usage:          "status -h | status --help",
+					ShortVersion:   "-h",
+					VerboseVersion: "--help",
+					Present:        false,
+					NeedValue:      false,
+				},
+			}, nodeControlFlags(CommandStatus)...),
+		},
+	}
+}
+
+// CommandExec - Execs the command
+func (s StatusCmd) CommandExec() (Result, error) {
+
+	if _, okHelp := s.IsCommandFlagUsed(StatusCmdFlagHelp); okHelp {
+		return NewHelpResult(s), nil
+	}
+
+	client, err := dialNode(s)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	status, err := client.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	return &StatusResult{NodeStatus: status}, nil
+}
+
+// MARK: StatusResult
+
+// StatusResult - Defines the result of the status command, encoded as the status of the node
+type StatusResult struct {
+	*app.NodeStatus
+}
+
+// WriteText - Implements Result interface
+func (r *StatusResult) WriteText(out io.Writer) error {
+	return writeNodeStatus(out, r.NodeStatus)
+}
+
+// MARK: status utils unexported
+
+// writeNodeStatus - Writes the status as a table of values
+func writeNodeStatus(out io.Writer, status *app.NodeStatus) error {
+
+	w := newTableWriter(out)
+
+	fmt.Fprintf(w, "ID\t%s\n", status.ID)
+	fmt.Fprintf(w, "Name\t%s\n", status.Name)
+	fmt.Fprintf(w, "Version\t%s\n", status.Version)
+	fmt.Fprintf(w, "Uptime\t%s\n", time.Duration(status.UptimeSeconds)*time.Second)
+	fmt.Fprintf(w, "Listen address\t%s\n", status.ListenAddress)
+	fmt.Fprintf(w, "Advertise address\t%s\n", status.AdvertiseAddress)
+	fmt.Fprintf(w, "Storage\t%s used of %s\n", utils.FormatByteSize(status.Storage.Used), utils.FormatByteSize(status.Storage.Capacity))
+	fmt.Fprintf(w, "Cluster\t%s\n", shortID(status.Cluster))
+	fmt.Fprintf(w, "Cluster size\t%d\n", status.ClusterSize)
+
+	return w.Flush()
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/IacopoMelani/vortex/core/app"
+	"github.com/IacopoMelani/vortex/core/storage"
+)
+
+func TestCmdStatus(t *testing.T) {
+
+	oldArgs := os.Args
+	defer func() {
+		os.Args = oldArgs
+		appCLI.resetCommands()
+	}()
+
+	startTestAppNode(t)
+
+	for _, args := range [][]string{{"-h"}, {}, {"--output=json"}} {
+
+		os.Args = append([]string{CommandBase, CommandStatus}, args...)
+
+		if err := Parse(); err != nil {
+			t.Fatal(err)
+		}
+
+		appCLI.resetCommands()
+	}
+
+	os.Args = []string{CommandBase, CommandStatus, "--output=yaml"}
+
+	var formatErr *InvalidOutputFormatError
+	if err := Parse(); !errors.As(err, &formatErr) {
+		t.Fatalf("Expected InvalidOutputFormatError, got %v", err)
+	}
+}
+
+func TestCmdStatusWrite(t *testing.T) {
+
+	var buf bytes.Buffer
+
+	status := &app.NodeStatus{
+		ID:               "0123456789abcdef",
+		Name:             "node-1",
+		Version:          app.VortexNodeVersion,
+		ListenAddress:    ":6000",
+		AdvertiseAddress: "10.0.0.1:6000",
+		UptimeSeconds:    3723,
+		Storage:          storage.ChunkStoreUsage{Capacity: 10 << 30, Used: 1536},
+		ClusterSize:      3,
+	}
+
+	if err := writeNodeStatus(&buf, status); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{"node-1", "1h2m3s", "10.0.0.1:6000", "1.5 KiB used of 10.0 GiB", "Cluster size       3"} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Fatalf("Expected %q in the status, got\n%s", expected, buf.String())
+		}
+	}
+}
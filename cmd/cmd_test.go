@@ -1,10 +1,26 @@
 package cmd
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/IacopoMelani/vortex/core/app"
+	"github.com/IacopoMelani/vortex/core/network"
+	"github.com/IacopoMelani/vortex/core/storage"
 )
 
+var updateGolden = flag.Bool("update", false, "update the golden files of the JSON output")
+
+// goldenJoinToken - A join token issued for 10.0.0.1:6000 by the identity with an all zero seed
+const goldenJoinToken = "VXTKN-1-eyJpZCI6Ijk0YmNjZTE2LTA1ZTctNDVkYi1hNzQ3LWRiNzdiYmY5MDA2YiIsImhvc3QiOiIxMC4wLjAuMSIsInBvcnQiOiI6NjAwMCIsImlhdCI6MTc5MjMyNDc5MywiZXhwIjoxNzkyMzI1MDkzLCJmcCI6IjEzOWUzOTQwZTY0YjU0OTE3MjIwODhkOWEwZDc0MTYyOGZjODI2ZTA5NDc1ZDM0MWE3ODBhY2RlM2M0YjgwNzAiLCJzZWNyZXQiOiJvMVRrdUVrSHlneFF2RzNieHZiVHBzYURXdHN2bjI4MkUwT05hSGxGV1JnZjMwRlh4bDM4S2FSMEtSQ0l2SW41In0.CP6luykt-GaieglPhtt0L6NrbuSoeCSkPkABQAB8s_1U0BK_3qMFFIwAIqlmWLAb_VxaG5UxauiE7IYRwH5iAw"
+
 func TestCmd(t *testing.T) {
 
 	oldArgs := os.Args
@@ -16,3 +32,202 @@ func TestCmd(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestParseOutputFlag(t *testing.T) {
+
+	for _, tc := range []struct {
+		args     []string
+		rest     []string
+		expected string
+	}{
+		{[]string{CommandBase, CommandGet, "--out=file"}, []string{CommandBase, CommandGet, "--out=file"}, OutputText},
+		{[]string{CommandBase, CommandGet, "--output=json", "--out=file"}, []string{CommandBase, CommandGet, "--out=file"}, OutputJSON},
+		{[]string{CommandBase, "--output", "json", CommandStatus}, []string{CommandBase, CommandStatus}, OutputJSON},
+		{[]string{CommandBase, CommandStatus, "--output=text"}, []string{CommandBase, CommandStatus}, OutputText},
+	} {
+
+		rest, output, err := parseOutputFlag(tc.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if output != tc.expected || len(rest) != len(tc.rest) {
+			t.Fatalf("Expected %s and args %v, got %s and %v", tc.expected, tc.rest, output, rest)
+		}
+
+		for i := range rest {
+			if rest[i] != tc.rest[i] {
+				t.Fatalf("Expected args %v, got %v", tc.rest, rest)
+			}
+		}
+	}
+
+	if _, _, err := parseOutputFlag([]string{CommandBase, "--output=yaml"}); err == nil {
+		t.Fatal("Expected error parsing an unknown output format")
+	}
+}
+
+func TestCmdOutputJSONErrors(t *testing.T) {
+
+	oldArgs := os.Args
+	defer func() {
+		os.Args = oldArgs
+		appCLI.out = os.Stdout
+		appCLI.resetCommands()
+	}()
+
+	os.Setenv(EnvDataDir, t.TempDir())
+	defer os.Unsetenv(EnvDataDir)
+
+	for _, tc := range []struct {
+		args     []string
+		code     string
+		exitCode int
+	}{
+		{[]string{CommandBase, "--output=json"}, ErrorCodeInvalidUsage, ExitCodeInvalidUsage},
+		{[]string{CommandBase, CommandGet, "--output=json"}, ErrorCodeInvalidUsage, ExitCodeInvalidUsage},
+		{[]string{CommandBase, CommandJoinToNode, "--output=json", "--token=token"}, ErrorCodeInvalidToken, ExitCodeFailure},
+		{[]string{CommandBase, CommandStatus, "--output=json"}, ErrorCodeNodeNotRunning, ExitCodeFailure},
+	} {
+
+		var buf bytes.Buffer
+		appCLI.out = &buf
+
+		os.Args = tc.args
+
+		err := Parse()
+		if ExitCode(err) != tc.exitCode {
+			t.Fatalf("Expected exit code %d for %v, got %v", tc.exitCode, tc.args, err)
+		}
+
+		var doc ResultDocument
+		if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+			t.Fatalf("Expected a JSON document for %v, got %s", tc.args, buf.String())
+		}
+
+		if doc.OK || doc.Error == nil || doc.Error.Code != tc.code || doc.Error.ExitCode != tc.exitCode {
+			t.Fatalf("Expected error %s for %v, got %s", tc.code, tc.args, buf.String())
+		}
+
+		appCLI.resetCommands()
+	}
+}
+
+func TestCmdOutputJSONGolden(t *testing.T) {
+
+	oldOutput := appCLI.output
+	defer func() { appCLI.output = oldOutput }()
+
+	appCLI.output = OutputJSON
+
+	lastSeen := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	rtt := 1.25
+	stored := int64(2 << 20)
+
+	joinToken, err := network.ParseJoinToken(goldenJoinToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	settings := app.DefaultAppNodeSettings()
+	settings.Name = "node-1"
+	settings.DataDir = "/var/lib/vortex"
+
+	for _, tc := range []struct {
+		name    string
+		command string
+		result  Result
+		err     error
+	}{
+		{"ca", CommandCA, &CARotateResult{Fingerprint: "0123456789abcdef"}, nil},
+		{"config", CommandConfig, &ConfigResult{AppNodeSettings: settings}, nil},
+		{"deploy", CommandDeployNode, nil, nil},
+		{"error", CommandStatus, nil, app.NewNodeNotRunningError("/var/lib/vortex/control.sock")},
+		{"get", CommandGet, &GetResult{FileID: "0123456789abcdef", Path: "file.txt", Size: 1536}, nil},
+		{"help", CommandIdentity, NewHelpResult(NewIdentityCmd()), nil},
+		{"identity", CommandIdentity, &IdentityResult{NodeID: "0123456789abcdef", PublicKey: "-----BEGIN PUBLIC KEY-----\n...\n-----END PUBLIC KEY-----\n"}, nil},
+		{"join", CommandJoinToNode, &DiscoveredClustersResult{Nodes: []DiscoveredNode{{Cluster: "0123456789abcdef", ID: "fedcba9876543210", Name: "node-1", Address: "10.0.0.1:6000"}}}, nil},
+		{"join-token", CommndGenerateJoinToken, NewJoinTokenResult(joinToken), nil},
+		{"peers", CommandPeers, &PeersResult{Peers: []app.PeerStatus{
+			{ID: "0123456789abcdef", Name: "node-1", Address: "10.0.0.1:6000", State: network.MemberAlive, LastSeen: &lastSeen, RTTMillis: &rtt, StoredBytes: &stored},
+			{ID: "fedcba9876543210", Name: "node-2", Address: "10.0.0.2:6000", State: app.PeerStateUnknown},
+		}}, nil},
+		{"put", CommandPut, &PutResult{FileID: "0123456789abcdef", Name: "file.txt", Size: 1536, Chunks: 1}, nil},
+		{"status", CommandStatus, &StatusResult{NodeStatus: &app.NodeStatus{
+			ID:               "0123456789abcdef",
+			Name:             "node-1",
+			Version:          "0.0.1",
+			Cluster:          "0123456789abcdef",
+			ListenAddress:    ":6000",
+			AdvertiseAddress: "10.0.0.1:6000",
+			StartedAt:        lastSeen,
+			UptimeSeconds:    3723,
+			Storage:          storage.ChunkStoreUsage{Capacity: 10 << 30, Used: 1536, Free: 10<<30 - 1536},
+			ClusterSize:      3,
+		}}, nil},
+	} {
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			var buf bytes.Buffer
+			if err := writeJSON(&buf, NewResultDocument(tc.command, tc.result, tc.err)); err != nil {
+				t.Fatal(err)
+			}
+
+			golden := filepath.Join("testdata", "golden", tc.name+".json")
+
+			if *updateGolden {
+				if err := os.MkdirAll(filepath.Dir(golden), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			expected, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(buf.Bytes(), expected) {
+				t.Fatalf("JSON output of %s changed, run go test -update if intended\nexpected:\n%s\ngot:\n%s", tc.name, expected, buf.String())
+			}
+		})
+	}
+}
+
+func TestHelpResultWriteText(t *testing.T) {
+
+	var buf bytes.Buffer
+	if err := NewHelpResult(NewIdentityCmd()).WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(buf.String(), CommandIdentity+" command") {
+		t.Fatalf("help of %s not written to the output: %q", CommandIdentity, buf.String())
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+
+	for _, tc := range []struct {
+		err      error
+		expected string
+	}{
+		{nil, ""},
+		{errors.New("boom"), ErrorCodeFailure},
+		{NewUsageError("No file provided!"), ErrorCodeInvalidUsage},
+		{NewExitError(ExitCodeInvalidUsage, NewUsageError("No file provided!")), ErrorCodeInvalidUsage},
+		{NewExitError(ExitCodeForcedShutdown, errors.New("boom")), ErrorCodeForcedShutdown},
+		{app.NewInvalidAppNodeSettingsError("listen", "boom"), ErrorCodeInvalidConfig},
+		{network.NewInvalidJoinTokenError(), ErrorCodeInvalidToken},
+		{NewNodeNotDiscoveredError("0123456789abcdef"), ErrorCodeNotFound},
+		{os.ErrNotExist, ErrorCodeNotFound},
+		{app.NewControlError(app.ControlMethodStatus, "boom"), ErrorCodeRemote},
+	} {
+		if code := ErrorCode(tc.err); code != tc.expected {
+			t.Fatalf("Expected code %q for %v, got %q", tc.expected, tc.err, code)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package app
 
 import (
+	"os"
+	"path/filepath"
+
 	"github.com/google/uuid"
 )
 
@@ -13,6 +16,11 @@ const (
 	VortexModeConsumer = "Consumer"
 )
 
+const (
+	// name of the data directory in the user home, see DefaultDataDir
+	DefaultDataDirName = ".vortex"
+)
+
 // Application - Defines a interface for an Application in Vortex system based on "mode", see const VortexMode*
 type Application interface {
 	// ID - Returns the Application ID
@@ -42,3 +50,14 @@ func NewApp(name, version, mode string) *AppStandard {
 		mode:    mode,
 	}
 }
+
+// DefaultDataDir - Returns the default data directory of Vortex, the working directory is used if the user home is unknown
+func DefaultDataDir() string {
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return DefaultDataDirName
+	}
+
+	return filepath.Join(home, DefaultDataDirName)
+}
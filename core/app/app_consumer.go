@@ -0,0 +1,690 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/IacopoMelani/vortex/core/network"
+	"github.com/IacopoMelani/vortex/core/storage"
+)
+
+// MARK: AppConsumer, consts, vars & constructors
+
+const (
+	// current Vortex consumer version
+	VortexConsumerVersion = "0.0.0"
+
+	// upper bound of the encoded Manifest fields but the name and the chunks
+	manifestHeaderSize = 512
+	// upper bound of the put-chunk request wrapping the Manifest, and of the sealed Manifest wrapping the encrypted one
+	manifestEnvelopeSize = 1 << 10
+)
+
+var (
+	// ErrConsumerNotConnected - Returned transferring files before AppConsumer.Connect
+	ErrConsumerNotConnected = errors.New("consumer not connected to the network")
+	// ErrConvergentWithoutKey - Returned uploading with convergent encryption and no user key to seal the Manifest
+	ErrConvergentWithoutKey = errors.New("convergent encryption requires a user key")
+)
+
+// AppConsumer - Defines the Application storing files on the nodes of Vortex Network.
+// Files are split in chunks replicated on the nodes, the file is described by a Manifest stored as a chunk itself:
+// the hash of the encoded Manifest is the file ID
+type AppConsumer struct {
+	AppStandard
+	sync.RWMutex
+	node    *network.Node
+	nodes   []network.NodeInfo
+	clients map[string]*network.RPCClient
+	index   *storage.ChunkIndex
+	// the largest RPC message accepted by the nodes, see network.MaxRPCMessageSize
+	maxMessageSize int
+}
+
+// PutConfig - Defines the config of an upload, see AppConsumer.Put.
+// Chunks are replicated as set by Placement, with Erasure they are erasure coded instead.
+// With Key every chunk is encrypted with a random file key wrapped by Key, see storage.SealManifest.
+// With Convergent every chunk is encrypted with the key derived from its content and TenantSecret instead,
+// identical chunks are stored once across files, see storage.ConvergentChunkKey
+type PutConfig struct {
+	Chunker      storage.ChunkerConfig
+	Placement    storage.PlacementConfig
+	Erasure      *storage.ErasureConfig
+	Key          *storage.UserKey
+	Convergent   bool
+	TenantSecret []byte
+}
+
+// NewAppConsumer - Returns an instance of Application storing files on Vortex Network, the consumer has an ephemeral identity
+func NewAppConsumer(name string) (*AppConsumer, error) {
+
+	node, err := network.NewNode()
+	if err != nil {
+		return nil, err
+	}
+
+	return &AppConsumer{
+		AppStandard:    *NewApp(name, VortexConsumerVersion, VortexModeConsumer),
+		node:           node,
+		clients:        make(map[string]*network.RPCClient),
+		index:          storage.NewChunkIndex(),
+		maxMessageSize: network.MaxRPCMessageSize,
+	}, nil
+}
+
+// MARK: AppConsumer Application implementation
+
+// ID - Returns the Application ID
+func (ac *AppConsumer) ID() string {
+	ac.RLock()
+	defer ac.RUnlock()
+	return ac.id
+}
+
+// Mode - Returns the Application Mode
+func (ac *AppConsumer) Mode() string {
+	ac.RLock()
+	defer ac.RUnlock()
+	return ac.mode
+}
+
+// Name - Returns the Application name
+func (ac *AppConsumer) Name() string {
+	ac.RLock()
+	defer ac.RUnlock()
+	return ac.name
+}
+
+// Version - Returns the Application version
+func (ac *AppConsumer) Version() string {
+	ac.RLock()
+	defer ac.RUnlock()
+	return ac.version
+}
+
+// MARK: AppConsumer exported
+
+// Close - Closes the connections with the nodes
+func (ac *AppConsumer) Close() error {
+	ac.Lock()
+	defer ac.Unlock()
+
+	var err error
+	if dht, derr := ac.node.DHT(); derr == nil {
+		err = dht.Close()
+	}
+
+	for id, client := range ac.clients {
+		if cerr := client.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		delete(ac.clients, id)
+	}
+
+	return err
+}
+
+// Connect - Discovers the nodes of the network through the node at host, the node and its neighbors are used
+func (ac *AppConsumer) Connect(host string) error {
+
+	client, err := ac.node.DialRPC(network.RPCAddress(host), "")
+	if err != nil {
+		return err
+	}
+
+	info, err := client.Info()
+	if err != nil {
+		client.Close()
+		return err
+	}
+
+	if info.ID != client.PeerID() {
+		client.Close()
+		return network.NewIdentityMismatchError(client.PeerID(), info.ID)
+	}
+
+	neighbors, err := client.Neighbors()
+	if err != nil {
+		client.Close()
+		return err
+	}
+
+	// the neighbors are sorted so that the same network always gets the same uploads
+	sort.Slice(neighbors, func(i, j int) bool { return neighbors[i].ID < neighbors[j].ID })
+
+	ac.Lock()
+	ac.clients[info.ID] = client
+	ac.nodes = append([]network.NodeInfo{info}, neighbors...)
+	ac.Unlock()
+
+	// the nodes are the first contacts of the lookups of the chunk holders, see getChunk
+	dht, err := ac.node.DHT()
+	if err != nil {
+		return err
+	}
+
+	dht.Observe(info)
+	for _, neighbor := range neighbors {
+		dht.Observe(neighbor)
+	}
+
+	return nil
+}
+
+// Get - Writes the file described by the Manifest to w, every chunk and the whole file are verified, see storage.Reassembler.
+// Encrypted chunks are decrypted with the file key returned by storage.OpenManifest, or with their own key if convergent:
+// any authentication failure is an error.
+// Chunks are fetched from the nodes recorded in the Manifest first. On error the data already written must be discarded
+func (ac *AppConsumer) Get(manifest *storage.Manifest, fileKey []byte, w io.Writer) error {
+
+	if manifest.Encryption != nil {
+		return storage.NewInvalidManifestError("sealed, open it with the user key first")
+	}
+
+	var erasure *storage.Erasure
+	if manifest.Erasure != nil {
+
+		var err error
+		if erasure, err = storage.NewErasureWithConfig(*manifest.Erasure); err != nil {
+			return err
+		}
+	}
+
+	refs := make(map[storage.Hash]storage.ChunkRef, len(manifest.Chunks))
+
+	for _, ref := range manifest.Chunks {
+
+		refs[ref.Hash] = ref
+
+		for _, nodeID := range ref.Nodes {
+			ac.index.Record(ref.Address(), nodeID)
+		}
+
+		for _, shard := range ref.Shards {
+			for _, nodeID := range shard.Nodes {
+				ac.index.Record(shard.Hash, nodeID)
+			}
+		}
+	}
+
+	source := storage.ChunkSourceFunc(func(hash storage.Hash) ([]byte, error) {
+
+		ref := refs[hash]
+
+		var data []byte
+		var err error
+
+		if erasure == nil {
+			data, err = ac.getChunk(ref.Address())
+		} else {
+			data, err = ac.getStripe(erasure, ref)
+		}
+
+		if err != nil || ref.Ciphertext == nil {
+			return data, err
+		}
+
+		if ref.Key != nil {
+			return storage.DecryptChunk(ref.Key, data)
+		}
+
+		if fileKey == nil {
+			return nil, storage.NewDecryptionError("no file key for an encrypted chunk")
+		}
+
+		return storage.DecryptChunk(fileKey, data)
+	})
+
+	_, err := storage.NewReassembler(manifest, source).WriteTo(w)
+
+	return err
+}
+
+// GetManifest - Returns the Manifest of the file with the ID passed
+func (ac *AppConsumer) GetManifest(id storage.Hash) (*storage.Manifest, error) {
+
+	b, err := ac.getChunk(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.ParseManifest(b)
+}
+
+// Nodes - Returns the nodes discovered with Connect
+func (ac *AppConsumer) Nodes() []network.NodeInfo {
+	ac.RLock()
+	defer ac.RUnlock()
+	return append([]network.NodeInfo(nil), ac.nodes...)
+}
+
+// Put - Splits the stream in chunks and stores every chunk on the nodes chosen by the placement, see storage.Placement.
+// Erasure coded chunks have each shard stored on a distinct node, unless degraded placement is allowed.
+// Returns the file ID and its Manifest, the Manifest is stored on every node, sealed if the file is encrypted.
+// The Manifest is stored as a single chunk: the upload fails with ManifestTooLargeError before storing a chunk
+// the Manifest could not describe
+func (ac *AppConsumer) Put(r io.Reader, name string, config PutConfig) (storage.Hash, *storage.Manifest, error) {
+
+	var erasure *storage.Erasure
+	placementConfig := config.Placement
+
+	if config.Erasure != nil {
+
+		var err error
+		if erasure, err = storage.NewErasureWithConfig(*config.Erasure); err != nil {
+			return storage.Hash{}, nil, err
+		}
+
+		placementConfig.Replicas = config.Erasure.Data + config.Erasure.Parity
+	}
+
+	placement, err := storage.NewPlacementWithConfig(placementConfig)
+	if err != nil {
+		return storage.Hash{}, nil, err
+	}
+
+	nodes := ac.Nodes()
+	if len(nodes) == 0 {
+		return storage.Hash{}, nil, ErrConsumerNotConnected
+	}
+
+	// fail before uploading anything if the network is too small
+	if err := placement.Check(len(nodes)); err != nil {
+		return storage.Hash{}, nil, err
+	}
+
+	byID := make(map[string]network.NodeInfo, len(nodes))
+	ids := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		byID[node.ID] = node
+		ids = append(ids, node.ID)
+	}
+
+	if config.Convergent && config.Key == nil {
+		return storage.Hash{}, nil, ErrConvergentWithoutKey
+	}
+
+	var fileKey []byte
+	if config.Key != nil {
+
+		if fileKey, err = storage.NewFileKey(); err != nil {
+			return storage.Hash{}, nil, err
+		}
+	}
+
+	// addresses of the stored chunks by plaintext hash, a chunk repeated in the file is stored once
+	addresses := make(map[storage.Hash]storage.Hash)
+	keys := make(map[storage.Hash][]byte)
+	shards := make(map[storage.Hash][]storage.ShardRef)
+
+	// the encoded Manifest grows with every chunk described, see describe
+	manifestLimit := ac.manifestSizeLimit(config.Key != nil)
+	manifestSize := manifestHeaderSize + len(name)
+	refSizes := make(map[storage.Hash]int)
+	var offset int64
+
+	describe := func(ref storage.ChunkRef) error {
+
+		b, err := json.Marshal(ref)
+		if err != nil {
+			return err
+		}
+
+		refSizes[ref.Hash] = len(b) + 1
+		manifestSize += len(b) + 1
+
+		if manifestSize > manifestLimit {
+			return storage.NewManifestTooLargeError(manifestSize, manifestLimit)
+		}
+
+		return nil
+	}
+
+	manifest, err := storage.Split(r, config.Chunker, func(chunk *storage.Chunk) error {
+
+		ref := storage.ChunkRef{Hash: chunk.Hash, Offset: offset, Size: int64(len(chunk.Data))}
+		offset += ref.Size
+
+		if _, ok := addresses[chunk.Hash]; ok {
+
+			manifestSize += refSizes[chunk.Hash]
+			if manifestSize > manifestLimit {
+				return storage.NewManifestTooLargeError(manifestSize, manifestLimit)
+			}
+
+			return nil
+		}
+
+		data, address := chunk.Data, chunk.Hash
+
+		if config.Convergent {
+
+			key := storage.ConvergentChunkKey(chunk.Hash, config.TenantSecret)
+
+			ciphertext, err := storage.EncryptChunkConvergent(key, chunk.Data)
+			if err != nil {
+				return err
+			}
+
+			data, address = ciphertext, storage.HashOf(ciphertext)
+			keys[chunk.Hash] = key
+
+		} else if fileKey != nil {
+
+			ciphertext, err := storage.EncryptChunk(fileKey, chunk.Data)
+			if err != nil {
+				return err
+			}
+
+			data, address = ciphertext, storage.HashOf(ciphertext)
+		}
+
+		addresses[chunk.Hash] = address
+
+		if address != chunk.Hash {
+			ref.Ciphertext = &address
+			ref.Key = keys[chunk.Hash]
+		}
+
+		targets, err := placement.Place(address, ids)
+		if err != nil {
+			return err
+		}
+
+		if erasure == nil {
+
+			ref.Nodes = targets
+			if err := describe(ref); err != nil {
+				return err
+			}
+
+			for _, id := range targets {
+				if err := ac.putChunk(byID[id], address, data); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}
+
+		encoded := erasure.Encode(data)
+		refs := make([]storage.ShardRef, 0, len(encoded))
+
+		// targets are fewer than the shards only with degraded placement
+		for i, shard := range encoded {
+			ref.Shards = append(ref.Shards, storage.ShardRef{Hash: storage.HashOf(shard), Nodes: []string{targets[i%len(targets)]}})
+		}
+
+		if err := describe(ref); err != nil {
+			return err
+		}
+
+		for i, shard := range encoded {
+
+			shardRef := storage.ShardRef{Hash: ref.Shards[i].Hash}
+
+			if err := ac.putChunk(byID[targets[i%len(targets)]], shardRef.Hash, shard); err != nil {
+				return err
+			}
+
+			refs = append(refs, shardRef)
+		}
+
+		shards[address] = refs
+
+		return nil
+	})
+	if err != nil {
+		return storage.Hash{}, nil, err
+	}
+
+	manifest.Name = name
+
+	if erasure != nil {
+
+		config := erasure.Config()
+		manifest.Erasure = &config
+
+	} else {
+
+		manifest.Replicas = placement.Replicas()
+	}
+
+	for i := range manifest.Chunks {
+
+		ref := &manifest.Chunks[i]
+
+		if fileKey != nil {
+			address := addresses[ref.Hash]
+			ref.Ciphertext = &address
+			ref.Key = keys[ref.Hash]
+		}
+
+		if erasure == nil {
+			ref.Nodes = ac.index.Locations(ref.Address())
+			continue
+		}
+
+		for _, shard := range shards[ref.Address()] {
+			shard.Nodes = ac.index.Locations(shard.Hash)
+			ref.Shards = append(ref.Shards, shard)
+		}
+	}
+
+	stored := manifest
+	if fileKey != nil {
+
+		if stored, err = storage.SealManifest(manifest, fileKey, config.Key); err != nil {
+			return storage.Hash{}, nil, err
+		}
+	}
+
+	b, err := stored.Encode()
+	if err != nil {
+		return storage.Hash{}, nil, err
+	}
+
+	// the nodes recorded may outnumber the estimated ones, the Manifest is checked once more as stored
+	if len(b) > ac.manifestSizeLimit(false) {
+		return storage.Hash{}, nil, storage.NewManifestTooLargeError(len(b), ac.manifestSizeLimit(false))
+	}
+
+	id := storage.HashOf(b)
+
+	for _, node := range nodes {
+		if err := ac.putChunk(node, id, b); err != nil {
+			return storage.Hash{}, nil, err
+		}
+	}
+
+	return id, manifest, nil
+}
+
+// MARK: AppConsumer unexported
+
+// client - Returns the client connected to the node, the connection is opened on first use
+func (ac *AppConsumer) client(info network.NodeInfo) (*network.RPCClient, error) {
+
+	ac.RLock()
+	client, ok := ac.clients[info.ID]
+	ac.RUnlock()
+
+	if ok {
+		return client, nil
+	}
+
+	client, err := ac.node.DialRPC(network.NewNodeFromInfo(info).Address(), info.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	ac.Lock()
+	defer ac.Unlock()
+
+	if existing, ok := ac.clients[info.ID]; ok {
+		client.Close()
+		return existing, nil
+	}
+
+	ac.clients[info.ID] = client
+
+	return client, nil
+}
+
+// findProviders - Returns the holders of the chunk looked up in the DHT, nil if the lookup fails
+func (ac *AppConsumer) findProviders(hash storage.Hash) []network.NodeInfo {
+
+	dht, err := ac.node.DHT()
+	if err != nil {
+		return nil
+	}
+
+	providers, err := dht.FindProviders(network.DHTKey(hash))
+	if err != nil {
+		return nil
+	}
+
+	return providers
+}
+
+// getChunk - Returns the chunk from the first node holding it. The nodes recorded in the index are tried first,
+// then the holders located through the DHT and at last every known node
+func (ac *AppConsumer) getChunk(hash storage.Hash) ([]byte, error) {
+
+	nodes := ac.Nodes()
+	if len(nodes) == 0 {
+		return nil, ErrConsumerNotConnected
+	}
+
+	var lastErr error = storage.NewChunkNotFoundError(hash)
+
+	tried := make(map[string]bool, len(nodes))
+	fetch := func(candidates []network.NodeInfo) ([]byte, bool) {
+
+		for _, node := range candidates {
+
+			if tried[node.ID] {
+				continue
+			}
+			tried[node.ID] = true
+
+			client, err := ac.client(node)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+
+			data, err := client.GetChunk(hash)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+
+			return data, true
+		}
+
+		return nil, false
+	}
+
+	if data, ok := fetch(ac.recordedNodes(nodes, ac.index.Locations(hash))); ok {
+		return data, nil
+	}
+
+	if data, ok := fetch(ac.findProviders(hash)); ok {
+		return data, nil
+	}
+
+	if data, ok := fetch(nodes); ok {
+		return data, nil
+	}
+
+	return nil, lastErr
+}
+
+// getStripe - Returns the erasure coded chunk fetching the shards, the data shards are preferred.
+// Missing shards are rebuilt from any Data shards, see storage.Erasure
+func (ac *AppConsumer) getStripe(erasure *storage.Erasure, ref storage.ChunkRef) ([]byte, error) {
+
+	config := erasure.Config()
+	if len(ref.Shards) != config.Data+config.Parity {
+		return nil, storage.NewChunkNotFoundError(ref.Hash)
+	}
+
+	shards := make([][]byte, len(ref.Shards))
+	fetched := 0
+
+	for i, shard := range ref.Shards {
+
+		if fetched == config.Data {
+			break
+		}
+
+		data, err := ac.getChunk(shard.Hash)
+		if err != nil {
+			continue
+		}
+
+		shards[i] = data
+		fetched++
+	}
+
+	if fetched < config.Data {
+		return nil, storage.NewInsufficientShardsError(fetched, config.Data)
+	}
+
+	if err := erasure.Reconstruct(shards); err != nil {
+		return nil, err
+	}
+
+	return erasure.Join(shards, int(ref.StoredSize()))
+}
+
+// manifestSizeLimit - Returns the largest encoded Manifest storable as a single chunk. The chunk is base64 encoded
+// in the put-chunk request, a sealed Manifest is base64 encoded once more in the stored one
+func (ac *AppConsumer) manifestSizeLimit(sealed bool) int {
+
+	limit := (ac.maxMessageSize - manifestEnvelopeSize) * 3 / 4
+	if sealed {
+		limit = (limit - manifestEnvelopeSize) * 3 / 4
+	}
+
+	return limit
+}
+
+// putChunk - Stores the chunk on the node and records it in the index
+func (ac *AppConsumer) putChunk(node network.NodeInfo, hash storage.Hash, data []byte) error {
+
+	client, err := ac.client(node)
+	if err != nil {
+		return err
+	}
+
+	if err := client.PutChunk(hash, data); err != nil {
+		return err
+	}
+
+	ac.index.Record(hash, node.ID)
+
+	return nil
+}
+
+// recordedNodes - Returns the nodes with the IDs passed
+func (ac *AppConsumer) recordedNodes(nodes []network.NodeInfo, ids []string) []network.NodeInfo {
+
+	isRecorded := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		isRecorded[id] = true
+	}
+
+	recorded := make([]network.NodeInfo, 0, len(ids))
+	for _, node := range nodes {
+		if isRecorded[node.ID] {
+			recorded = append(recorded, node)
+		}
+	}
+
+	return recorded
+}
@@ -0,0 +1,532 @@
+package app
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IacopoMelani/vortex/core/network"
+	"github.com/IacopoMelani/vortex/core/storage"
+)
+
+// startTestAppNode - Returns a node serving RPC requests on a loopback port, its info advertise the port
+func startTestAppNode(t *testing.T) *AppNode {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	appNode, err := NewAppNode("node", network.NodeConfig{IP: "127.0.0.1", RPCPort: ":" + port})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go appNode.rpcServer.Serve(listener)
+
+	t.Cleanup(func() { appNode.Stop() })
+
+	return appNode
+}
+
+// startTestNetwork - Returns n nodes, the first one is the cluster CA and the others joined it
+func startTestNetwork(t *testing.T, n int) []*AppNode {
+
+	nodes := []*AppNode{startTestAppNode(t)}
+
+	if err := nodes[0].node.BootstrapClusterCA(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i < n; i++ {
+
+		jt, err := nodes[0].NewJoinToken()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		joining := startTestAppNode(t)
+		if err := joining.Join(jt.String(), nodes[0].node.Address()); err != nil {
+			t.Fatal(err)
+		}
+
+		nodes = append(nodes, joining)
+	}
+
+	return nodes
+}
+
+func TestAppConsumerPutGet(t *testing.T) {
+
+	nodes := startTestNetwork(t, 3)
+
+	consumer, err := NewAppConsumer("consumer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Close()
+
+	if _, _, err := consumer.Put(bytes.NewReader(nil), "empty", PutConfig{}); err != ErrConsumerNotConnected {
+		t.Fatalf("Expected ErrConsumerNotConnected, got %v", err)
+	}
+
+	if err := consumer.Connect(nodes[0].node.Address()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(consumer.Nodes()) != len(nodes) {
+		t.Fatalf("Expected %d nodes discovered, got %d", len(nodes), len(consumer.Nodes()))
+	}
+
+	data := make([]byte, 64*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	config := PutConfig{
+		Chunker:   storage.ChunkerConfig{ChunkSize: 1024},
+		Placement: storage.PlacementConfig{Replicas: 2},
+	}
+
+	id, manifest, err := consumer.Put(bytes.NewReader(data), "file.bin", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// chunks are spread over the nodes, every chunk on 2 of them
+	for _, node := range nodes {
+		if node.Store().Usage().Used <= 0 {
+			t.Fatalf("Expected node %s to store chunks", node.ID())
+		}
+	}
+
+	for _, ref := range manifest.Chunks {
+		if len(ref.Nodes) != 2 {
+			t.Fatalf("Expected chunk %s on 2 nodes, got %v", ref.Hash, ref.Nodes)
+		}
+	}
+
+	fetched, err := consumer.GetManifest(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fetched.Name != "file.bin" || fetched.Hash != manifest.Hash {
+		t.Fatalf("Unexpected manifest %+v", fetched)
+	}
+
+	var out bytes.Buffer
+	if err := consumer.Get(fetched, nil, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Fatal("Retrieved file differs from the stored one")
+	}
+
+	if _, err := consumer.GetManifest(storage.HashOf([]byte("missing"))); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("Expected not found error for a missing file, got %v", err)
+	}
+
+	// every chunk survives the loss of a node
+	if err := nodes[1].Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	out.Reset()
+	if err := consumer.Get(fetched, nil, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Fatal("Retrieved file differs from the stored one after a node loss")
+	}
+
+}
+
+func TestAppConsumerDHTProviders(t *testing.T) {
+
+	nodes := startTestNetwork(t, 3)
+
+	consumer, err := NewAppConsumer("consumer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Close()
+
+	// the last joined node knows only the node it joined through
+	if err := consumer.Connect(nodes[2].node.Address()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, info := range consumer.Nodes() {
+		if info.ID == nodes[1].ID() {
+			t.Fatal("Expected the holder unknown to the consumer")
+		}
+	}
+
+	data := []byte("chunk held by a node unknown to the consumer")
+	hash := storage.HashOf(data)
+
+	if err := nodes[1].Store().Put(hash, data); err != nil {
+		t.Fatal(err)
+	}
+
+	// the put is announced in the background
+	dht, err := nodes[2].node.DHT()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100; i++ {
+		if providers, err := dht.FindProviders(network.DHTKey(hash)); err == nil && len(providers) > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	fetched, err := consumer.getChunk(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(fetched, data) {
+		t.Fatal("Retrieved chunk differs from the stored one")
+	}
+}
+
+func TestAppConsumerManifestTooLarge(t *testing.T) {
+
+	nodes := startTestNetwork(t, 3)
+
+	consumer, err := NewAppConsumer("consumer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Close()
+
+	if err := consumer.Connect(nodes[0].node.Address()); err != nil {
+		t.Fatal(err)
+	}
+
+	// a Manifest of about 20 chunks fits a single message
+	consumer.maxMessageSize = 8 << 10
+
+	config := PutConfig{
+		Chunker:   storage.ChunkerConfig{ChunkSize: 1024},
+		Placement: storage.PlacementConfig{Replicas: 2},
+	}
+
+	if _, _, err := consumer.Put(bytes.NewReader(make([]byte, 4*1024)), "small", config); err != nil {
+		t.Fatal(err)
+	}
+
+	var used int64
+	for _, node := range nodes {
+		used += node.Store().Usage().Used
+	}
+
+	data := make([]byte, 256*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	_, _, err = consumer.Put(bytes.NewReader(data), "large", config)
+	if _, ok := err.(*storage.ManifestTooLargeError); !ok {
+		t.Fatalf("Expected ManifestTooLargeError, got %v", err)
+	}
+
+	// the upload stopped once the Manifest outgrew the limit
+	var stored int64
+	for _, node := range nodes {
+		stored += node.Store().Usage().Used
+	}
+
+	if stored-used >= int64(len(data)) {
+		t.Fatalf("Expected the upload stopped early, %d bytes stored", stored-used)
+	}
+}
+
+func TestAppConsumerInsufficientNodes(t *testing.T) {
+
+	nodes := startTestNetwork(t, 2)
+
+	consumer, err := NewAppConsumer("consumer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Close()
+
+	if err := consumer.Connect(nodes[0].node.Address()); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = consumer.Put(bytes.NewReader([]byte("vortex")), "file", PutConfig{})
+	if _, ok := err.(*storage.InsufficientNodesError); !ok {
+		t.Fatalf("Expected InsufficientNodesError with %d replicas, got %v", storage.DefaultReplicas, err)
+	}
+
+	for _, node := range nodes {
+		if node.Store().Usage().Used != 0 {
+			t.Fatal("Expected no chunk stored on a refused upload")
+		}
+	}
+
+	_, manifest, err := consumer.Put(bytes.NewReader([]byte("vortex")), "file", PutConfig{Placement: storage.PlacementConfig{AllowDegraded: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(manifest.Chunks[0].Nodes) != len(nodes) {
+		t.Fatalf("Expected degraded chunk on every node, got %v", manifest.Chunks[0].Nodes)
+	}
+}
+
+func TestAppConsumerErasure(t *testing.T) {
+
+	nodes := startTestNetwork(t, 6)
+
+	consumer, err := NewAppConsumer("consumer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Close()
+
+	if err := consumer.Connect(nodes[0].node.Address()); err != nil {
+		t.Fatal(err)
+	}
+
+	data := make([]byte, 32*1024)
+	rand.New(rand.NewSource(2)).Read(data)
+
+	config := PutConfig{
+		Chunker: storage.ChunkerConfig{ChunkSize: 4096},
+		Erasure: &storage.ErasureConfig{Data: 4, Parity: 2},
+	}
+
+	id, manifest, err := consumer.Put(bytes.NewReader(data), "file.bin", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every shard of a chunk is on a distinct node
+	for _, ref := range manifest.Chunks {
+
+		seen := map[string]bool{}
+		for _, shard := range ref.Shards {
+
+			if len(shard.Nodes) != 1 || seen[shard.Nodes[0]] {
+				t.Fatalf("Expected shards of chunk %s on distinct nodes", ref.Hash)
+			}
+
+			seen[shard.Nodes[0]] = true
+		}
+	}
+
+	// stored size is data plus parity, not a multiple of it
+	var used int64
+	for _, node := range nodes {
+		used += node.Store().Usage().Used
+	}
+
+	if limit := int64(len(data))*3/2 + 64*1024; used > limit {
+		t.Fatalf("Expected about %d bytes stored, got %d", len(data)*3/2, used)
+	}
+
+	// any 2 nodes can be lost
+	for _, node := range nodes[4:] {
+		if err := node.Stop(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fetched, err := consumer.GetManifest(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := consumer.Get(fetched, nil, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Fatal("Retrieved file differs from the stored one")
+	}
+
+	// losing more nodes than the parity shards loses the file
+	if err := nodes[3].Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := consumer.Get(fetched, nil, io.Discard); err == nil {
+		t.Fatal("Expected error losing more shards than the parity ones")
+	}
+}
+
+func TestAppConsumerEncryption(t *testing.T) {
+
+	nodes := startTestNetwork(t, 3)
+
+	consumer, err := NewAppConsumer("consumer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Close()
+
+	if err := consumer.Connect(nodes[0].node.Address()); err != nil {
+		t.Fatal(err)
+	}
+
+	data := bytes.Repeat([]byte("plaintext "), 1000)
+	params := storage.KDFParams{N: 1 << 4, R: 1, P: 1}
+
+	config := PutConfig{
+		Chunker: storage.ChunkerConfig{ChunkSize: 1024},
+		Erasure: &storage.ErasureConfig{Data: 2, Parity: 1},
+		Key:     storage.NewPassphraseKeyWithParams("passphrase", params),
+	}
+
+	id, _, err := consumer.Put(bytes.NewReader(data), "secret.txt", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the nodes only hold ciphertext
+	for _, node := range nodes {
+
+		hashes, err := node.Store().List()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, hash := range hashes {
+
+			b, err := node.Store().Get(hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if bytes.Contains(b, []byte("plaintext")) || bytes.Contains(b, []byte("secret.txt")) {
+				t.Fatalf("Node %s stores plaintext", node.ID())
+			}
+		}
+	}
+
+	sealed, err := consumer.GetManifest(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := consumer.Get(sealed, nil, io.Discard); err == nil {
+		t.Fatal("Expected error getting a sealed manifest")
+	}
+
+	if _, _, err := storage.OpenManifest(sealed, storage.NewPassphraseKeyWithParams("wrong", params)); err == nil {
+		t.Fatal("Expected error opening with a wrong passphrase")
+	}
+
+	manifest, fileKey, err := storage.OpenManifest(sealed, storage.NewPassphraseKeyWithParams("passphrase", params))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := consumer.Get(manifest, fileKey, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(out.Bytes(), data) || manifest.Name != "secret.txt" {
+		t.Fatal("Retrieved file differs from the stored one")
+	}
+
+	wrongKey, err := storage.NewFileKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = consumer.Get(manifest, wrongKey, io.Discard)
+	if _, ok := err.(*storage.DecryptionError); !ok {
+		t.Fatalf("Expected DecryptionError with a wrong file key, got %v", err)
+	}
+}
+
+func TestAppConsumerConvergent(t *testing.T) {
+
+	nodes := startTestNetwork(t, 2)
+
+	consumer, err := NewAppConsumer("consumer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Close()
+
+	if err := consumer.Connect(nodes[0].node.Address()); err != nil {
+		t.Fatal(err)
+	}
+
+	data := make([]byte, 8*1024)
+	rand.New(rand.NewSource(3)).Read(data)
+	params := storage.KDFParams{N: 1 << 4, R: 1, P: 1}
+
+	config := PutConfig{
+		Chunker:      storage.ChunkerConfig{ChunkSize: 1024},
+		Placement:    storage.PlacementConfig{Replicas: 2},
+		Key:          storage.NewPassphraseKeyWithParams("passphrase", params),
+		Convergent:   true,
+		TenantSecret: []byte("tenant"),
+	}
+
+	if _, _, err := consumer.Put(bytes.NewReader(data), "first.bin", config); err != nil {
+		t.Fatal(err)
+	}
+
+	used := nodes[0].Store().Usage().Used
+
+	// another user of the tenant uploads the same content under its own passphrase
+	config.Key = storage.NewPassphraseKeyWithParams("another", params)
+
+	id, _, err := consumer.Put(bytes.NewReader(data), "second.bin", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only the second manifest is new
+	sealed, err := consumer.GetManifest(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := sealed.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := nodes[0].Store().Usage().Used; got != used+int64(len(b)) {
+		t.Fatalf("Expected identical chunks to be stored once, used %d then %d", used, got)
+	}
+
+	manifest, fileKey, err := storage.OpenManifest(sealed, config.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := consumer.Get(manifest, fileKey, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Fatal("Retrieved file differs from the stored one")
+	}
+
+	config.Key = nil
+	if _, _, err := consumer.Put(bytes.NewReader(data), "plain.bin", config); err != ErrConvergentWithoutKey {
+		t.Fatalf("Expected ErrConvergentWithoutKey, got %v", err)
+	}
+}
@@ -1,11 +1,14 @@
 package app
 
 import (
+	"context"
 	"fmt"
+	"path/filepath"
 	"sync"
 	"time"
 
 	"github.com/IacopoMelani/vortex/core/network"
+	"github.com/IacopoMelani/vortex/core/storage"
 )
 
 // MARK: AppNode, consts & constructors
@@ -13,28 +16,124 @@ import (
 const (
 	// current Vortex node version
 	VortexNodeVersion = "0.0.0"
+	// time given to in-flight requests to complete on shutdown
+	DefaultShutdownTimeout = 30 * time.Second
+	// number of members the departure of the node is announced to on shutdown
+	DefaultLeaveAnnouncements = 5
+)
+
+// defines the stages of the node shutdown, see ShutdownError
+const (
+	ShutdownStageRPC   = "rpc"
+	ShutdownStageFlush = "flush"
 )
 
 // AppNode - Defines the Application for Vortex Network
 type AppNode struct {
 	AppStandard
 	sync.RWMutex
-	node *network.Node
-	// file system manager
+	node      *network.Node
+	rpcServer *network.RPCServer
+	control   *ControlServer
+	store     storage.ChunkStore
+	state     *nodeState
+	config    AppNodeConfig
+	started   bool
+	startedAt time.Time
+	stopped   bool
+	stop      chan struct{}
 	// communicator
 	// api repository
 	// Blockchain
 }
 
-// NewAppNode - Returns an instance of Application for Vortex Network
-func NewAppNode(name string) *AppNode {
+// AppNodeConfig - Defines the config of the AppNode, zero values are replaced by the defaults
+type AppNodeConfig struct {
+	Node network.NodeConfig
+	// capacity in bytes of the chunk store
+	Capacity int64
+	// time given to in-flight requests to complete on shutdown
+	ShutdownTimeout time.Duration
+	// announces the node on the local network, see network.Discovery
+	Discovery bool
+}
+
+// NewAppNode - Returns an instance of Application for Vortex Network with the default config, see NewAppNodeWithConfig
+func NewAppNode(name string, config network.NodeConfig) (*AppNode, error) {
+	return NewAppNodeWithConfig(name, AppNodeConfig{Node: config})
+}
+
+// NewAppNodeWithConfig - Returns an instance of Application for Vortex Network, the Application ID is the node ID.
+// Chunks are stored in the data directory, in memory if the config has no data directory.
+// The state persisted in the data directory is recovered: the neighbors, the join tokens and the chunk index.
+// The config is persisted for inspection only, it is never recovered so that the config passed is the effective one
+func NewAppNodeWithConfig(name string, config AppNodeConfig) (*AppNode, error) {
 
 	app := NewApp(name, VortexNodeVersion, VortexModeNode)
 
-	return &AppNode{
+	config = config.withDefaults()
+
+	var state *nodeState
+	if dataDir := config.Node.DataDir; dataDir != "" {
+
+		var err error
+		if state, err = openNodeState(dataDir); err != nil {
+			return nil, err
+		}
+
+		if err := state.saveConfig(config); err != nil {
+			return nil, err
+		}
+	}
+
+	node, err := network.NewWithConfig(config.Node)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := node.LoadClusterCredentials(); err != nil {
+		return nil, err
+	}
+
+	var store storage.ChunkStore = storage.NewMemoryChunkStore(config.Capacity)
+	if state != nil {
+
+		store, err = storage.OpenDiskChunkStore(filepath.Join(config.Node.DataDir, storage.ChunkStoreDirName), config.Capacity)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := state.restore(node, store); err != nil {
+			return nil, err
+		}
+	}
+
+	dht, err := node.DHT()
+	if err != nil {
+		return nil, err
+	}
+
+	indexed := newIndexedChunkStore(store, state, dht)
+
+	rpcServer := network.NewRPCServer(node)
+	rpcServer.HandleChunkStore(indexed)
+
+	app.id = node.ID()
+
+	an := &AppNode{
 		AppStandard: *app,
-		node:        nil,
+		node:        node,
+		rpcServer:   rpcServer,
+		store:       indexed,
+		state:       state,
+		config:      config,
+		stop:        make(chan struct{}),
 	}
+
+	// the chunks put are announced in the DHT until the node is stopped
+	go indexed.runAnnouncer(an.stop)
+
+	return an, nil
 }
 
 // MARK: AppNode Application implementation
@@ -69,6 +168,46 @@ func (an *AppNode) Version() string {
 
 // MARK: AppNode exported
 
+// Join - Joins the Vortex network through the node that issued the join token, see network.Node.Join.
+// The DHT is bootstrapped through the joined node
+func (an *AppNode) Join(token, address string) error {
+	an.Lock()
+	defer an.Unlock()
+
+	if _, err := an.node.Join(token, address); err != nil {
+		return err
+	}
+
+	dht, err := an.node.DHT()
+	if err != nil {
+		return err
+	}
+
+	// the joined node is already a contact, a failed lookup only leaves the routing table smaller
+	dht.Bootstrap(nil)
+
+	return nil
+}
+
+// Index - Returns the index of the chunks held by the node, empty for a node without data directory
+func (an *AppNode) Index() *storage.ChunkIndex {
+	an.RLock()
+	defer an.RUnlock()
+
+	if an.state == nil {
+		return storage.NewChunkIndex()
+	}
+
+	return an.state.index
+}
+
+// Node - Returns the network node of the Application
+func (an *AppNode) Node() *network.Node {
+	an.RLock()
+	defer an.RUnlock()
+	return an.node
+}
+
 // NewJoinToken - Return a new NewJoinToken
 func (an *AppNode) NewJoinToken() (*network.JoinToken, error) {
 	an.Lock()
@@ -76,9 +215,301 @@ func (an *AppNode) NewJoinToken() (*network.JoinToken, error) {
 	return an.node.NewJoinToken()
 }
 
-func (an *AppNode) Start() error {
+// NewJoinTokenForAddress - Returns a new join token advertising host and rpcPort, see network.Node.NewJoinTokenForAddress
+func (an *AppNode) NewJoinTokenForAddress(host, rpcPort string) (*network.JoinToken, error) {
+	an.Lock()
+	defer an.Unlock()
+	return an.node.NewJoinTokenForAddress(host, rpcPort)
+}
+
+// Reload - Applies the reloadable values of the config to the running node: the store capacity and the shutdown timeout.
+// The node config can not change while the node is running and is ignored
+func (an *AppNode) Reload(config AppNodeConfig) error {
+	an.Lock()
+	defer an.Unlock()
+
+	config = config.withDefaults()
+
+	if err := an.store.SetCapacity(config.Capacity); err != nil {
+		return err
+	}
+
+	an.config.Capacity = config.Capacity
+	an.config.ShutdownTimeout = config.ShutdownTimeout
+
+	if an.state != nil {
+		return an.state.saveConfig(an.config)
+	}
+
+	return nil
+}
+
+// Shutdown - Gracefully stops the node: the control socket is closed, the RPC server stops accepting connections and in-flight requests are given
+// until ctx is done to complete, the departure is announced to the members, the background tasks are stopped and the
+// state is flushed to the data directory. Calling Shutdown on a stopped node does nothing
+func (an *AppNode) Shutdown(ctx context.Context) error {
+	an.Lock()
+	if an.stopped {
+		an.Unlock()
+		return nil
+	}
+	an.stopped = true
+	started := an.started
+	rpcServer := an.rpcServer
+	control := an.control
+	an.Unlock()
+
+	// the local control requests are not drained, the CLI gets a closed connection
+	if control != nil {
+		control.Close()
+	}
+
+	var shutdownErr error
+	if err := rpcServer.ShutdownContext(ctx); err != nil {
+		shutdownErr = NewShutdownError(ShutdownStageRPC, err)
+	}
+
+	// only a started node takes part in the membership
+	if started {
+		if membership, err := an.node.Membership(); err == nil {
+			membership.Leave(DefaultLeaveAnnouncements)
+		}
+	}
+
+	close(an.stop)
+
+	if dht, err := an.node.DHT(); err == nil {
+		dht.Close()
+	}
+
+	if err := an.flush(); err != nil {
+		return NewShutdownError(ShutdownStageFlush, err)
+	}
+
+	return shutdownErr
+}
+
+// Start - Starts the node serving RPC requests on its rpcPort and control requests on the control socket of its data
+// directory, see ControlServer. Blocks until ctx is done or the server fails.
+// When ctx is done the node is shut down within the shutdown timeout, see Shutdown
+func (an *AppNode) Start(ctx context.Context) error {
+
+	// the first deployed node of a cluster acts as cluster CA, a former member with expired credentials
+	// starts outside the cluster instead of forking a new one
+	if !an.node.IsMember() && !an.node.NeedsJoin() {
+		if err := an.node.BootstrapClusterCA(); err != nil {
+			return err
+		}
+	}
+
+	membership, err := an.node.Membership()
+	if err != nil {
+		return err
+	}
+
+	an.RLock()
+	discoveryEnabled := an.config.Discovery
+	dataDir := an.config.Node.DataDir
+	an.RUnlock()
+
+	// a node without data directory has no control socket
+	var control *ControlServer
+	if dataDir != "" {
+
+		control = an.newControlServer()
+		if err := control.Listen(ControlSocketPath(dataDir)); err != nil {
+			return err
+		}
+	}
+
+	var discovery *network.Discovery
+	if discoveryEnabled {
+		if discovery, err = network.ListenDiscovery(network.DefaultDiscoveryGroup); err != nil {
+			if control != nil {
+				control.Close()
+			}
+			return err
+		}
+	}
+
+	an.Lock()
+	an.started = true
+	an.startedAt = time.Now().UTC()
+	an.control = control
+	rpcServer := an.rpcServer
+	an.Unlock()
+
+	events, unsubscribe := membership.Subscribe()
+
+	go an.watchMembership(events, unsubscribe)
+	go membership.Run(an.stop)
+
+	go an.node.RunJoinTokenSweeper(network.DefaultJoinTokenSweepInterval*time.Second, an.stop)
+
+	if dht, err := an.node.DHT(); err == nil {
+		go dht.RunRepublisher(network.DefaultDHTRepublishInterval, an.heldKeys, an.stop)
+	}
+	go an.node.RunCertificateRenewer(network.DefaultCertificateRenewInterval*time.Second, an.stop)
+
+	if discovery != nil {
+		go discovery.RunAnnouncer(an.node, network.DefaultDiscoveryInterval, an.stop)
+	}
+
+	if control != nil {
+		go control.Serve()
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- rpcServer.ListenAndServe()
+	}()
+
+	select {
+	case err := <-served:
+		if err == network.ErrRPCServerClosed {
+			return nil
+		}
+		an.Shutdown(context.Background())
+		return err
+
+	case <-ctx.Done():
+	}
+
+	an.RLock()
+	timeout := an.config.ShutdownTimeout
+	an.RUnlock()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return an.Shutdown(shutdownCtx)
+}
+
+// Subscribe - Returns a channel receiving the membership events of the cluster and the func to unsubscribe,
+// see network.Membership.Subscribe
+func (an *AppNode) Subscribe() (<-chan network.MembershipEvent, func(), error) {
+
+	membership, err := an.node.Membership()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	events, unsubscribe := membership.Subscribe()
+
+	return events, unsubscribe, nil
+}
+
+// Store - Returns the chunk store of the node
+func (an *AppNode) Store() storage.ChunkStore {
+	an.RLock()
+	defer an.RUnlock()
+	return an.store
+}
+
+// Stop - Stops the node waiting for in-flight requests to complete, see Shutdown
+func (an *AppNode) Stop() error {
+	return an.Shutdown(context.Background())
+}
+
+// MARK: AppNode unexported
+
+// flush - Snapshots the state and closes the state store, a node without data directory has nothing to flush
+func (an *AppNode) flush() error {
+
+	an.RLock()
+	state := an.state
+	an.RUnlock()
+
+	if state == nil {
+		return nil
+	}
+
+	// the neighbors removed by the departure are kept for the next run
+	an.node.SetObserver(nil)
+
+	return state.store.Close()
+}
+
+// heldKeys - Returns the DHT keys of the chunks held by the node, announced by the DHT republisher
+func (an *AppNode) heldKeys() []network.DHTKey {
+
+	hashes, err := an.Store().List()
+	if err != nil {
+		return nil
+	}
+
+	keys := make([]network.DHTKey, len(hashes))
+	for i, hash := range hashes {
+		keys[i] = network.DHTKey(hash)
+	}
+
+	return keys
+}
+
+// watchMembership - Keeps the neighbors in sync with the alive members until the node is stopped:
+// dead and left members are removed, members discovered by gossip are added
+func (an *AppNode) watchMembership(events <-chan network.MembershipEvent, unsubscribe func()) {
+
+	defer unsubscribe()
+
 	for {
-		time.Sleep(1 * time.Second)
-		fmt.Println("Do something")
+		select {
+		case <-an.stop:
+			return
+		case event := <-events:
+
+			info := event.Member.Info
+
+			switch event.Member.State {
+			case network.MemberAlive:
+				an.node.AddNeighbor(network.NewNodeFromInfo(info))
+
+			case network.MemberDead, network.MemberLeft:
+				an.node.RemoveNeighbor(info.ID)
+
+				if dht, err := an.node.DHT(); err == nil {
+					dht.Table().Remove(info.ID)
+				}
+			}
+		}
+	}
+}
+
+// MARK: AppNodeConfig unexported
+
+// withDefaults - Returns a copy of the config with the zero values replaced by the defaults
+func (c AppNodeConfig) withDefaults() AppNodeConfig {
+
+	if c.Capacity <= 0 {
+		c.Capacity = storage.DefaultChunkStoreCapacity
+	}
+
+	if c.ShutdownTimeout <= 0 {
+		c.ShutdownTimeout = DefaultShutdownTimeout
 	}
+
+	return c
+}
+
+// MARK: ShutdownError
+
+// ShutdownError - Defines error for a node shutdown failed at the stage passed, see ShutdownStage*
+type ShutdownError struct {
+	Stage string
+	err   error
+}
+
+// NewShutdownError - Returns a new instance of ShutdownError
+func NewShutdownError(stage string, err error) error {
+	return &ShutdownError{Stage: stage, err: err}
+}
+
+// Error - Implements error interface
+func (e *ShutdownError) Error() string {
+	return fmt.Sprintf("Shutdown failed at stage %s: %s", e.Stage, e.err)
+}
+
+// Unwrap - Returns the error causing the shutdown failure
+func (e *ShutdownError) Unwrap() error {
+	return e.err
 }
@@ -0,0 +1,219 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IacopoMelani/vortex/core/network"
+	"github.com/IacopoMelani/vortex/core/storage"
+	"github.com/IacopoMelani/vortex/utils"
+)
+
+// MARK: AppNodeSettings, consts & constructors
+
+// defines the IP families of the IPFamily setting
+const (
+	IPFamilyIPv4 = "ipv4"
+	IPFamilyIPv6 = "ipv6"
+)
+
+// AppNodeSettings - Defines the user-facing settings of a node as written in a config file, environment variables or flags.
+// Empty values are not set, settings from different sources are layered with Merge and converted with Config
+type AppNodeSettings struct {
+	// name of the node, the hostname by default
+	Name string `json:"name"`
+	// address the RPC server listens on, host:port or :port
+	Listen string `json:"listen"`
+	// address advertised to the other nodes, host:port or host, detected by default
+	AdvertiseAddr string `json:"advertise_addr"`
+	// interface the advertised address is detected on
+	Interface string `json:"interface"`
+	// network the advertised address is detected in, e.g. 10.0.0.0/8 or 2001:db8::/32
+	CIDR string `json:"cidr"`
+	// family preferred detecting the advertised address on dual-stack hosts, ipv4 or ipv6
+	IPFamily string `json:"ip_family"`
+	// data directory of the node
+	DataDir string `json:"data_dir"`
+	// capacity of the chunk store, in bytes with an optional unit, e.g. 10GiB
+	Capacity string `json:"capacity"`
+	// time given to in-flight requests to complete on shutdown, e.g. 30s
+	ShutdownTimeout string `json:"shutdown_timeout"`
+	// announces the node on the local network over multicast, true or false
+	Discovery string `json:"discovery"`
+}
+
+// DefaultAppNodeSettings - Returns the settings used when no source sets them
+func DefaultAppNodeSettings() AppNodeSettings {
+	return AppNodeSettings{
+		Listen:          network.DefaultRPCPort,
+		DataDir:         DefaultDataDir(),
+		Capacity:        fmt.Sprintf("%dGiB", storage.DefaultChunkStoreCapacity>>30),
+		IPFamily:        IPFamilyIPv4,
+		ShutdownTimeout: DefaultShutdownTimeout.String(),
+		Discovery:       "false",
+	}
+}
+
+// LoadAppNodeSettings - Returns the settings of the JSON config file, unknown settings are rejected
+func LoadAppNodeSettings(filename string) (AppNodeSettings, error) {
+
+	var settings AppNodeSettings
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return settings, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(&settings); err != nil {
+		return settings, NewInvalidAppNodeSettingsError(filename, err.Error())
+	}
+
+	return settings, nil
+}
+
+// MARK: AppNodeSettings exported
+
+// Config - Returns the AppNodeConfig of the settings, values not set are left to the defaults of the node.
+// Without an advertised address the node advertises the port it listens on
+func (s AppNodeSettings) Config() (AppNodeConfig, error) {
+
+	config := AppNodeConfig{Node: network.NodeConfig{Name: s.Name, DataDir: s.DataDir}}
+
+	if config.Node.DataDir == "" {
+		config.Node.DataDir = DefaultDataDir()
+	}
+
+	if s.Listen != "" {
+
+		host, port, err := net.SplitHostPort(s.Listen)
+		if err != nil {
+			return config, NewInvalidAppNodeSettingsError("listen", err.Error())
+		}
+
+		config.Node.ListenAddr = s.Listen
+		config.Node.RPCPort = ":" + port
+
+		// a node listening on a single interface advertises it
+		if ip := net.ParseIP(host); ip != nil && !ip.IsUnspecified() {
+			config.Node.IP = host
+		}
+	}
+
+	if s.AdvertiseAddr != "" {
+
+		host, port, err := net.SplitHostPort(s.AdvertiseAddr)
+		if err != nil {
+			host, port = strings.Trim(s.AdvertiseAddr, "[]"), ""
+		}
+
+		if host == "" {
+			return config, NewInvalidAppNodeSettingsError("advertise_addr", "missing host")
+		}
+
+		config.Node.IP = host
+		if port != "" {
+			config.Node.RPCPort = ":" + port
+		}
+	}
+
+	config.Node.Interface = s.Interface
+
+	if s.CIDR != "" {
+
+		if _, _, err := net.ParseCIDR(s.CIDR); err != nil {
+			return config, NewInvalidAppNodeSettingsError("cidr", err.Error())
+		}
+
+		config.Node.CIDR = s.CIDR
+	}
+
+	switch s.IPFamily {
+	case "", IPFamilyIPv4:
+	case IPFamilyIPv6:
+		config.Node.PreferIPv6 = true
+	default:
+		return config, NewInvalidAppNodeSettingsError("ip_family", "expected "+IPFamilyIPv4+" or "+IPFamilyIPv6)
+	}
+
+	if s.Capacity != "" {
+
+		capacity, err := utils.ParseByteSize(s.Capacity)
+		if err != nil || capacity <= 0 {
+			return config, NewInvalidAppNodeSettingsError("capacity", "expected a positive size, e.g. 10GiB")
+		}
+
+		config.Capacity = capacity
+	}
+
+	if s.ShutdownTimeout != "" {
+
+		timeout, err := time.ParseDuration(s.ShutdownTimeout)
+		if err != nil || timeout <= 0 {
+			return config, NewInvalidAppNodeSettingsError("shutdown_timeout", "expected a positive duration, e.g. 30s")
+		}
+
+		config.ShutdownTimeout = timeout
+	}
+
+	if s.Discovery != "" {
+
+		discovery, err := strconv.ParseBool(s.Discovery)
+		if err != nil {
+			return config, NewInvalidAppNodeSettingsError("discovery", "expected true or false")
+		}
+
+		config.Discovery = discovery
+	}
+
+	return config, nil
+}
+
+// Merge - Returns the settings with the values set by over replacing the ones of s
+func (s AppNodeSettings) Merge(over AppNodeSettings) AppNodeSettings {
+
+	merge := func(value *string, over string) {
+		if over != "" {
+			*value = over
+		}
+	}
+
+	merge(&s.Name, over.Name)
+	merge(&s.Listen, over.Listen)
+	merge(&s.AdvertiseAddr, over.AdvertiseAddr)
+	merge(&s.Interface, over.Interface)
+	merge(&s.CIDR, over.CIDR)
+	merge(&s.IPFamily, over.IPFamily)
+	merge(&s.DataDir, over.DataDir)
+	merge(&s.Capacity, over.Capacity)
+	merge(&s.ShutdownTimeout, over.ShutdownTimeout)
+	merge(&s.Discovery, over.Discovery)
+
+	return s
+}
+
+// MARK: InvalidAppNodeSettingsError
+
+// InvalidAppNodeSettingsError - Defines error for a node setting that can not be applied
+type InvalidAppNodeSettingsError struct {
+	setting string
+	reason  string
+}
+
+// NewInvalidAppNodeSettingsError - Returns a new instance of InvalidAppNodeSettingsError
+func NewInvalidAppNodeSettingsError(setting, reason string) error {
+	return &InvalidAppNodeSettingsError{setting: setting, reason: reason}
+}
+
+// Error - Implements error interface
+func (e *InvalidAppNodeSettingsError) Error() string {
+	return fmt.Sprintf("Invalid node setting %s: %s", e.setting, e.reason)
+}
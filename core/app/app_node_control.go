@@ -0,0 +1,227 @@
+package app
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/IacopoMelani/vortex/core/network"
+	"github.com/IacopoMelani/vortex/core/storage"
+)
+
+// MARK: consts
+
+// defines the control methods of a running AppNode
+const (
+	ControlMethodJoinToken = "join-token"
+	ControlMethodPeers     = "peers"
+	ControlMethodStatus    = "status"
+)
+
+const (
+	// time given to a neighbor to answer the peers control method
+	DefaultPeerQueryTimeout = 2 * time.Second
+	// state of a neighbor not known by the membership
+	PeerStateUnknown = "unknown"
+)
+
+// MARK: control payloads
+
+// JoinTokenRequest - Defines the payload of the join-token control method, empty values are replaced by the node address
+type JoinTokenRequest struct {
+	Host    string `json:"host,omitempty"`
+	RPCPort string `json:"rpc_port,omitempty"`
+}
+
+// JoinTokenResponse - Defines the payload returned by the join-token control method, Token is the encoded JoinToken
+type JoinTokenResponse struct {
+	Token   string    `json:"token"`
+	Expires time.Time `json:"expires"`
+}
+
+// NodeStatus - Defines the payload returned by the status control method.
+// ClusterSize counts the node and the members not dead or left
+type NodeStatus struct {
+	ID               string                  `json:"id"`
+	Name             string                  `json:"name"`
+	Version          string                  `json:"version"`
+	Cluster          string                  `json:"cluster"`
+	ListenAddress    string                  `json:"listen_address"`
+	AdvertiseAddress string                  `json:"advertise_address"`
+	StartedAt        time.Time               `json:"started_at"`
+	UptimeSeconds    int64                   `json:"uptime_seconds"`
+	Storage          storage.ChunkStoreUsage `json:"storage"`
+	ClusterSize      int                     `json:"cluster_size"`
+}
+
+// PeerStatus - Defines a neighbor of the node in the payload returned by the peers control method.
+// State is the membership state of the neighbor, the values the neighbor did not answer are nil
+type PeerStatus struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	Address     string     `json:"address"`
+	State       string     `json:"state"`
+	LastSeen    *time.Time `json:"last_seen"`
+	RTTMillis   *float64   `json:"rtt_ms"`
+	StoredBytes *int64     `json:"stored_bytes"`
+}
+
+// PeersResponse - Defines the payload returned by the peers control method
+type PeersResponse struct {
+	Peers []PeerStatus `json:"peers"`
+}
+
+// MARK: ControlClient AppNode methods
+
+// JoinToken - Returns a join token registered in the running node, see JoinTokenRequest
+func (c *ControlClient) JoinToken(req JoinTokenRequest) (*JoinTokenResponse, error) {
+	var res JoinTokenResponse
+	if err := c.Call(ControlMethodJoinToken, req, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Peers - Returns the neighbors of the running node
+func (c *ControlClient) Peers() ([]PeerStatus, error) {
+	var res PeersResponse
+	err := c.Call(ControlMethodPeers, nil, &res)
+	return res.Peers, err
+}
+
+// Status - Returns the status of the running node
+func (c *ControlClient) Status() (*NodeStatus, error) {
+	var res NodeStatus
+	if err := c.Call(ControlMethodStatus, nil, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// MARK: AppNode control unexported
+
+// newControlServer - Returns the control server of the node with the handlers of the ControlMethod* methods
+func (an *AppNode) newControlServer() *ControlServer {
+
+	s := NewControlServer()
+
+	s.Handle(ControlMethodJoinToken, an.handleControlJoinToken)
+	s.Handle(ControlMethodPeers, an.handleControlPeers)
+	s.Handle(ControlMethodStatus, an.handleControlStatus)
+
+	return s
+}
+
+func (an *AppNode) handleControlJoinToken(payload json.RawMessage) (interface{}, error) {
+
+	var req JoinTokenRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return nil, err
+	}
+
+	jt, err := an.NewJoinTokenForAddress(req.Host, req.RPCPort)
+	if err != nil {
+		return nil, err
+	}
+
+	return JoinTokenResponse{Token: jt.String(), Expires: jt.Exp()}, nil
+}
+
+func (an *AppNode) handleControlPeers(payload json.RawMessage) (interface{}, error) {
+
+	neighbors := an.node.Neighbors()
+	peers := make([]PeerStatus, len(neighbors))
+
+	var wg sync.WaitGroup
+	for i, neighbor := range neighbors {
+
+		wg.Add(1)
+		go func(i int, neighbor *network.Node) {
+			defer wg.Done()
+			peers[i] = an.peerStatus(neighbor)
+		}(i, neighbor)
+	}
+
+	wg.Wait()
+
+	sort.Slice(peers, func(i, j int) bool { return peers[i].ID < peers[j].ID })
+
+	return PeersResponse{Peers: peers}, nil
+}
+
+func (an *AppNode) handleControlStatus(payload json.RawMessage) (interface{}, error) {
+
+	an.RLock()
+	startedAt := an.startedAt
+	store := an.store
+	an.RUnlock()
+
+	status := NodeStatus{
+		ID:               an.node.ID(),
+		Name:             an.node.Name(),
+		Version:          an.Version(),
+		Cluster:          an.node.ClusterCAInfo().ID,
+		ListenAddress:    an.node.ListenAddress(),
+		AdvertiseAddress: an.node.Address(),
+		StartedAt:        startedAt,
+		UptimeSeconds:    int64(time.Since(startedAt).Seconds()),
+		Storage:          store.Usage(),
+		ClusterSize:      1,
+	}
+
+	if membership, err := an.node.Membership(); err == nil {
+		for _, member := range membership.Members() {
+			if member.State == network.MemberAlive || member.State == network.MemberSuspect {
+				status.ClusterSize++
+			}
+		}
+	}
+
+	return status, nil
+}
+
+// peerStatus - Returns the status of the neighbor, the neighbor is pinged and asked for its store usage
+func (an *AppNode) peerStatus(neighbor *network.Node) PeerStatus {
+
+	peer := PeerStatus{ID: neighbor.ID(), Name: neighbor.Name(), Address: neighbor.Address(), State: PeerStateUnknown}
+
+	if membership, err := an.node.Membership(); err == nil {
+		if member, ok := membership.Member(peer.ID); ok {
+
+			peer.State = member.State
+			if !member.LastSeen.IsZero() {
+				lastSeen := member.LastSeen.UTC()
+				peer.LastSeen = &lastSeen
+			}
+		}
+	}
+
+	transport, err := an.node.Transport()
+	if err != nil {
+		return peer
+	}
+
+	client, err := network.DialRPCWithTimeout(transport, neighbor.Address(), peer.ID, false, DefaultPeerQueryTimeout)
+	if err != nil {
+		return peer
+	}
+	defer client.Close()
+
+	client.SetDeadline(time.Now().Add(DefaultPeerQueryTimeout))
+
+	rtt, err := client.Ping()
+	if err != nil {
+		return peer
+	}
+
+	now := time.Now().UTC()
+	rttMillis := float64(rtt.Microseconds()) / 1000
+	peer.LastSeen, peer.RTTMillis = &now, &rttMillis
+
+	if usage, err := client.Usage(); err == nil {
+		peer.StoredBytes = &usage.Used
+	}
+
+	return peer
+}
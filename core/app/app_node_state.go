@@ -0,0 +1,249 @@
+package app
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/IacopoMelani/vortex/core/network"
+	"github.com/IacopoMelani/vortex/core/storage"
+)
+
+// MARK: consts
+
+// defines the keys of the node state, see nodeState
+const (
+	stateKeyConfig          = "config"
+	stateKeyJoinTokenPrefix = "join-token/"
+	stateKeyNeighborPrefix  = "neighbor/"
+	stateKeyChunkPrefix     = "chunk/"
+)
+
+// chunkAnnounceQueueSize - Defines the chunks put waiting to be announced in the DHT, see indexedChunkStore
+const chunkAnnounceQueueSize = 1024
+
+// MARK: nodeState & constructors
+
+// nodeState - Defines the persisted state of an AppNode: neighbors, join tokens, chunk index and config.
+// It observes the node and the chunk store recording every change in the state store
+type nodeState struct {
+	store *storage.StateStore
+	index *storage.ChunkIndex
+	self  string
+}
+
+// persistedNodeConfig - Defines the effective config of the last start, kept for inspection and never recovered
+type persistedNodeConfig struct {
+	Name            string        `json:"name,omitempty"`
+	IP              string        `json:"ip,omitempty"`
+	RPCPort         string        `json:"rpc_port,omitempty"`
+	ListenAddr      string        `json:"listen_addr,omitempty"`
+	Interface       string        `json:"interface,omitempty"`
+	CIDR            string        `json:"cidr,omitempty"`
+	Capacity        int64         `json:"capacity,omitempty"`
+	ShutdownTimeout time.Duration `json:"shutdown_timeout,omitempty"`
+}
+
+// openNodeState - Returns the state persisted in the data directory
+func openNodeState(dataDir string) (*nodeState, error) {
+
+	store, err := storage.OpenStateStore(filepath.Join(dataDir, storage.StateDirName))
+	if err != nil {
+		return nil, err
+	}
+
+	return &nodeState{store: store, index: storage.NewChunkIndex()}, nil
+}
+
+// MARK: nodeState network.NodeObserver implementation
+
+// JoinTokenAdded - Implements network.NodeObserver interface
+func (s *nodeState) JoinTokenAdded(jt *network.JoinToken) {
+	s.store.Put(stateKeyJoinTokenPrefix+jt.ID(), jt.String())
+}
+
+// JoinTokenRemoved - Implements network.NodeObserver interface
+func (s *nodeState) JoinTokenRemoved(id string) {
+	s.store.Delete(stateKeyJoinTokenPrefix + id)
+}
+
+// NeighborAdded - Implements network.NodeObserver interface
+func (s *nodeState) NeighborAdded(info network.NodeInfo) {
+	s.store.Put(stateKeyNeighborPrefix+info.ID, info)
+}
+
+// NeighborRemoved - Implements network.NodeObserver interface
+func (s *nodeState) NeighborRemoved(id string) {
+	s.store.Delete(stateKeyNeighborPrefix + id)
+}
+
+// MARK: nodeState unexported
+
+// recordChunk - Records in the chunk index the chunk held by the node, or not held anymore
+func (s *nodeState) recordChunk(hash storage.Hash, held bool) error {
+
+	if held {
+		s.index.Record(hash, s.self)
+	} else {
+		s.index.Remove(hash, s.self)
+	}
+
+	locations := s.index.Locations(hash)
+	if len(locations) == 0 {
+		return s.store.Delete(stateKeyChunkPrefix + hash.String())
+	}
+
+	return s.store.Put(stateKeyChunkPrefix+hash.String(), locations)
+}
+
+// restore - Restores the neighbors and join tokens on the node and the chunk index of the chunks still in the store.
+// Expired tokens and chunks missing from the store are removed from the state
+func (s *nodeState) restore(node *network.Node, chunks storage.ChunkStore) error {
+
+	s.self = node.ID()
+
+	for _, key := range s.store.Keys(stateKeyNeighborPrefix) {
+
+		var info network.NodeInfo
+		if _, err := s.store.Get(key, &info); err != nil {
+			return err
+		}
+
+		if info.ID != s.self {
+			node.AddNeighbor(network.NewNodeFromInfo(info))
+		}
+	}
+
+	for _, key := range s.store.Keys(stateKeyJoinTokenPrefix) {
+
+		var encoded string
+		if _, err := s.store.Get(key, &encoded); err != nil {
+			return err
+		}
+
+		jt, err := network.ParseJoinToken(encoded)
+		if err == nil {
+			err = node.RestoreJoinToken(jt)
+		}
+
+		if err != nil {
+			if err := s.store.Delete(key); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, key := range s.store.Keys(stateKeyChunkPrefix) {
+
+		hash, err := storage.ParseHash(strings.TrimPrefix(key, stateKeyChunkPrefix))
+		if err != nil {
+			return err
+		}
+
+		var locations []string
+		if _, err := s.store.Get(key, &locations); err != nil {
+			return err
+		}
+
+		for _, id := range locations {
+			s.index.Record(hash, id)
+		}
+
+		if held, err := chunks.Has(hash); err != nil {
+			return err
+		} else if !held {
+			if err := s.recordChunk(hash, false); err != nil {
+				return err
+			}
+		}
+	}
+
+	node.SetObserver(s)
+
+	return nil
+}
+
+// saveConfig - Persists the effective config of the node
+func (s *nodeState) saveConfig(config AppNodeConfig) error {
+	return s.store.Put(stateKeyConfig, persistedNodeConfig{
+		Name:            config.Node.Name,
+		IP:              config.Node.IP,
+		RPCPort:         config.Node.RPCPort,
+		ListenAddr:      config.Node.ListenAddr,
+		Interface:       config.Node.Interface,
+		CIDR:            config.Node.CIDR,
+		Capacity:        config.Capacity,
+		ShutdownTimeout: config.ShutdownTimeout,
+	})
+}
+
+// MARK: indexedChunkStore & constructors
+
+// indexedChunkStore - Defines a ChunkStore announcing the chunks put in the DHT and recording the chunks put and deleted
+// in the node state, a node without data directory has no state
+type indexedChunkStore struct {
+	storage.ChunkStore
+	state     *nodeState
+	dht       *network.DHT
+	announced chan storage.Hash
+}
+
+// newIndexedChunkStore - Returns the store passed announcing its chunks in the DHT and recording them in the state, if any
+func newIndexedChunkStore(store storage.ChunkStore, state *nodeState, dht *network.DHT) *indexedChunkStore {
+	return &indexedChunkStore{
+		ChunkStore: store,
+		state:      state,
+		dht:        dht,
+		announced:  make(chan storage.Hash, chunkAnnounceQueueSize),
+	}
+}
+
+// MARK: indexedChunkStore storage.ChunkStore implementation
+
+// Delete - Implements storage.ChunkStore interface
+func (s *indexedChunkStore) Delete(hash storage.Hash) error {
+
+	if err := s.ChunkStore.Delete(hash); err != nil {
+		return err
+	}
+
+	if s.state == nil {
+		return nil
+	}
+
+	return s.state.recordChunk(hash, false)
+}
+
+// Put - Implements storage.ChunkStore interface
+func (s *indexedChunkStore) Put(hash storage.Hash, data []byte) error {
+
+	if err := s.ChunkStore.Put(hash, data); err != nil {
+		return err
+	}
+
+	// the announcement does not hold the put, a chunk dropped from a full queue is announced by the republisher
+	select {
+	case s.announced <- hash:
+	default:
+	}
+
+	if s.state == nil {
+		return nil
+	}
+
+	return s.state.recordChunk(hash, true)
+}
+
+// MARK: indexedChunkStore unexported
+
+// runAnnouncer - Announces in the DHT the chunks put, one at a time, until stop is closed
+func (s *indexedChunkStore) runAnnouncer(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case hash := <-s.announced:
+			s.dht.Provide(network.DHTKey(hash))
+		}
+	}
+}
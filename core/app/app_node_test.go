@@ -0,0 +1,382 @@
+package app
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/IacopoMelani/vortex/core/network"
+	"github.com/IacopoMelani/vortex/core/storage"
+)
+
+func TestAppNodeStartShutdown(t *testing.T) {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener.Close()
+
+	dataDir := t.TempDir()
+
+	appNode, err := NewAppNodeWithConfig("node", AppNodeConfig{
+		Node:            network.NodeConfig{IP: "127.0.0.1", RPCPort: ":" + port, DataDir: dataDir},
+		ShutdownTimeout: time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	neighbor := startTestAppNode(t)
+	appNode.node.AddNeighbor(network.NewNodeFromInfo(neighbor.node.Info()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	started := make(chan error)
+	go func() { started <- appNode.Start(ctx) }()
+
+	// the node serves requests until ctx is done
+	var client *network.RPCClient
+	for i := 0; i < 50 && client == nil; i++ {
+		client, _ = appNode.node.DialRPC("127.0.0.1:"+port, appNode.ID())
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if client == nil {
+		t.Fatal("Expected started node serving requests")
+	}
+	defer client.Close()
+
+	if _, err := client.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+
+	if err := <-started; err != nil {
+		t.Fatalf("Expected clean shutdown, got %v", err)
+	}
+
+	if _, err := appNode.node.DialRPC("127.0.0.1:"+port, appNode.ID()); err == nil {
+		t.Fatal("Expected error dialing a stopped node")
+	}
+
+	if err := appNode.Stop(); err != nil {
+		t.Fatalf("Expected stopping a stopped node to do nothing, got %v", err)
+	}
+
+	// the neighbors are flushed and loaded again by the next run
+	restarted, err := NewAppNodeWithConfig("node", AppNodeConfig{Node: network.NodeConfig{DataDir: dataDir}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restarted.Stop()
+
+	if neighbors := restarted.node.Neighbors(); len(neighbors) != 1 || neighbors[0].ID() != neighbor.ID() {
+		t.Fatalf("Expected neighbor %s loaded on restart", neighbor.ID())
+	}
+}
+
+func TestAppNodeStateRecovery(t *testing.T) {
+
+	dataDir := t.TempDir()
+
+	appNode, err := NewAppNodeWithConfig("node", AppNodeConfig{
+		Node:     network.NodeConfig{IP: "127.0.0.1", RPCPort: ":7414", DataDir: dataDir},
+		Capacity: 1 << 20,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jt, err := appNode.NewJoinToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	consumed, err := appNode.NewJoinToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := appNode.node.ConsumeJoinToken(consumed.Value()); err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("chunk held by the node")
+	if err := appNode.Store().Put(storage.HashOf(data), data); err != nil {
+		t.Fatal(err)
+	}
+
+	neighbor, err := network.NewNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := appNode.node.AddNeighbor(neighbor); err != nil {
+		t.Fatal(err)
+	}
+
+	// the node crashes, its state store is not closed
+	restarted, err := NewAppNodeWithConfig("node", AppNodeConfig{Node: network.NodeConfig{DataDir: dataDir}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restarted.Stop()
+
+	// the config passed is the effective one, the persisted config is only overwritten
+	if restarted.node.Address() == "127.0.0.1:7414" || restarted.Store().Usage().Capacity != storage.DefaultChunkStoreCapacity {
+		t.Fatalf("Expected config not recovered, got address %s and capacity %d", restarted.node.Address(), restarted.Store().Usage().Capacity)
+	}
+
+	var persisted persistedNodeConfig
+	if _, err := restarted.state.store.Get(stateKeyConfig, &persisted); err != nil || persisted.Capacity != storage.DefaultChunkStoreCapacity || persisted.ShutdownTimeout != DefaultShutdownTimeout {
+		t.Fatalf("Expected effective config persisted, got %+v", persisted)
+	}
+
+	if neighbors := restarted.node.Neighbors(); len(neighbors) != 1 || neighbors[0].ID() != neighbor.ID() {
+		t.Fatal("Expected neighbor recovered")
+	}
+
+	if tokens := restarted.node.JoinTokens(); len(tokens) != 1 || tokens[0].ID() != jt.ID() {
+		t.Fatalf("Expected only the issued token recovered, got %d tokens", len(tokens))
+	}
+
+	if locations := restarted.Index().Locations(storage.HashOf(data)); len(locations) != 1 || locations[0] != restarted.ID() {
+		t.Fatalf("Expected chunk indexed on the node, got %v", locations)
+	}
+}
+
+func TestAppNodeReload(t *testing.T) {
+
+	appNode := startTestAppNode(t)
+
+	if err := appNode.Reload(AppNodeConfig{Capacity: 1 << 20, ShutdownTimeout: time.Second}); err != nil {
+		t.Fatal(err)
+	}
+
+	if usage := appNode.Store().Usage(); usage.Capacity != 1<<20 {
+		t.Fatalf("Expected reloaded capacity %d, got %d", 1<<20, usage.Capacity)
+	}
+
+	if appNode.config.ShutdownTimeout != time.Second {
+		t.Fatalf("Expected reloaded shutdown timeout, got %s", appNode.config.ShutdownTimeout)
+	}
+}
+
+func TestAppNodeControl(t *testing.T) {
+
+	dataDir := t.TempDir()
+
+	appNode, err := NewAppNodeWithConfig("node", AppNodeConfig{
+		Node:            network.NodeConfig{IP: "127.0.0.1", ListenAddr: "127.0.0.1:0", DataDir: dataDir},
+		ShutdownTimeout: time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	started := make(chan error)
+	go func() { started <- appNode.Start(ctx) }()
+
+	var client *ControlClient
+	for i := 0; i < 50 && client == nil; i++ {
+		client, _ = DialControl(dataDir)
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if client == nil {
+		t.Fatal("Expected started node serving control requests")
+	}
+	defer client.Close()
+
+	if info, err := os.Stat(ControlSocketPath(dataDir)); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("Expected control socket accessible by the owner only, got %v", info.Mode())
+	}
+
+	// the token is registered in the running node
+	res, err := client.JoinToken(JoinTokenRequest{Host: "203.0.113.7", RPCPort: ":7000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jt, err := network.ParseJoinToken(res.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if jt.Address() != "203.0.113.7:7000" {
+		t.Fatalf("Expected the requested address in the token, got %s", jt.Address())
+	}
+
+	if _, err := appNode.node.ValidateJoinToken(jt.Value()); err != nil {
+		t.Fatalf("Expected token accepted by the running node, got %v", err)
+	}
+
+	status, err := client.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status.ID != appNode.ID() || status.Version != VortexNodeVersion || status.StartedAt.IsZero() {
+		t.Fatalf("Unexpected status %+v", status)
+	}
+
+	if status.Cluster != appNode.ID() || status.ClusterSize != 1 || status.Storage.Capacity != storage.DefaultChunkStoreCapacity {
+		t.Fatalf("Expected a single node cluster with the default capacity, got %+v", status)
+	}
+
+	// the reachable neighbors are pinged and report their store usage
+	neighbor := startTestAppNode(t)
+
+	data := []byte("chunk held by the neighbor")
+	if err := neighbor.Store().Put(storage.HashOf(data), data); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := appNode.node.AddNeighbor(network.NewNodeFromInfo(neighbor.node.Info())); err != nil {
+		t.Fatal(err)
+	}
+
+	peers, err := client.Peers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(peers) != 1 || peers[0].ID != neighbor.ID() || peers[0].RTTMillis == nil || peers[0].LastSeen == nil {
+		t.Fatalf("Expected the neighbor pinged, got %+v", peers)
+	}
+
+	if peers[0].StoredBytes == nil || *peers[0].StoredBytes != int64(len(data)) {
+		t.Fatalf("Expected %d bytes stored by the neighbor, got %v", len(data), peers[0].StoredBytes)
+	}
+
+	if err := client.Call("unknown", nil, nil); err == nil {
+		t.Fatal("Expected error calling an unknown control method")
+	}
+
+	// a second node can not take over the socket
+	if err := NewControlServer().Listen(ControlSocketPath(dataDir)); err == nil {
+		t.Fatal("Expected error listening on a control socket in use")
+	}
+
+	cancel()
+
+	if err := <-started; err != nil {
+		t.Fatalf("Expected clean shutdown, got %v", err)
+	}
+
+	if _, err := DialControl(dataDir); err == nil {
+		t.Fatal("Expected error dialing the control socket of a stopped node")
+	}
+}
+
+func TestAppNodeRestartExpiredCredentials(t *testing.T) {
+
+	dataDir := t.TempDir()
+
+	identity, err := network.LoadOrCreateIdentity(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// credentials of a member left offline longer than their validity, issued by a CA held by another node
+	caPub, caKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UTC()
+
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "vortex cluster CA"},
+		NotBefore:             now.Add(-72 * time.Hour),
+		NotAfter:              now.Add(72 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, caPub, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		NotBefore:    now.Add(-48 * time.Hour),
+		NotAfter:     now.Add(-24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTemplate, caCert, identity.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caInfo := network.NodeInfo{ID: network.NodeIDFromPublicKey(caPub), Host: "203.0.113.7", RPCPort: network.DefaultRPCPort}
+	creds := &network.NodeCredentials{Certificate: leafDER, CACertificates: [][]byte{caDER}}
+
+	if err := network.SaveNodeCredentials(dataDir, creds, caInfo); err != nil {
+		t.Fatal(err)
+	}
+
+	appNode, err := NewAppNodeWithConfig("node", AppNodeConfig{
+		Node:            network.NodeConfig{IP: "127.0.0.1", ListenAddr: "127.0.0.1:0", DataDir: dataDir},
+		ShutdownTimeout: time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	started := make(chan error)
+	go func() { started <- appNode.Start(ctx) }()
+
+	for i := 0; i < 50; i++ {
+		if client, err := DialControl(dataDir); err == nil {
+			client.Close()
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the node waits for a join instead of bootstrapping a cluster of its own
+	if !appNode.Node().NeedsJoin() || appNode.Node().IsMember() || appNode.Node().ClusterCA() != nil {
+		t.Fatal("Expected the restarted node outside any cluster")
+	}
+
+	if _, err := os.Stat(filepath.Join(dataDir, network.ClusterCAKeyFileName)); !os.IsNotExist(err) {
+		t.Fatalf("Expected no CA saved in the data directory, got %v", err)
+	}
+
+	cancel()
+
+	if err := <-started; err != nil {
+		t.Fatalf("Expected clean shutdown, got %v", err)
+	}
+}
@@ -0,0 +1,383 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/IacopoMelani/vortex/core/network"
+)
+
+// MARK: consts & vars
+
+const (
+	// name of the control socket in the node data directory
+	ControlSocketFileName = "control.sock"
+
+	DefaultControlDialTimeout = 2 * time.Second
+)
+
+var (
+	// ErrControlServerClosed - Returned by ControlServer.Serve after ControlServer.Close
+	ErrControlServerClosed = errors.New("control server closed")
+)
+
+// MARK: ControlServer & constructors
+
+// ControlHandlerFunc - Defines the func that handles a control method, payload is the raw request payload
+type ControlHandlerFunc func(payload json.RawMessage) (interface{}, error)
+
+// ControlServer - Defines the local control API of a running node, served over a Unix domain socket.
+// Requests and responses are framed like the RPC ones, see network.RPCRequest. The socket is only accessible
+// to the user running the node, callers are trusted as the node itself
+type ControlServer struct {
+	sync.RWMutex
+	handlers map[string]ControlHandlerFunc
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// NewControlServer - Returns a new instance of ControlServer without handlers
+func NewControlServer() *ControlServer {
+	return &ControlServer{
+		handlers: make(map[string]ControlHandlerFunc),
+		conns:    make(map[net.Conn]struct{}),
+	}
+}
+
+// ControlSocketPath - Returns the path of the control socket of the node with the data directory passed
+func ControlSocketPath(dataDir string) string {
+	return filepath.Join(dataDir, ControlSocketFileName)
+}
+
+// MARK: ControlServer exported
+
+// Close - Stops accepting connections and closes the open ones, the socket is removed
+func (s *ControlServer) Close() error {
+	s.Lock()
+
+	if s.closed {
+		s.Unlock()
+		return nil
+	}
+
+	s.closed = true
+
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+
+	for conn := range s.conns {
+		conn.Close()
+	}
+
+	s.Unlock()
+
+	s.wg.Wait()
+
+	return err
+}
+
+// Handle - Registers the handler for the control method
+func (s *ControlServer) Handle(method string, handler ControlHandlerFunc) {
+	s.Lock()
+	defer s.Unlock()
+	s.handlers[method] = handler
+}
+
+// Listen - Listens on the Unix socket at path, readable and writable by the owner only.
+// A socket left by a node that is not running anymore is replaced, a socket in use is reported
+func (s *ControlServer) Listen(path string) error {
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(path); err == nil {
+
+		if conn, err := net.DialTimeout("unix", path, DefaultControlDialTimeout); err == nil {
+			conn.Close()
+			return NewControlSocketInUseError(path)
+		}
+
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if s.closed {
+		listener.Close()
+		return ErrControlServerClosed
+	}
+
+	s.listener = listener
+
+	return nil
+}
+
+// Serve - Accepts and serves connections on the socket passed to Listen, always returns a non-nil error
+func (s *ControlServer) Serve() error {
+
+	s.RLock()
+	listener := s.listener
+	s.RUnlock()
+
+	if listener == nil {
+		return ErrControlServerClosed
+	}
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrControlServerClosed
+			}
+			return err
+		}
+
+		if !s.trackConn(conn, true) {
+			conn.Close()
+			return ErrControlServerClosed
+		}
+
+		go func() {
+			defer s.trackConn(conn, false)
+			s.serveConn(conn)
+		}()
+	}
+}
+
+// MARK: ControlServer unexported
+
+func (s *ControlServer) dispatch(req network.RPCRequest) network.RPCResponse {
+
+	res := network.RPCResponse{Version: network.RPCProtocolVersion, ID: req.ID}
+
+	if req.Version != network.RPCProtocolVersion {
+		res.Error = network.NewRPCUnsupportedVersionError(req.Version).Error()
+		return res
+	}
+
+	s.RLock()
+	handler, ok := s.handlers[req.Method]
+	s.RUnlock()
+
+	if !ok {
+		res.Error = network.NewRPCMethodNotFoundError(req.Method).Error()
+		return res
+	}
+
+	result, err := handler(req.Payload)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+
+	payload, err := json.Marshal(result)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+
+	res.Payload = payload
+
+	return res
+}
+
+func (s *ControlServer) isClosed() bool {
+	s.RLock()
+	defer s.RUnlock()
+	return s.closed
+}
+
+func (s *ControlServer) serveConn(conn net.Conn) {
+
+	defer conn.Close()
+
+	decoder := json.NewDecoder(conn)
+	encoder := json.NewEncoder(conn)
+
+	for {
+		var req network.RPCRequest
+		if err := decoder.Decode(&req); err != nil {
+			return
+		}
+
+		if err := encoder.Encode(s.dispatch(req)); err != nil {
+			return
+		}
+	}
+}
+
+// trackConn - Adds or removes the connection from the active ones, returns false if the server is closed
+func (s *ControlServer) trackConn(conn net.Conn, add bool) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if !add {
+		delete(s.conns, conn)
+		s.wg.Done()
+		return true
+	}
+
+	if s.closed {
+		return false
+	}
+
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+
+	return true
+}
+
+// MARK: ControlClient & constructors
+
+// ControlClient - Defines a client connected to the control socket of a running node
+type ControlClient struct {
+	sync.Mutex
+	conn    net.Conn
+	decoder *json.Decoder
+	encoder *json.Encoder
+	nextID  uint64
+}
+
+// DialControl - Returns a new ControlClient connected to the control socket of the node with the data directory passed.
+// A node that is not running is reported as NodeNotRunningError
+func DialControl(dataDir string) (*ControlClient, error) {
+
+	path := ControlSocketPath(dataDir)
+
+	conn, err := net.DialTimeout("unix", path, DefaultControlDialTimeout)
+	if err != nil {
+		return nil, NewNodeNotRunningError(path)
+	}
+
+	return &ControlClient{
+		conn:    conn,
+		decoder: json.NewDecoder(conn),
+		encoder: json.NewEncoder(conn),
+	}, nil
+}
+
+// MARK: ControlClient exported
+
+// Call - Calls the control method with args as payload and decodes the result in reply, reply can be nil
+func (c *ControlClient) Call(method string, args interface{}, reply interface{}) error {
+
+	payload, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.nextID++
+	req := network.RPCRequest{
+		Version: network.RPCProtocolVersion,
+		ID:      c.nextID,
+		Method:  method,
+		Payload: payload,
+	}
+
+	if err := c.encoder.Encode(req); err != nil {
+		return err
+	}
+
+	var res network.RPCResponse
+	if err := c.decoder.Decode(&res); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	if res.Error != "" {
+		return NewControlError(method, res.Error)
+	}
+
+	if reply == nil || len(res.Payload) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(res.Payload, reply)
+}
+
+// Close - Closes the connection with the control socket
+func (c *ControlClient) Close() error {
+	return c.conn.Close()
+}
+
+// MARK: ControlError
+
+// ControlError - Defines error returned by the control server of the node
+type ControlError struct {
+	method  string
+	message string
+}
+
+// NewControlError - Returns a new instance of ControlError
+func NewControlError(method, message string) error {
+	return &ControlError{method: method, message: message}
+}
+
+// Error - Implements error interface
+func (e *ControlError) Error() string {
+	return fmt.Sprintf("Node control %s failed: %s", e.method, e.message)
+}
+
+// MARK: ControlSocketInUseError
+
+// ControlSocketInUseError - Defines error for a control socket already served by a running node
+type ControlSocketInUseError struct {
+	path string
+}
+
+// NewControlSocketInUseError - Returns a new instance of ControlSocketInUseError
+func NewControlSocketInUseError(path string) error {
+	return &ControlSocketInUseError{path: path}
+}
+
+// Error - Implements error interface
+func (e *ControlSocketInUseError) Error() string {
+	return fmt.Sprintf("Control socket %s in use, is another node running with the same data directory?", e.path)
+}
+
+// MARK: NodeNotRunningError
+
+// NodeNotRunningError - Defines error for a control socket without a running node
+type NodeNotRunningError struct {
+	path string
+}
+
+// NewNodeNotRunningError - Returns a new instance of NodeNotRunningError
+func NewNodeNotRunningError(path string) error {
+	return &NodeNotRunningError{path: path}
+}
+
+// Error - Implements error interface
+func (e *NodeNotRunningError) Error() string {
+	return fmt.Sprintf("Node not running, no control socket at %s", e.path)
+}
@@ -0,0 +1,392 @@
+package network
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/IacopoMelani/vortex/utils"
+)
+
+// MARK: consts
+
+const (
+	// files storing the cluster trust in the data directory
+	ClusterCAKeyFileName    = "ca.key"
+	ClusterCACertFileName   = "ca.crt"
+	ClusterInfoFileName     = "cluster.json"
+	NodeCertificateFileName = "node.crt"
+
+	DefaultClusterCAValidity        = 10 * 365 * 24 * time.Hour
+	DefaultNodeCertificateValidity  = 24 * time.Hour
+	DefaultCertificateRenewInterval = 10 * 60 // 10 minutes
+	// after a rotation the CA node keeps presenting the certificate issued by the replaced key, until the members renewed theirs
+	DefaultCARotationGracePeriod = 2 * DefaultCertificateRenewInterval * time.Second
+
+	certificatePEMType = "CERTIFICATE"
+)
+
+// MARK: ClusterCA & constructors
+
+// ClusterCA - Defines the certificate authority of the cluster, held by the first deployed node.
+// After a rotation the previous CA certificate stays trusted, so that node certificates can be renewed
+type ClusterCA struct {
+	sync.RWMutex
+	key      ed25519.PrivateKey
+	cert     *x509.Certificate
+	previous *x509.Certificate
+}
+
+// NodeCredentials - Defines the node certificate issued by the cluster CA and the CA certificates to trust
+type NodeCredentials struct {
+	Certificate    []byte   `json:"certificate"`
+	CACertificates [][]byte `json:"ca_certificates"`
+}
+
+// clusterInfo - Defines the cluster info stored in the data directory
+type clusterInfo struct {
+	CA NodeInfo `json:"ca"`
+}
+
+// NewClusterCA - Returns a new ClusterCA with a fresh key
+func NewClusterCA() (*ClusterCA, error) {
+
+	key, cert, err := newClusterCACertificate()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ClusterCA{key: key, cert: cert}, nil
+}
+
+// LoadClusterCA - Loads the ClusterCA stored in the data directory
+func LoadClusterCA(dataDir string) (*ClusterCA, error) {
+
+	b, err := os.ReadFile(filepath.Join(dataDir, ClusterCAKeyFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != identityPEMPrivateKeyType {
+		return nil, NewMalformedCertificateError(ClusterCAKeyFileName)
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, NewMalformedCertificateError(ClusterCAKeyFileName)
+	}
+
+	certs, err := readCertificates(filepath.Join(dataDir, ClusterCACertFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	ca := &ClusterCA{key: key}
+
+	for _, cert := range certs {
+
+		pub, ok := cert.PublicKey.(ed25519.PublicKey)
+		if ok && pub.Equal(key.Public()) {
+			ca.cert = cert
+		} else if ca.previous == nil {
+			ca.previous = cert
+		}
+	}
+
+	if ca.cert == nil {
+		return nil, NewMalformedCertificateError(ClusterCACertFileName)
+	}
+
+	return ca, nil
+}
+
+// MARK: ClusterCA exported
+
+// Certificates - Returns the DER encoded CA certificates to trust, the current one first
+func (ca *ClusterCA) Certificates() [][]byte {
+	ca.RLock()
+	defer ca.RUnlock()
+
+	certs := [][]byte{ca.cert.Raw}
+	if ca.previous != nil && time.Now().Before(ca.previous.NotAfter) {
+		certs = append(certs, ca.previous.Raw)
+	}
+
+	return certs
+}
+
+// Fingerprint - Returns the fingerprint of the current CA key
+func (ca *ClusterCA) Fingerprint() string {
+	ca.RLock()
+	defer ca.RUnlock()
+	return Fingerprint(ca.key.Public().(ed25519.PublicKey))
+}
+
+// RotatedAt - Returns when the CA key has been rotated, the zero time if the CA has never been rotated
+func (ca *ClusterCA) RotatedAt() time.Time {
+	ca.RLock()
+	defer ca.RUnlock()
+
+	if ca.previous == nil {
+		return time.Time{}
+	}
+
+	// the certificate of the new key is valid from the rotation
+	return ca.cert.NotBefore
+}
+
+// Issue - Returns the credentials for the node identity public key, the certificate lasts DefaultNodeCertificateValidity
+func (ca *ClusterCA) Issue(pub ed25519.PublicKey) (*NodeCredentials, error) {
+
+	if len(pub) != ed25519.PublicKeySize {
+		return nil, NewInvalidPeerCertificateError("not an ed25519 identity")
+	}
+
+	serial, err := newCertificateSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: NodeIDFromPublicKey(pub)},
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(DefaultNodeCertificateValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	ca.RLock()
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, pub, ca.key)
+	ca.RUnlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &NodeCredentials{Certificate: der, CACertificates: ca.Certificates()}, nil
+}
+
+// Rotate - Replaces the CA key, the certificate of the replaced key stays trusted until the next rotation
+func (ca *ClusterCA) Rotate() error {
+
+	key, cert, err := newClusterCACertificate()
+	if err != nil {
+		return err
+	}
+
+	ca.Lock()
+	defer ca.Unlock()
+
+	ca.previous = ca.cert
+	ca.key = key
+	ca.cert = cert
+
+	return nil
+}
+
+// Save - Stores the CA key and the CA certificates in the data directory
+func (ca *ClusterCA) Save(dataDir string) error {
+
+	ca.RLock()
+	b, err := x509.MarshalPKCS8PrivateKey(ca.key)
+	ca.RUnlock()
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return err
+	}
+
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: identityPEMPrivateKeyType, Bytes: b})
+	if err := utils.WriteFileAtomic(filepath.Join(dataDir, ClusterCAKeyFileName), keyPEM, 0600); err != nil {
+		return err
+	}
+
+	return utils.WriteFileAtomic(filepath.Join(dataDir, ClusterCACertFileName), encodeCertificates(ca.Certificates()), 0644)
+}
+
+// MARK: NodeCredentials utils exported
+
+// LoadNodeCredentials - Loads the node credentials and the CA node info stored in the data directory
+func LoadNodeCredentials(dataDir string) (*NodeCredentials, NodeInfo, error) {
+
+	certs, err := readCertificates(filepath.Join(dataDir, NodeCertificateFileName))
+	if err != nil {
+		return nil, NodeInfo{}, err
+	}
+
+	if len(certs) != 1 {
+		return nil, NodeInfo{}, NewMalformedCertificateError(NodeCertificateFileName)
+	}
+
+	caCerts, err := readCertificates(filepath.Join(dataDir, ClusterCACertFileName))
+	if err != nil {
+		return nil, NodeInfo{}, err
+	}
+
+	creds := &NodeCredentials{Certificate: certs[0].Raw}
+	for _, cert := range caCerts {
+		creds.CACertificates = append(creds.CACertificates, cert.Raw)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dataDir, ClusterInfoFileName))
+	if err != nil {
+		return nil, NodeInfo{}, err
+	}
+
+	var info clusterInfo
+	if err := json.Unmarshal(b, &info); err != nil {
+		return nil, NodeInfo{}, err
+	}
+
+	return creds, info.CA, nil
+}
+
+// SaveNodeCredentials - Stores the node credentials and the CA node info in the data directory
+func SaveNodeCredentials(dataDir string, creds *NodeCredentials, ca NodeInfo) error {
+
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return err
+	}
+
+	if err := utils.WriteFileAtomic(filepath.Join(dataDir, NodeCertificateFileName), encodeCertificates([][]byte{creds.Certificate}), 0644); err != nil {
+		return err
+	}
+
+	if err := utils.WriteFileAtomic(filepath.Join(dataDir, ClusterCACertFileName), encodeCertificates(creds.CACertificates), 0644); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(clusterInfo{CA: ca})
+	if err != nil {
+		return err
+	}
+
+	return utils.WriteFileAtomic(filepath.Join(dataDir, ClusterInfoFileName), b, 0644)
+}
+
+// MARK: ClusterCA utils unexported
+
+func newCertificateSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+func newClusterCACertificate() (ed25519.PrivateKey, *x509.Certificate, error) {
+
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := newCertificateSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now().UTC()
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "vortex cluster CA " + Fingerprint(pub)[:16]},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(DefaultClusterCAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, pub, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return key, cert, nil
+}
+
+func encodeCertificates(certs [][]byte) []byte {
+
+	var b []byte
+	for _, der := range certs {
+		b = append(b, pem.EncodeToMemory(&pem.Block{Type: certificatePEMType, Bytes: der})...)
+	}
+
+	return b
+}
+
+func readCertificates(filename string) ([]*x509.Certificate, error) {
+
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []*x509.Certificate
+
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+
+		if block.Type != certificatePEMType {
+			return nil, NewMalformedCertificateError(filepath.Base(filename))
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, NewMalformedCertificateError(filepath.Base(filename))
+	}
+
+	return certs, nil
+}
+
+// MARK: MalformedCertificateError
+
+// MalformedCertificateError - Defines error for a certificate or key file that can not be decoded
+type MalformedCertificateError struct {
+	filename string
+}
+
+// NewMalformedCertificateError - Returns a new instance of MalformedCertificateError
+func NewMalformedCertificateError(filename string) error {
+	return &MalformedCertificateError{filename: filename}
+}
+
+// Error - Implements error interface
+func (e *MalformedCertificateError) Error() string {
+	return fmt.Sprintf("Malformed certificate file %s", e.filename)
+}
@@ -0,0 +1,347 @@
+package network
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClusterCAJoinAndRotate(t *testing.T) {
+
+	caNode, err := NewWithConfig(NodeConfig{DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := caNode.BootstrapClusterCA(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !caNode.IsMember() {
+		t.Fatal("Expected CA node to be a cluster member")
+	}
+
+	address := startTestRPCServer(t, caNode)
+
+	// the members reach the CA node through its info, the test server listens on a random port
+	caNode.Lock()
+	caNode.caInfo.Host, caNode.caInfo.RPCPort = splitTestAddress(t, address)
+	caNode.Unlock()
+
+	jt, err := caNode.NewJoinToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataDir := t.TempDir()
+
+	member, err := NewWithConfig(NodeConfig{DataDir: dataDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	guest, err := NewNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := member.Join(jt.String(), address); err != nil {
+		t.Fatal(err)
+	}
+
+	if !member.IsMember() {
+		t.Fatal("Expected joined node to be a cluster member")
+	}
+
+	guestClient, err := guest.DialRPC(address, caNode.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer guestClient.Close()
+
+	if err := guestClient.Call(RPCMethodRenewCertificate, nil, nil); err == nil {
+		t.Fatal("Expected guest not allowed to renew a certificate")
+	}
+
+	if _, err := guestClient.RotateCA(); err == nil {
+		t.Fatal("Expected guest not allowed to rotate the CA")
+	}
+
+	if member.needsRenewal(time.Now()) {
+		t.Fatal("Expected fresh credentials not to need renewal")
+	}
+
+	if member.needsRenewal(time.Now().Add(DefaultNodeCertificateValidity * 3 / 4)) == false {
+		t.Fatal("Expected credentials close to expiry to need renewal")
+	}
+
+	fingerprint, err := caNode.RotateClusterCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fingerprint != caNode.ClusterCA().Fingerprint() {
+		t.Fatal("Expected the fingerprint of the rotated CA")
+	}
+
+	if !member.needsRenewal(time.Now()) {
+		t.Fatal("Expected credentials issued by the rotated CA to need renewal")
+	}
+
+	// the CA node keeps a certificate trusted by the members not renewed yet
+	caTransport, _ := caNode.Transport()
+	memberTransport, _ := member.Transport()
+
+	if !memberTransport.IsMember(caTransport.MemberCertificate()) {
+		t.Fatal("Expected the CA node trusted by a member not renewed during the rotation grace period")
+	}
+
+	if caNode.needsRenewal(time.Now()) {
+		t.Fatal("Expected the CA node not to renew during the rotation grace period")
+	}
+
+	if err := member.RenewCredentials(); err != nil {
+		t.Fatal(err)
+	}
+
+	if member.needsRenewal(time.Now()) {
+		t.Fatal("Expected renewed credentials not to need renewal")
+	}
+
+	if !caTransport.IsMember(memberTransport.MemberCertificate()) {
+		t.Fatal("Expected a renewed member trusted by the CA node")
+	}
+
+	// once the grace period is over the CA node presents a certificate of the new key, trusted by the renewed members
+	afterGrace := time.Now().Add(DefaultCARotationGracePeriod)
+
+	if !caNode.needsRenewal(afterGrace) {
+		t.Fatal("Expected the CA node to renew after the rotation grace period")
+	}
+
+	if err := caNode.renewCredentials(afterGrace); err != nil {
+		t.Fatal(err)
+	}
+
+	if !caTransport.IsIssuedBy(caNode.ClusterCA().Certificates()[0]) || !memberTransport.IsMember(caTransport.MemberCertificate()) {
+		t.Fatal("Expected the CA node certificate issued by the new key and trusted by the renewed member")
+	}
+
+	restarted, err := NewWithConfig(NodeConfig{DataDir: dataDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, err := restarted.LoadClusterCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ok || !restarted.IsMember() {
+		t.Fatal("Expected restarted node to load its cluster credentials")
+	}
+}
+
+func TestLoadExpiredClusterCredentials(t *testing.T) {
+
+	caNode, err := NewWithConfig(NodeConfig{DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := caNode.BootstrapClusterCA(); err != nil {
+		t.Fatal(err)
+	}
+
+	address := startTestRPCServer(t, caNode)
+
+	caNode.Lock()
+	caNode.caInfo.Host, caNode.caInfo.RPCPort = splitTestAddress(t, address)
+	caNode.Unlock()
+
+	dataDir := t.TempDir()
+
+	member, err := NewWithConfig(NodeConfig{DataDir: dataDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// credentials of a member left offline longer than DefaultNodeCertificateValidity
+	ca := caNode.ClusterCA()
+	now := time.Now().UTC()
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: member.ID()},
+		NotBefore:    now.Add(-2 * DefaultNodeCertificateValidity),
+		NotAfter:     now.Add(-DefaultNodeCertificateValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, member.PublicKey(), ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SaveNodeCredentials(dataDir, &NodeCredentials{Certificate: der, CACertificates: ca.Certificates()}, caNode.ClusterCAInfo()); err != nil {
+		t.Fatal(err)
+	}
+
+	ok, err := member.LoadClusterCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok || member.IsMember() || member.ClusterCAInfo().ID != "" {
+		t.Fatal("Expected expired credentials dropped")
+	}
+
+	if !member.NeedsJoin() {
+		t.Fatal("Expected a node with expired credentials to need a join")
+	}
+
+	// the CA of a forked cluster, bootstrapped by the node while outside its cluster, is dropped joining
+	if err := member.BootstrapClusterCA(); err != nil {
+		t.Fatal(err)
+	}
+
+	jt, err := caNode.NewJoinToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := member.Join(jt.String(), address); err != nil {
+		t.Fatal(err)
+	}
+
+	if !member.IsMember() || member.NeedsJoin() {
+		t.Fatal("Expected the node with expired credentials to join again")
+	}
+
+	if member.ClusterCA() != nil || member.ClusterCAInfo().ID != caNode.ID() {
+		t.Fatal("Expected the joined node to drop its own CA")
+	}
+
+	if _, err := os.Stat(filepath.Join(dataDir, ClusterCAKeyFileName)); !os.IsNotExist(err) {
+		t.Fatalf("Expected the CA key removed from the data directory, got %v", err)
+	}
+
+	restarted, err := NewWithConfig(NodeConfig{DataDir: dataDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := restarted.LoadClusterCredentials(); err != nil || !ok {
+		t.Fatalf("Expected the credentials of the new join loaded, got %v", err)
+	}
+
+	if restarted.ClusterCA() != nil || restarted.ClusterCAInfo().ID != caNode.ID() {
+		t.Fatal("Expected the restarted node member of the joined cluster")
+	}
+}
+
+func TestIssueJoinCredentials(t *testing.T) {
+
+	caNode, err := NewNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := caNode.BootstrapClusterCA(); err != nil {
+		t.Fatal(err)
+	}
+
+	address := startTestRPCServer(t, caNode)
+
+	caNode.Lock()
+	caNode.caInfo.Host, caNode.caInfo.RPCPort = splitTestAddress(t, address)
+	caNode.Unlock()
+
+	jt, err := caNode.NewJoinToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	member, err := NewNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	memberAddress := startTestRPCServer(t, member)
+
+	if _, err := member.Join(jt.String(), address); err != nil {
+		t.Fatal(err)
+	}
+
+	// a node joining through a member gets credentials from the CA with the token of the member
+	memberToken, err := member.NewJoinToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	joining, err := NewNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := joining.Join(memberToken.String(), memberAddress); err != nil {
+		t.Fatal(err)
+	}
+
+	if !joining.IsMember() {
+		t.Fatal("Expected the node joined through a member to be a cluster member")
+	}
+
+	client, err := member.dialClusterCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	outsider, err := NewNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issue := func(token string) error {
+		return client.Call(RPCMethodIssueCertificate, IssueCertificateRequest{PublicKey: outsider.PublicKey(), Token: token}, nil)
+	}
+
+	if err := issue(""); err == nil {
+		t.Fatal("Expected credentials refused without a join token")
+	}
+
+	// a token not signed by the requesting member
+	caToken, err := caNode.NewJoinToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := issue(caToken.String()); err == nil {
+		t.Fatal("Expected credentials refused for a token of another node")
+	}
+
+	// the token already redeemed by the join
+	if err := issue(memberToken.String()); err == nil {
+		t.Fatal("Expected credentials refused for a redeemed token")
+	}
+
+	fresh, err := member.NewJoinToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := issue(fresh.String()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := issue(fresh.String()); err == nil {
+		t.Fatal("Expected a token to get credentials once")
+	}
+}
@@ -0,0 +1,524 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// MARK: consts & vars
+
+const (
+	// number of parallel requests of an iterative lookup
+	DefaultDHTAlpha = 3
+
+	// lifetime of a provider record, holders announce their chunks again before it expires
+	DefaultDHTRecordTTL = 24 * time.Hour
+
+	// interval of the sweep of the expired records and of the announcements of the held keys, see RunRepublisher
+	DefaultDHTRepublishInterval = time.Hour
+)
+
+var (
+	// ErrDHTNoContacts - Returned looking up a key with an empty routing table
+	ErrDHTNoContacts = errors.New("no DHT contacts, bootstrap the DHT first")
+)
+
+// MARK: DHTClient
+
+// DHTClient - Defines the client sending the DHT requests to the other nodes, see NewRPCDHTClient.
+// The requests carry the sender info, so that the receiver records it in its routing table
+type DHTClient interface {
+	FindNode(to NodeInfo, target DHTKey) ([]NodeInfo, error)
+	FindValue(to NodeInfo, key DHTKey) (*FindValueResponse, error)
+	Ping(to NodeInfo) error
+	Store(to NodeInfo, key DHTKey) error
+}
+
+// FindValueResponse - Defines the payload returned by find value, the providers of the key if known, the closest contacts otherwise
+type FindValueResponse struct {
+	Providers []NodeInfo `json:"providers,omitempty"`
+	Closer    []NodeInfo `json:"closer,omitempty"`
+}
+
+// MARK: DHT, DHTConfig & constructors
+
+// DHT - Defines the Kademlia distributed hash table of a node, mapping chunk hashes to the nodes holding them.
+// Lookups query the known contacts closest to the key by XOR distance and move closer at every hop,
+// so a key is located in O(log n) hops. Provider records are stored on the DefaultDHTBucketSize nodes closest to the key
+type DHT struct {
+	sync.RWMutex
+	self    NodeInfo
+	key     DHTKey
+	table   *RoutingTable
+	client  DHTClient
+	records map[DHTKey]map[string]dhtRecord
+	k       int
+	alpha   int
+	ttl     time.Duration
+}
+
+// DHTConfig - Defines the DHT config struct, zero values are replaced by the defaults
+type DHTConfig struct {
+	BucketSize int
+	Alpha      int
+	RecordTTL  time.Duration
+}
+
+// dhtRecord - Defines a provider record held by the DHT
+type dhtRecord struct {
+	provider NodeInfo
+	expires  time.Time
+}
+
+// NewDHT - Returns a new instance of DHT for the node passed with the default config
+func NewDHT(self NodeInfo, client DHTClient) (*DHT, error) {
+	return NewDHTWithConfig(self, client, DHTConfig{})
+}
+
+// NewDHTWithConfig - Returns a new instance of DHT for the node passed with the config passed
+func NewDHTWithConfig(self NodeInfo, client DHTClient, config DHTConfig) (*DHT, error) {
+
+	key, err := ParseDHTKey(self.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.BucketSize <= 0 {
+		config.BucketSize = DefaultDHTBucketSize
+	}
+	if config.Alpha <= 0 {
+		config.Alpha = DefaultDHTAlpha
+	}
+	if config.RecordTTL <= 0 {
+		config.RecordTTL = DefaultDHTRecordTTL
+	}
+
+	return &DHT{
+		self:    self,
+		key:     key,
+		table:   NewRoutingTableWithBucketSize(key, config.BucketSize),
+		client:  client,
+		records: make(map[DHTKey]map[string]dhtRecord),
+		k:       config.BucketSize,
+		alpha:   config.Alpha,
+		ttl:     config.RecordTTL,
+	}, nil
+}
+
+// MARK: DHT exported
+
+// Bootstrap - Adds the seed contacts and looks up the node itself, filling the buckets close to the node
+func (d *DHT) Bootstrap(seeds []NodeInfo) error {
+
+	for _, seed := range seeds {
+		d.Observe(seed)
+	}
+
+	_, err := d.FindNode(d.key)
+
+	return err
+}
+
+// Close - Closes the connections kept open by the DHT client, if any
+func (d *DHT) Close() error {
+
+	if closer, ok := d.client.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+// FindNode - Returns the nodes closest to the target, looked up iteratively
+func (d *DHT) FindNode(target DHTKey) ([]NodeInfo, error) {
+	closest, _, err := d.lookup(target, false)
+	return closest, err
+}
+
+// FindProviders - Returns the nodes holding the key, looked up iteratively. Returns an empty list if no holder is known
+func (d *DHT) FindProviders(key DHTKey) ([]NodeInfo, error) {
+
+	if providers := d.providers(key); len(providers) > 0 {
+		return providers, nil
+	}
+
+	_, providers, err := d.lookup(key, true)
+
+	return providers, err
+}
+
+// HandleFindNode - Serves a find node request of the node from, nil for a requester outside the routing table.
+// Returns the contacts closest to the target
+func (d *DHT) HandleFindNode(from *NodeInfo, target DHTKey) []NodeInfo {
+
+	if from != nil {
+		d.Observe(*from)
+	}
+
+	return d.table.Closest(target, d.k)
+}
+
+// HandleFindValue - Serves a find value request of the node from, see HandleFindNode. Returns the providers of the key if known
+func (d *DHT) HandleFindValue(from *NodeInfo, key DHTKey) *FindValueResponse {
+
+	if from != nil {
+		d.Observe(*from)
+	}
+
+	if providers := d.providers(key); len(providers) > 0 {
+		return &FindValueResponse{Providers: providers}
+	}
+
+	return &FindValueResponse{Closer: d.table.Closest(key, d.k)}
+}
+
+// HandleStore - Serves a store request, the node from is recorded as provider of the key
+func (d *DHT) HandleStore(from NodeInfo, key DHTKey) {
+	d.Observe(from)
+	d.addProvider(key, from, time.Now())
+}
+
+// Observe - Records the contact as just seen in the routing table.
+// When its bucket is full the least recently seen contact is pinged and replaced only if it does not answer
+func (d *DHT) Observe(contact NodeInfo) {
+
+	if contact.ID == d.self.ID {
+		return
+	}
+
+	oldest, err := d.table.Update(contact)
+	if err != nil || oldest == nil {
+		return
+	}
+
+	go func() {
+		if d.client.Ping(*oldest) != nil {
+			d.table.Replace(oldest.ID, contact)
+		}
+	}()
+}
+
+// Provide - Announces the node as provider of the key to the nodes closest to it, a node without contacts keeps the record alone
+func (d *DHT) Provide(key DHTKey) error {
+
+	closest, err := d.FindNode(key)
+	if err == ErrDHTNoContacts {
+		d.addProvider(key, d.self, time.Now())
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// the node keeps the record too when it is among the closest
+	if len(closest) < d.k || d.key.Distance(key).Less(mustDHTKey(closest[len(closest)-1].ID).Distance(key)) {
+		d.addProvider(key, d.self, time.Now())
+	}
+
+	stored := 0
+	for _, contact := range closest {
+		if err := d.client.Store(contact, key); err == nil {
+			stored++
+		}
+	}
+
+	if stored == 0 && len(closest) > 0 {
+		return NewDHTLookupError(key, "no node stored the record")
+	}
+
+	return nil
+}
+
+// RunRepublisher - Announces the keys returned by held, then every interval removes the expired provider records
+// and announces the held keys again, until stop is closed
+func (d *DHT) RunRepublisher(interval time.Duration, held func() []DHTKey, stop <-chan struct{}) {
+
+	republish := func() {
+		for _, key := range held() {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			// a key not announced now is announced at the next interval, well before its records expire
+			d.Provide(key)
+		}
+	}
+
+	republish()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			d.SweepRecords(now)
+			republish()
+		}
+	}
+}
+
+// SweepRecords - Removes the provider records expired at the time passed, returns the number of records removed
+func (d *DHT) SweepRecords(now time.Time) int {
+
+	d.Lock()
+	defer d.Unlock()
+
+	removed := 0
+	for key, providers := range d.records {
+		for id, record := range providers {
+			if now.After(record.expires) {
+				delete(providers, id)
+				removed++
+			}
+		}
+
+		if len(providers) == 0 {
+			delete(d.records, key)
+		}
+	}
+
+	return removed
+}
+
+// Table - Returns the routing table of the DHT
+func (d *DHT) Table() *RoutingTable {
+	return d.table
+}
+
+// MARK: DHT unexported
+
+func (d *DHT) addProvider(key DHTKey, provider NodeInfo, now time.Time) {
+
+	d.Lock()
+	defer d.Unlock()
+
+	providers, ok := d.records[key]
+	if !ok {
+		providers = make(map[string]dhtRecord)
+		d.records[key] = providers
+	}
+
+	providers[provider.ID] = dhtRecord{provider: provider, expires: now.Add(d.ttl)}
+}
+
+// lookup - Queries iteratively the closest contacts to the target, alpha at a time, until the k closest answered.
+// With findValue the lookup stops at the first node returning providers
+func (d *DHT) lookup(target DHTKey, findValue bool) ([]NodeInfo, []NodeInfo, error) {
+
+	seeds := d.table.Closest(target, d.k)
+	if len(seeds) == 0 {
+		return nil, nil, ErrDHTNoContacts
+	}
+
+	shortlist := newDHTShortlist(target, d.k)
+	shortlist.add(d.self.ID, seeds)
+
+	type reply struct {
+		contact NodeInfo
+		closer  []NodeInfo
+		found   []NodeInfo
+		err     error
+	}
+
+	for {
+		batch := shortlist.next(d.alpha)
+		if len(batch) == 0 {
+			break
+		}
+
+		replies := make(chan reply, len(batch))
+
+		for _, contact := range batch {
+			go func(contact NodeInfo) {
+
+				if !findValue {
+					closer, err := d.client.FindNode(contact, target)
+					replies <- reply{contact: contact, closer: closer, err: err}
+					return
+				}
+
+				res, err := d.client.FindValue(contact, target)
+				if err != nil {
+					replies <- reply{contact: contact, err: err}
+					return
+				}
+
+				replies <- reply{contact: contact, closer: res.Closer, found: res.Providers}
+			}(contact)
+		}
+
+		var found []NodeInfo
+
+		for range batch {
+
+			r := <-replies
+
+			if r.err != nil {
+				shortlist.fail(r.contact.ID)
+				d.table.Remove(r.contact.ID)
+				continue
+			}
+
+			d.Observe(r.contact)
+			shortlist.add(d.self.ID, r.closer)
+			found = append(found, r.found...)
+		}
+
+		if len(found) > 0 {
+			return shortlist.closest(), found, nil
+		}
+	}
+
+	return shortlist.closest(), nil, nil
+}
+
+func (d *DHT) providers(key DHTKey) []NodeInfo {
+
+	d.RLock()
+	defer d.RUnlock()
+
+	now := time.Now()
+
+	var providers []NodeInfo
+	for _, record := range d.records[key] {
+		if now.Before(record.expires) {
+			providers = append(providers, record.provider)
+		}
+	}
+
+	sortByDistance(providers, key)
+
+	return providers
+}
+
+// MARK: dhtShortlist
+
+// dhtShortlist - Defines the candidates of an iterative lookup sorted by distance from the target
+type dhtShortlist struct {
+	target   DHTKey
+	k        int
+	contacts []NodeInfo
+	queried  map[string]bool
+	failed   map[string]bool
+}
+
+func newDHTShortlist(target DHTKey, k int) *dhtShortlist {
+	return &dhtShortlist{
+		target:  target,
+		k:       k,
+		queried: make(map[string]bool),
+		failed:  make(map[string]bool),
+	}
+}
+
+// add - Adds the contacts not yet known, selfID is skipped
+func (s *dhtShortlist) add(selfID string, contacts []NodeInfo) {
+
+	for _, contact := range contacts {
+
+		if contact.ID == selfID || s.has(contact.ID) {
+			continue
+		}
+
+		if _, err := ParseDHTKey(contact.ID); err != nil {
+			continue
+		}
+
+		s.contacts = append(s.contacts, contact)
+	}
+
+	sortByDistance(s.contacts, s.target)
+}
+
+// closest - Returns the k closest contacts that answered
+func (s *dhtShortlist) closest() []NodeInfo {
+
+	var closest []NodeInfo
+	for _, contact := range s.contacts {
+		if s.queried[contact.ID] && !s.failed[contact.ID] {
+			closest = append(closest, contact)
+		}
+		if len(closest) == s.k {
+			break
+		}
+	}
+
+	return closest
+}
+
+func (s *dhtShortlist) fail(id string) {
+	s.failed[id] = true
+}
+
+func (s *dhtShortlist) has(id string) bool {
+	for _, contact := range s.contacts {
+		if contact.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// next - Marks as queried and returns at most count contacts not yet queried among the k closest alive,
+// the lookup has converged when none is left
+func (s *dhtShortlist) next(count int) []NodeInfo {
+
+	var batch []NodeInfo
+	alive := 0
+
+	for _, contact := range s.contacts {
+
+		if s.failed[contact.ID] {
+			continue
+		}
+
+		if alive++; alive > s.k {
+			break
+		}
+
+		if !s.queried[contact.ID] {
+			s.queried[contact.ID] = true
+			batch = append(batch, contact)
+		}
+
+		if len(batch) == count {
+			break
+		}
+	}
+
+	return batch
+}
+
+// MARK: DHT utils unexported
+
+// mustDHTKey - Returns the key of the node ID, the IDs in the routing table are always valid keys
+func mustDHTKey(id string) DHTKey {
+	key, _ := ParseDHTKey(id)
+	return key
+}
+
+// MARK: DHTLookupError
+
+// DHTLookupError - Defines error for a DHT operation that could not reach the nodes closest to a key
+type DHTLookupError struct {
+	key    DHTKey
+	reason string
+}
+
+// NewDHTLookupError - Returns a new instance of DHTLookupError
+func NewDHTLookupError(key DHTKey, reason string) error {
+	return &DHTLookupError{key: key, reason: reason}
+}
+
+// Error - Implements error interface
+func (e *DHTLookupError) Error() string {
+	return fmt.Sprintf("DHT lookup of %s failed: %s", e.key, e.reason)
+}
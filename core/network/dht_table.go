@@ -0,0 +1,281 @@
+package network
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math/bits"
+	"sort"
+	"sync"
+)
+
+// MARK: consts
+
+const (
+	// number of bits of the DHT key space, node IDs and chunk hashes are sha256 digests
+	DHTKeyBits = 256
+
+	// number of contacts per k-bucket and of nodes returned by a lookup
+	DefaultDHTBucketSize = 20
+)
+
+// MARK: DHTKey
+
+// DHTKey - Defines a key of the DHT key space, both node IDs and chunk hashes are keys
+type DHTKey [32]byte
+
+// ParseDHTKey - Returns the DHTKey of the hex encoded value, node IDs are valid keys
+func ParseDHTKey(value string) (DHTKey, error) {
+
+	var key DHTKey
+
+	b, err := hex.DecodeString(value)
+	if err != nil || len(b) != len(key) {
+		return key, NewInvalidDHTKeyError(value)
+	}
+
+	copy(key[:], b)
+
+	return key, nil
+}
+
+// Distance - Returns the XOR distance between the keys
+func (k DHTKey) Distance(other DHTKey) DHTKey {
+
+	var d DHTKey
+	for i := range k {
+		d[i] = k[i] ^ other[i]
+	}
+
+	return d
+}
+
+// Less - Returns true if the key, read as a distance, is smaller than other
+func (k DHTKey) Less(other DHTKey) bool {
+	return bytes.Compare(k[:], other[:]) < 0
+}
+
+// MarshalText - Implements encoding.TextMarshaler, the key is hex encoded
+func (k DHTKey) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// String - Returns the hex encoded key
+func (k DHTKey) String() string {
+	return hex.EncodeToString(k[:])
+}
+
+// UnmarshalText - Implements encoding.TextUnmarshaler
+func (k *DHTKey) UnmarshalText(text []byte) error {
+
+	key, err := ParseDHTKey(string(text))
+	if err != nil {
+		return err
+	}
+
+	*k = key
+
+	return nil
+}
+
+// MARK: RoutingTable & constructors
+
+// RoutingTable - Defines the Kademlia routing table of a node, contacts are split in k-buckets by XOR distance from the node.
+// Bucket i holds the contacts sharing the first 255-i bits with the node, least recently seen first
+type RoutingTable struct {
+	sync.RWMutex
+	self       DHTKey
+	bucketSize int
+	buckets    [DHTKeyBits][]NodeInfo
+}
+
+// NewRoutingTable - Returns a new instance of RoutingTable for the node with the key passed, with DefaultDHTBucketSize
+func NewRoutingTable(self DHTKey) *RoutingTable {
+	return NewRoutingTableWithBucketSize(self, DefaultDHTBucketSize)
+}
+
+// NewRoutingTableWithBucketSize - Returns a new instance of RoutingTable holding at most bucketSize contacts per bucket
+func NewRoutingTableWithBucketSize(self DHTKey, bucketSize int) *RoutingTable {
+	return &RoutingTable{self: self, bucketSize: bucketSize}
+}
+
+// MARK: RoutingTable exported
+
+// Closest - Returns at most count contacts sorted by distance from the target
+func (rt *RoutingTable) Closest(target DHTKey, count int) []NodeInfo {
+	rt.RLock()
+	defer rt.RUnlock()
+
+	var contacts []NodeInfo
+	for _, bucket := range rt.buckets {
+		contacts = append(contacts, bucket...)
+	}
+
+	sortByDistance(contacts, target)
+
+	if len(contacts) > count {
+		contacts = contacts[:count]
+	}
+
+	return contacts
+}
+
+// Contacts - Returns every contact of the table
+func (rt *RoutingTable) Contacts() []NodeInfo {
+	rt.RLock()
+	defer rt.RUnlock()
+
+	var contacts []NodeInfo
+	for _, bucket := range rt.buckets {
+		contacts = append(contacts, bucket...)
+	}
+
+	return contacts
+}
+
+// Len - Returns the number of contacts of the table
+func (rt *RoutingTable) Len() int {
+	rt.RLock()
+	defer rt.RUnlock()
+
+	count := 0
+	for _, bucket := range rt.buckets {
+		count += len(bucket)
+	}
+
+	return count
+}
+
+// Remove - Removes the contact with the ID passed, returns false if missing
+func (rt *RoutingTable) Remove(id string) bool {
+
+	key, err := ParseDHTKey(id)
+	if err != nil {
+		return false
+	}
+
+	rt.Lock()
+	defer rt.Unlock()
+
+	i := rt.bucketIndex(key)
+	if i < 0 {
+		return false
+	}
+
+	bucket := rt.buckets[i]
+	for j, contact := range bucket {
+		if contact.ID == id {
+			rt.buckets[i] = append(bucket[:j:j], bucket[j+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// Update - Records the contact as just seen, moving it to the tail of its bucket.
+// When the bucket is full the contact is not added and the least recently seen one is returned:
+// the caller pings it and calls Replace if it does not answer
+func (rt *RoutingTable) Update(contact NodeInfo) (*NodeInfo, error) {
+
+	key, err := ParseDHTKey(contact.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	rt.Lock()
+	defer rt.Unlock()
+
+	i := rt.bucketIndex(key)
+	if i < 0 {
+		return nil, nil
+	}
+
+	bucket := rt.buckets[i]
+	for j, known := range bucket {
+		if known.ID == contact.ID {
+			bucket = append(bucket[:j:j], bucket[j+1:]...)
+			rt.buckets[i] = append(bucket, contact)
+			return nil, nil
+		}
+	}
+
+	if len(bucket) < rt.bucketSize {
+		rt.buckets[i] = append(bucket, contact)
+		return nil, nil
+	}
+
+	oldest := bucket[0]
+
+	return &oldest, nil
+}
+
+// Replace - Replaces the stale contact with the ID passed with contact, if the bucket still holds it
+func (rt *RoutingTable) Replace(staleID string, contact NodeInfo) bool {
+
+	if !rt.Remove(staleID) {
+		return false
+	}
+
+	if _, err := rt.Update(contact); err != nil {
+		return false
+	}
+
+	return true
+}
+
+// MARK: RoutingTable unexported
+
+// bucketIndex - Returns the index of the bucket of the key, -1 for the node itself
+func (rt *RoutingTable) bucketIndex(key DHTKey) int {
+
+	d := rt.self.Distance(key)
+
+	for i, b := range d {
+		if b != 0 {
+			return DHTKeyBits - 1 - (i*8 + bits.LeadingZeros8(b))
+		}
+	}
+
+	return -1
+}
+
+// MARK: RoutingTable utils unexported
+
+// sortByDistance - Sorts the contacts by distance from the target, contacts with an invalid ID last
+func sortByDistance(contacts []NodeInfo, target DHTKey) {
+
+	distances := make(map[string]DHTKey, len(contacts))
+	for _, contact := range contacts {
+		key, err := ParseDHTKey(contact.ID)
+		if err != nil {
+			for i := range key {
+				key[i] = 0xff
+			}
+			distances[contact.ID] = key
+			continue
+		}
+		distances[contact.ID] = key.Distance(target)
+	}
+
+	sort.SliceStable(contacts, func(i, j int) bool {
+		return distances[contacts[i].ID].Less(distances[contacts[j].ID])
+	})
+}
+
+// MARK: InvalidDHTKeyError
+
+// InvalidDHTKeyError - Defines error for a value that is not a hex encoded DHT key
+type InvalidDHTKeyError struct {
+	value string
+}
+
+// NewInvalidDHTKeyError - Returns a new instance of InvalidDHTKeyError
+func NewInvalidDHTKeyError(value string) error {
+	return &InvalidDHTKeyError{value: value}
+}
+
+// Error - Implements error interface
+func (e *InvalidDHTKeyError) Error() string {
+	return fmt.Sprintf("Invalid DHT key %q", e.value)
+}
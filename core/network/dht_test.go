@@ -0,0 +1,511 @@
+package network
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testDHTNetwork - In-process network of DHTs, requests are served by calling the remote DHT directly
+type testDHTNetwork struct {
+	sync.RWMutex
+	dhts    map[string]*DHT
+	down    map[string]bool
+	queries int64
+}
+
+type testDHTClient struct {
+	network *testDHTNetwork
+	self    NodeInfo
+}
+
+func newTestDHTNetwork(t *testing.T, n int, config DHTConfig) (*testDHTNetwork, []*DHT) {
+
+	network := &testDHTNetwork{dhts: make(map[string]*DHT), down: make(map[string]bool)}
+	dhts := make([]*DHT, 0, n)
+
+	for i := 0; i < n; i++ {
+
+		sum := sha256.Sum256([]byte(fmt.Sprintf("node-%d", i)))
+		info := NodeInfo{ID: hex.EncodeToString(sum[:]), Name: fmt.Sprintf("node-%d", i)}
+
+		dht, err := NewDHTWithConfig(info, &testDHTClient{network: network, self: info}, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		network.Lock()
+		network.dhts[info.ID] = dht
+		network.Unlock()
+
+		// every node bootstraps through a node already in the network
+		if i > 0 {
+			if err := dht.Bootstrap([]NodeInfo{dhts[(i*7)%i].self}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		dhts = append(dhts, dht)
+	}
+
+	return network, dhts
+}
+
+func (n *testDHTNetwork) remote(id string) (*DHT, error) {
+	n.RLock()
+	defer n.RUnlock()
+
+	atomic.AddInt64(&n.queries, 1)
+
+	if n.down[id] {
+		return nil, errors.New("node down")
+	}
+
+	return n.dhts[id], nil
+}
+
+func (c *testDHTClient) FindNode(to NodeInfo, target DHTKey) ([]NodeInfo, error) {
+
+	remote, err := c.network.remote(to.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return remote.HandleFindNode(&c.self, target), nil
+}
+
+func (c *testDHTClient) FindValue(to NodeInfo, key DHTKey) (*FindValueResponse, error) {
+
+	remote, err := c.network.remote(to.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return remote.HandleFindValue(&c.self, key), nil
+}
+
+func (c *testDHTClient) Ping(to NodeInfo) error {
+	_, err := c.network.remote(to.ID)
+	return err
+}
+
+func (c *testDHTClient) Store(to NodeInfo, key DHTKey) error {
+
+	remote, err := c.network.remote(to.ID)
+	if err != nil {
+		return err
+	}
+
+	remote.HandleStore(c.self, key)
+
+	return nil
+}
+
+// closestTestIDs - Returns the IDs of the count nodes closest to the target, by brute force
+func closestTestIDs(dhts []*DHT, target DHTKey, count int, skip map[string]bool) []string {
+
+	var infos []NodeInfo
+	for _, dht := range dhts {
+		if !skip[dht.self.ID] {
+			infos = append(infos, dht.self)
+		}
+	}
+
+	sortByDistance(infos, target)
+
+	ids := make([]string, 0, count)
+	for _, info := range infos[:count] {
+		ids = append(ids, info.ID)
+	}
+
+	return ids
+}
+
+func testInfoIDs(infos []NodeInfo) []string {
+	ids := make([]string, 0, len(infos))
+	for _, info := range infos {
+		ids = append(ids, info.ID)
+	}
+	return ids
+}
+
+func TestRoutingTable(t *testing.T) {
+
+	self := DHTKey{}
+	rt := NewRoutingTableWithBucketSize(self, 2)
+
+	contact := func(first byte, last byte) NodeInfo {
+		key := DHTKey{}
+		key[0], key[31] = first, last
+		return NodeInfo{ID: key.String()}
+	}
+
+	if i := rt.bucketIndex(DHTKey{}); i != -1 {
+		t.Fatalf("Expected no bucket for the node itself, got %d", i)
+	}
+
+	if i := rt.bucketIndex(mustDHTKey(contact(0x80, 0).ID)); i != DHTKeyBits-1 {
+		t.Fatalf("Expected farthest bucket, got %d", i)
+	}
+
+	if i := rt.bucketIndex(mustDHTKey(contact(0, 1).ID)); i != 0 {
+		t.Fatalf("Expected closest bucket, got %d", i)
+	}
+
+	for _, c := range []NodeInfo{contact(0x80, 1), contact(0x80, 2)} {
+		if oldest, err := rt.Update(c); err != nil || oldest != nil {
+			t.Fatalf("Expected contact added, got %v %v", oldest, err)
+		}
+	}
+
+	// seeing the first contact again moves it to the tail
+	rt.Update(contact(0x80, 1))
+
+	oldest, err := rt.Update(contact(0x80, 3))
+	if err != nil || oldest == nil || oldest.ID != contact(0x80, 2).ID {
+		t.Fatalf("Expected least recently seen contact of the full bucket, got %v %v", oldest, err)
+	}
+
+	if !rt.Replace(oldest.ID, contact(0x80, 3)) || rt.Len() != 2 {
+		t.Fatal("Expected stale contact replaced")
+	}
+
+	rt.Update(contact(0x01, 0))
+
+	closest := rt.Closest(DHTKey{}, 2)
+	if len(closest) != 2 || closest[0].ID != contact(0x01, 0).ID || closest[1].ID != contact(0x80, 1).ID {
+		t.Fatalf("Unexpected closest contacts %v", testInfoIDs(closest))
+	}
+
+	if _, err := rt.Update(NodeInfo{ID: "not hex"}); err == nil {
+		t.Fatal("Expected error adding a contact with an invalid ID")
+	}
+}
+
+func TestDHTLookupConverges(t *testing.T) {
+
+	const nodes = 128
+
+	config := DHTConfig{BucketSize: 8}
+	network, dhts := newTestDHTNetwork(t, nodes, config)
+
+	atomic.StoreInt64(&network.queries, 0)
+
+	const lookups = 32
+
+	for i := 0; i < lookups; i++ {
+
+		target := DHTKey(sha256.Sum256([]byte(fmt.Sprintf("key-%d", i))))
+
+		origin := dhts[(i*13)%nodes]
+
+		found, err := origin.FindNode(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the node looking up the key is not part of the result
+		expected := closestTestIDs(dhts, target, config.BucketSize, map[string]bool{origin.self.ID: true})
+
+		if got := testInfoIDs(found); strings.Join(got, ",") != strings.Join(expected, ",") {
+			t.Fatalf("Lookup %d did not converge to the closest nodes", i)
+		}
+	}
+
+	// a lookup contacts a small part of the network
+	if avg := atomic.LoadInt64(&network.queries) / lookups; avg > nodes/4 {
+		t.Fatalf("Expected lookups to query few nodes, queried %d on average", avg)
+	}
+}
+
+func TestDHTProviders(t *testing.T) {
+
+	_, dhts := newTestDHTNetwork(t, 64, DHTConfig{BucketSize: 8})
+
+	key := DHTKey(sha256.Sum256([]byte("chunk")))
+
+	for _, provider := range []*DHT{dhts[3], dhts[41]} {
+		if err := provider.Provide(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, dht := range []*DHT{dhts[0], dhts[17], dhts[63]} {
+
+		providers, err := dht.FindProviders(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ids := testInfoIDs(providers)
+		sort.Strings(ids)
+
+		expected := []string{dhts[3].self.ID, dhts[41].self.ID}
+		sort.Strings(expected)
+
+		if strings.Join(ids, ",") != strings.Join(expected, ",") {
+			t.Fatalf("Expected providers %v, got %v", expected, ids)
+		}
+	}
+
+	unknown, err := dhts[5].FindProviders(DHTKey(sha256.Sum256([]byte("missing"))))
+	if err != nil || len(unknown) != 0 {
+		t.Fatalf("Expected no providers of a missing key, got %v %v", unknown, err)
+	}
+}
+
+func TestDHTRepublisher(t *testing.T) {
+
+	_, dhts := newTestDHTNetwork(t, 16, DHTConfig{BucketSize: 4, RecordTTL: 50 * time.Millisecond})
+
+	key := DHTKey(sha256.Sum256([]byte("held chunk")))
+	stale := DHTKey(sha256.Sum256([]byte("dropped chunk")))
+
+	provider := dhts[3]
+	provider.addProvider(stale, dhts[7].self, time.Now().Add(-time.Second))
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		provider.RunRepublisher(10*time.Millisecond, func() []DHTKey { return []DHTKey{key} }, stop)
+	}()
+
+	// the records outlive their TTL as long as the key is announced again
+	time.Sleep(150 * time.Millisecond)
+
+	providers, err := dhts[11].FindProviders(key)
+	if err != nil || len(providers) != 1 || providers[0].ID != provider.self.ID {
+		t.Fatalf("Expected the republished provider, got %v %v", providers, err)
+	}
+
+	close(stop)
+	<-done
+
+	provider.RLock()
+	_, ok := provider.records[stale]
+	provider.RUnlock()
+
+	if ok {
+		t.Fatal("Expected the expired records swept")
+	}
+}
+
+func TestDHTLookupWithFailedNodes(t *testing.T) {
+
+	network, dhts := newTestDHTNetwork(t, 96, DHTConfig{BucketSize: 8})
+
+	down := make(map[string]bool)
+	for i := 0; i < len(dhts); i += 5 {
+		down[dhts[i].self.ID] = true
+	}
+
+	network.Lock()
+	network.down = down
+	network.Unlock()
+
+	for i := 0; i < 16; i++ {
+
+		target := DHTKey(sha256.Sum256([]byte(fmt.Sprintf("key-%d", i))))
+		origin := dhts[i*5+1]
+
+		found, err := origin.FindNode(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		skip := map[string]bool{origin.self.ID: true}
+		for id := range down {
+			skip[id] = true
+		}
+
+		expected := closestTestIDs(dhts, target, 1, skip)
+
+		if len(found) == 0 || found[0].ID != expected[0] {
+			t.Fatalf("Lookup %d missed the closest alive node", i)
+		}
+
+		for _, info := range found {
+			if down[info.ID] {
+				t.Fatalf("Lookup %d returned a failed node", i)
+			}
+		}
+	}
+}
+
+func TestDHTEmpty(t *testing.T) {
+
+	node, err := NewNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dht, err := node.DHT()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := dht.FindNode(DHTKey{}); err != ErrDHTNoContacts {
+		t.Fatalf("Expected ErrDHTNoContacts, got %v", err)
+	}
+
+	// a lone node keeps its own records
+	if err := dht.Provide(DHTKey{1}); err != nil {
+		t.Fatal(err)
+	}
+
+	providers, err := dht.FindProviders(DHTKey{1})
+	if err != nil || len(providers) != 1 || providers[0].ID != node.ID() {
+		t.Fatalf("Expected the node as provider, got %v %v", providers, err)
+	}
+
+	if _, err := NewNodeFromInfo(node.Info()).DHT(); err != ErrNodeWithoutIdentity {
+		t.Fatalf("Expected ErrNodeWithoutIdentity for a remote node, got %v", err)
+	}
+}
+
+func TestDHTOverRPC(t *testing.T) {
+
+	newMember := func() (*Node, string) {
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		host, port := splitTestAddress(t, listener.Addr().String())
+
+		node, err := NewWithConfig(NodeConfig{IP: host, RPCPort: port})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		go NewRPCServer(node).Serve(listener)
+		t.Cleanup(func() { listener.Close() })
+
+		return node, listener.Addr().String()
+	}
+
+	caNode, address := newMember()
+	if err := caNode.BootstrapClusterCA(); err != nil {
+		t.Fatal(err)
+	}
+
+	var members []*Node
+	for i := 0; i < 3; i++ {
+
+		member, _ := newMember()
+
+		jt, err := caNode.NewJoinToken()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := member.Join(jt.String(), address); err != nil {
+			t.Fatal(err)
+		}
+
+		dht, err := member.DHT()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := dht.Bootstrap(nil); err != nil {
+			t.Fatal(err)
+		}
+
+		members = append(members, member)
+	}
+
+	key := DHTKey(sha256.Sum256([]byte("chunk")))
+
+	provider, err := members[0].DHT()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := provider.Provide(key); err != nil {
+		t.Fatal(err)
+	}
+
+	lookup, err := members[2].DHT()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lookup.Table().Len() < 2 {
+		t.Fatalf("Expected bootstrap to discover the members, %d contacts", lookup.Table().Len())
+	}
+
+	providers, err := lookup.FindProviders(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(providers) != 1 || providers[0].ID != members[0].ID() {
+		t.Fatalf("Expected %s as provider, got %v", members[0].ID(), testInfoIDs(providers))
+	}
+
+	// a guest can look up the key but not store records
+	guest, err := NewNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := guest.DialRPC(address, caNode.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if _, err := client.FindValue(guest.Info(), key); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.StoreProvider(guest.Info(), key); err == nil {
+		t.Fatal("Expected guest not allowed to store a record")
+	}
+
+	caDHT, err := caNode.DHT()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, contact := range caDHT.Table().Contacts() {
+		if contact.ID == guest.ID() {
+			t.Fatal("Expected guest not recorded in the routing table")
+		}
+	}
+
+	// the connection to a contact is reused, a broken one is dialed again
+	dhtClient := NewRPCDHTClient(members[1])
+	defer dhtClient.Close()
+
+	contact := members[0].Info()
+	clientKey := rpcDHTClientKey{id: contact.ID, member: true}
+
+	if err := dhtClient.Ping(contact); err != nil {
+		t.Fatal(err)
+	}
+
+	first := dhtClient.clients[clientKey]
+
+	if err := dhtClient.Ping(contact); err != nil || dhtClient.clients[clientKey] != first {
+		t.Fatalf("Expected the connection reused, got %v", err)
+	}
+
+	first.Close()
+
+	if err := dhtClient.Ping(contact); err != nil || dhtClient.clients[clientKey] == first {
+		t.Fatalf("Expected the broken connection dialed again, got %v", err)
+	}
+}
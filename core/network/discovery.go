@@ -0,0 +1,370 @@
+package network
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MARK: consts & vars
+
+const (
+	// administratively scoped IPv4 multicast group the nodes announce themselves on
+	DefaultDiscoveryGroup = "239.255.64.14:6415"
+	// interval between the announcements of a node
+	DefaultDiscoveryInterval = 5 * time.Second
+	// time spent collecting announcements browsing the local network
+	DefaultDiscoveryBrowseTimeout = 2 * time.Second
+
+	// tags the packets of the discovery protocol, other traffic on the group is ignored
+	discoveryMagic = "vortex-discovery-v1"
+
+	discoveryPacketAnnounce = "announce"
+	discoveryPacketQuery    = "query"
+
+	discoveryMaxPacketSize = 64 << 10
+)
+
+var (
+	// ErrDiscoveryClosed - Returned using a Discovery after Close
+	ErrDiscoveryClosed = errors.New("discovery closed")
+	// ErrNotClusterMember - Returned announcing a node without valid cluster credentials
+	ErrNotClusterMember = errors.New("node is not a cluster member")
+)
+
+// MARK: DiscoveryConn, DiscoveryAnnouncement & DiscoveredCluster
+
+// DiscoveryConn - Defines the packet socket the discovery protocol runs on, e.g. a multicast *net.UDPConn.
+// Packets written to the group are expected to be received by every socket joined to it, the sender included
+type DiscoveryConn interface {
+	// Close - Closes the socket, blocked reads return an error
+	Close() error
+	// ReadFrom - Reads a packet, see net.PacketConn
+	ReadFrom(b []byte) (int, net.Addr, error)
+	// SetReadDeadline - Sets the deadline of the reads, see net.PacketConn
+	SetReadDeadline(t time.Time) error
+	// WriteTo - Writes a packet to addr, see net.PacketConn
+	WriteTo(b []byte, addr net.Addr) (int, error)
+}
+
+// DiscoveryAnnouncement - Defines the announcement of a node on the local network, signed by the node identity.
+// An announcement only proves the identity of the node, joining its cluster still requires a JoinToken
+type DiscoveryAnnouncement struct {
+	Node      NodeInfo `json:"node"`
+	Cluster   string   `json:"cluster"`
+	Signature []byte   `json:"signature,omitempty"`
+}
+
+// DiscoveredCluster - Defines a cluster found on the local network, ID is the ID of its CA node
+type DiscoveredCluster struct {
+	ID    string     `json:"id"`
+	Nodes []NodeInfo `json:"nodes"`
+}
+
+// discoveryPacket - Defines the packets exchanged on the group, a query asks the nodes to announce themselves
+type discoveryPacket struct {
+	Magic        string                 `json:"magic"`
+	Type         string                 `json:"type"`
+	Announcement *DiscoveryAnnouncement `json:"announcement,omitempty"`
+}
+
+// NewDiscoveryAnnouncement - Returns the announcement of the node signed by its identity, the node must be a cluster member
+func NewDiscoveryAnnouncement(node *Node) (*DiscoveryAnnouncement, error) {
+
+	node.RLock()
+	identity := node.identity
+	node.RUnlock()
+
+	if identity == nil {
+		return nil, ErrNodeWithoutIdentity
+	}
+
+	cluster := node.ClusterCAInfo().ID
+	if cluster == "" || !node.IsMember() {
+		return nil, ErrNotClusterMember
+	}
+
+	announcement := &DiscoveryAnnouncement{Node: node.Info(), Cluster: cluster}
+
+	payload, err := announcement.payload()
+	if err != nil {
+		return nil, err
+	}
+
+	announcement.Signature = ed25519.Sign(identity, payload)
+
+	return announcement, nil
+}
+
+// MARK: DiscoveryAnnouncement exported
+
+// Verify - Verifies the announcement has been signed by the identity of the node it describes
+func (a *DiscoveryAnnouncement) Verify() error {
+
+	pub := ed25519.PublicKey(a.Node.PublicKey)
+	if len(pub) != ed25519.PublicKeySize || NodeIDFromPublicKey(pub) != a.Node.ID {
+		return NewInvalidDiscoveryAnnouncementError("node ID does not match the public key")
+	}
+
+	if a.Cluster == "" || a.Node.Host == "" {
+		return NewInvalidDiscoveryAnnouncementError("missing cluster or address")
+	}
+
+	payload, err := a.payload()
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(pub, payload, a.Signature) {
+		return NewInvalidDiscoveryAnnouncementError("invalid signature")
+	}
+
+	return nil
+}
+
+// MARK: DiscoveryAnnouncement unexported
+
+// payload - Returns the signed content of the announcement
+func (a *DiscoveryAnnouncement) payload() ([]byte, error) {
+	return json.Marshal(DiscoveryAnnouncement{Node: a.Node, Cluster: a.Cluster})
+}
+
+// MARK: Discovery & constructors
+
+// Discovery - Defines the discovery of the nodes on the local network over a multicast group.
+// Nodes announce themselves every interval and whenever a browsing node queries the group
+type Discovery struct {
+	sync.Mutex
+	conn   DiscoveryConn
+	group  net.Addr
+	closed bool
+}
+
+// NewDiscovery - Returns a new instance of Discovery sending the packets to group over conn
+func NewDiscovery(conn DiscoveryConn, group net.Addr) *Discovery {
+	return &Discovery{conn: conn, group: group}
+}
+
+// ListenDiscovery - Returns a Discovery joined to the UDP multicast group, e.g. DefaultDiscoveryGroup
+func ListenDiscovery(group string) (*Discovery, error) {
+
+	addr, err := net.ResolveUDPAddr("udp", group)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.ListenMulticastUDP("udp", nil, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewDiscovery(conn, addr), nil
+}
+
+// MARK: Discovery exported
+
+// Announce - Sends the announcement to the group
+func (d *Discovery) Announce(announcement *DiscoveryAnnouncement) error {
+	return d.send(discoveryPacket{Type: discoveryPacketAnnounce, Announcement: announcement})
+}
+
+// Browse - Queries the group and returns the clusters announced until timeout, sorted by ID with their nodes sorted by ID.
+// Announcements with an invalid signature are ignored
+func (d *Discovery) Browse(timeout time.Duration) ([]DiscoveredCluster, error) {
+
+	if err := d.conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+
+	if err := d.send(discoveryPacket{Type: discoveryPacketQuery}); err != nil {
+		return nil, err
+	}
+
+	nodes := make(map[string]DiscoveryAnnouncement)
+
+	for {
+
+		packet, err := d.receive()
+
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if packet == nil || packet.Type != discoveryPacketAnnounce || packet.Announcement == nil {
+			continue
+		}
+
+		if packet.Announcement.Verify() != nil {
+			continue
+		}
+
+		nodes[packet.Announcement.Node.ID] = *packet.Announcement
+	}
+
+	return groupDiscoveredClusters(nodes), nil
+}
+
+// Close - Closes the socket of the discovery
+func (d *Discovery) Close() error {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.closed {
+		return nil
+	}
+
+	d.closed = true
+
+	return d.conn.Close()
+}
+
+// RunAnnouncer - Announces the node every interval and on every query until stop is closed, then the discovery is closed.
+// Nothing is announced while the node is not a cluster member
+func (d *Discovery) RunAnnouncer(node *Node, interval time.Duration, stop <-chan struct{}) {
+
+	queries := make(chan struct{}, 1)
+
+	go func() {
+		for {
+
+			packet, err := d.receive()
+			if err != nil {
+
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					continue
+				}
+
+				return
+			}
+
+			if packet != nil && packet.Type == discoveryPacketQuery {
+				select {
+				case queries <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	announce := func() {
+		if announcement, err := NewDiscoveryAnnouncement(node); err == nil {
+			d.Announce(announcement)
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	announce()
+
+	for {
+		select {
+		case <-stop:
+			d.Close()
+			return
+		case <-ticker.C:
+			announce()
+		case <-queries:
+			announce()
+		}
+	}
+}
+
+// MARK: Discovery unexported
+
+// receive - Reads the next packet, packets of other protocols are returned as nil
+func (d *Discovery) receive() (*discoveryPacket, error) {
+
+	buf := make([]byte, discoveryMaxPacketSize)
+
+	n, _, err := d.conn.ReadFrom(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	var packet discoveryPacket
+	if err := json.Unmarshal(buf[:n], &packet); err != nil || packet.Magic != discoveryMagic {
+		return nil, nil
+	}
+
+	return &packet, nil
+}
+
+// send - Writes the packet to the group
+func (d *Discovery) send(packet discoveryPacket) error {
+
+	d.Lock()
+	closed := d.closed
+	d.Unlock()
+
+	if closed {
+		return ErrDiscoveryClosed
+	}
+
+	packet.Magic = discoveryMagic
+
+	data, err := json.Marshal(packet)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.conn.WriteTo(data, d.group)
+
+	return err
+}
+
+// MARK: discovery utils unexported
+
+// groupDiscoveredClusters - Returns the announced nodes grouped by cluster, sorted by ID
+func groupDiscoveredClusters(nodes map[string]DiscoveryAnnouncement) []DiscoveredCluster {
+
+	byID := make(map[string]*DiscoveredCluster)
+	for _, announcement := range nodes {
+
+		cluster, ok := byID[announcement.Cluster]
+		if !ok {
+			cluster = &DiscoveredCluster{ID: announcement.Cluster}
+			byID[announcement.Cluster] = cluster
+		}
+
+		cluster.Nodes = append(cluster.Nodes, announcement.Node)
+	}
+
+	clusters := make([]DiscoveredCluster, 0, len(byID))
+	for _, cluster := range byID {
+
+		sort.Slice(cluster.Nodes, func(i, j int) bool { return cluster.Nodes[i].ID < cluster.Nodes[j].ID })
+		clusters = append(clusters, *cluster)
+	}
+
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i].ID < clusters[j].ID })
+
+	return clusters
+}
+
+// MARK: InvalidDiscoveryAnnouncementError
+
+// InvalidDiscoveryAnnouncementError - Defines error for an announcement not signed by the node it describes
+type InvalidDiscoveryAnnouncementError struct {
+	reason string
+}
+
+// NewInvalidDiscoveryAnnouncementError - Returns a new instance of InvalidDiscoveryAnnouncementError
+func NewInvalidDiscoveryAnnouncementError(reason string) error {
+	return &InvalidDiscoveryAnnouncementError{reason: reason}
+}
+
+// Error - Implements error interface
+func (e *InvalidDiscoveryAnnouncementError) Error() string {
+	return fmt.Sprintf("Invalid discovery announcement: %s", e.reason)
+}
@@ -0,0 +1,235 @@
+package network
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testDiscoveryHub - Delivers the packets written by any of its conns to all of them, like a multicast group with loopback
+type testDiscoveryHub struct {
+	sync.Mutex
+	conns []*testDiscoveryConn
+}
+
+// testDiscoveryConn - Defines an in-memory DiscoveryConn joined to a testDiscoveryHub
+type testDiscoveryConn struct {
+	sync.Mutex
+	hub      *testDiscoveryHub
+	packets  chan []byte
+	closed   chan struct{}
+	deadline time.Time
+}
+
+func (h *testDiscoveryHub) conn() *testDiscoveryConn {
+	h.Lock()
+	defer h.Unlock()
+
+	conn := &testDiscoveryConn{hub: h, packets: make(chan []byte, 64), closed: make(chan struct{})}
+	h.conns = append(h.conns, conn)
+
+	return conn
+}
+
+func (c *testDiscoveryConn) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func (c *testDiscoveryConn) ReadFrom(b []byte) (int, net.Addr, error) {
+
+	c.Lock()
+	deadline := c.deadline
+	c.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case packet := <-c.packets:
+		return copy(b, packet), nil, nil
+	case <-timeout:
+		return 0, nil, &net.OpError{Op: "read", Err: testTimeoutError{}}
+	case <-c.closed:
+		return 0, nil, net.ErrClosed
+	}
+}
+
+func (c *testDiscoveryConn) SetReadDeadline(t time.Time) error {
+	c.Lock()
+	defer c.Unlock()
+	c.deadline = t
+	return nil
+}
+
+func (c *testDiscoveryConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.hub.Lock()
+	defer c.hub.Unlock()
+
+	for _, conn := range c.hub.conns {
+		select {
+		case conn.packets <- append([]byte(nil), b...):
+		default:
+		}
+	}
+
+	return len(b), nil
+}
+
+type testTimeoutError struct{}
+
+func (testTimeoutError) Error() string   { return "i/o timeout" }
+func (testTimeoutError) Timeout() bool   { return true }
+func (testTimeoutError) Temporary() bool { return true }
+
+func newTestClusterNode(t *testing.T) *Node {
+
+	node, err := NewNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := node.BootstrapClusterCA(); err != nil {
+		t.Fatal(err)
+	}
+
+	return node
+}
+
+func TestDiscoveryAnnouncementVerify(t *testing.T) {
+
+	node, err := NewNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewDiscoveryAnnouncement(node); err != ErrNotClusterMember {
+		t.Fatalf("Expected ErrNotClusterMember announcing a node outside a cluster, got %v", err)
+	}
+
+	node = newTestClusterNode(t)
+
+	announcement, err := NewDiscoveryAnnouncement(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := announcement.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	if announcement.Cluster != node.ID() {
+		t.Fatalf("Expected cluster %s, got %s", node.ID(), announcement.Cluster)
+	}
+
+	// redirecting the announcement to another address breaks the signature
+	forged := *announcement
+	forged.Node.Host = "10.66.66.66"
+
+	if err := forged.Verify(); err == nil {
+		t.Fatal("Expected error verifying a forged announcement")
+	}
+
+	// impersonating another node breaks the ID check
+	other := newTestClusterNode(t)
+	forged = *announcement
+	forged.Node.ID = other.ID()
+
+	if err := forged.Verify(); err == nil {
+		t.Fatal("Expected error verifying an announcement with a foreign ID")
+	}
+}
+
+func TestDiscoveryBrowse(t *testing.T) {
+
+	hub := &testDiscoveryHub{}
+	group := &net.UDPAddr{IP: net.ParseIP("239.255.64.14"), Port: 6415}
+
+	first := newTestClusterNode(t)
+	second := newTestClusterNode(t)
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	// long intervals, the nodes are expected to answer the browse query
+	for _, node := range []*Node{first, second} {
+		go NewDiscovery(hub.conn(), group).RunAnnouncer(node, time.Hour, stop)
+	}
+
+	browser := NewDiscovery(hub.conn(), group)
+	defer browser.Close()
+
+	// a forged announcement on the group is ignored
+	forged, err := NewDiscoveryAnnouncement(newTestClusterNode(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged.Node.Host = "10.66.66.66"
+
+	if err := browser.Announce(forged); err != nil {
+		t.Fatal(err)
+	}
+
+	clusters, err := browser.Browse(200 * time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(clusters) != 2 {
+		t.Fatalf("Expected 2 clusters discovered, got %+v", clusters)
+	}
+
+	for _, cluster := range clusters {
+
+		if len(cluster.Nodes) != 1 || cluster.Nodes[0].ID != cluster.ID {
+			t.Fatalf("Expected the CA node alone in cluster %s, got %+v", cluster.ID, cluster.Nodes)
+		}
+
+		if cluster.ID != first.ID() && cluster.ID != second.ID() {
+			t.Fatalf("Unexpected cluster %s", cluster.ID)
+		}
+	}
+
+	if clusters[0].ID > clusters[1].ID {
+		t.Fatal("Expected clusters sorted by ID")
+	}
+}
+
+func TestDiscoveryLoopbackMulticast(t *testing.T) {
+
+	listen := func() *Discovery {
+		discovery, err := ListenDiscovery("239.255.64.14:46415")
+		if err != nil {
+			t.Skipf("Multicast not available: %v", err)
+		}
+		return discovery
+	}
+
+	node := newTestClusterNode(t)
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go listen().RunAnnouncer(node, 50*time.Millisecond, stop)
+
+	browser := listen()
+	defer browser.Close()
+
+	clusters, err := browser.Browse(500 * time.Millisecond)
+	if err != nil {
+		t.Skipf("Multicast not available: %v", err)
+	}
+
+	if len(clusters) == 0 {
+		t.Skip("Multicast loopback not delivered")
+	}
+
+	if clusters[0].ID != node.ID() {
+		t.Fatalf("Expected cluster %s, got %s", node.ID(), clusters[0].ID)
+	}
+}
@@ -0,0 +1,140 @@
+package network
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/IacopoMelani/vortex/utils"
+)
+
+// MARK: consts
+
+const (
+	// name of the file storing the node identity key in the data directory
+	IdentityKeyFileName = "identity.key"
+
+	identityPEMPrivateKeyType = "PRIVATE KEY"
+	identityPEMPublicKeyType  = "PUBLIC KEY"
+)
+
+// MARK: Identity utils exported
+
+// Fingerprint - Returns the fingerprint of the identity public key
+func Fingerprint(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:])
+}
+
+// NodeIDFromPublicKey - Returns the node ID derived from the identity public key
+func NodeIDFromPublicKey(pub ed25519.PublicKey) string {
+	return Fingerprint(pub)
+}
+
+// LoadIdentity - Loads the identity key stored in the data directory passed
+func LoadIdentity(dataDir string) (ed25519.PrivateKey, error) {
+
+	b, err := os.ReadFile(filepath.Join(dataDir, IdentityKeyFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != identityPEMPrivateKeyType {
+		return nil, NewMalformedIdentityError(dataDir)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	identity, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, NewMalformedIdentityError(dataDir)
+	}
+
+	return identity, nil
+}
+
+// LoadOrCreateIdentity - Loads the identity key stored in the data directory, a new one is generated and stored if missing
+func LoadOrCreateIdentity(dataDir string) (ed25519.PrivateKey, error) {
+
+	identity, err := LoadIdentity(dataDir)
+	if err == nil || !os.IsNotExist(err) {
+		return identity, err
+	}
+
+	_, identity, err = ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := x509.MarshalPKCS8PrivateKey(identity)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return nil, err
+	}
+
+	data := pem.EncodeToMemory(&pem.Block{Type: identityPEMPrivateKeyType, Bytes: b})
+	if err := utils.WriteFileAtomic(filepath.Join(dataDir, IdentityKeyFileName), data, 0600); err != nil {
+		return nil, err
+	}
+
+	return identity, nil
+}
+
+// MarshalPublicIdentity - Returns the identity public key PEM encoded
+func MarshalPublicIdentity(pub ed25519.PublicKey) ([]byte, error) {
+
+	b, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: identityPEMPublicKeyType, Bytes: b}), nil
+}
+
+// MARK: MalformedIdentityError
+
+// MalformedIdentityError - Defines error for an identity key file that can not be decoded
+type MalformedIdentityError struct {
+	dataDir string
+}
+
+// NewMalformedIdentityError - Returns a new instance of MalformedIdentityError
+func NewMalformedIdentityError(dataDir string) error {
+	return &MalformedIdentityError{dataDir: dataDir}
+}
+
+// Error - Implements error interface
+func (e *MalformedIdentityError) Error() string {
+	return fmt.Sprintf("Malformed identity key in %s", filepath.Join(e.dataDir, IdentityKeyFileName))
+}
+
+// MARK: IdentityMismatchError
+
+// IdentityMismatchError - Defines error for a node that does not prove the expected identity
+type IdentityMismatchError struct {
+	expected string
+	actual   string
+}
+
+// NewIdentityMismatchError - Returns a new instance of IdentityMismatchError
+func NewIdentityMismatchError(expected, actual string) error {
+	return &IdentityMismatchError{expected: expected, actual: actual}
+}
+
+// Error - Implements error interface
+func (e *IdentityMismatchError) Error() string {
+	return fmt.Sprintf("Node identity mismatch: expected %s, got %s", e.expected, e.actual)
+}
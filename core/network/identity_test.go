@@ -0,0 +1,43 @@
+package network
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadOrCreateIdentity(t *testing.T) {
+
+	dataDir := filepath.Join(t.TempDir(), "data")
+
+	if _, err := LoadIdentity(dataDir); !os.IsNotExist(err) {
+		t.Fatalf("Expected not exist error, got %v", err)
+	}
+
+	first, err := NewWithConfig(NodeConfig{DataDir: dataDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(filepath.Join(dataDir, IdentityKeyFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("Expected identity key permissions 0600, got %o", info.Mode().Perm())
+	}
+
+	second, err := NewWithConfig(NodeConfig{DataDir: dataDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first.ID() != second.ID() {
+		t.Fatalf("Expected the same node id across restarts, got %s and %s", first.ID(), second.ID())
+	}
+
+	if first.ID() != NodeIDFromPublicKey(first.PublicKey()) {
+		t.Fatal("Node id not derived from its public key")
+	}
+}
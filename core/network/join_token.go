@@ -1,6 +1,12 @@
 package network
 
 import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
 	"sync"
 	"time"
 
@@ -11,65 +17,187 @@ import (
 // MARK: consts
 
 const (
-	DefaultExpJoinToken = 60 * 5 // 5 minutes
+	DefaultExpJoinToken           = 60 * 5 // 5 minutes
+	DefaultJoinTokenSweepInterval = 60     // 1 minute
+
+	// prefix of the encoded join token, the number is the format version
+	JoinTokenPrefix = "VXTKN-1-"
 )
 
 // MARK: JoinToken, JoinTokenConfig & constructors
 
-// JoinToken - Defines a struct for node join token
+// JoinToken - Defines a struct for node join token.
+// A JoinToken is encoded as a single opaque string signed by the identity of the issuing node, see String
 type JoinToken struct {
 	sync.RWMutex
-	id    string
-	host  string
-	value string
-	iat   time.Time
-	exp   time.Time
+	id          string
+	host        string
+	rpcPort     string
+	value       string
+	fingerprint string
+	iat         time.Time
+	exp         time.Time
+	payload     []byte
+	signature   []byte
 }
 
-// JoinTokenConfig - Defines the JoinToken config for constructor
+// JoinTokenConfig - Defines the JoinToken config for constructor, Identity is the key used to sign the token
 type JoinTokenConfig struct {
-	Host string
+	Host     string
+	RPCPort  string
+	Identity ed25519.PrivateKey
+}
+
+// JoinRequest - Defines the payload sent by a node joining the network
+type JoinRequest struct {
+	Token string   `json:"token"`
+	Node  NodeInfo `json:"node"`
+}
+
+// JoinResponse - Defines the payload returned to a node joining the network.
+// Credentials are issued by the cluster CA, held by the CA node
+type JoinResponse struct {
+	Node        NodeInfo         `json:"node"`
+	CA          NodeInfo         `json:"ca"`
+	Credentials *NodeCredentials `json:"credentials"`
+}
+
+// joinTokenClaims - Defines the signed content of an encoded JoinToken
+type joinTokenClaims struct {
+	ID          string `json:"id"`
+	Host        string `json:"host"`
+	RPCPort     string `json:"port"`
+	Iat         int64  `json:"iat"`
+	Exp         int64  `json:"exp"`
+	Fingerprint string `json:"fp"`
+	Secret      string `json:"secret"`
+}
+
+// NewJoinToken - Returns a new join token signed by the identity passed
+func NewJoinToken(identity ed25519.PrivateKey) (*JoinToken, error) {
+	return NewJoinTokenWithConfig(JoinTokenConfig{Identity: identity})
 }
 
-// NewJoinToken - Returns a new join token
-func NewJoinToken() (*JoinToken, error) {
+// NewJoinTokenWithConfig - Returns a new JoinToken instance with config param, see JoinTokenConfig
+func NewJoinTokenWithConfig(jtConfig JoinTokenConfig) (*JoinToken, error) {
+
+	if len(jtConfig.Identity) != ed25519.PrivateKeySize {
+		return nil, NewMalformedJoinTokenError("missing identity to sign the token")
+	}
 
 	value, err := utils.SecureRandomString(64)
 	if err != nil {
 		return nil, err
 	}
 
-	ipAddr, err := utils.GetPrimaryIP()
+	host := jtConfig.Host
+	if host == "" {
+
+		ipAddr, err := utils.GetPrimaryIP()
+		if err != nil {
+			return nil, err
+		}
+
+		host = ipAddr.String()
+	}
+
+	rpcPort := jtConfig.RPCPort
+	if rpcPort == "" {
+		rpcPort = DefaultRPCPort
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+
+	claims := joinTokenClaims{
+		ID:          uuid.New().String(),
+		Host:        host,
+		RPCPort:     rpcPort,
+		Iat:         now.Unix(),
+		Exp:         now.Add(DefaultExpJoinToken * time.Second).Unix(),
+		Fingerprint: Fingerprint(jtConfig.Identity.Public().(ed25519.PublicKey)),
+		Secret:      value,
+	}
+
+	payload, err := json.Marshal(claims)
 	if err != nil {
 		return nil, err
 	}
 
-	return &JoinToken{
-		id:    uuid.New().String(),
-		iat:   time.Now().UTC(),
-		exp:   time.Now().UTC().Add(DefaultExpJoinToken * time.Second),
-		host:  ipAddr.String(),
-		value: value,
-	}, nil
+	return newJoinTokenFromClaims(claims, payload, ed25519.Sign(jtConfig.Identity, payload)), nil
 }
 
-// NewJoinTokenWithConfig - Returns a new JoinToken instance with config param, see JoinTokenConfig
-func NewJoinTokenWithConfig(jtConfig JoinTokenConfig) (*JoinToken, error) {
+// ParseJoinToken - Decodes a JoinToken encoded with String, the signature is not verified, see Verify
+func ParseJoinToken(encoded string) (*JoinToken, error) {
+
+	if !strings.HasPrefix(encoded, JoinTokenPrefix) {
+		return nil, NewMalformedJoinTokenError("unknown format")
+	}
 
-	jt, err := NewJoinToken()
+	parts := strings.Split(strings.TrimPrefix(encoded, JoinTokenPrefix), ".")
+	if len(parts) != 2 {
+		return nil, NewMalformedJoinTokenError("unknown format")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
 	if err != nil {
-		return nil, err
+		return nil, NewMalformedJoinTokenError("invalid payload encoding")
 	}
 
-	if jtConfig.Host != "" {
-		jt.host = jtConfig.Host
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, NewMalformedJoinTokenError("invalid signature encoding")
+	}
+
+	var claims joinTokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, NewMalformedJoinTokenError("invalid payload")
 	}
 
-	return jt, nil
+	if claims.ID == "" || claims.Host == "" || claims.Fingerprint == "" || claims.Secret == "" {
+		return nil, NewMalformedJoinTokenError("missing claims")
+	}
+
+	return newJoinTokenFromClaims(claims, payload, signature), nil
+}
+
+func newJoinTokenFromClaims(claims joinTokenClaims, payload []byte, signature []byte) *JoinToken {
+	return &JoinToken{
+		id:          claims.ID,
+		host:        claims.Host,
+		rpcPort:     claims.RPCPort,
+		value:       claims.Secret,
+		fingerprint: claims.Fingerprint,
+		iat:         time.Unix(claims.Iat, 0).UTC(),
+		exp:         time.Unix(claims.Exp, 0).UTC(),
+		payload:     payload,
+		signature:   signature,
+	}
 }
 
 // MARK: JoinToken exported
 
+// Address - Returns the RPC address of the node that issued the JoinToken
+func (j *JoinToken) Address() string {
+	j.RLock()
+	defer j.RUnlock()
+	return net.JoinHostPort(j.host, strings.TrimPrefix(j.rpcPort, ":"))
+}
+
+// Exp - Returns JoinToken expiration time
+func (j *JoinToken) Exp() time.Time {
+	j.RLock()
+	defer j.RUnlock()
+	return j.exp
+}
+
+// Fingerprint - Returns the fingerprint of the identity of the node that issued the JoinToken
+func (j *JoinToken) Fingerprint() string {
+	j.RLock()
+	defer j.RUnlock()
+	return j.fingerprint
+}
+
+// Host - Returns JoinToken host
 func (j *JoinToken) Host() string {
 	j.RLock()
 	defer j.RUnlock()
@@ -83,9 +211,105 @@ func (j *JoinToken) ID() string {
 	return j.id
 }
 
+// IsExpired - Returns true if the JoinToken is expired at the time passed
+func (j *JoinToken) IsExpired(now time.Time) bool {
+	j.RLock()
+	defer j.RUnlock()
+	return !now.Before(j.exp)
+}
+
+// String - Returns the JoinToken encoded as a single opaque string, see ParseJoinToken
+func (j *JoinToken) String() string {
+	j.RLock()
+	defer j.RUnlock()
+	return JoinTokenPrefix + base64.RawURLEncoding.EncodeToString(j.payload) + "." + base64.RawURLEncoding.EncodeToString(j.signature)
+}
+
 // Value - Returns JoinToken Value
 func (j *JoinToken) Value() string {
 	j.RLock()
 	defer j.RUnlock()
 	return j.value
 }
+
+// Verify - Verifies the JoinToken has been signed by the identity public key passed
+func (j *JoinToken) Verify(pub ed25519.PublicKey) error {
+	j.RLock()
+	defer j.RUnlock()
+
+	if Fingerprint(pub) != j.fingerprint {
+		return NewIdentityMismatchError(j.fingerprint, Fingerprint(pub))
+	}
+
+	if !ed25519.Verify(pub, j.payload, j.signature) {
+		return NewMalformedJoinTokenError("invalid signature")
+	}
+
+	return nil
+}
+
+// MARK: InvalidJoinTokenError
+
+// InvalidJoinTokenError - Defines error for a join token not issued by the node or already consumed
+type InvalidJoinTokenError struct{}
+
+// NewInvalidJoinTokenError - Returns a new instance of InvalidJoinTokenError
+func NewInvalidJoinTokenError() error {
+	return &InvalidJoinTokenError{}
+}
+
+// Error - Implements error interface
+func (e *InvalidJoinTokenError) Error() string {
+	return "Invalid join token"
+}
+
+// MARK: ExpiredJoinTokenError
+
+// ExpiredJoinTokenError - Defines error for a join token used after its expiration
+type ExpiredJoinTokenError struct {
+	exp time.Time
+}
+
+// NewExpiredJoinTokenError - Returns a new instance of ExpiredJoinTokenError
+func NewExpiredJoinTokenError(jt *JoinToken) error {
+	return &ExpiredJoinTokenError{exp: jt.Exp()}
+}
+
+// Error - Implements error interface
+func (e *ExpiredJoinTokenError) Error() string {
+	return fmt.Sprintf("Join token expired at %s", e.exp.Format(time.RFC3339))
+}
+
+// MARK: InvalidJoinRequestError
+
+// InvalidJoinRequestError - Defines error for a malformed join request
+type InvalidJoinRequestError struct {
+	reason string
+}
+
+// NewInvalidJoinRequestError - Returns a new instance of InvalidJoinRequestError
+func NewInvalidJoinRequestError(reason string) error {
+	return &InvalidJoinRequestError{reason: reason}
+}
+
+// Error - Implements error interface
+func (e *InvalidJoinRequestError) Error() string {
+	return fmt.Sprintf("Invalid join request: %s", e.reason)
+}
+
+// MARK: MalformedJoinTokenError
+
+// MalformedJoinTokenError - Defines error for a join token that can not be decoded or verified
+type MalformedJoinTokenError struct {
+	reason string
+}
+
+// NewMalformedJoinTokenError - Returns a new instance of MalformedJoinTokenError
+func NewMalformedJoinTokenError(reason string) error {
+	return &MalformedJoinTokenError{reason: reason}
+}
+
+// Error - Implements error interface
+func (e *MalformedJoinTokenError) Error() string {
+	return fmt.Sprintf("Malformed join token: %s", e.reason)
+}
@@ -0,0 +1,235 @@
+package network
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNodeConsumeJoinToken(t *testing.T) {
+
+	node, err := NewNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jt, err := node.NewJoinToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := node.ValidateJoinToken(jt.Value()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := node.ConsumeJoinToken("invalid"); err == nil {
+		t.Fatal("Expected error consuming an invalid token")
+	}
+
+	if _, err := node.ConsumeJoinToken(jt.Value()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := node.ConsumeJoinToken(jt.Value()); err == nil {
+		t.Fatal("Expected error consuming a token twice")
+	}
+}
+
+func TestNodeConsumeJoinTokenConcurrent(t *testing.T) {
+
+	node, err := NewNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jt, err := node.NewJoinToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	consumed := 0
+
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := node.ConsumeJoinToken(jt.Value()); err == nil {
+				mu.Lock()
+				consumed++
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if consumed != 1 {
+		t.Fatalf("Expected token consumed once, consumed %d times", consumed)
+	}
+}
+
+func TestNodeJoinTokenExpiry(t *testing.T) {
+
+	node, err := NewNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired, err := node.NewJoinToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired.exp = time.Now().UTC().Add(-time.Second)
+
+	valid, err := node.NewJoinToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := node.ValidateJoinToken(expired.Value()); err == nil {
+		t.Fatal("Expected error validating an expired token")
+	}
+
+	if removed := node.SweepJoinTokens(time.Now().UTC()); removed != 1 {
+		t.Fatalf("Expected 1 token removed, removed %d", removed)
+	}
+
+	if _, err := node.ValidateJoinToken(valid.Value()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJoinTokenEncoding(t *testing.T) {
+
+	node, err := NewNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jt, err := node.NewJoinToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := ParseJoinToken(jt.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed.ID() != jt.ID() || parsed.Value() != jt.Value() || parsed.Address() != jt.Address() {
+		t.Fatal("Parsed token differs from the issued one")
+	}
+
+	if !parsed.Exp().Equal(jt.Exp()) {
+		t.Fatalf("Expected exp %s, got %s", jt.Exp(), parsed.Exp())
+	}
+
+	if parsed.Fingerprint() != Fingerprint(node.PublicKey()) {
+		t.Fatal("Token fingerprint differs from the node one")
+	}
+
+	if err := parsed.Verify(node.PublicKey()); err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := NewNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := parsed.Verify(other.PublicKey()); err == nil {
+		t.Fatal("Expected error verifying with another identity")
+	}
+
+	tampered := []byte(jt.String())
+	tampered[len(JoinTokenPrefix)+4] ^= 1
+
+	if parsed, err := ParseJoinToken(string(tampered)); err == nil && parsed.Verify(node.PublicKey()) == nil {
+		t.Fatal("Expected error verifying a tampered token")
+	}
+
+	if _, err := ParseJoinToken(jt.Value()); err == nil {
+		t.Fatal("Expected error parsing a raw secret")
+	}
+}
+
+// testNodeObserver - Records the changes notified by a node
+type testNodeObserver struct {
+	sync.Mutex
+	added   []string
+	removed []string
+}
+
+func (o *testNodeObserver) JoinTokenAdded(jt *JoinToken) {
+	o.Lock()
+	defer o.Unlock()
+	o.added = append(o.added, jt.ID())
+}
+
+func (o *testNodeObserver) JoinTokenRemoved(id string) {
+	o.Lock()
+	defer o.Unlock()
+	o.removed = append(o.removed, id)
+}
+
+func (o *testNodeObserver) NeighborAdded(info NodeInfo) {}
+
+func (o *testNodeObserver) NeighborRemoved(id string) {}
+
+func TestNodeRestoreJoinToken(t *testing.T) {
+
+	node, err := NewNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	observer := &testNodeObserver{}
+	node.SetObserver(observer)
+
+	jt, err := node.NewJoinToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(observer.added) != 1 || observer.added[0] != jt.ID() {
+		t.Fatalf("Expected issued token notified, got %v", observer.added)
+	}
+
+	// the token survives a restart of the node through its encoding
+	restarted, err := newNodeWithIdentity(node.identity, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := ParseJoinToken(jt.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := restarted.RestoreJoinToken(decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := restarted.ConsumeJoinToken(jt.Value()); err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := NewNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := other.RestoreJoinToken(decoded); err == nil {
+		t.Fatal("Expected error restoring a token issued by another node")
+	}
+
+	if _, err := node.ConsumeJoinToken(jt.Value()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(observer.removed) != 1 || observer.removed[0] != jt.ID() {
+		t.Fatalf("Expected consumed token notified, got %v", observer.removed)
+	}
+}
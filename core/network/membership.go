@@ -0,0 +1,662 @@
+package network
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MARK: consts
+
+// defines the states of a member
+const (
+	MemberAlive   = "alive"
+	MemberSuspect = "suspect"
+	MemberDead    = "dead"
+	MemberLeft    = "left"
+)
+
+const (
+	DefaultMembershipProbeInterval    = time.Second
+	DefaultMembershipProbeTimeout     = 500 * time.Millisecond
+	DefaultMembershipIndirectProbes   = 3
+	DefaultMembershipSuspicionTimeout = 5 * time.Second
+	// an update is gossiped RetransmitMult * log10(members + 1) times
+	DefaultMembershipRetransmitMult = 4
+	// maximum number of updates piggybacked on a message
+	DefaultMembershipMaxGossip = 16
+
+	membershipEventsBuffer = 64
+)
+
+// MARK: MembershipTransport
+
+// MembershipTransport - Defines the transport of the membership messages, see NewRPCMembershipTransport.
+// Every message piggybacks membership updates, the acks carry the updates of the receiver
+type MembershipTransport interface {
+	// Ping - Pings the member, returns its ack
+	Ping(to NodeInfo, updates []MemberUpdate, timeout time.Duration) ([]MemberUpdate, error)
+	// PingReq - Asks the member via to ping target, returns the ack of target relayed by via
+	PingReq(via NodeInfo, target NodeInfo, updates []MemberUpdate, timeout time.Duration) ([]MemberUpdate, error)
+}
+
+// MARK: Member, MemberUpdate & MembershipEvent
+
+// Member - Defines a member of the cluster as seen by the local node.
+// The incarnation is raised only by the member itself, refuting a suspicion
+type Member struct {
+	Info        NodeInfo  `json:"info"`
+	State       string    `json:"state"`
+	Incarnation uint64    `json:"incarnation"`
+	Since       time.Time `json:"since"`
+	// last time the member acked a probe, zero if never
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// MemberUpdate - Defines the state of a member disseminated by gossip
+type MemberUpdate struct {
+	Node        NodeInfo `json:"node"`
+	State       string   `json:"state"`
+	Incarnation uint64   `json:"incarnation"`
+}
+
+// MembershipEvent - Defines the change of state of a member, see Membership.Subscribe
+type MembershipEvent struct {
+	Member Member
+	// Previous is empty for a member just discovered
+	Previous string
+}
+
+// MARK: Membership, MembershipConfig & constructors
+
+// Membership - Defines the SWIM membership of a node: every ProbeInterval a member is pinged, on timeout IndirectProbes
+// other members ping it on behalf of the node. A member not answering is suspected, and declared dead if it does not
+// refute the suspicion within SuspicionTimeout. Changes are gossiped piggybacked on the probes
+type Membership struct {
+	sync.RWMutex
+	self        NodeInfo
+	incarnation uint64
+	left        bool
+	members     map[string]*Member
+	sorted      []*Member
+	transport   MembershipTransport
+	config      MembershipConfig
+	gossip      map[string]*membershipGossip
+	probeOrder  []string
+	subscribers map[chan MembershipEvent]struct{}
+	rand        *rand.Rand
+}
+
+// MembershipConfig - Defines the membership config struct, zero values are replaced by the defaults
+type MembershipConfig struct {
+	ProbeInterval    time.Duration
+	ProbeTimeout     time.Duration
+	IndirectProbes   int
+	SuspicionTimeout time.Duration
+	RetransmitMult   int
+	MaxGossip        int
+}
+
+// membershipGossip - Defines an update waiting to be gossiped
+type membershipGossip struct {
+	update    MemberUpdate
+	transmits int
+}
+
+// NewMembership - Returns a new instance of Membership for the node passed with the default config
+func NewMembership(self NodeInfo, transport MembershipTransport) *Membership {
+	return NewMembershipWithConfig(self, transport, MembershipConfig{})
+}
+
+// NewMembershipWithConfig - Returns a new instance of Membership for the node passed with the config passed
+func NewMembershipWithConfig(self NodeInfo, transport MembershipTransport, config MembershipConfig) *Membership {
+
+	if config.ProbeInterval <= 0 {
+		config.ProbeInterval = DefaultMembershipProbeInterval
+	}
+	if config.ProbeTimeout <= 0 {
+		config.ProbeTimeout = DefaultMembershipProbeTimeout
+	}
+	if config.IndirectProbes <= 0 {
+		config.IndirectProbes = DefaultMembershipIndirectProbes
+	}
+	if config.SuspicionTimeout <= 0 {
+		config.SuspicionTimeout = DefaultMembershipSuspicionTimeout
+	}
+	if config.RetransmitMult <= 0 {
+		config.RetransmitMult = DefaultMembershipRetransmitMult
+	}
+	if config.MaxGossip <= 0 {
+		config.MaxGossip = DefaultMembershipMaxGossip
+	}
+
+	m := &Membership{
+		self:        self,
+		members:     make(map[string]*Member),
+		transport:   transport,
+		config:      config,
+		gossip:      make(map[string]*membershipGossip),
+		subscribers: make(map[chan MembershipEvent]struct{}),
+		rand:        rand.New(rand.NewSource(time.Now().UnixNano())),
+		// a restarted node supersedes the states gossiped about its previous run, left included
+		incarnation: uint64(time.Now().UnixNano()),
+	}
+
+	m.enqueueLocked(MemberUpdate{Node: self, State: MemberAlive, Incarnation: m.incarnation})
+
+	return m
+}
+
+// MARK: Membership exported
+
+// Add - Adds the node as alive member, known members are left untouched. Returns false if the node was known
+func (m *Membership) Add(info NodeInfo) bool {
+
+	m.Lock()
+	defer m.Unlock()
+
+	if info.ID == m.self.ID {
+		return false
+	}
+
+	if _, ok := m.members[info.ID]; ok {
+		return false
+	}
+
+	m.applyLocked(MemberUpdate{Node: info, State: MemberAlive}, time.Now())
+
+	return true
+}
+
+// Config - Returns the config of the membership
+func (m *Membership) Config() MembershipConfig {
+	return m.config
+}
+
+// HandlePing - Serves a ping piggybacking updates, returns the ack updates
+func (m *Membership) HandlePing(updates []MemberUpdate) []MemberUpdate {
+	m.Apply(updates)
+	return m.nextGossip()
+}
+
+// HandlePingReq - Serves an indirect ping, pinging target on behalf of the sender. Returns the ack of target
+func (m *Membership) HandlePingReq(target NodeInfo, updates []MemberUpdate) ([]MemberUpdate, error) {
+
+	m.Apply(updates)
+
+	ack, err := m.transport.Ping(target, m.nextGossip(), m.config.ProbeTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	m.Apply(ack)
+
+	return m.nextGossip(), nil
+}
+
+// Apply - Applies the updates received by gossip, stale updates are ignored.
+// A suspicion about the node itself is refuted raising its incarnation
+func (m *Membership) Apply(updates []MemberUpdate) {
+
+	m.Lock()
+	defer m.Unlock()
+
+	now := time.Now()
+	for _, update := range updates {
+		m.applyLocked(update, now)
+	}
+}
+
+// Incarnation - Returns the incarnation of the node itself
+func (m *Membership) Incarnation() uint64 {
+	m.RLock()
+	defer m.RUnlock()
+	return m.incarnation
+}
+
+// Leave - Announces the departure of the node to up to count members, the node stops probing
+func (m *Membership) Leave(count int) {
+
+	m.Lock()
+	m.left = true
+	m.enqueueLocked(MemberUpdate{Node: m.self, State: MemberLeft, Incarnation: m.incarnation})
+	targets := m.aliveLocked()
+	m.Unlock()
+
+	if len(targets) > count {
+		targets = targets[:count]
+	}
+
+	var wg sync.WaitGroup
+	for _, target := range targets {
+
+		wg.Add(1)
+		go func(target NodeInfo) {
+			defer wg.Done()
+			m.transport.Ping(target, m.nextGossip(), m.config.ProbeTimeout)
+		}(target)
+	}
+
+	wg.Wait()
+}
+
+// Member - Returns the member with the ID passed
+func (m *Membership) Member(id string) (Member, bool) {
+	m.RLock()
+	defer m.RUnlock()
+
+	member, ok := m.members[id]
+	if !ok {
+		return Member{}, false
+	}
+
+	return *member, true
+}
+
+// Members - Returns the members known by the node sorted by ID, dead and left ones included
+func (m *Membership) Members() []Member {
+	m.RLock()
+	defer m.RUnlock()
+
+	members := make([]Member, 0, len(m.members))
+	for _, member := range m.members {
+		members = append(members, *member)
+	}
+
+	sort.Slice(members, func(i, j int) bool { return members[i].Info.ID < members[j].Info.ID })
+
+	return members
+}
+
+// Probe - Runs a protocol period: pings the next member, directly and then through other members,
+// suspects it if no ack arrives and declares dead the suspects not refuting in time
+func (m *Membership) Probe() {
+
+	m.expireSuspects(time.Now())
+
+	target, ok := m.nextTarget()
+	if !ok {
+		return
+	}
+
+	if ack, err := m.transport.Ping(target.Info, m.nextGossip(), m.config.ProbeTimeout); err == nil {
+		m.Apply(ack)
+		m.seen(target.Info.ID)
+		return
+	}
+
+	if m.probeIndirect(target.Info) {
+		m.seen(target.Info.ID)
+		return
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	// the member may have refuted meanwhile
+	if current := m.members[target.Info.ID]; current != nil && current.State == MemberAlive && current.Incarnation == target.Incarnation {
+		m.applyLocked(MemberUpdate{Node: current.Info, State: MemberSuspect, Incarnation: current.Incarnation}, time.Now())
+	}
+}
+
+// Run - Probes a member every ProbeInterval until stop is closed or the node leaves
+func (m *Membership) Run(stop <-chan struct{}) {
+
+	ticker := time.NewTicker(m.config.ProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if m.hasLeft() {
+				return
+			}
+			m.Probe()
+		}
+	}
+}
+
+// Subscribe - Returns a channel receiving the membership events and the func to unsubscribe.
+// Events are dropped if the subscriber does not keep up with the buffer
+func (m *Membership) Subscribe() (<-chan MembershipEvent, func()) {
+
+	events := make(chan MembershipEvent, membershipEventsBuffer)
+
+	m.Lock()
+	m.subscribers[events] = struct{}{}
+	m.Unlock()
+
+	return events, func() {
+		m.Lock()
+		defer m.Unlock()
+
+		if _, ok := m.subscribers[events]; ok {
+			delete(m.subscribers, events)
+			close(events)
+		}
+	}
+}
+
+// MARK: Membership unexported
+
+// aliveLocked - Returns the info of the members not dead or left in random order, must be called holding the lock
+func (m *Membership) aliveLocked() []NodeInfo {
+
+	var alive []NodeInfo
+	for _, member := range m.members {
+		if member.State == MemberAlive || member.State == MemberSuspect {
+			alive = append(alive, member.Info)
+		}
+	}
+
+	sort.Slice(alive, func(i, j int) bool { return alive[i].ID < alive[j].ID })
+	m.rand.Shuffle(len(alive), func(i, j int) { alive[i], alive[j] = alive[j], alive[i] })
+
+	return alive
+}
+
+// applyLocked - Applies the update following the SWIM precedence rules, must be called holding the lock
+func (m *Membership) applyLocked(update MemberUpdate, now time.Time) {
+
+	if update.Node.ID == "" {
+		return
+	}
+
+	if update.Node.ID == m.self.ID {
+		m.refuteLocked(update)
+		return
+	}
+
+	current, known := m.members[update.Node.ID]
+
+	if !known {
+
+		// a member is discovered only through an alive update, the others may be stale
+		if update.State != MemberAlive {
+			return
+		}
+
+		current = &Member{Info: update.Node}
+		m.members[update.Node.ID] = current
+
+	} else if !supersedes(update, current) {
+		return
+	}
+
+	previous := current.State
+
+	current.Info = update.Node
+	current.State = update.State
+	current.Incarnation = update.Incarnation
+	current.Since = now
+
+	if !known {
+		previous = ""
+	}
+
+	m.enqueueLocked(update)
+	m.notifyLocked(MembershipEvent{Member: *current, Previous: previous})
+}
+
+// enqueueLocked - Queues the update for gossip replacing the previous one about the same member, must be called holding the lock
+func (m *Membership) enqueueLocked(update MemberUpdate) {
+	m.gossip[update.Node.ID] = &membershipGossip{update: update}
+}
+
+func (m *Membership) expireSuspects(now time.Time) {
+
+	m.Lock()
+	defer m.Unlock()
+
+	for _, member := range m.members {
+		if member.State == MemberSuspect && now.Sub(member.Since) >= m.config.SuspicionTimeout {
+			m.applyLocked(MemberUpdate{Node: member.Info, State: MemberDead, Incarnation: member.Incarnation}, now)
+		}
+	}
+}
+
+func (m *Membership) hasLeft() bool {
+	m.RLock()
+	defer m.RUnlock()
+	return m.left
+}
+
+// nextGossip - Returns the updates to piggyback, the least transmitted first.
+// An update is dropped after RetransmitMult * log10(members + 1) transmissions,
+// the free slots carry the state of random members so that late joiners converge too
+func (m *Membership) nextGossip() []MemberUpdate {
+
+	m.Lock()
+	defer m.Unlock()
+
+	queue := make([]*membershipGossip, 0, len(m.gossip))
+	for _, g := range m.gossip {
+		queue = append(queue, g)
+	}
+
+	sort.Slice(queue, func(i, j int) bool {
+		if queue[i].transmits != queue[j].transmits {
+			return queue[i].transmits < queue[j].transmits
+		}
+		return queue[i].update.Node.ID < queue[j].update.Node.ID
+	})
+
+	limit := m.config.RetransmitMult * int(math.Ceil(math.Log10(float64(len(m.members)+2))))
+
+	updates := make([]MemberUpdate, 0, m.config.MaxGossip)
+	for _, g := range queue {
+
+		if len(updates) == m.config.MaxGossip {
+			break
+		}
+
+		updates = append(updates, g.update)
+
+		if g.transmits++; g.transmits >= limit {
+			delete(m.gossip, g.update.Node.ID)
+		}
+	}
+
+	queued := make(map[string]bool, len(updates))
+	for _, update := range updates {
+		queued[update.Node.ID] = true
+	}
+
+	if !queued[m.self.ID] && len(updates) < m.config.MaxGossip {
+		state := MemberAlive
+		if m.left {
+			state = MemberLeft
+		}
+		updates = append(updates, MemberUpdate{Node: m.self, State: state, Incarnation: m.incarnation})
+	}
+
+	for _, i := range m.rand.Perm(len(m.members)) {
+
+		if len(updates) >= m.config.MaxGossip {
+			break
+		}
+
+		member := m.memberAtLocked(i)
+		if !queued[member.Info.ID] {
+			updates = append(updates, MemberUpdate{Node: member.Info, State: member.State, Incarnation: member.Incarnation})
+		}
+	}
+
+	return updates
+}
+
+// memberAtLocked - Returns the i-th member sorted by ID, must be called holding the lock
+func (m *Membership) memberAtLocked(i int) *Member {
+
+	if len(m.sorted) != len(m.members) {
+
+		m.sorted = m.sorted[:0]
+		for _, member := range m.members {
+			m.sorted = append(m.sorted, member)
+		}
+
+		sort.Slice(m.sorted, func(i, j int) bool { return m.sorted[i].Info.ID < m.sorted[j].Info.ID })
+	}
+
+	return m.sorted[i]
+}
+
+// nextTarget - Returns the next member to probe, members are probed round robin in random order
+func (m *Membership) nextTarget() (Member, bool) {
+
+	m.Lock()
+	defer m.Unlock()
+
+	for {
+		if len(m.probeOrder) == 0 {
+
+			for _, info := range m.aliveLocked() {
+				m.probeOrder = append(m.probeOrder, info.ID)
+			}
+
+			if len(m.probeOrder) == 0 {
+				return Member{}, false
+			}
+		}
+
+		id := m.probeOrder[0]
+		m.probeOrder = m.probeOrder[1:]
+
+		if member, ok := m.members[id]; ok && (member.State == MemberAlive || member.State == MemberSuspect) {
+			return *member, true
+		}
+	}
+}
+
+func (m *Membership) notifyLocked(event MembershipEvent) {
+	for events := range m.subscribers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+// probeIndirect - Asks IndirectProbes other members to ping target, returns true if any ack arrives
+func (m *Membership) probeIndirect(target NodeInfo) bool {
+
+	m.Lock()
+	var helpers []NodeInfo
+	for _, info := range m.aliveLocked() {
+		if info.ID != target.ID && len(helpers) < m.config.IndirectProbes {
+			helpers = append(helpers, info)
+		}
+	}
+	m.Unlock()
+
+	if len(helpers) == 0 {
+		return false
+	}
+
+	acks := make(chan []MemberUpdate, len(helpers))
+	updates := m.nextGossip()
+
+	for _, helper := range helpers {
+		go func(helper NodeInfo) {
+
+			ack, err := m.transport.PingReq(helper, target, updates, m.config.ProbeTimeout)
+			if err != nil {
+				acks <- nil
+				return
+			}
+
+			acks <- ack
+		}(helper)
+	}
+
+	acked := false
+	for range helpers {
+		if ack := <-acks; ack != nil {
+			m.Apply(ack)
+			acked = true
+		}
+	}
+
+	return acked
+}
+
+// refuteLocked - Refutes a suspicion or death of the node itself gossiping a higher incarnation, must be called holding the lock
+func (m *Membership) refuteLocked(update MemberUpdate) {
+
+	if m.left || update.State == MemberAlive || update.State == MemberLeft {
+		return
+	}
+
+	// a stale suspicion is overridden by the current incarnation
+	if update.Incarnation >= m.incarnation {
+		m.incarnation = update.Incarnation + 1
+	}
+
+	m.enqueueLocked(MemberUpdate{Node: m.self, State: MemberAlive, Incarnation: m.incarnation})
+}
+
+// seen - Records the ack of the member received now
+func (m *Membership) seen(id string) {
+	m.Lock()
+	defer m.Unlock()
+
+	if member, ok := m.members[id]; ok {
+		member.LastSeen = time.Now()
+	}
+}
+
+// MARK: Membership utils unexported
+
+// supersedes - Returns true if the update overrides the current state of the member:
+// a higher incarnation wins, at the same incarnation suspect overrides alive and dead or left override both.
+// A left member is overridden only by an alive update with a higher incarnation, gossiped by the node restarted
+func supersedes(update MemberUpdate, current *Member) bool {
+
+	if current.State == MemberLeft {
+		return update.State == MemberAlive && update.Incarnation > current.Incarnation
+	}
+
+	switch update.State {
+	case MemberAlive:
+		return update.Incarnation > current.Incarnation
+
+	case MemberSuspect:
+		if current.State == MemberDead {
+			return false
+		}
+		return update.Incarnation > current.Incarnation || (update.Incarnation == current.Incarnation && current.State == MemberAlive)
+
+	case MemberDead:
+		if current.State == MemberDead {
+			return false
+		}
+		return update.Incarnation >= current.Incarnation
+
+	case MemberLeft:
+		return update.Incarnation >= current.Incarnation
+	}
+
+	return false
+}
+
+// MARK: InvalidMemberUpdateError
+
+// InvalidMemberUpdateError - Defines error for a membership message that can not be applied
+type InvalidMemberUpdateError struct {
+	reason string
+}
+
+// NewInvalidMemberUpdateError - Returns a new instance of InvalidMemberUpdateError
+func NewInvalidMemberUpdateError(reason string) error {
+	return &InvalidMemberUpdateError{reason: reason}
+}
+
+// Error - Implements error interface
+func (e *InvalidMemberUpdateError) Error() string {
+	return fmt.Sprintf("Invalid member update: %s", e.reason)
+}
@@ -0,0 +1,344 @@
+package network
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testLossyNetwork - Simulated network of memberships dropping every message with the given probability
+type testLossyNetwork struct {
+	sync.Mutex
+	members map[string]*Membership
+	crashed map[string]bool
+	loss    float64
+	rand    *rand.Rand
+}
+
+type testLossyTransport struct {
+	network *testLossyNetwork
+}
+
+var errTestMessageLost = errors.New("message lost")
+
+func newTestLossyNetwork(t *testing.T, n int, loss float64, config MembershipConfig) (*testLossyNetwork, []*Membership) {
+
+	network := &testLossyNetwork{
+		members: make(map[string]*Membership),
+		crashed: make(map[string]bool),
+		loss:    loss,
+		rand:    rand.New(rand.NewSource(1)),
+	}
+
+	memberships := make([]*Membership, 0, n)
+
+	for i := 0; i < n; i++ {
+
+		sum := sha256.Sum256([]byte(fmt.Sprintf("member-%d", i)))
+		info := NodeInfo{ID: hex.EncodeToString(sum[:]), Name: fmt.Sprintf("member-%d", i)}
+
+		membership := NewMembershipWithConfig(info, &testLossyTransport{network: network}, config)
+
+		// every member joins through the first one
+		if i > 0 {
+			membership.Add(memberships[0].self)
+			memberships[0].Add(info)
+		}
+
+		network.members[info.ID] = membership
+		memberships = append(memberships, membership)
+	}
+
+	return network, memberships
+}
+
+// deliver - Returns the membership receiving a message, or an error if the message is lost or the receiver crashed
+func (n *testLossyNetwork) deliver(id string) (*Membership, error) {
+	n.Lock()
+	defer n.Unlock()
+
+	if n.crashed[id] || n.rand.Float64() < n.loss {
+		return nil, errTestMessageLost
+	}
+
+	return n.members[id], nil
+}
+
+func (n *testLossyNetwork) crash(id string) {
+	n.Lock()
+	defer n.Unlock()
+	n.crashed[id] = true
+}
+
+func (t *testLossyTransport) Ping(to NodeInfo, updates []MemberUpdate, timeout time.Duration) ([]MemberUpdate, error) {
+
+	receiver, err := t.network.deliver(to.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	ack := receiver.HandlePing(updates)
+
+	// the ack can be lost too
+	if _, err := t.network.deliver(to.ID); err != nil {
+		return nil, err
+	}
+
+	return ack, nil
+}
+
+func (t *testLossyTransport) PingReq(via NodeInfo, target NodeInfo, updates []MemberUpdate, timeout time.Duration) ([]MemberUpdate, error) {
+
+	helper, err := t.network.deliver(via.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return helper.HandlePingReq(target, updates)
+}
+
+// runTestMemberships - Runs the protocol periods of the alive memberships for the duration passed
+func runTestMemberships(network *testLossyNetwork, memberships []*Membership, d time.Duration) {
+
+	stop := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for _, membership := range memberships {
+
+		network.Lock()
+		crashed := network.crashed[membership.self.ID]
+		network.Unlock()
+
+		if crashed {
+			continue
+		}
+
+		wg.Add(1)
+		go func(membership *Membership) {
+			defer wg.Done()
+			membership.Run(stop)
+		}(membership)
+	}
+
+	time.Sleep(d)
+	close(stop)
+	wg.Wait()
+}
+
+func testMemberStates(memberships []*Membership, id string) map[string]int {
+
+	states := make(map[string]int)
+	for _, membership := range memberships {
+		if membership.self.ID == id {
+			continue
+		}
+
+		member, ok := membership.Member(id)
+		if !ok {
+			states[""]++
+			continue
+		}
+
+		states[member.State]++
+	}
+
+	return states
+}
+
+func TestMembershipConvergesUnderLoss(t *testing.T) {
+
+	config := MembershipConfig{
+		ProbeInterval:    5 * time.Millisecond,
+		SuspicionTimeout: 200 * time.Millisecond,
+	}
+
+	network, memberships := newTestLossyNetwork(t, 8, 0.1, config)
+
+	runTestMemberships(network, memberships, 400*time.Millisecond)
+
+	// every member learned about every other one by gossip, no alive member has been declared dead
+	for _, membership := range memberships {
+
+		if states := testMemberStates(memberships, membership.self.ID); states[MemberDead] > 0 || states[""] > 0 {
+			t.Fatalf("Unexpected states of alive member %s: %v", membership.self.Name, states)
+		}
+	}
+}
+
+func TestMembershipDetectsFailure(t *testing.T) {
+
+	config := MembershipConfig{
+		ProbeInterval:    5 * time.Millisecond,
+		SuspicionTimeout: 50 * time.Millisecond,
+	}
+
+	network, memberships := newTestLossyNetwork(t, 6, 0.05, config)
+
+	runTestMemberships(network, memberships, 100*time.Millisecond)
+
+	events, unsubscribe := memberships[1].Subscribe()
+	defer unsubscribe()
+
+	crashed := memberships[4]
+	network.crash(crashed.self.ID)
+
+	runTestMemberships(network, memberships, 400*time.Millisecond)
+
+	if states := testMemberStates(memberships[:4], crashed.self.ID); states[MemberDead] != 4 {
+		t.Fatalf("Expected crashed member declared dead by every member, got %v", states)
+	}
+
+	// the crashed member was seen acking probes before crashing, the dead ones are not seen anymore
+	if member, ok := memberships[1].Member(crashed.self.ID); !ok || member.LastSeen.IsZero() || member.LastSeen.After(member.Since) {
+		t.Fatalf("Expected crashed member last seen before being declared dead, got %+v", member)
+	}
+
+	var seen []string
+	for len(events) > 0 {
+		if event := <-events; event.Member.Info.ID == crashed.self.ID {
+			seen = append(seen, event.Member.State)
+		}
+	}
+
+	if len(seen) == 0 || seen[len(seen)-1] != MemberDead {
+		t.Fatalf("Expected events ending with the member dead, got %v", seen)
+	}
+}
+
+func TestMembershipLeave(t *testing.T) {
+
+	config := MembershipConfig{ProbeInterval: 5 * time.Millisecond}
+
+	network, memberships := newTestLossyNetwork(t, 5, 0, config)
+
+	runTestMemberships(network, memberships, 50*time.Millisecond)
+
+	leaving := memberships[2]
+	leaving.Leave(3)
+
+	runTestMemberships(network, memberships, 100*time.Millisecond)
+
+	if states := testMemberStates(memberships, leaving.self.ID); states[MemberLeft] != 4 {
+		t.Fatalf("Expected member left for every member, got %v", states)
+	}
+
+	// the node restarted gossips a higher incarnation and joins again through the first member
+	restarted := NewMembershipWithConfig(leaving.self, &testLossyTransport{network: network}, config)
+	restarted.Add(memberships[0].self)
+
+	network.Lock()
+	network.members[leaving.self.ID] = restarted
+	network.Unlock()
+
+	memberships[2] = restarted
+
+	runTestMemberships(network, memberships, 200*time.Millisecond)
+
+	if states := testMemberStates(memberships, leaving.self.ID); states[MemberAlive] != 4 {
+		t.Fatalf("Expected restarted member alive for every member, got %v", states)
+	}
+
+	// the left state of the previous run is not gossiped back
+	memberships[0].Apply([]MemberUpdate{{Node: leaving.self, State: MemberLeft, Incarnation: leaving.Incarnation()}})
+
+	if member, _ := memberships[0].Member(leaving.self.ID); member.State != MemberAlive {
+		t.Fatalf("Expected stale left update ignored, got %s", member.State)
+	}
+}
+
+func TestMembershipRefuteSuspicion(t *testing.T) {
+
+	_, memberships := newTestLossyNetwork(t, 2, 0, MembershipConfig{})
+
+	self := memberships[1]
+	incarnation := self.Incarnation()
+
+	self.Apply([]MemberUpdate{{Node: self.self, State: MemberSuspect, Incarnation: incarnation}})
+
+	if self.Incarnation() != incarnation+1 {
+		t.Fatalf("Expected incarnation raised to %d, got %d", incarnation+1, self.Incarnation())
+	}
+
+	// the refutation reaches the suspecting member with the next ack
+	other := memberships[0]
+	other.Apply([]MemberUpdate{{Node: self.self, State: MemberSuspect, Incarnation: incarnation}})
+	other.Apply(self.HandlePing(nil))
+
+	member, ok := other.Member(self.self.ID)
+	if !ok || member.State != MemberAlive || member.Incarnation != incarnation+1 {
+		t.Fatalf("Expected member alive at incarnation %d, got %+v", incarnation+1, member)
+	}
+
+	// stale updates are ignored
+	other.Apply([]MemberUpdate{{Node: self.self, State: MemberDead, Incarnation: incarnation}})
+
+	if member, _ := other.Member(self.self.ID); member.State != MemberAlive {
+		t.Fatalf("Expected stale dead update ignored, got %s", member.State)
+	}
+}
+
+func TestMembershipOverRPC(t *testing.T) {
+
+	newMember := func() (*Node, net.Listener) {
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		host, port := splitTestAddress(t, listener.Addr().String())
+
+		node, err := NewWithConfig(NodeConfig{IP: host, RPCPort: port})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		go NewRPCServer(node).Serve(listener)
+
+		return node, listener
+	}
+
+	caNode, caListener := newMember()
+	defer caListener.Close()
+
+	if err := caNode.BootstrapClusterCA(); err != nil {
+		t.Fatal(err)
+	}
+
+	member, listener := newMember()
+
+	jt, err := caNode.NewJoinToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := member.Join(jt.String(), caListener.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+
+	membership, err := caNode.Membership()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	membership.Probe()
+
+	if m, ok := membership.Member(member.ID()); !ok || m.State != MemberAlive {
+		t.Fatalf("Expected joined node alive, got %+v", m)
+	}
+
+	// the joined node stops answering
+	listener.Close()
+
+	membership.Probe()
+
+	if m, _ := membership.Member(member.ID()); m.State != MemberSuspect {
+		t.Fatalf("Expected unreachable node suspected, got %s", m.State)
+	}
+}
@@ -1,20 +1,31 @@
 package network
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
 	"fmt"
+	"net"
 	"os"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/IacopoMelani/vortex/utils"
-	"github.com/google/uuid"
 )
 
-// MARK: consts
+// MARK: consts & vars
 
 const (
 	DefaultRPCPort = ":6414"
 )
 
+var (
+	// ErrNodeWithoutIdentity - Returned using a remote node as a local one, remote nodes have no identity key
+	ErrNodeWithoutIdentity = errors.New("node without identity key")
+)
+
 // MARK: Node, NodeConfig & constructors
 
 // Node - Defines a node of vortex network
@@ -22,46 +33,107 @@ type Node struct {
 	sync.RWMutex
 	neighbors  map[string]*Node
 	joinTokens map[string]*JoinToken
-	host       string
-	id         string
-	name       string
-	rpcPort    string
+	identity   ed25519.PrivateKey
+	publicKey  ed25519.PublicKey
+	transport  *Transport
+	ca         *ClusterCA
+	caInfo     NodeInfo
+	// the credentials loaded from the data directory are expired, the node has to join again
+	needsJoin bool
+	// the join tokens forwarded by the members the CA issued credentials for, until they expire
+	redeemedTokens map[string]time.Time
+	dht            *DHT
+	membership     *Membership
+	observer       NodeObserver
+	dataDir        string
+	listenAddr     string
+	host           string
+	id             string
+	name           string
+	rpcPort        string
 }
 
-// NodeConfig - Defines a node config struct
+// NodeConfig - Defines a node config struct.
+// When DataDir is set the node identity is loaded from it, or created on first use, otherwise an ephemeral one is generated.
+// IP and RPCPort are the address advertised to the other nodes, the RPC server listens on ListenAddr if set, on RPCPort otherwise.
+// Without IP the primary IP of the host is advertised, selected on Interface and in CIDR when set, see utils.SelectPrimaryIP
 type NodeConfig struct {
-	Name    string
-	IP      string
-	RPCPort string
+	Name       string
+	IP         string
+	RPCPort    string
+	ListenAddr string
+	Interface  string
+	CIDR       string
+	PreferIPv6 bool
+	DataDir    string
+}
+
+// NodeObserver - Defines a generic interface notified of the changes of the node state, e.g. to persist it.
+// Notifications are delivered after the node lock is released
+type NodeObserver interface {
+	// JoinTokenAdded - Notifies a join token issued by the node
+	JoinTokenAdded(jt *JoinToken)
+	// JoinTokenRemoved - Notifies a join token consumed or expired
+	JoinTokenRemoved(id string)
+	// NeighborAdded - Notifies a new neighbor of the node
+	NeighborAdded(info NodeInfo)
+	// NeighborRemoved - Notifies a neighbor removed from the node
+	NeighborRemoved(id string)
+}
+
+// NodeInfo - Defines the public info of a node exchanged with other nodes
+type NodeInfo struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Host      string `json:"host"`
+	RPCPort   string `json:"rpc_port"`
+	PublicKey []byte `json:"public_key,omitempty"`
 }
 
-// NewNode - Returns a new instance of Node
+// NewNode - Returns a new instance of Node with an ephemeral identity
 func NewNode() (*Node, error) {
 
-	hostname, err := os.Hostname()
+	_, identity, err := ed25519.GenerateKey(rand.Reader)
 	if err != nil {
 		return nil, err
 	}
 
-	ip, err := utils.GetPrimaryIP()
+	return newNodeWithIdentity(identity, "")
+}
+
+// NewWithConfig - Return a new instance of Node with config passed
+func NewWithConfig(config NodeConfig) (*Node, error) {
+
+	var identity ed25519.PrivateKey
+	var err error
+
+	if config.DataDir != "" {
+		identity, err = LoadOrCreateIdentity(config.DataDir)
+	} else {
+		_, identity, err = ed25519.GenerateKey(rand.Reader)
+	}
+
 	if err != nil {
 		return nil, err
 	}
 
-	return &Node{
-		id:         uuid.New().String(),
-		name:       hostname,
-		host:       ip.String(),
-		rpcPort:    DefaultRPCPort,
-		neighbors:  make(map[string]*Node),
-		joinTokens: make(map[string]*JoinToken),
-	}, nil
-}
+	// an IPv6 address may be written in its bracketed form
+	host := strings.Trim(config.IP, "[]")
+	if host == "" {
 
-// NewWithConfig - Return a new instance of Node with config passed
-func NewWithConfig(config NodeConfig) (*Node, error) {
+		ip, err := utils.GetPrimaryIPWithConfig(utils.PrimaryIPConfig{
+			Interface:  config.Interface,
+			CIDR:       config.CIDR,
+			PreferIPv6: config.PreferIPv6,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		host = ip.String()
+	}
 
-	node, err := NewNode()
+	node, err := newNodeWithIdentity(identity, host)
 	if err != nil {
 		return nil, err
 	}
@@ -75,31 +147,181 @@ func NewWithConfig(config NodeConfig) (*Node, error) {
 	if config.RPCPort != "" {
 		node.rpcPort = config.RPCPort
 	}
-	if config.IP != "" {
-		node.host = config.IP
-	}
+	node.dataDir = config.DataDir
+	node.listenAddr = config.ListenAddr
 
 	return node, nil
 }
 
+// NewNodeFromInfo - Returns a new instance of Node describing a remote node
+func NewNodeFromInfo(info NodeInfo) *Node {
+	return &Node{
+		publicKey:  ed25519.PublicKey(info.PublicKey),
+		id:         info.ID,
+		name:       info.Name,
+		host:       info.Host,
+		rpcPort:    info.RPCPort,
+		neighbors:  make(map[string]*Node),
+		joinTokens: make(map[string]*JoinToken),
+	}
+}
+
+// newNodeWithIdentity - Returns a new instance of Node advertising host, the primary IP of the host if empty
+func newNodeWithIdentity(identity ed25519.PrivateKey, host string) (*Node, error) {
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	if host == "" {
+
+		ip, err := utils.GetPrimaryIP()
+		if err != nil {
+			return nil, err
+		}
+
+		host = ip.String()
+	}
+
+	publicKey := identity.Public().(ed25519.PublicKey)
+
+	transport, err := NewTransport(identity)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Node{
+		identity:   identity,
+		publicKey:  publicKey,
+		transport:  transport,
+		id:         NodeIDFromPublicKey(publicKey),
+		name:       hostname,
+		host:       host,
+		rpcPort:    DefaultRPCPort,
+		neighbors:  make(map[string]*Node),
+		joinTokens: make(map[string]*JoinToken),
+	}, nil
+}
+
 // MARK: Node exported
 
+// AcceptJoin - Validates the join request of the peer against the issued join tokens and adds the joining node as neighbor
+func (n *Node) AcceptJoin(peer *RPCPeer, req JoinRequest) (*JoinResponse, error) {
+
+	if req.Node.ID == "" || req.Node.ID == n.ID() {
+		return nil, NewInvalidJoinRequestError("invalid node id")
+	}
+
+	if req.Node.ID != peer.ID {
+		return nil, NewIdentityMismatchError(peer.ID, req.Node.ID)
+	}
+
+	req.Node.PublicKey = peer.PublicKey
+
+	jt, err := ParseJoinToken(req.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := jt.Verify(n.PublicKey()); err != nil {
+		return nil, NewInvalidJoinTokenError()
+	}
+
+	// a failed join leaves no side effects: the request is checked before burning the token,
+	// the token is restored if the neighbor can not be added anyway
+	neighbor := NewNodeFromInfo(req.Node)
+
+	n.RLock()
+	_, isNeighbor := n.neighbors[neighbor.ID()]
+	registered, err := n.validateJoinToken(jt.Value(), time.Now().UTC())
+	n.RUnlock()
+
+	if err != nil {
+
+		// an expired token is useless anyway, it is removed
+		if registered != nil {
+			n.ConsumeJoinToken(jt.Value())
+		}
+		return nil, err
+	}
+
+	if isNeighbor {
+		return nil, NewNodeAlreadyNeighborError(neighbor)
+	}
+
+	if registered.ID() != jt.ID() {
+		return nil, NewInvalidJoinTokenError()
+	}
+
+	creds, err := n.IssueCredentials(peer.PublicKey, jt)
+	if err != nil {
+		return nil, err
+	}
+
+	consumed, err := n.ConsumeJoinToken(jt.Value())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := n.AddNeighbor(neighbor); err != nil {
+		n.registerJoinToken(consumed)
+		return nil, err
+	}
+
+	return &JoinResponse{Node: n.Info(), CA: n.ClusterCAInfo(), Credentials: creds}, nil
+}
+
 // AddNeighbor - Add new node in the neighbors networks
 func (n *Node) AddNeighbor(newNode *Node) error {
 
 	n.Lock()
-	defer n.Unlock()
 
 	_, ok := n.neighbors[newNode.ID()]
 	if ok {
+		n.Unlock()
 		return NewNodeAlreadyNeighborError(newNode)
 	}
 
 	n.neighbors[newNode.ID()] = newNode
+	observer := n.observer
+
+	n.Unlock()
+
+	if observer != nil {
+		observer.NeighborAdded(newNode.Info())
+	}
+
+	// neighbors are the first contacts of the routing table and the first members probed
+	if dht, err := n.DHT(); err == nil {
+		dht.Observe(newNode.Info())
+	}
+
+	if membership, err := n.Membership(); err == nil {
+		membership.Add(newNode.Info())
+	}
 
 	return nil
 }
 
+// ConsumeJoinToken - Validates the join token value and burns it, a token can be consumed only once
+func (n *Node) ConsumeJoinToken(value string) (*JoinToken, error) {
+
+	n.Lock()
+	jt, err := n.validateJoinToken(value, time.Now().UTC())
+	if jt != nil {
+		delete(n.joinTokens, jt.ID())
+	}
+	observer := n.observer
+	n.Unlock()
+
+	if jt != nil && observer != nil {
+		observer.JoinTokenRemoved(jt.ID())
+	}
+
+	return jt, err
+}
+
 // Host - Returns current node host
 func (n *Node) Host() string {
 	n.RLock()
@@ -107,6 +329,97 @@ func (n *Node) Host() string {
 	return n.host
 }
 
+// Address - Returns the RPC address of the node in host:port form
+func (n *Node) Address() string {
+	n.RLock()
+	defer n.RUnlock()
+	return net.JoinHostPort(n.host, strings.TrimPrefix(n.rpcPort, ":"))
+}
+
+// DHT - Returns the DHT of the node, created on first use with the neighbors as contacts.
+// Remote nodes have no DHT
+func (n *Node) DHT() (*DHT, error) {
+
+	if _, err := n.Transport(); err != nil {
+		return nil, err
+	}
+
+	info := n.Info()
+
+	n.Lock()
+
+	if n.dht != nil {
+		dht := n.dht
+		n.Unlock()
+		return dht, nil
+	}
+
+	dht, err := NewDHT(info, NewRPCDHTClient(n))
+	if err != nil {
+		n.Unlock()
+		return nil, err
+	}
+
+	n.dht = dht
+
+	neighbors := make([]NodeInfo, 0, len(n.neighbors))
+	for _, neighbor := range n.neighbors {
+		neighbors = append(neighbors, neighbor.Info())
+	}
+
+	n.Unlock()
+
+	for _, neighbor := range neighbors {
+		dht.Observe(neighbor)
+	}
+
+	return dht, nil
+}
+
+// DialNeighbor - Returns a client connected to the neighbor with the id passed, the neighbor must prove its identity.
+// When the node is a cluster member the neighbor has to be a member too
+func (n *Node) DialNeighbor(id string) (*RPCClient, error) {
+
+	n.RLock()
+	neighbor, ok := n.neighbors[id]
+	n.RUnlock()
+
+	if !ok {
+		return nil, NewNodeNotNeighborError(id)
+	}
+
+	transport, err := n.Transport()
+	if err != nil {
+		return nil, err
+	}
+
+	return DialRPC(transport, neighbor.Address(), neighbor.ID(), n.IsMember())
+}
+
+// DialRPC - Returns a client connected to the node at address over the node transport, see network.DialRPC
+func (n *Node) DialRPC(address string, expectedID string) (*RPCClient, error) {
+
+	transport, err := n.Transport()
+	if err != nil {
+		return nil, err
+	}
+
+	return DialRPC(transport, address, expectedID, false)
+}
+
+// Info - Returns the public info of the node, see NodeInfo
+func (n *Node) Info() NodeInfo {
+	n.RLock()
+	defer n.RUnlock()
+	return NodeInfo{
+		ID:        n.id,
+		Name:      n.name,
+		Host:      n.host,
+		RPCPort:   n.rpcPort,
+		PublicKey: n.publicKey,
+	}
+}
+
 // ID - Returns Node ID
 func (n *Node) ID() string {
 	n.RLock()
@@ -114,6 +427,127 @@ func (n *Node) ID() string {
 	return n.id
 }
 
+// Join - Joins the node that issued the encoded join token, on success both nodes are neighbors.
+// The address of the node is read from the token unless address is passed.
+// The token is sent only after the node proved, during the transport handshake, to own the identity that signed the token
+func (n *Node) Join(token, address string) (*Node, error) {
+
+	jt, err := ParseJoinToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if jt.IsExpired(time.Now().UTC()) {
+		return nil, NewExpiredJoinTokenError(jt)
+	}
+
+	if address == "" {
+		address = jt.Address()
+	}
+
+	client, err := n.DialRPC(RPCAddress(address), jt.Fingerprint())
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	pub := client.PeerPublicKey()
+
+	if err := jt.Verify(pub); err != nil {
+		return nil, err
+	}
+
+	var res JoinResponse
+	if err := client.Call(RPCMethodJoin, JoinRequest{Token: token, Node: n.Info()}, &res); err != nil {
+		return nil, err
+	}
+
+	if res.Node.ID != NodeIDFromPublicKey(pub) {
+		return nil, NewIdentityMismatchError(NodeIDFromPublicKey(pub), res.Node.ID)
+	}
+	res.Node.PublicKey = pub
+
+	if res.Credentials == nil {
+		return nil, NewInvalidJoinRequestError("no credentials issued by the cluster CA")
+	}
+
+	// the node is a member of the joined cluster only, a CA held before is dropped
+	if err := n.dropClusterCA(); err != nil {
+		return nil, err
+	}
+
+	if err := n.setClusterCredentials(res.Credentials, res.CA); err != nil {
+		return nil, err
+	}
+
+	neighbor := NewNodeFromInfo(res.Node)
+	if err := n.AddNeighbor(neighbor); err != nil {
+		return nil, err
+	}
+
+	return neighbor, nil
+}
+
+// Membership - Returns the membership of the node, created on first use with the neighbors as members.
+// Remote nodes have no membership
+func (n *Node) Membership() (*Membership, error) {
+
+	if _, err := n.Transport(); err != nil {
+		return nil, err
+	}
+
+	info := n.Info()
+
+	n.Lock()
+
+	if n.membership != nil {
+		membership := n.membership
+		n.Unlock()
+		return membership, nil
+	}
+
+	membership := NewMembership(info, NewRPCMembershipTransport(n))
+	n.membership = membership
+
+	neighbors := make([]NodeInfo, 0, len(n.neighbors))
+	for _, neighbor := range n.neighbors {
+		neighbors = append(neighbors, neighbor.Info())
+	}
+
+	n.Unlock()
+
+	for _, neighbor := range neighbors {
+		membership.Add(neighbor)
+	}
+
+	return membership, nil
+}
+
+// JoinTokens - Returns the join tokens issued by the node and not consumed yet
+func (n *Node) JoinTokens() []*JoinToken {
+	n.RLock()
+	defer n.RUnlock()
+
+	tokens := make([]*JoinToken, 0, len(n.joinTokens))
+	for _, jt := range n.joinTokens {
+		tokens = append(tokens, jt)
+	}
+
+	return tokens
+}
+
+// ListenAddress - Returns the address the RPC server of the node listens on, all interfaces on the RPC port by default
+func (n *Node) ListenAddress() string {
+	n.RLock()
+	defer n.RUnlock()
+
+	if n.listenAddr != "" {
+		return n.listenAddr
+	}
+
+	return n.rpcPort
+}
+
 // Name - Returns node name
 func (n *Node) Name() string {
 	n.RLock()
@@ -121,22 +555,220 @@ func (n *Node) Name() string {
 	return n.name
 }
 
+// Neighbors - Returns the neighbors of the node
+func (n *Node) Neighbors() []*Node {
+	n.RLock()
+	defer n.RUnlock()
+
+	neighbors := make([]*Node, 0, len(n.neighbors))
+	for _, neighbor := range n.neighbors {
+		neighbors = append(neighbors, neighbor)
+	}
+
+	return neighbors
+}
+
 // NewJoinToken - Return a new NewJoinToken
 func (n *Node) NewJoinToken() (*JoinToken, error) {
+	return n.NewJoinTokenForAddress("", "")
+}
+
+// NewJoinTokenForAddress - Returns a new JoinToken advertising host and rpcPort instead of the node ones,
+// e.g. the address of the node behind a NAT. Empty values are replaced by the node ones
+func (n *Node) NewJoinTokenForAddress(host, rpcPort string) (*JoinToken, error) {
+
+	n.RLock()
+	jtConfig := JoinTokenConfig{
+		Host:     n.host,
+		RPCPort:  n.rpcPort,
+		Identity: n.identity,
+	}
+	n.RUnlock()
+
+	if host != "" {
+		jtConfig.Host = strings.Trim(host, "[]")
+	}
+	if rpcPort != "" {
+		jtConfig.RPCPort = rpcPort
+	}
 
-	jt, err := NewJoinTokenWithConfig(JoinTokenConfig{Host: n.Host()})
+	jt, err := NewJoinTokenWithConfig(jtConfig)
 	if err != nil {
 		return nil, err
 	}
 
+	n.registerJoinToken(jt)
+
+	return jt, nil
+}
+
+// PublicKey - Returns the public key of the node identity
+func (n *Node) PublicKey() ed25519.PublicKey {
+	n.RLock()
+	defer n.RUnlock()
+	return n.publicKey
+}
+
+// Transport - Returns the transport secured by the node identity, remote nodes have no transport
+func (n *Node) Transport() (*Transport, error) {
+	n.RLock()
+	defer n.RUnlock()
+
+	if n.transport == nil {
+		return nil, ErrNodeWithoutIdentity
+	}
+
+	return n.transport, nil
+}
+
+// RemoveNeighbor - Removes the neighbor with the id passed, returns false if it was not a neighbor
+func (n *Node) RemoveNeighbor(id string) bool {
+	n.Lock()
+
+	if _, ok := n.neighbors[id]; !ok {
+		n.Unlock()
+		return false
+	}
+
+	delete(n.neighbors, id)
+	observer := n.observer
+
+	n.Unlock()
+
+	if observer != nil {
+		observer.NeighborRemoved(id)
+	}
+
+	return true
+}
+
+// RestoreJoinToken - Registers again a join token issued by the node, e.g. recovered from the persisted state.
+// The token must be signed by the node identity and not expired, the observer is not notified
+func (n *Node) RestoreJoinToken(jt *JoinToken) error {
+
+	if err := jt.Verify(n.PublicKey()); err != nil {
+		return err
+	}
+
+	if jt.IsExpired(time.Now().UTC()) {
+		return NewExpiredJoinTokenError(jt)
+	}
+
 	n.Lock()
 	defer n.Unlock()
 
 	n.joinTokens[jt.ID()] = jt
 
+	return nil
+}
+
+// RPCPort - Returns node RPC port
+func (n *Node) RPCPort() string {
+	n.RLock()
+	defer n.RUnlock()
+	return n.rpcPort
+}
+
+// RunJoinTokenSweeper - Removes the expired join tokens every interval until stop is closed
+func (n *Node) RunJoinTokenSweeper(interval time.Duration, stop <-chan struct{}) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			n.SweepJoinTokens(now.UTC())
+		}
+	}
+}
+
+// SetObserver - Sets the observer notified of the changes of neighbors and join tokens, nil removes it
+func (n *Node) SetObserver(observer NodeObserver) {
+	n.Lock()
+	defer n.Unlock()
+	n.observer = observer
+}
+
+// SweepJoinTokens - Removes the join tokens expired at the time passed, returns the number of tokens removed
+func (n *Node) SweepJoinTokens(now time.Time) int {
+
+	n.Lock()
+
+	removed := make([]string, 0)
+	for id, jt := range n.joinTokens {
+		if jt.IsExpired(now) {
+			delete(n.joinTokens, id)
+			removed = append(removed, id)
+		}
+	}
+
+	observer := n.observer
+
+	n.Unlock()
+
+	if observer != nil {
+		for _, id := range removed {
+			observer.JoinTokenRemoved(id)
+		}
+	}
+
+	return len(removed)
+}
+
+// ValidateJoinToken - Validates the join token value without consuming it
+func (n *Node) ValidateJoinToken(value string) (*JoinToken, error) {
+
+	n.RLock()
+	defer n.RUnlock()
+
+	jt, err := n.validateJoinToken(value, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+
 	return jt, nil
 }
 
+// MARK: Node unexported
+
+// registerJoinToken - Registers the join token issued by the node and notifies the observer
+func (n *Node) registerJoinToken(jt *JoinToken) {
+
+	n.Lock()
+	n.joinTokens[jt.ID()] = jt
+	observer := n.observer
+	n.Unlock()
+
+	if observer != nil {
+		observer.JoinTokenAdded(jt)
+	}
+}
+
+// validateJoinToken - Looks up the token comparing every value in constant time, must be called holding the lock.
+// An expired token is returned alongside the error so that the caller can remove it
+func (n *Node) validateJoinToken(value string, now time.Time) (*JoinToken, error) {
+
+	var found *JoinToken
+	for _, jt := range n.joinTokens {
+		if subtle.ConstantTimeCompare([]byte(jt.Value()), []byte(value)) == 1 {
+			found = jt
+		}
+	}
+
+	if found == nil {
+		return nil, NewInvalidJoinTokenError()
+	}
+
+	if found.IsExpired(now) {
+		return found, NewExpiredJoinTokenError(found)
+	}
+
+	return found, nil
+}
+
 // MARK: NodeAlreadyNeighborError
 
 // NodeAlreadyNeighborError - Defines error for
@@ -153,3 +785,20 @@ func NewNodeAlreadyNeighborError(node *Node) error {
 func (e *NodeAlreadyNeighborError) Error() string {
 	return fmt.Sprintf("Node %s already present in the newtowrk", e.nodeName)
 }
+
+// MARK: NodeNotNeighborError
+
+// NodeNotNeighborError - Defines error for a node missing from the neighbors
+type NodeNotNeighborError struct {
+	nodeID string
+}
+
+// NewNodeNotNeighborError - Returns a new instance of NodeNotNeighborError
+func NewNodeNotNeighborError(nodeID string) error {
+	return &NodeNotNeighborError{nodeID: nodeID}
+}
+
+// Error - Implements error interface
+func (e *NodeNotNeighborError) Error() string {
+	return fmt.Sprintf("Node %s is not a neighbor", e.nodeID)
+}
@@ -0,0 +1,464 @@
+package network
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// MARK: IssueCertificateRequest & ClusterCAResponse
+
+// IssueCertificateRequest - Defines the payload sent by a member to get credentials for a node joining through it,
+// Token is the join token issued by the member and presented by the joining node
+type IssueCertificateRequest struct {
+	PublicKey []byte `json:"public_key"`
+	Token     string `json:"token"`
+}
+
+// ClusterCAResponse - Defines the payload describing the current cluster CA
+type ClusterCAResponse struct {
+	Fingerprint    string   `json:"fingerprint"`
+	CACertificates [][]byte `json:"ca_certificates"`
+}
+
+// MARK: Node cluster CA exported
+
+// BootstrapClusterCA - Makes the node the CA of a new cluster and issues its own credentials
+func (n *Node) BootstrapClusterCA() error {
+
+	ca, err := NewClusterCA()
+	if err != nil {
+		return err
+	}
+
+	n.Lock()
+	n.ca = ca
+	dataDir := n.dataDir
+	n.Unlock()
+
+	if dataDir != "" {
+		if err := ca.Save(dataDir); err != nil {
+			return err
+		}
+	}
+
+	creds, err := ca.Issue(n.PublicKey())
+	if err != nil {
+		return err
+	}
+
+	return n.setClusterCredentials(creds, n.Info())
+}
+
+// ClusterCAInfo - Returns the info of the node holding the cluster CA
+func (n *Node) ClusterCAInfo() NodeInfo {
+	n.RLock()
+	defer n.RUnlock()
+	return n.caInfo
+}
+
+// ClusterCA - Returns the cluster CA held by the node, nil if the node is not the cluster CA
+func (n *Node) ClusterCA() *ClusterCA {
+	n.RLock()
+	defer n.RUnlock()
+	return n.ca
+}
+
+// NeedsJoin - Returns true if the node has been a cluster member and its credentials expired, see LoadClusterCredentials.
+// Such a node must not bootstrap a new cluster, it has to join again with a join token
+func (n *Node) NeedsJoin() bool {
+	n.RLock()
+	defer n.RUnlock()
+	return n.needsJoin
+}
+
+// IsMember - Returns true if the node holds valid credentials issued by the cluster CA
+func (n *Node) IsMember() bool {
+
+	transport, err := n.Transport()
+	if err != nil {
+		return false
+	}
+
+	_, notAfter, ok := transport.CertificateValidity()
+
+	return ok && time.Now().Before(notAfter)
+}
+
+// IssueCredentials - Returns the credentials for the identity public key of a node joining with the join token passed.
+// The request is forwarded to the CA node with the token if needed, see IssueJoinCredentials
+func (n *Node) IssueCredentials(pub ed25519.PublicKey, jt *JoinToken) (*NodeCredentials, error) {
+
+	if ca := n.ClusterCA(); ca != nil {
+		return ca.Issue(pub)
+	}
+
+	client, err := n.dialClusterCA()
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	var creds NodeCredentials
+	if err := client.Call(RPCMethodIssueCertificate, IssueCertificateRequest{PublicKey: pub, Token: jt.String()}, &creds); err != nil {
+		return nil, err
+	}
+
+	return &creds, nil
+}
+
+// IssueJoinCredentials - Issues the credentials requested by the member peer for a node joining through it.
+// The join token must be signed by the member and not expired, a token gets credentials once
+func (n *Node) IssueJoinCredentials(peer *RPCPeer, req IssueCertificateRequest) (*NodeCredentials, error) {
+
+	ca := n.ClusterCA()
+	if ca == nil {
+		return nil, NewNotClusterCAError(n.ID())
+	}
+
+	jt, err := ParseJoinToken(req.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := jt.Verify(peer.PublicKey); err != nil {
+		return nil, NewInvalidJoinTokenError()
+	}
+
+	now := time.Now().UTC()
+	if jt.IsExpired(now) {
+		return nil, NewExpiredJoinTokenError(jt)
+	}
+
+	n.Lock()
+
+	if n.redeemedTokens == nil {
+		n.redeemedTokens = make(map[string]time.Time)
+	}
+
+	// the tokens are remembered until they expire, an expired token is refused anyway
+	for id, exp := range n.redeemedTokens {
+		if !now.Before(exp) {
+			delete(n.redeemedTokens, id)
+		}
+	}
+
+	_, redeemed := n.redeemedTokens[jt.ID()]
+	if !redeemed {
+		n.redeemedTokens[jt.ID()] = jt.Exp()
+	}
+
+	n.Unlock()
+
+	if redeemed {
+		return nil, NewInvalidJoinTokenError()
+	}
+
+	creds, err := ca.Issue(req.PublicKey)
+	if err != nil {
+
+		// the token is not burnt by a request the CA refused
+		n.Lock()
+		delete(n.redeemedTokens, jt.ID())
+		n.Unlock()
+
+		return nil, err
+	}
+
+	return creds, nil
+}
+
+// LoadClusterCredentials - Loads the cluster credentials, and the CA if held, from the data directory.
+// Returns false if the node never joined a cluster or its credentials are expired
+func (n *Node) LoadClusterCredentials() (bool, error) {
+
+	n.RLock()
+	dataDir := n.dataDir
+	n.RUnlock()
+
+	if dataDir == "" {
+		return false, nil
+	}
+
+	creds, caInfo, err := LoadNodeCredentials(dataDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	ca, err := LoadClusterCA(dataDir)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+
+	// expired credentials can not be renewed, they are dropped and the node starts outside the cluster until it joins again
+	if ca == nil && credentialsExpired(creds, time.Now()) {
+		n.Lock()
+		n.needsJoin = true
+		n.Unlock()
+		return false, nil
+	}
+
+	n.Lock()
+	n.ca = ca
+	n.caInfo = caInfo
+	n.Unlock()
+
+	transport, err := n.Transport()
+	if err != nil {
+		return false, err
+	}
+
+	// the CA node issues its own credentials from the stored CA, the stored certificate is kept
+	// only during the grace period of a rotation, see renewCredentials
+	if ca != nil {
+		if !credentialsExpired(creds, time.Now()) {
+			transport.SetCredentials(&NodeCredentials{Certificate: creds.Certificate, CACertificates: ca.Certificates()})
+		}
+		return true, n.RenewCredentials()
+	}
+
+	if err := transport.SetCredentials(creds); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// RenewCredentials - Renews the node credentials with the cluster CA, picking up a rotated CA
+func (n *Node) RenewCredentials() error {
+	return n.renewCredentials(time.Now())
+}
+
+// RotateClusterCA - Replaces the cluster CA key, the members pick up the new CA renewing their credentials.
+// The CA node switches to a certificate of the new key after DefaultCARotationGracePeriod, see renewCredentials.
+// Returns the fingerprint of the new CA key
+func (n *Node) RotateClusterCA() (string, error) {
+
+	ca := n.ClusterCA()
+	if ca == nil {
+		return "", NewNotClusterCAError(n.ID())
+	}
+
+	if err := ca.Rotate(); err != nil {
+		return "", err
+	}
+
+	n.RLock()
+	dataDir := n.dataDir
+	n.RUnlock()
+
+	if dataDir != "" {
+		if err := ca.Save(dataDir); err != nil {
+			return "", err
+		}
+	}
+
+	if err := n.RenewCredentials(); err != nil {
+		return "", err
+	}
+
+	return ca.Fingerprint(), nil
+}
+
+// RunCertificateRenewer - Renews the node credentials every interval if they are about to expire or the CA has been rotated,
+// until stop is closed
+func (n *Node) RunCertificateRenewer(interval time.Duration, stop <-chan struct{}) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			if n.needsRenewal(now) {
+				n.renewCredentials(now)
+			}
+		}
+	}
+}
+
+// MARK: Node cluster CA unexported
+
+func (n *Node) dialClusterCA() (*RPCClient, error) {
+
+	caInfo := n.ClusterCAInfo()
+	if caInfo.ID == "" {
+		return nil, NewNotClusterCAError(n.ID())
+	}
+
+	// the CA node is pinned by its identity, after a rotation its certificate is issued by a CA unknown to the members
+	return n.DialRPC(NewNodeFromInfo(caInfo).Address(), caInfo.ID)
+}
+
+// needsRenewal - Returns true if a third of the credentials validity is left or the cluster CA has been rotated
+func (n *Node) needsRenewal(now time.Time) bool {
+
+	transport, err := n.Transport()
+	if err != nil {
+		return false
+	}
+
+	notBefore, notAfter, ok := transport.CertificateValidity()
+	if !ok {
+		return false
+	}
+
+	if now.After(notBefore.Add(notAfter.Sub(notBefore) * 2 / 3)) {
+		return true
+	}
+
+	// the CA node switches to the certificate of a rotated key once the grace period is over
+	if ca := n.ClusterCA(); ca != nil {
+		return !transport.IsIssuedBy(ca.Certificates()[0]) && n.rotatingCertificate(ca, now) == nil
+	}
+
+	client, err := n.dialClusterCA()
+	if err != nil {
+		return false
+	}
+	defer client.Close()
+
+	var res ClusterCAResponse
+	if err := client.Call(RPCMethodClusterCA, nil, &res); err != nil || len(res.CACertificates) == 0 {
+		return false
+	}
+
+	return !transport.IsIssuedBy(res.CACertificates[0])
+}
+
+// renewCredentials - Renews the node credentials at the time passed, see RenewCredentials.
+// After a rotation the members not renewed yet trust only the replaced key, the CA node keeps presenting
+// the certificate issued by that key during DefaultCARotationGracePeriod while trusting both keys
+func (n *Node) renewCredentials(now time.Time) error {
+
+	if ca := n.ClusterCA(); ca != nil {
+
+		if leaf := n.rotatingCertificate(ca, now); leaf != nil {
+			return n.setClusterCredentials(&NodeCredentials{Certificate: leaf.Raw, CACertificates: ca.Certificates()}, n.Info())
+		}
+
+		creds, err := ca.Issue(n.PublicKey())
+		if err != nil {
+			return err
+		}
+
+		return n.setClusterCredentials(creds, n.Info())
+	}
+
+	client, err := n.dialClusterCA()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	var creds NodeCredentials
+	if err := client.Call(RPCMethodRenewCertificate, nil, &creds); err != nil {
+		return err
+	}
+
+	return n.setClusterCredentials(&creds, n.ClusterCAInfo())
+}
+
+// rotatingCertificate - Returns the certificate of the CA node issued by the replaced key if the CA has been rotated
+// less than DefaultCARotationGracePeriod before now and the certificate outlasts the grace period, nil otherwise
+func (n *Node) rotatingCertificate(ca *ClusterCA, now time.Time) *x509.Certificate {
+
+	rotatedAt := ca.RotatedAt()
+	if rotatedAt.IsZero() || !now.Before(rotatedAt.Add(DefaultCARotationGracePeriod)) {
+		return nil
+	}
+
+	transport, err := n.Transport()
+	if err != nil {
+		return nil
+	}
+
+	// the certificate has to be issued by the replaced key, still trusted by the members after the rotation
+	certs := ca.Certificates()
+	leaf := transport.MemberCertificate()
+	if leaf == nil || len(certs) < 2 || !transport.IsIssuedBy(certs[1]) || !leaf.NotAfter.After(rotatedAt.Add(DefaultCARotationGracePeriod)) {
+		return nil
+	}
+
+	return leaf
+}
+
+func (n *Node) setClusterCredentials(creds *NodeCredentials, caInfo NodeInfo) error {
+
+	transport, err := n.Transport()
+	if err != nil {
+		return err
+	}
+
+	if err := transport.SetCredentials(creds); err != nil {
+		return err
+	}
+
+	n.Lock()
+	n.caInfo = caInfo
+	n.needsJoin = false
+	dataDir := n.dataDir
+	n.Unlock()
+
+	if dataDir == "" {
+		return nil
+	}
+
+	return SaveNodeCredentials(dataDir, creds, caInfo)
+}
+
+// dropClusterCA - Drops the cluster CA held by the node and its key from the data directory.
+// The CA certificates are left, they are replaced by the ones of the credentials saved next
+func (n *Node) dropClusterCA() error {
+
+	n.Lock()
+	n.ca = nil
+	dataDir := n.dataDir
+	n.Unlock()
+
+	if dataDir == "" {
+		return nil
+	}
+
+	if err := os.Remove(filepath.Join(dataDir, ClusterCAKeyFileName)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// credentialsExpired - Returns true if the node certificate of the credentials is expired at the time passed
+func credentialsExpired(creds *NodeCredentials, now time.Time) bool {
+
+	leaf, err := x509.ParseCertificate(creds.Certificate)
+	if err != nil {
+		return false
+	}
+
+	return !now.Before(leaf.NotAfter)
+}
+
+// MARK: NotClusterCAError
+
+// NotClusterCAError - Defines error for a cluster CA operation on a node not holding the CA
+type NotClusterCAError struct {
+	nodeID string
+}
+
+// NewNotClusterCAError - Returns a new instance of NotClusterCAError
+func NewNotClusterCAError(nodeID string) error {
+	return &NotClusterCAError{nodeID: nodeID}
+}
+
+// Error - Implements error interface
+func (e *NotClusterCAError) Error() string {
+	return fmt.Sprintf("Node %s does not hold the cluster CA", e.nodeID)
+}
@@ -0,0 +1,711 @@
+package network
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MARK: consts & vars
+
+const (
+	// current RPC protocol version, requests with a different version are rejected
+	RPCProtocolVersion = 1
+
+	DefaultRPCDialTimeout = 10 * time.Second
+	// time given to a peer to send the next request on an open connection, idle time included
+	DefaultRPCReadTimeout = 2 * time.Minute
+
+	// largest request or response read from a connection, chunks included
+	MaxRPCMessageSize = 16 << 20
+)
+
+// defines available RPC methods
+const (
+	RPCMethodClusterCA        = "cluster-ca"
+	RPCMethodInfo             = "info"
+	RPCMethodIssueCertificate = "issue-certificate"
+	RPCMethodJoin             = "join"
+	RPCMethodNeighbors        = "neighbors"
+	RPCMethodPing             = "ping"
+	RPCMethodRenewCertificate = "renew-certificate"
+	RPCMethodRotateCA         = "rotate-ca"
+)
+
+var (
+	// ErrRPCServerClosed - Returned by RPCServer.Serve after RPCServer.Shutdown
+	ErrRPCServerClosed = errors.New("RPC server closed")
+)
+
+// MARK: RPCRequest & RPCResponse
+
+// RPCRequest - Defines a request sent to a node RPC server
+type RPCRequest struct {
+	Version int             `json:"version"`
+	ID      uint64          `json:"id"`
+	Method  string          `json:"method"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// RPCResponse - Defines a response returned by a node RPC server
+type RPCResponse struct {
+	Version int             `json:"version"`
+	ID      uint64          `json:"id"`
+	Error   string          `json:"error,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// PingResponse - Defines the payload returned by ping method
+type PingResponse struct {
+	Time time.Time `json:"time"`
+}
+
+// NeighborsResponse - Defines the payload returned by neighbors method
+type NeighborsResponse struct {
+	Neighbors []NodeInfo `json:"neighbors"`
+}
+
+// RPCPeer - Defines the peer of an RPC connection, its identity is proved by the transport handshake.
+// Member is true if the peer certificate has been issued by the cluster CA
+type RPCPeer struct {
+	ID         string
+	PublicKey  ed25519.PublicKey
+	RemoteAddr string
+	Member     bool
+}
+
+// RPCHandlerFunc - Defines the func that handles an RPC method, payload is the raw request payload
+type RPCHandlerFunc func(peer *RPCPeer, payload json.RawMessage) (interface{}, error)
+
+// MARK: RPCServer & constructors
+
+// RPCServer - Defines the RPC server of a node, listening on node rpcPort
+type RPCServer struct {
+	sync.RWMutex
+	node      *Node
+	handlers  map[string]RPCHandlerFunc
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+	// largest request read from a connection, MaxRPCMessageSize by default
+	maxMessageSize int64
+}
+
+// NewRPCServer - Returns a new instance of RPCServer for the node passed
+func NewRPCServer(node *Node) *RPCServer {
+
+	s := &RPCServer{
+		node:           node,
+		handlers:       make(map[string]RPCHandlerFunc),
+		listeners:      make(map[net.Listener]struct{}),
+		conns:          make(map[net.Conn]struct{}),
+		maxMessageSize: MaxRPCMessageSize,
+	}
+
+	s.Handle(RPCMethodInfo, s.handleInfo)
+	s.Handle(RPCMethodJoin, s.handleJoin)
+	s.Handle(RPCMethodNeighbors, s.handleNeighbors)
+	s.Handle(RPCMethodPing, s.handlePing)
+	s.Handle(RPCMethodRotateCA, s.handleRotateCA)
+
+	s.HandleMember(RPCMethodClusterCA, s.handleClusterCA)
+	s.HandleMember(RPCMethodIssueCertificate, s.handleIssueCertificate)
+	s.HandleMember(RPCMethodRenewCertificate, s.handleRenewCertificate)
+
+	if dht, err := node.DHT(); err == nil {
+		s.HandleDHT(dht)
+	}
+
+	if membership, err := node.Membership(); err == nil {
+		s.HandleMembership(membership)
+	}
+
+	return s
+}
+
+// MARK: RPCServer exported
+
+// Handle - Registers the handler for the RPC method
+func (s *RPCServer) Handle(method string, handler RPCHandlerFunc) {
+	s.Lock()
+	defer s.Unlock()
+	s.handlers[method] = handler
+}
+
+// HandleMember - Registers the handler for the RPC method, only the cluster members are allowed to call it
+func (s *RPCServer) HandleMember(method string, handler RPCHandlerFunc) {
+	s.Handle(method, func(peer *RPCPeer, payload json.RawMessage) (interface{}, error) {
+
+		if !peer.Member {
+			return nil, NewRPCUnauthorizedError(method, peer.ID)
+		}
+
+		return handler(peer, payload)
+	})
+}
+
+// ListenAndServe - Listens on the node listen address and serves incoming connections, see Node.ListenAddress
+func (s *RPCServer) ListenAndServe() error {
+
+	listener, err := net.Listen("tcp", s.node.ListenAddress())
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(listener)
+}
+
+// Serve - Accepts and serves incoming connections on the listener passed, always returns a non-nil error.
+// Connections are secured by the node Transport
+func (s *RPCServer) Serve(inner net.Listener) error {
+
+	transport, err := s.node.Transport()
+	if err != nil {
+		inner.Close()
+		return err
+	}
+
+	listener := transport.NewListener(inner)
+
+	if !s.trackListener(listener, true) {
+		listener.Close()
+		return ErrRPCServerClosed
+	}
+	defer s.trackListener(listener, false)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrRPCServerClosed
+			}
+			return err
+		}
+
+		if !s.trackConn(conn, true) {
+			conn.Close()
+			return ErrRPCServerClosed
+		}
+
+		go func() {
+			defer s.trackConn(conn, false)
+			s.serveConn(conn)
+		}()
+	}
+}
+
+// Shutdown - Stops accepting connections, lets in-flight requests complete and waits for the connections to be closed
+func (s *RPCServer) Shutdown() error {
+	return s.ShutdownContext(context.Background())
+}
+
+// ShutdownContext - Stops accepting connections and lets in-flight requests complete until ctx is done,
+// then the connections left are closed and the ctx error is returned
+func (s *RPCServer) ShutdownContext(ctx context.Context) error {
+
+	s.Lock()
+
+	if s.closed {
+		s.Unlock()
+		return nil
+	}
+
+	s.closed = true
+
+	var err error
+	for listener := range s.listeners {
+		if cerr := listener.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+
+	// unblock the idle reads, in-flight requests still write their response
+	for conn := range s.conns {
+		conn.SetReadDeadline(time.Now())
+	}
+
+	s.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return err
+	case <-ctx.Done():
+	}
+
+	s.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.Unlock()
+
+	return ctx.Err()
+}
+
+// MARK: RPCServer unexported
+
+func (s *RPCServer) handler(method string) (RPCHandlerFunc, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	handler, ok := s.handlers[method]
+	return handler, ok
+}
+
+func (s *RPCServer) handleClusterCA(peer *RPCPeer, payload json.RawMessage) (interface{}, error) {
+
+	ca := s.node.ClusterCA()
+	if ca == nil {
+		return nil, NewNotClusterCAError(s.node.ID())
+	}
+
+	return ClusterCAResponse{Fingerprint: ca.Fingerprint(), CACertificates: ca.Certificates()}, nil
+}
+
+func (s *RPCServer) handleIssueCertificate(peer *RPCPeer, payload json.RawMessage) (interface{}, error) {
+
+	var req IssueCertificateRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return nil, err
+	}
+
+	return s.node.IssueJoinCredentials(peer, req)
+}
+
+func (s *RPCServer) handleInfo(peer *RPCPeer, payload json.RawMessage) (interface{}, error) {
+	return s.node.Info(), nil
+}
+
+func (s *RPCServer) handleJoin(peer *RPCPeer, payload json.RawMessage) (interface{}, error) {
+
+	var req JoinRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return nil, err
+	}
+
+	return s.node.AcceptJoin(peer, req)
+}
+
+func (s *RPCServer) handleNeighbors(peer *RPCPeer, payload json.RawMessage) (interface{}, error) {
+
+	res := NeighborsResponse{Neighbors: make([]NodeInfo, 0)}
+	for _, neighbor := range s.node.Neighbors() {
+		res.Neighbors = append(res.Neighbors, neighbor.Info())
+	}
+
+	return res, nil
+}
+
+func (s *RPCServer) handlePing(peer *RPCPeer, payload json.RawMessage) (interface{}, error) {
+	return PingResponse{Time: time.Now().UTC()}, nil
+}
+
+func (s *RPCServer) handleRenewCertificate(peer *RPCPeer, payload json.RawMessage) (interface{}, error) {
+
+	ca := s.node.ClusterCA()
+	if ca == nil {
+		return nil, NewNotClusterCAError(s.node.ID())
+	}
+
+	return ca.Issue(peer.PublicKey)
+}
+
+// handleRotateCA - Only the node itself is allowed to rotate its CA, the CLI dials it with the node identity
+func (s *RPCServer) handleRotateCA(peer *RPCPeer, payload json.RawMessage) (interface{}, error) {
+
+	if peer.ID != s.node.ID() {
+		return nil, NewRPCUnauthorizedError(RPCMethodRotateCA, peer.ID)
+	}
+
+	fingerprint, err := s.node.RotateClusterCA()
+	if err != nil {
+		return nil, err
+	}
+
+	ca := s.node.ClusterCA()
+
+	return ClusterCAResponse{Fingerprint: fingerprint, CACertificates: ca.Certificates()}, nil
+}
+
+func (s *RPCServer) isClosed() bool {
+	s.RLock()
+	defer s.RUnlock()
+	return s.closed
+}
+
+// trackConn - Adds or removes the connection from the active ones, returns false if the server is closed
+func (s *RPCServer) trackConn(conn net.Conn, add bool) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if !add {
+		delete(s.conns, conn)
+		s.wg.Done()
+		return true
+	}
+
+	if s.closed {
+		return false
+	}
+
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+
+	return true
+}
+
+// trackListener - Adds or removes the listener from the active ones, returns false if the server is closed
+func (s *RPCServer) trackListener(listener net.Listener, add bool) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if !add {
+		delete(s.listeners, listener)
+		return true
+	}
+
+	if s.closed {
+		return false
+	}
+
+	s.listeners[listener] = struct{}{}
+
+	return true
+}
+
+func (s *RPCServer) serveConn(conn net.Conn) {
+
+	defer conn.Close()
+
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return
+	}
+
+	conn.SetDeadline(time.Now().Add(DefaultRPCDialTimeout))
+
+	leaf, pub, err := PeerCertificate(tlsConn)
+	if err != nil {
+		return
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	transport, err := s.node.Transport()
+	if err != nil {
+		return
+	}
+
+	peer := &RPCPeer{
+		ID:         NodeIDFromPublicKey(pub),
+		PublicKey:  pub,
+		RemoteAddr: conn.RemoteAddr().String(),
+		Member:     transport.IsMember(leaf),
+	}
+
+	reader := &io.LimitedReader{R: conn}
+	decoder := json.NewDecoder(reader)
+	encoder := json.NewEncoder(conn)
+
+	for {
+		if !s.armReadDeadline(conn) {
+			return
+		}
+
+		var req RPCRequest
+		reader.N = s.maxMessageSize
+		if err := decoder.Decode(&req); err != nil {
+			return
+		}
+
+		if err := encoder.Encode(s.dispatch(peer, req)); err != nil {
+			return
+		}
+	}
+}
+
+// armReadDeadline - Sets the deadline to read the next request on the connection, returns false if the server is closed.
+// Checked under the lock, a deadline set by ShutdownContext to unblock the idle reads is never pushed back
+func (s *RPCServer) armReadDeadline(conn net.Conn) bool {
+	s.RLock()
+	defer s.RUnlock()
+
+	if s.closed {
+		return false
+	}
+
+	conn.SetReadDeadline(time.Now().Add(DefaultRPCReadTimeout))
+
+	return true
+}
+
+func (s *RPCServer) dispatch(peer *RPCPeer, req RPCRequest) RPCResponse {
+
+	res := RPCResponse{Version: RPCProtocolVersion, ID: req.ID}
+
+	if req.Version != RPCProtocolVersion {
+		res.Error = NewRPCUnsupportedVersionError(req.Version).Error()
+		return res
+	}
+
+	handler, ok := s.handler(req.Method)
+	if !ok {
+		res.Error = NewRPCMethodNotFoundError(req.Method).Error()
+		return res
+	}
+
+	result, err := handler(peer, req.Payload)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+
+	payload, err := json.Marshal(result)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+
+	res.Payload = payload
+
+	return res
+}
+
+// MARK: RPCClient & constructors
+
+// RPCClient - Defines a client connected to a node RPC server
+type RPCClient struct {
+	sync.Mutex
+	conn    net.Conn
+	peer    ed25519.PublicKey
+	reader  *io.LimitedReader
+	decoder *json.Decoder
+	encoder *json.Encoder
+	nextID  uint64
+}
+
+// DialRPC - Returns a new RPCClient connected to the address passed over the transport, see RPCAddress.
+// The connection fails if the server does not prove to be the node with expectedID, see Transport.ClientConfig
+func DialRPC(transport *Transport, address string, expectedID string, requireMember bool) (*RPCClient, error) {
+	return DialRPCWithTimeout(transport, address, expectedID, requireMember, DefaultRPCDialTimeout)
+}
+
+// DialRPCWithTimeout - Returns a new RPCClient like DialRPC, the connection fails after timeout
+func DialRPCWithTimeout(transport *Transport, address string, expectedID string, requireMember bool, timeout time.Duration) (*RPCClient, error) {
+
+	conn, err := transport.DialTimeout(address, expectedID, requireMember, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	_, peer, err := PeerCertificate(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	reader := &io.LimitedReader{R: conn}
+
+	return &RPCClient{
+		conn:    conn,
+		peer:    peer,
+		reader:  reader,
+		decoder: json.NewDecoder(reader),
+		encoder: json.NewEncoder(conn),
+	}, nil
+}
+
+// MARK: RPCClient exported
+
+// Call - Calls the RPC method with args as payload and decodes the result in reply, reply can be nil
+func (c *RPCClient) Call(method string, args interface{}, reply interface{}) error {
+
+	payload, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.nextID++
+	req := RPCRequest{
+		Version: RPCProtocolVersion,
+		ID:      c.nextID,
+		Method:  method,
+		Payload: payload,
+	}
+
+	if err := c.encoder.Encode(req); err != nil {
+		return err
+	}
+
+	var res RPCResponse
+	c.reader.N = MaxRPCMessageSize
+	if err := c.decoder.Decode(&res); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	if res.ID != req.ID {
+		return NewRPCRemoteError(method, fmt.Sprintf("unexpected response id %d", res.ID))
+	}
+
+	if res.Error != "" {
+		return NewRPCRemoteError(method, res.Error)
+	}
+
+	if reply == nil || len(res.Payload) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(res.Payload, reply)
+}
+
+// Close - Closes the connection with the RPC server
+func (c *RPCClient) Close() error {
+	return c.conn.Close()
+}
+
+// SetDeadline - Sets the deadline of the calls on the connection, the zero value means no deadline
+func (c *RPCClient) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+// Info - Returns the info of the remote node
+func (c *RPCClient) Info() (NodeInfo, error) {
+	var info NodeInfo
+	err := c.Call(RPCMethodInfo, nil, &info)
+	return info, err
+}
+
+// Neighbors - Returns the neighbors info of the remote node
+func (c *RPCClient) Neighbors() ([]NodeInfo, error) {
+	var res NeighborsResponse
+	err := c.Call(RPCMethodNeighbors, nil, &res)
+	return res.Neighbors, err
+}
+
+// RotateCA - Rotates the cluster CA of the remote node, only the node itself is allowed to call it
+func (c *RPCClient) RotateCA() (*ClusterCAResponse, error) {
+	var res ClusterCAResponse
+	if err := c.Call(RPCMethodRotateCA, nil, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// PeerID - Returns the node ID proved by the server during the handshake
+func (c *RPCClient) PeerID() string {
+	return NodeIDFromPublicKey(c.peer)
+}
+
+// PeerPublicKey - Returns the identity public key proved by the server during the handshake
+func (c *RPCClient) PeerPublicKey() ed25519.PublicKey {
+	return c.peer
+}
+
+// Ping - Pings the remote node, returns the round trip time
+func (c *RPCClient) Ping() (time.Duration, error) {
+	start := time.Now()
+	err := c.Call(RPCMethodPing, nil, nil)
+	return time.Since(start), err
+}
+
+// MARK: RPC utils exported
+
+// RPCAddress - Returns the RPC address of host in host:port form, DefaultRPCPort is used if host has no port.
+// IPv6 addresses are accepted with or without brackets
+func RPCAddress(host string) string {
+
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+
+	return net.JoinHostPort(strings.Trim(host, "[]"), strings.TrimPrefix(DefaultRPCPort, ":"))
+}
+
+// MARK: RPCMethodNotFoundError
+
+// RPCMethodNotFoundError - Defines error for an RPC method not handled by the server
+type RPCMethodNotFoundError struct {
+	method string
+}
+
+// NewRPCMethodNotFoundError - Returns a new instance of RPCMethodNotFoundError
+func NewRPCMethodNotFoundError(method string) error {
+	return &RPCMethodNotFoundError{method: method}
+}
+
+// Error - Implements error interface
+func (e *RPCMethodNotFoundError) Error() string {
+	return fmt.Sprintf("RPC method %s not found", e.method)
+}
+
+// MARK: RPCUnsupportedVersionError
+
+// RPCUnsupportedVersionError - Defines error for a request with an unsupported protocol version
+type RPCUnsupportedVersionError struct {
+	version int
+}
+
+// NewRPCUnsupportedVersionError - Returns a new instance of RPCUnsupportedVersionError
+func NewRPCUnsupportedVersionError(version int) error {
+	return &RPCUnsupportedVersionError{version: version}
+}
+
+// Error - Implements error interface
+func (e *RPCUnsupportedVersionError) Error() string {
+	return fmt.Sprintf("RPC protocol version %d not supported, expected %d", e.version, RPCProtocolVersion)
+}
+
+// MARK: RPCUnauthorizedError
+
+// RPCUnauthorizedError - Defines error for a peer not allowed to call an RPC method
+type RPCUnauthorizedError struct {
+	method string
+	peerID string
+}
+
+// NewRPCUnauthorizedError - Returns a new instance of RPCUnauthorizedError
+func NewRPCUnauthorizedError(method, peerID string) error {
+	return &RPCUnauthorizedError{method: method, peerID: peerID}
+}
+
+// Error - Implements error interface
+func (e *RPCUnauthorizedError) Error() string {
+	return fmt.Sprintf("Peer %s not allowed to call RPC %s", e.peerID, e.method)
+}
+
+// MARK: RPCRemoteError
+
+// RPCRemoteError - Defines error returned by the remote RPC server
+type RPCRemoteError struct {
+	method  string
+	message string
+}
+
+// NewRPCRemoteError - Returns a new instance of RPCRemoteError
+func NewRPCRemoteError(method, message string) error {
+	return &RPCRemoteError{method: method, message: message}
+}
+
+// Error - Implements error interface
+func (e *RPCRemoteError) Error() string {
+	return fmt.Sprintf("RPC %s failed: %s", e.method, e.message)
+}
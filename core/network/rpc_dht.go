@@ -0,0 +1,261 @@
+package network
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+// MARK: consts
+
+// defines available RPC methods of the DHT
+const (
+	RPCMethodDHTFindNode  = "dht-find-node"
+	RPCMethodDHTFindValue = "dht-find-value"
+	RPCMethodDHTStore     = "dht-store"
+)
+
+// MARK: DHTRequest & FindNodeResponse
+
+// DHTRequest - Defines the payload of the DHT methods, From is the sender info and must match the peer identity
+type DHTRequest struct {
+	From NodeInfo `json:"from"`
+	Key  DHTKey   `json:"key"`
+}
+
+// FindNodeResponse - Defines the payload returned by find node, the contacts closest to the key
+type FindNodeResponse struct {
+	Contacts []NodeInfo `json:"contacts"`
+}
+
+// MARK: RPCServer DHT exported
+
+// HandleDHT - Registers the DHT methods serving the DHT passed.
+// Anyone can look up a key, only the cluster members are recorded in the routing table and can store records
+func (s *RPCServer) HandleDHT(dht *DHT) {
+
+	s.Handle(RPCMethodDHTFindNode, func(peer *RPCPeer, payload json.RawMessage) (interface{}, error) {
+
+		from, req, err := decodeDHTRequest(peer, payload)
+		if err != nil {
+			return nil, err
+		}
+
+		return FindNodeResponse{Contacts: dht.HandleFindNode(from, req.Key)}, nil
+	})
+
+	s.Handle(RPCMethodDHTFindValue, func(peer *RPCPeer, payload json.RawMessage) (interface{}, error) {
+
+		from, req, err := decodeDHTRequest(peer, payload)
+		if err != nil {
+			return nil, err
+		}
+
+		return dht.HandleFindValue(from, req.Key), nil
+	})
+
+	s.HandleMember(RPCMethodDHTStore, func(peer *RPCPeer, payload json.RawMessage) (interface{}, error) {
+
+		from, req, err := decodeDHTRequest(peer, payload)
+		if err != nil {
+			return nil, err
+		}
+
+		dht.HandleStore(*from, req.Key)
+
+		return nil, nil
+	})
+}
+
+// MARK: RPCClient DHT exported
+
+// FindNode - Returns the contacts of the remote node closest to the key, from is the info of the sender
+func (c *RPCClient) FindNode(from NodeInfo, key DHTKey) ([]NodeInfo, error) {
+	var res FindNodeResponse
+	err := c.Call(RPCMethodDHTFindNode, DHTRequest{From: from, Key: key}, &res)
+	return res.Contacts, err
+}
+
+// FindValue - Returns the providers of the key known by the remote node, or its contacts closest to the key
+func (c *RPCClient) FindValue(from NodeInfo, key DHTKey) (*FindValueResponse, error) {
+
+	var res FindValueResponse
+	if err := c.Call(RPCMethodDHTFindValue, DHTRequest{From: from, Key: key}, &res); err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+// StoreProvider - Records the sender as provider of the key on the remote node
+func (c *RPCClient) StoreProvider(from NodeInfo, key DHTKey) error {
+	return c.Call(RPCMethodDHTStore, DHTRequest{From: from, Key: key}, nil)
+}
+
+// MARK: RPCDHTClient & constructors
+
+// RPCDHTClient - Defines the DHTClient sending the DHT requests over the RPC transport of the node.
+// The connection to a contact, which has to prove its node ID, is kept open and reused by the next requests
+type RPCDHTClient struct {
+	sync.Mutex
+	node    *Node
+	clients map[rpcDHTClientKey]*RPCClient
+}
+
+// rpcDHTClientKey - Defines the key of a connection kept open, a node joining the cluster dials its contacts again as member
+type rpcDHTClientKey struct {
+	id     string
+	member bool
+}
+
+// NewRPCDHTClient - Returns a new instance of RPCDHTClient for the node passed
+func NewRPCDHTClient(node *Node) *RPCDHTClient {
+	return &RPCDHTClient{node: node, clients: make(map[rpcDHTClientKey]*RPCClient)}
+}
+
+// MARK: RPCDHTClient DHTClient implementation
+
+// FindNode - Implements DHTClient interface
+func (c *RPCDHTClient) FindNode(to NodeInfo, target DHTKey) ([]NodeInfo, error) {
+
+	var contacts []NodeInfo
+	err := c.call(to, func(client *RPCClient) error {
+		var err error
+		contacts, err = client.FindNode(c.node.Info(), target)
+		return err
+	})
+
+	return contacts, err
+}
+
+// FindValue - Implements DHTClient interface
+func (c *RPCDHTClient) FindValue(to NodeInfo, key DHTKey) (*FindValueResponse, error) {
+
+	var res *FindValueResponse
+	err := c.call(to, func(client *RPCClient) error {
+		var err error
+		res, err = client.FindValue(c.node.Info(), key)
+		return err
+	})
+
+	return res, err
+}
+
+// Ping - Implements DHTClient interface
+func (c *RPCDHTClient) Ping(to NodeInfo) error {
+	return c.call(to, func(client *RPCClient) error {
+		_, err := client.Ping()
+		return err
+	})
+}
+
+// Store - Implements DHTClient interface
+func (c *RPCDHTClient) Store(to NodeInfo, key DHTKey) error {
+	return c.call(to, func(client *RPCClient) error {
+		return client.StoreProvider(c.node.Info(), key)
+	})
+}
+
+// MARK: RPCDHTClient exported
+
+// Close - Closes the connections kept open
+func (c *RPCDHTClient) Close() error {
+	c.Lock()
+	defer c.Unlock()
+
+	var err error
+	for key, client := range c.clients {
+		if cerr := client.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		delete(c.clients, key)
+	}
+
+	return err
+}
+
+// MARK: RPCDHTClient unexported
+
+// call - Runs the request on the connection to the contact, dialed if not open.
+// A broken connection is closed, a reused one is dialed again once since the contact closes the idle connections
+func (c *RPCDHTClient) call(to NodeInfo, request func(client *RPCClient) error) error {
+
+	key := rpcDHTClientKey{id: to.ID, member: c.node.IsMember()}
+
+	for attempt := 0; ; attempt++ {
+
+		c.Lock()
+		client, reused := c.clients[key]
+		c.Unlock()
+
+		if !reused {
+
+			var err error
+			if client, err = c.dial(to, key.member); err != nil {
+				return err
+			}
+
+			c.Lock()
+			if existing, ok := c.clients[key]; ok {
+				client.Close()
+				client = existing
+			} else {
+				c.clients[key] = client
+			}
+			c.Unlock()
+		}
+
+		err := request(client)
+
+		var remoteErr *RPCRemoteError
+		if err == nil || errors.As(err, &remoteErr) {
+			return err
+		}
+
+		c.Lock()
+		if c.clients[key] == client {
+			delete(c.clients, key)
+		}
+		c.Unlock()
+
+		client.Close()
+
+		if !reused || attempt > 0 {
+			return err
+		}
+	}
+}
+
+// dial - Dials the contact, cluster members only talk to members
+func (c *RPCDHTClient) dial(to NodeInfo, member bool) (*RPCClient, error) {
+
+	transport, err := c.node.Transport()
+	if err != nil {
+		return nil, err
+	}
+
+	return DialRPC(transport, NewNodeFromInfo(to).Address(), to.ID, member)
+}
+
+// MARK: RPC DHT utils unexported
+
+// decodeDHTRequest - Decodes the DHT request of the peer, the sender info is returned only for cluster members
+func decodeDHTRequest(peer *RPCPeer, payload json.RawMessage) (*NodeInfo, *DHTRequest, error) {
+
+	var req DHTRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return nil, nil, err
+	}
+
+	if !peer.Member {
+		return nil, &req, nil
+	}
+
+	if req.From.ID != peer.ID {
+		return nil, nil, NewIdentityMismatchError(peer.ID, req.From.ID)
+	}
+
+	req.From.PublicKey = peer.PublicKey
+
+	return &req.From, &req, nil
+}
@@ -0,0 +1,163 @@
+package network
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// MARK: consts
+
+// defines available RPC methods of the membership
+const (
+	RPCMethodMemberPing    = "member-ping"
+	RPCMethodMemberPingReq = "member-ping-req"
+)
+
+// MARK: MemberPingRequest & MemberAckResponse
+
+// MemberPingRequest - Defines the payload of the membership methods, Target is only sent by member-ping-req
+type MemberPingRequest struct {
+	Target  *NodeInfo      `json:"target,omitempty"`
+	Updates []MemberUpdate `json:"updates,omitempty"`
+}
+
+// MemberAckResponse - Defines the payload returned by the membership methods, the updates gossiped by the receiver
+type MemberAckResponse struct {
+	Updates []MemberUpdate `json:"updates,omitempty"`
+}
+
+// MARK: RPCServer membership exported
+
+// HandleMembership - Registers the membership methods serving the membership passed, only the cluster members take part
+func (s *RPCServer) HandleMembership(membership *Membership) {
+
+	s.HandleMember(RPCMethodMemberPing, func(peer *RPCPeer, payload json.RawMessage) (interface{}, error) {
+
+		req, err := decodeMemberPingRequest(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		return MemberAckResponse{Updates: membership.HandlePing(req.Updates)}, nil
+	})
+
+	s.HandleMember(RPCMethodMemberPingReq, func(peer *RPCPeer, payload json.RawMessage) (interface{}, error) {
+
+		req, err := decodeMemberPingRequest(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		if req.Target == nil {
+			return nil, NewInvalidMemberUpdateError("no target")
+		}
+
+		updates, err := membership.HandlePingReq(*req.Target, req.Updates)
+		if err != nil {
+			return nil, err
+		}
+
+		return MemberAckResponse{Updates: updates}, nil
+	})
+}
+
+// MARK: RPCClient membership exported
+
+// MemberPing - Pings the remote member piggybacking the updates, returns the updates of its ack
+func (c *RPCClient) MemberPing(updates []MemberUpdate) ([]MemberUpdate, error) {
+	var res MemberAckResponse
+	err := c.Call(RPCMethodMemberPing, MemberPingRequest{Updates: updates}, &res)
+	return res.Updates, err
+}
+
+// MemberPingReq - Asks the remote member to ping target, returns the updates of the ack of target
+func (c *RPCClient) MemberPingReq(target NodeInfo, updates []MemberUpdate) ([]MemberUpdate, error) {
+	var res MemberAckResponse
+	err := c.Call(RPCMethodMemberPingReq, MemberPingRequest{Target: &target, Updates: updates}, &res)
+	return res.Updates, err
+}
+
+// MARK: RPCMembershipTransport & constructors
+
+// RPCMembershipTransport - Defines the MembershipTransport sending the membership messages over the RPC transport of the node.
+// Every message dials the member, which has to prove its node ID and be a cluster member
+type RPCMembershipTransport struct {
+	node *Node
+}
+
+// NewRPCMembershipTransport - Returns a new instance of RPCMembershipTransport for the node passed
+func NewRPCMembershipTransport(node *Node) *RPCMembershipTransport {
+	return &RPCMembershipTransport{node: node}
+}
+
+// MARK: RPCMembershipTransport MembershipTransport implementation
+
+// Ping - Implements MembershipTransport interface
+func (t *RPCMembershipTransport) Ping(to NodeInfo, updates []MemberUpdate, timeout time.Duration) ([]MemberUpdate, error) {
+
+	client, err := t.dial(to, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	return client.MemberPing(updates)
+}
+
+// PingReq - Implements MembershipTransport interface, the helper gets twice the timeout to ping target
+func (t *RPCMembershipTransport) PingReq(via NodeInfo, target NodeInfo, updates []MemberUpdate, timeout time.Duration) ([]MemberUpdate, error) {
+
+	client, err := t.dial(via, 2*timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	return client.MemberPingReq(target, updates)
+}
+
+// MARK: RPCMembershipTransport unexported
+
+// dial - Dials the member, the whole exchange fails after timeout
+func (t *RPCMembershipTransport) dial(to NodeInfo, timeout time.Duration) (*RPCClient, error) {
+
+	transport, err := t.node.Transport()
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+
+	client, err := DialRPCWithTimeout(transport, NewNodeFromInfo(to).Address(), to.ID, true, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := client.SetDeadline(deadline); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// MARK: RPC membership utils unexported
+
+// decodeMemberPingRequest - Decodes the membership request, updates with an unknown state are rejected
+func decodeMemberPingRequest(payload json.RawMessage) (*MemberPingRequest, error) {
+
+	var req MemberPingRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return nil, err
+	}
+
+	for _, update := range req.Updates {
+		switch update.State {
+		case MemberAlive, MemberSuspect, MemberDead, MemberLeft:
+		default:
+			return nil, NewInvalidMemberUpdateError("unknown state " + update.State)
+		}
+	}
+
+	return &req, nil
+}
@@ -0,0 +1,125 @@
+package network
+
+import (
+	"encoding/json"
+
+	"github.com/IacopoMelani/vortex/core/storage"
+)
+
+// MARK: consts
+
+// defines available RPC methods of the chunk store
+const (
+	RPCMethodGetChunk = "get-chunk"
+	RPCMethodHasChunk = "has-chunk"
+	RPCMethodPutChunk = "put-chunk"
+	RPCMethodUsage    = "store-usage"
+)
+
+// MARK: ChunkRequest & ChunkResponse
+
+// ChunkRequest - Defines the payload of the chunk store methods, Data is only sent by put-chunk
+type ChunkRequest struct {
+	Hash storage.Hash `json:"hash"`
+	Data []byte       `json:"data,omitempty"`
+}
+
+// ChunkResponse - Defines the payload returned by the chunk store methods
+type ChunkResponse struct {
+	Data  []byte                   `json:"data,omitempty"`
+	Found bool                     `json:"found"`
+	Usage *storage.ChunkStoreUsage `json:"usage,omitempty"`
+}
+
+// MARK: RPCServer chunk store exported
+
+// HandleChunkStore - Registers the chunk store methods serving the store passed
+func (s *RPCServer) HandleChunkStore(store storage.ChunkStore) {
+
+	s.Handle(RPCMethodGetChunk, func(peer *RPCPeer, payload json.RawMessage) (interface{}, error) {
+
+		var req ChunkRequest
+		if err := json.Unmarshal(payload, &req); err != nil {
+			return nil, err
+		}
+
+		data, err := store.Get(req.Hash)
+		if err != nil {
+			return nil, err
+		}
+
+		return ChunkResponse{Data: data, Found: true}, nil
+	})
+
+	s.Handle(RPCMethodHasChunk, func(peer *RPCPeer, payload json.RawMessage) (interface{}, error) {
+
+		var req ChunkRequest
+		if err := json.Unmarshal(payload, &req); err != nil {
+			return nil, err
+		}
+
+		found, err := store.Has(req.Hash)
+		if err != nil {
+			return nil, err
+		}
+
+		return ChunkResponse{Found: found}, nil
+	})
+
+	s.Handle(RPCMethodPutChunk, func(peer *RPCPeer, payload json.RawMessage) (interface{}, error) {
+
+		var req ChunkRequest
+		if err := json.Unmarshal(payload, &req); err != nil {
+			return nil, err
+		}
+
+		if err := store.Put(req.Hash, req.Data); err != nil {
+			return nil, err
+		}
+
+		usage := store.Usage()
+
+		return ChunkResponse{Found: true, Usage: &usage}, nil
+	})
+
+	s.Handle(RPCMethodUsage, func(peer *RPCPeer, payload json.RawMessage) (interface{}, error) {
+		return store.Usage(), nil
+	})
+}
+
+// MARK: RPCClient chunk store exported
+
+// GetChunk - Returns the chunk with the hash passed stored by the remote node, the data is verified against the hash.
+// Implements storage.ChunkSource interface
+func (c *RPCClient) GetChunk(hash storage.Hash) ([]byte, error) {
+
+	var res ChunkResponse
+	if err := c.Call(RPCMethodGetChunk, ChunkRequest{Hash: hash}, &res); err != nil {
+		return nil, err
+	}
+
+	if storage.HashOf(res.Data) != hash {
+		return nil, storage.NewCorruptedChunkError(hash)
+	}
+
+	return res.Data, nil
+}
+
+// HasChunk - Returns true if the remote node stores the chunk with the hash passed
+func (c *RPCClient) HasChunk(hash storage.Hash) (bool, error) {
+	var res ChunkResponse
+	err := c.Call(RPCMethodHasChunk, ChunkRequest{Hash: hash}, &res)
+	return res.Found, err
+}
+
+// PutChunk - Stores the chunk on the remote node
+func (c *RPCClient) PutChunk(hash storage.Hash, data []byte) error {
+	return c.Call(RPCMethodPutChunk, ChunkRequest{Hash: hash, Data: data}, nil)
+}
+
+// Usage - Returns the usage of the chunk store of the remote node
+func (c *RPCClient) Usage() (storage.ChunkStoreUsage, error) {
+	var usage storage.ChunkStoreUsage
+	err := c.Call(RPCMethodUsage, nil, &usage)
+	return usage, err
+}
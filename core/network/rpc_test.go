@@ -0,0 +1,334 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func startTestRPCServer(t *testing.T, node *Node) string {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go NewRPCServer(node).Serve(listener)
+
+	t.Cleanup(func() { listener.Close() })
+
+	return listener.Addr().String()
+}
+
+func TestNodeJoin(t *testing.T) {
+
+	host, err := NewNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := host.BootstrapClusterCA(); err != nil {
+		t.Fatal(err)
+	}
+
+	address := startTestRPCServer(t, host)
+
+	jt, err := host.NewJoinToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	joining, err := NewNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := joining.Join(jt.Value(), address); err == nil {
+		t.Fatal("Expected error joining with a malformed token")
+	}
+
+	forged, err := joining.NewJoinToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := joining.Join(forged.String(), address); err == nil {
+		t.Fatal("Expected error joining with a token issued by another node")
+	}
+
+	neighbor, err := joining.Join(jt.String(), address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if neighbor.ID() != host.ID() {
+		t.Fatalf("Expected neighbor %s, got %s", host.ID(), neighbor.ID())
+	}
+
+	if len(host.Neighbors()) != 1 || host.Neighbors()[0].ID() != joining.ID() {
+		t.Fatal("Joining node not recorded as neighbor of host")
+	}
+
+	if _, err := NewNodeFromInfo(joining.Info()).Join(jt.String(), address); err == nil {
+		t.Fatal("Expected error joining with a consumed token")
+	}
+
+	// a failed join does not burn the token
+	jt, err = host.NewJoinToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := joining.Join(jt.String(), address); err == nil {
+		t.Fatal("Expected error joining a node already neighbor")
+	}
+
+	if len(host.JoinTokens()) != 1 {
+		t.Fatal("Expected the token of a failed join still registered")
+	}
+
+	host.RemoveNeighbor(joining.ID())
+	joining.RemoveNeighbor(host.ID())
+
+	if _, err := joining.Join(jt.String(), address); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(host.JoinTokens()) != 0 {
+		t.Fatal("Expected the token consumed by the join")
+	}
+}
+
+func TestRPCServerMethods(t *testing.T) {
+
+	node, err := NewNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	address := startTestRPCServer(t, node)
+
+	client, err := node.DialRPC(address, node.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if _, err := client.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := client.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.ID != node.ID() {
+		t.Fatalf("Expected node id %s, got %s", node.ID(), info.ID)
+	}
+
+	neighbors, err := client.Neighbors()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(neighbors) != 0 {
+		t.Fatalf("Expected no neighbors, got %d", len(neighbors))
+	}
+
+	if err := client.Call("unknown", nil, nil); err == nil {
+		t.Fatal("Expected error calling an unknown method")
+	}
+}
+
+func TestRPCServerMessageSize(t *testing.T) {
+
+	node, err := NewNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	// a small limit, a request of MaxRPCMessageSize is slow to scan with the race detector
+	server := NewRPCServer(node)
+	server.maxMessageSize = 1 << 10
+
+	go server.Serve(listener)
+
+	client, err := node.DialRPC(listener.Addr().String(), node.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// the server stops reading past the limit and drops the connection
+	if err := client.Call(RPCMethodPing, strings.Repeat("a", 1<<12), nil); err == nil {
+		t.Fatal("Expected error sending a request larger than the limit")
+	}
+
+	client, err = node.DialRPC(listener.Addr().String(), node.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if _, err := client.Ping(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRPCServerShutdown(t *testing.T) {
+
+	node, err := NewNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := NewRPCServer(node)
+
+	done := make(chan error)
+	go func() { done <- server.Serve(listener) }()
+
+	client, err := node.DialRPC(listener.Addr().String(), node.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if _, err := client.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := server.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-done; err != ErrRPCServerClosed {
+		t.Fatalf("Expected ErrRPCServerClosed, got %v", err)
+	}
+
+	if _, err := client.Ping(); err == nil {
+		t.Fatal("Expected error calling a closed server")
+	}
+}
+
+func TestRPCServerShutdownContext(t *testing.T) {
+
+	node, err := NewNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := NewRPCServer(node)
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+
+	server.Handle("block", func(peer *RPCPeer, payload json.RawMessage) (interface{}, error) {
+		close(entered)
+		<-release
+		return nil, nil
+	})
+
+	go server.Serve(listener)
+
+	client, err := node.DialRPC(listener.Addr().String(), node.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	called := make(chan error)
+	go func() { called <- client.Call("block", nil, nil) }()
+
+	<-entered
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// the in-flight request never completes, its connection is closed on the deadline
+	if err := server.ShutdownContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+	}
+
+	if err := <-called; err == nil {
+		t.Fatal("Expected error for the request interrupted by the shutdown")
+	}
+}
+
+func TestRPCAddress(t *testing.T) {
+
+	cases := map[string]string{
+		"10.0.0.1":      "10.0.0.1:6414",
+		"10.0.0.1:7000": "10.0.0.1:7000",
+		"node.example":  "node.example:6414",
+		"2001:db8::1":   "[2001:db8::1]:6414",
+		"[2001:db8::1]": "[2001:db8::1]:6414",
+		"[::1]:7000":    "[::1]:7000",
+	}
+
+	for host, expected := range cases {
+		if address := RPCAddress(host); address != expected {
+			t.Fatalf("Expected %s for %s, got %s", expected, host, address)
+		}
+	}
+}
+
+func TestNodeIPv6Address(t *testing.T) {
+
+	node, err := NewWithConfig(NodeConfig{IP: "[::1]", RPCPort: ":7000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if node.Host() != "::1" || node.Address() != "[::1]:7000" {
+		t.Fatalf("Expected host ::1 at [::1]:7000, got %s at %s", node.Host(), node.Address())
+	}
+
+	jt, err := node.NewJoinToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := ParseJoinToken(jt.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.Address() != "[::1]:7000" {
+		t.Fatalf("Expected token address [::1]:7000, got %s", decoded.Address())
+	}
+
+	if _, err := NewWithConfig(NodeConfig{Interface: "no-such-interface"}); err == nil {
+		t.Fatal("Expected error detecting the address on a missing interface")
+	}
+}
+
+func splitTestAddress(t *testing.T, address string) (string, string) {
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return host, ":" + port
+}
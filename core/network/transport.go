@@ -0,0 +1,346 @@
+package network
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// MARK: consts
+
+const (
+	// ALPN protocol negotiated by the nodes, the number is the RPCProtocolVersion
+	TransportProtocol = "vortex-rpc/1"
+
+	// validity of the certificates self-issued from the node identity
+	DefaultIdentityCertificateValidity = 24 * time.Hour * 365
+)
+
+// MARK: Transport & constructors
+
+// Transport - Defines the TLS 1.3 transport between nodes.
+// Every node presents a certificate for its identity key, the handshake binds the session to the node ID.
+// Cluster members present the certificate issued by the cluster CA, the others a self-issued one
+type Transport struct {
+	sync.RWMutex
+	identity    ed25519.PrivateKey
+	certificate tls.Certificate
+	member      *tls.Certificate
+	roots       *x509.CertPool
+}
+
+// NewTransport - Returns a new instance of Transport for the identity passed
+func NewTransport(identity ed25519.PrivateKey) (*Transport, error) {
+
+	certificate, err := NewIdentityCertificate(identity)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Transport{
+		identity:    identity,
+		certificate: certificate,
+	}, nil
+}
+
+// NewIdentityCertificate - Returns a certificate self-issued by the identity key, its subject is the node ID
+func NewIdentityCertificate(identity ed25519.PrivateKey) (tls.Certificate, error) {
+
+	serial, err := newCertificateSerial()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	pub := identity.Public().(ed25519.PublicKey)
+	now := time.Now().UTC()
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: NodeIDFromPublicKey(pub)},
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(DefaultIdentityCertificateValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, pub, identity)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: identity}, nil
+}
+
+// MARK: Transport exported
+
+// ClientConfig - Returns the TLS config used to dial a node, expectedID is the node ID the peer must prove.
+// With an empty expectedID any identity is accepted, the caller has to check PeerPublicKey.
+// With requireMember the peer certificate must be issued by the cluster CA
+func (t *Transport) ClientConfig(expectedID string, requireMember bool) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS13,
+		NextProtos: []string{TransportProtocol},
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return t.currentCertificate(), nil
+		},
+		// the chain is verified against the expected identity in VerifyPeerCertificate
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+
+			leaf, pub, err := parseIdentityCertificate(rawCerts)
+			if err != nil {
+				return err
+			}
+
+			if expectedID != "" && NodeIDFromPublicKey(pub) != expectedID {
+				return NewIdentityMismatchError(expectedID, NodeIDFromPublicKey(pub))
+			}
+
+			if requireMember && !t.IsMember(leaf) {
+				return NewInvalidPeerCertificateError("not issued by the cluster CA")
+			}
+
+			return nil
+		},
+	}
+}
+
+// CertificateValidity - Returns the validity of the certificate issued by the cluster CA, false if not a cluster member
+func (t *Transport) CertificateValidity() (time.Time, time.Time, bool) {
+	t.RLock()
+	defer t.RUnlock()
+
+	if t.member == nil {
+		return time.Time{}, time.Time{}, false
+	}
+
+	return t.member.Leaf.NotBefore, t.member.Leaf.NotAfter, true
+}
+
+// MemberCertificate - Returns the certificate issued by the cluster CA, nil if not a cluster member
+func (t *Transport) MemberCertificate() *x509.Certificate {
+	t.RLock()
+	defer t.RUnlock()
+
+	if t.member == nil {
+		return nil
+	}
+
+	return t.member.Leaf
+}
+
+// Dial - Dials the node at address, the handshake fails if the peer does not prove the expectedID, see ClientConfig
+func (t *Transport) Dial(address string, expectedID string, requireMember bool) (*tls.Conn, error) {
+	return t.DialTimeout(address, expectedID, requireMember, DefaultRPCDialTimeout)
+}
+
+// DialTimeout - Dials the node at address like Dial, the connection and the handshake fail after timeout
+func (t *Transport) DialTimeout(address string, expectedID string, requireMember bool, timeout time.Duration) (*tls.Conn, error) {
+
+	dialer := &net.Dialer{Timeout: timeout}
+
+	conn, err := tls.DialWithDialer(dialer, "tcp", address, t.ClientConfig(expectedID, requireMember))
+	if err != nil {
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// IsMember - Returns true if the certificate has been issued by the cluster CA trusted by the transport
+func (t *Transport) IsMember(leaf *x509.Certificate) bool {
+	t.RLock()
+	roots := t.roots
+	t.RUnlock()
+
+	if roots == nil {
+		return false
+	}
+
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+
+	return err == nil
+}
+
+// IsIssuedBy - Returns true if the transport credentials have been issued by the DER encoded CA certificate
+func (t *Transport) IsIssuedBy(caDER []byte) bool {
+
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return false
+	}
+
+	t.RLock()
+	defer t.RUnlock()
+
+	if t.member == nil {
+		return false
+	}
+
+	return bytes.Equal(t.member.Leaf.RawIssuer, ca.RawSubject) && t.member.Leaf.CheckSignatureFrom(ca) == nil
+}
+
+// SetCredentials - Sets the certificate issued by the cluster CA and the CA certificates to trust
+func (t *Transport) SetCredentials(creds *NodeCredentials) error {
+
+	leaf, err := x509.ParseCertificate(creds.Certificate)
+	if err != nil {
+		return err
+	}
+
+	pub, ok := leaf.PublicKey.(ed25519.PublicKey)
+	if !ok || !pub.Equal(t.identity.Public()) {
+		return NewInvalidPeerCertificateError("not issued for the node identity")
+	}
+
+	roots := x509.NewCertPool()
+	for _, der := range creds.CACertificates {
+
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return err
+		}
+
+		roots.AddCert(cert)
+	}
+
+	if _, err := leaf.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}); err != nil {
+		return NewInvalidPeerCertificateError(err.Error())
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	t.member = &tls.Certificate{
+		Certificate: [][]byte{creds.Certificate},
+		PrivateKey:  t.identity,
+		Leaf:        leaf,
+	}
+	t.roots = roots
+
+	return nil
+}
+
+// NewListener - Returns a listener accepting TLS connections from nodes over the inner listener
+func (t *Transport) NewListener(inner net.Listener) net.Listener {
+	return tls.NewListener(inner, t.ServerConfig())
+}
+
+// ServerConfig - Returns the TLS config used to accept nodes, every peer has to present an identity certificate.
+// Peers not issued by the cluster CA are accepted, see IsMember
+func (t *Transport) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS13,
+		NextProtos: []string{TransportProtocol},
+		ClientAuth: tls.RequireAnyClientCert,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return t.currentCertificate(), nil
+		},
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			_, _, err := parseIdentityCertificate(rawCerts)
+			return err
+		},
+	}
+}
+
+// MARK: Transport utils exported
+
+// PeerCertificate - Returns the leaf certificate and the identity public key proved by the peer during the handshake
+func PeerCertificate(conn *tls.Conn) (*x509.Certificate, ed25519.PublicKey, error) {
+
+	if err := conn.Handshake(); err != nil {
+		return nil, nil, err
+	}
+
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, nil, NewInvalidPeerCertificateError("no certificate")
+	}
+
+	pub, err := verifyIdentityCertificate(certs[0])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return certs[0], pub, nil
+}
+
+// MARK: Transport unexported
+
+// currentCertificate - Returns the certificate issued by the cluster CA, the self-issued one if missing or expired
+func (t *Transport) currentCertificate() *tls.Certificate {
+	t.RLock()
+	defer t.RUnlock()
+
+	if t.member != nil && time.Now().Before(t.member.Leaf.NotAfter) {
+		return t.member
+	}
+
+	return &t.certificate
+}
+
+// MARK: Transport utils unexported
+
+// parseIdentityCertificate - Parses the leaf certificate presented during the handshake, see verifyIdentityCertificate
+func parseIdentityCertificate(rawCerts [][]byte) (*x509.Certificate, ed25519.PublicKey, error) {
+
+	if len(rawCerts) == 0 {
+		return nil, nil, NewInvalidPeerCertificateError("no certificate")
+	}
+
+	leaf, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return nil, nil, NewInvalidPeerCertificateError(err.Error())
+	}
+
+	pub, err := verifyIdentityCertificate(leaf)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return leaf, pub, nil
+}
+
+// verifyIdentityCertificate - Returns the identity public key of the leaf certificate.
+// The possession of the key is proved by the TLS 1.3 CertificateVerify message
+func verifyIdentityCertificate(leaf *x509.Certificate) (ed25519.PublicKey, error) {
+
+	pub, ok := leaf.PublicKey.(ed25519.PublicKey)
+	if !ok {
+		return nil, NewInvalidPeerCertificateError("not an ed25519 identity")
+	}
+
+	now := time.Now()
+	if now.Before(leaf.NotBefore) || now.After(leaf.NotAfter) {
+		return nil, NewInvalidPeerCertificateError("certificate expired or not yet valid")
+	}
+
+	return pub, nil
+}
+
+// MARK: InvalidPeerCertificateError
+
+// InvalidPeerCertificateError - Defines error for a peer presenting an unusable certificate
+type InvalidPeerCertificateError struct {
+	reason string
+}
+
+// NewInvalidPeerCertificateError - Returns a new instance of InvalidPeerCertificateError
+func NewInvalidPeerCertificateError(reason string) error {
+	return &InvalidPeerCertificateError{reason: reason}
+}
+
+// Error - Implements error interface
+func (e *InvalidPeerCertificateError) Error() string {
+	return fmt.Sprintf("Invalid peer certificate: %s", e.reason)
+}
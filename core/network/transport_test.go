@@ -0,0 +1,89 @@
+package network
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+)
+
+func TestTransportMutualAuthentication(t *testing.T) {
+
+	server, err := NewNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := NewNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rpcServer := NewRPCServer(server)
+
+	peers := make(chan *RPCPeer, 1)
+	rpcServer.Handle("whoami", func(peer *RPCPeer, payload json.RawMessage) (interface{}, error) {
+		peers <- peer
+		return nil, nil
+	})
+
+	go rpcServer.Serve(listener)
+	defer rpcServer.Shutdown()
+
+	address := listener.Addr().String()
+
+	if _, err := client.DialNeighbor(server.ID()); err == nil {
+		t.Fatal("Expected error dialing a node missing from the neighbors")
+	}
+
+	if _, err := client.DialRPC(address, client.ID()); err == nil {
+		t.Fatal("Expected error dialing a node with an unexpected identity")
+	}
+
+	rpcClient, err := client.DialRPC(address, server.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rpcClient.Close()
+
+	if rpcClient.PeerID() != server.ID() {
+		t.Fatalf("Expected peer %s, got %s", server.ID(), rpcClient.PeerID())
+	}
+
+	if err := rpcClient.Call("whoami", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if peer := <-peers; peer.ID != client.ID() {
+		t.Fatalf("Expected server to see peer %s, got %s", client.ID(), peer.ID)
+	}
+}
+
+func TestTransportRejectsPlaintext(t *testing.T) {
+
+	server, err := NewNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	address := startTestRPCServer(t, server)
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := json.NewEncoder(conn).Encode(RPCRequest{Version: RPCProtocolVersion, ID: 1, Method: RPCMethodPing}); err != nil {
+		t.Fatal(err)
+	}
+
+	var res RPCResponse
+	if err := json.NewDecoder(conn).Decode(&res); err == nil {
+		t.Fatal("Expected plaintext request to be rejected")
+	}
+}
@@ -0,0 +1,204 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+)
+
+// MARK: consts
+
+const (
+	DefaultChunkSize = 1 << 18 // 256 KiB
+
+	// chunking strategies, see ChunkerConfig
+	ChunkerModeFixed = "fixed"
+	ChunkerModeCDC   = "cdc"
+)
+
+// MARK: Hash
+
+// Hash - Defines the SHA-256 content address of a chunk
+type Hash [sha256.Size]byte
+
+// HashOf - Returns the content address of the data passed
+func HashOf(data []byte) Hash {
+	return Hash(sha256.Sum256(data))
+}
+
+// ParseHash - Returns the Hash hex encoded in s
+func ParseHash(s string) (Hash, error) {
+
+	var h Hash
+
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != len(h) {
+		return h, NewInvalidHashError(s)
+	}
+
+	copy(h[:], b)
+
+	return h, nil
+}
+
+// MarshalText - Implements encoding.TextMarshaler
+func (h Hash) MarshalText() ([]byte, error) {
+	return []byte(h.String()), nil
+}
+
+// String - Returns the hex encoded Hash
+func (h Hash) String() string {
+	return hex.EncodeToString(h[:])
+}
+
+// UnmarshalText - Implements encoding.TextUnmarshaler
+func (h *Hash) UnmarshalText(text []byte) error {
+
+	parsed, err := ParseHash(string(text))
+	if err != nil {
+		return err
+	}
+
+	*h = parsed
+
+	return nil
+}
+
+// MARK: Chunk, Chunker, ChunkerConfig & constructors
+
+// Chunk - Defines a piece of a file addressed by its content
+type Chunk struct {
+	Hash Hash
+	Data []byte
+}
+
+// Chunker - Defines a generic interface splitting a stream in chunks
+type Chunker interface {
+	// Next - Returns the next chunk of the stream, io.EOF when the stream is over
+	Next() (*Chunk, error)
+}
+
+// ChunkerConfig - Defines the Chunker config for constructor.
+// Mode selects fixed-size chunking (default) of ChunkSize bytes or content-defined chunking between MinSize and MaxSize
+// bytes, averaging AvgSize. Zero sizes take the defaults
+type ChunkerConfig struct {
+	Mode      string `json:"mode,omitempty"`
+	ChunkSize int    `json:"chunk_size,omitempty"`
+	MinSize   int    `json:"min_size,omitempty"`
+	AvgSize   int    `json:"avg_size,omitempty"`
+	MaxSize   int    `json:"max_size,omitempty"`
+}
+
+// FixedChunker - Defines a Chunker splitting the stream in chunks of the same size, the last one can be smaller
+type FixedChunker struct {
+	reader    io.Reader
+	chunkSize int
+}
+
+// NewChunker - Returns a new Chunker over the reader with DefaultChunkSize
+func NewChunker(r io.Reader) Chunker {
+	return &FixedChunker{reader: r, chunkSize: DefaultChunkSize}
+}
+
+// NewChunkerWithConfig - Returns a new Chunker over the reader with config param, see ChunkerConfig
+func NewChunkerWithConfig(r io.Reader, config ChunkerConfig) (Chunker, error) {
+
+	switch config.Mode {
+	case "", ChunkerModeFixed:
+
+		if config.ChunkSize < 0 {
+			return nil, NewInvalidChunkerConfigError("chunk size must be positive")
+		}
+
+		chunker := &FixedChunker{reader: r, chunkSize: DefaultChunkSize}
+		if config.ChunkSize != 0 {
+			chunker.chunkSize = config.ChunkSize
+		}
+
+		return chunker, nil
+
+	case ChunkerModeCDC:
+
+		minSize := valueOrDefault(config.MinSize, DefaultCDCMinSize)
+		avgSize := valueOrDefault(config.AvgSize, DefaultCDCAvgSize)
+		maxSize := valueOrDefault(config.MaxSize, DefaultCDCMaxSize)
+
+		if minSize <= 0 || minSize > avgSize || avgSize > maxSize {
+			return nil, NewInvalidChunkerConfigError("sizes must be positive with min <= avg <= max")
+		}
+
+		if avgSize < 4 {
+			return nil, NewInvalidChunkerConfigError("average size too small")
+		}
+
+		return newCDCChunker(r, minSize, avgSize, maxSize), nil
+	}
+
+	return nil, NewInvalidChunkerConfigError("unknown mode " + config.Mode)
+}
+
+// MARK: FixedChunker Chunker implementation
+
+// Next - Returns the next chunk of the stream, io.EOF when the stream is over
+func (c *FixedChunker) Next() (*Chunk, error) {
+
+	buf := make([]byte, c.chunkSize)
+
+	n, err := io.ReadFull(c.reader, buf)
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+
+	data := buf[:n]
+
+	return &Chunk{Hash: HashOf(data), Data: data}, nil
+}
+
+// MARK: Chunker utils unexported
+
+func valueOrDefault(value, def int) int {
+
+	if value == 0 {
+		return def
+	}
+
+	return value
+}
+
+// MARK: InvalidHashError
+
+// InvalidHashError - Defines error for a string not encoding a Hash
+type InvalidHashError struct {
+	value string
+}
+
+// NewInvalidHashError - Returns a new instance of InvalidHashError
+func NewInvalidHashError(value string) error {
+	return &InvalidHashError{value: value}
+}
+
+// Error - Implements error interface
+func (e *InvalidHashError) Error() string {
+	return fmt.Sprintf("Invalid chunk hash %q", e.value)
+}
+
+// MARK: InvalidChunkerConfigError
+
+// InvalidChunkerConfigError - Defines error for an unusable ChunkerConfig
+type InvalidChunkerConfigError struct {
+	reason string
+}
+
+// NewInvalidChunkerConfigError - Returns a new instance of InvalidChunkerConfigError
+func NewInvalidChunkerConfigError(reason string) error {
+	return &InvalidChunkerConfigError{reason: reason}
+}
+
+// Error - Implements error interface
+func (e *InvalidChunkerConfigError) Error() string {
+	return fmt.Sprintf("Invalid chunker config: %s", e.reason)
+}
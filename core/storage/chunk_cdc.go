@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"io"
+	"math/bits"
+)
+
+// MARK: consts
+
+const (
+	DefaultCDCMinSize = DefaultChunkSize / 4
+	DefaultCDCAvgSize = DefaultChunkSize
+	DefaultCDCMaxSize = DefaultChunkSize * 4
+)
+
+// gearTable - random values indexed by byte feeding the gear rolling hash, the table must never change:
+// chunks boundaries, and so deduplication, depend on it
+var gearTable = newGearTable(0x766f72746578)
+
+// MARK: CDCChunker & constructors
+
+// CDCChunker - Defines a content-defined Chunker based on FastCDC.
+// Boundaries are found with a gear rolling hash over the data, so an insertion only changes the chunks around it.
+// Chunks are between minSize and maxSize bytes, averaging avgSize thanks to normalized chunking
+type CDCChunker struct {
+	reader  io.Reader
+	buf     []byte
+	eof     bool
+	minSize int
+	avgSize int
+	maxSize int
+	maskS   uint64
+	maskL   uint64
+}
+
+// newCDCChunker - Returns a new CDCChunker, the sizes must have been validated
+func newCDCChunker(r io.Reader, minSize, avgSize, maxSize int) *CDCChunker {
+
+	avgBits := bits.Len(uint(avgSize)) - 1
+
+	return &CDCChunker{
+		reader:  r,
+		buf:     make([]byte, 0, maxSize),
+		minSize: minSize,
+		avgSize: avgSize,
+		maxSize: maxSize,
+		// harder to match before avgSize, easier after: chunk sizes concentrate around avgSize
+		maskS: gearMask(avgBits + 1),
+		maskL: gearMask(avgBits - 1),
+	}
+}
+
+// MARK: CDCChunker Chunker implementation
+
+// Next - Returns the next chunk of the stream, io.EOF when the stream is over
+func (c *CDCChunker) Next() (*Chunk, error) {
+
+	if err := c.fill(); err != nil {
+		return nil, err
+	}
+
+	if len(c.buf) == 0 {
+		return nil, io.EOF
+	}
+
+	cut := c.cutPoint(c.buf)
+
+	data := make([]byte, cut)
+	copy(data, c.buf[:cut])
+
+	c.buf = c.buf[:copy(c.buf, c.buf[cut:])]
+
+	return &Chunk{Hash: HashOf(data), Data: data}, nil
+}
+
+// MARK: CDCChunker unexported
+
+// cutPoint - Returns the length of the chunk starting at the beginning of data
+func (c *CDCChunker) cutPoint(data []byte) int {
+
+	n := len(data)
+	if n <= c.minSize {
+		return n
+	}
+
+	if n > c.maxSize {
+		n = c.maxSize
+	}
+
+	normal := c.avgSize
+	if normal > n {
+		normal = n
+	}
+
+	var fp uint64
+	i := c.minSize
+
+	for ; i < normal; i++ {
+		fp = (fp << 1) + gearTable[data[i]]
+		if fp&c.maskS == 0 {
+			return i + 1
+		}
+	}
+
+	for ; i < n; i++ {
+		fp = (fp << 1) + gearTable[data[i]]
+		if fp&c.maskL == 0 {
+			return i + 1
+		}
+	}
+
+	return n
+}
+
+// fill - Reads from the stream until the buffer holds maxSize bytes or the stream is over
+func (c *CDCChunker) fill() error {
+
+	for !c.eof && len(c.buf) < c.maxSize {
+
+		n, err := c.reader.Read(c.buf[len(c.buf):c.maxSize])
+		c.buf = c.buf[:len(c.buf)+n]
+
+		if err == io.EOF {
+			c.eof = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MARK: CDCChunker utils unexported
+
+// gearMask - Returns a mask of the n most significant bits, they depend on the last 64 bytes rolled in
+func gearMask(n int) uint64 {
+
+	if n <= 0 {
+		return 0
+	}
+
+	return ^uint64(0) << (64 - n)
+}
+
+// newGearTable - Returns the gear table generated with splitmix64 from seed
+func newGearTable(seed uint64) [256]uint64 {
+
+	var table [256]uint64
+
+	for i := range table {
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+
+	return table
+}
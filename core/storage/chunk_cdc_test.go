@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+var testCDCConfig = ChunkerConfig{Mode: ChunkerModeCDC, MinSize: 512, AvgSize: 2048, MaxSize: 8192}
+
+func chunkAll(t *testing.T, data []byte, config ChunkerConfig) []*Chunk {
+
+	chunker, err := NewChunkerWithConfig(bytes.NewReader(data), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var chunks []*Chunk
+	for {
+		chunk, err := chunker.Next()
+		if err == io.EOF {
+			return chunks
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks = append(chunks, chunk)
+	}
+}
+
+// sharedChunks - Returns the number of chunks of b also present in a
+func sharedChunks(a, b []*Chunk) int {
+
+	seen := map[Hash]bool{}
+	for _, chunk := range a {
+		seen[chunk.Hash] = true
+	}
+
+	shared := 0
+	for _, chunk := range b {
+		if seen[chunk.Hash] {
+			shared++
+		}
+	}
+
+	return shared
+}
+
+func TestCDCChunkerSizes(t *testing.T) {
+
+	data := randomData(t, 1<<20, 3)
+	chunks := chunkAll(t, data, testCDCConfig)
+
+	var joined []byte
+	for i, chunk := range chunks {
+
+		if len(chunk.Data) > testCDCConfig.MaxSize {
+			t.Fatalf("Chunk %d of %d bytes exceeds the max size", i, len(chunk.Data))
+		}
+
+		if i < len(chunks)-1 && len(chunk.Data) < testCDCConfig.MinSize {
+			t.Fatalf("Chunk %d of %d bytes below the min size", i, len(chunk.Data))
+		}
+
+		joined = append(joined, chunk.Data...)
+	}
+
+	if !bytes.Equal(joined, data) {
+		t.Fatal("Expected chunks to rebuild the data")
+	}
+
+	avg := len(data) / len(chunks)
+	if avg < testCDCConfig.AvgSize/2 || avg > testCDCConfig.AvgSize*2 {
+		t.Fatalf("Average chunk size %d too far from %d", avg, testCDCConfig.AvgSize)
+	}
+}
+
+func TestCDCChunkerBoundaryStability(t *testing.T) {
+
+	data := randomData(t, 1<<20, 4)
+
+	edited := make([]byte, 0, len(data)+1)
+	edited = append(edited, data[:100]...)
+	edited = append(edited, 0x42)
+	edited = append(edited, data[100:]...)
+
+	original := chunkAll(t, data, testCDCConfig)
+	insertion := chunkAll(t, edited, testCDCConfig)
+
+	// only the chunks around the insertion change
+	if shared := sharedChunks(original, insertion); shared < len(insertion)-2 {
+		t.Fatalf("Expected at most 2 new chunks after an insertion, got %d of %d", len(insertion)-shared, len(insertion))
+	}
+
+	middle := append(append(append([]byte{}, data[:len(data)/2]...), []byte("inserted")...), data[len(data)/2:]...)
+	if shared := sharedChunks(original, chunkAll(t, middle, testCDCConfig)); shared < len(original)-2 {
+		t.Fatalf("Expected at most 2 changed chunks after an insertion in the middle, %d shared of %d", shared, len(original))
+	}
+
+	// fixed-size chunking shifts every boundary after the insertion
+	fixed := ChunkerConfig{ChunkSize: 2048}
+	if shared := sharedChunks(chunkAll(t, data, fixed), chunkAll(t, edited, fixed)); shared > 1 {
+		t.Fatalf("Expected fixed-size chunks to be shifted, %d shared", shared)
+	}
+}
+
+func TestCDCChunkerConfig(t *testing.T) {
+
+	invalid := []ChunkerConfig{
+		{Mode: ChunkerModeCDC, MinSize: 4096, AvgSize: 2048, MaxSize: 8192},
+		{Mode: ChunkerModeCDC, MinSize: 512, AvgSize: 2048, MaxSize: 1024},
+		{Mode: ChunkerModeCDC, MinSize: -1},
+		{Mode: "unknown"},
+	}
+
+	for _, config := range invalid {
+		if _, err := NewChunkerWithConfig(bytes.NewReader(nil), config); err == nil {
+			t.Fatalf("Expected error with config %+v", config)
+		}
+	}
+
+	if chunks := chunkAll(t, nil, testCDCConfig); len(chunks) != 0 {
+		t.Fatalf("Expected no chunks for empty data, got %d", len(chunks))
+	}
+}
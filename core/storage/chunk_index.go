@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"sort"
+	"sync"
+)
+
+// MARK: ChunkIndex & constructors
+
+// ChunkIndex - Defines the index of the nodes holding every chunk
+type ChunkIndex struct {
+	sync.RWMutex
+	locations map[Hash]map[string]struct{}
+}
+
+// NewChunkIndex - Returns a new empty instance of ChunkIndex
+func NewChunkIndex() *ChunkIndex {
+	return &ChunkIndex{locations: make(map[Hash]map[string]struct{})}
+}
+
+// MARK: ChunkIndex exported
+
+// Chunks - Returns the chunks held by the node, sorted
+func (i *ChunkIndex) Chunks(nodeID string) []Hash {
+	i.RLock()
+	defer i.RUnlock()
+
+	hashes := make([]Hash, 0)
+	for hash, nodes := range i.locations {
+		if _, ok := nodes[nodeID]; ok {
+			hashes = append(hashes, hash)
+		}
+	}
+
+	sortHashes(hashes)
+
+	return hashes
+}
+
+// Forget - Removes the node from the index, e.g. when the node left the network.
+// Returns the chunks the node held, sorted
+func (i *ChunkIndex) Forget(nodeID string) []Hash {
+
+	hashes := i.Chunks(nodeID)
+
+	i.Lock()
+	defer i.Unlock()
+
+	for _, hash := range hashes {
+		i.remove(hash, nodeID)
+	}
+
+	return hashes
+}
+
+// Locations - Returns the IDs of the nodes holding the chunk, sorted
+func (i *ChunkIndex) Locations(hash Hash) []string {
+	i.RLock()
+	defer i.RUnlock()
+
+	ids := make([]string, 0, len(i.locations[hash]))
+	for id := range i.locations[hash] {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	return ids
+}
+
+// Record - Records that the node holds the chunk
+func (i *ChunkIndex) Record(hash Hash, nodeID string) {
+	i.Lock()
+	defer i.Unlock()
+
+	nodes, ok := i.locations[hash]
+	if !ok {
+		nodes = make(map[string]struct{})
+		i.locations[hash] = nodes
+	}
+
+	nodes[nodeID] = struct{}{}
+}
+
+// Remove - Records that the node does not hold the chunk anymore
+func (i *ChunkIndex) Remove(hash Hash, nodeID string) {
+	i.Lock()
+	defer i.Unlock()
+	i.remove(hash, nodeID)
+}
+
+// UnderReplicated - Returns the chunks held by fewer nodes than replicas, sorted
+func (i *ChunkIndex) UnderReplicated(replicas int) []Hash {
+	i.RLock()
+	defer i.RUnlock()
+
+	hashes := make([]Hash, 0)
+	for hash, nodes := range i.locations {
+		if len(nodes) < replicas {
+			hashes = append(hashes, hash)
+		}
+	}
+
+	sortHashes(hashes)
+
+	return hashes
+}
+
+// MARK: ChunkIndex unexported
+
+// remove - Removes the node from the chunk locations, the lock must be held
+func (i *ChunkIndex) remove(hash Hash, nodeID string) {
+
+	nodes, ok := i.locations[hash]
+	if !ok {
+		return
+	}
+
+	delete(nodes, nodeID)
+
+	if len(nodes) == 0 {
+		delete(i.locations, hash)
+	}
+}
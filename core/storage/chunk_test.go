@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// MARK: test utils
+
+// memorySource - Defines a ChunkSource backed by a map, used in tests
+type memorySource map[Hash][]byte
+
+func (s memorySource) GetChunk(hash Hash) ([]byte, error) {
+
+	data, ok := s[hash]
+	if !ok {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	return data, nil
+}
+
+func randomData(t *testing.T, size int, seed int64) []byte {
+
+	data := make([]byte, size)
+	if _, err := rand.New(rand.NewSource(seed)).Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+// MARK: tests
+
+func TestFixedChunker(t *testing.T) {
+
+	data := randomData(t, 10*1024+17, 1)
+
+	chunker, err := NewChunkerWithConfig(bytes.NewReader(data), ChunkerConfig{ChunkSize: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var chunks []*Chunk
+	for {
+		chunk, err := chunker.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	if len(chunks) != 11 {
+		t.Fatalf("Expected 11 chunks, got %d", len(chunks))
+	}
+
+	if len(chunks[10].Data) != 17 {
+		t.Fatalf("Expected last chunk of 17 bytes, got %d", len(chunks[10].Data))
+	}
+
+	for _, chunk := range chunks {
+		if chunk.Hash != HashOf(chunk.Data) {
+			t.Fatal("Expected chunk hash to match its data")
+		}
+	}
+
+	if _, err := NewChunkerWithConfig(bytes.NewReader(data), ChunkerConfig{ChunkSize: -1}); err == nil {
+		t.Fatal("Expected error with a negative chunk size")
+	}
+}
+
+func TestHashText(t *testing.T) {
+
+	h := HashOf([]byte("vortex"))
+
+	parsed, err := ParseHash(h.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed != h {
+		t.Fatal("Expected parsed hash to match")
+	}
+
+	if _, err := ParseHash("invalid"); err == nil {
+		t.Fatal("Expected error parsing an invalid hash")
+	}
+}
+
+func TestSplitAndReassemble(t *testing.T) {
+
+	for _, size := range []int{0, 1, 1024, 5000} {
+
+		data := randomData(t, size, int64(size))
+		source := memorySource{}
+
+		manifest, err := Split(bytes.NewReader(data), ChunkerConfig{ChunkSize: 1024}, func(chunk *Chunk) error {
+			source[chunk.Hash] = chunk.Data
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if manifest.Size != int64(size) || manifest.Hash != HashOf(data) {
+			t.Fatalf("Unexpected manifest for %d bytes", size)
+		}
+
+		b, err := manifest.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		parsed, err := ParseManifest(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var out bytes.Buffer
+		if _, err := NewReassembler(parsed, source).WriteTo(&out); err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(out.Bytes(), data) {
+			t.Fatalf("Reassembled file of %d bytes differs from the original", size)
+		}
+	}
+}
+
+func TestReassembleCorrupted(t *testing.T) {
+
+	data := randomData(t, 4096, 2)
+	source := memorySource{}
+
+	manifest, err := Split(bytes.NewReader(data), ChunkerConfig{ChunkSize: 1024}, func(chunk *Chunk) error {
+		source[chunk.Hash] = append([]byte(nil), chunk.Data...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source[manifest.Chunks[2].Hash][0] ^= 0xff
+
+	_, err = NewReassembler(manifest, source).WriteTo(io.Discard)
+	if _, ok := err.(*CorruptedChunkError); !ok {
+		t.Fatalf("Expected CorruptedChunkError, got %v", err)
+	}
+
+	source[manifest.Chunks[2].Hash][0] ^= 0xff
+	manifest.Hash = HashOf(nil)
+
+	_, err = NewReassembler(manifest, source).WriteTo(io.Discard)
+	if _, ok := err.(*CorruptedFileError); !ok {
+		t.Fatalf("Expected CorruptedFileError, got %v", err)
+	}
+}
+
+func TestParseManifestInvalid(t *testing.T) {
+
+	manifest := &Manifest{Version: ManifestVersion, Size: 10, Chunks: []ChunkRef{{Offset: 0, Size: 4}}}
+
+	b, err := manifest.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ParseManifest(b); err == nil {
+		t.Fatal("Expected error parsing an inconsistent manifest")
+	}
+
+	if _, err := ParseManifest([]byte("{")); err == nil {
+		t.Fatal("Expected error parsing a malformed manifest")
+	}
+}
@@ -0,0 +1,361 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// MARK: consts
+
+const (
+	// cipher encrypting chunks, manifests and file keys
+	CipherAES256GCM = "aes-256-gcm"
+
+	// key derivation functions of the user key
+	KDFKeyfile = "keyfile"
+	KDFScrypt  = "scrypt"
+
+	// scrypt cost, about 32 MiB of memory per derivation
+	DefaultScryptN = 1 << 15
+	DefaultScryptR = 8
+	DefaultScryptP = 1
+
+	// highest scrypt cost accepted, the params of a manifest are untrusted: about 128 MiB of memory per derivation
+	MaxScryptN = 4 * DefaultScryptN
+	MaxScryptR = DefaultScryptR
+	MaxScryptP = 4 * DefaultScryptP
+
+	// minimum size of a keyfile
+	MinKeyfileSize = 32
+
+	// bytes added by the encryption of a chunk: nonce and GCM tag
+	ChunkCiphertextOverhead = 12 + 16
+
+	fileKeySize = 32
+	saltSize    = 16
+
+	// additional data binding every ciphertext to its purpose
+	chunkAdditionalData     = "vortex-chunk-v1"
+	convergentKeyDerivation = "vortex-convergent-key-v1"
+	fileKeyAdditionalData   = "vortex-file-key-v1"
+	manifestAdditionalData  = "vortex-manifest-v1"
+)
+
+// MARK: Encryption, KDFParams & UserKey
+
+// Encryption - Defines the encryption header of a sealed Manifest: the file key wrapped with the user key and how
+// to derive the user key. Nothing else about the file is readable without the user key
+type Encryption struct {
+	Cipher     string    `json:"cipher"`
+	KDF        KDFParams `json:"kdf"`
+	WrappedKey []byte    `json:"wrapped_key"`
+}
+
+// KDFParams - Defines the function deriving the user key, see KDFScrypt and KDFKeyfile
+type KDFParams struct {
+	Name string `json:"name"`
+	Salt []byte `json:"salt,omitempty"`
+	N    int    `json:"n,omitempty"`
+	R    int    `json:"r,omitempty"`
+	P    int    `json:"p,omitempty"`
+}
+
+// UserKey - Defines the secret of the user wrapping the file keys, a passphrase or a keyfile
+type UserKey struct {
+	passphrase []byte
+	keyfile    []byte
+	params     KDFParams
+}
+
+// NewPassphraseKey - Returns the UserKey for the passphrase, the key is derived with scrypt
+func NewPassphraseKey(passphrase string) *UserKey {
+	return &UserKey{
+		passphrase: []byte(passphrase),
+		params:     KDFParams{Name: KDFScrypt, N: DefaultScryptN, R: DefaultScryptR, P: DefaultScryptP},
+	}
+}
+
+// NewPassphraseKeyWithParams - Returns the UserKey for the passphrase with the scrypt cost passed, the salt is ignored
+func NewPassphraseKeyWithParams(passphrase string, params KDFParams) *UserKey {
+
+	params.Name = KDFScrypt
+	params.Salt = nil
+
+	return &UserKey{passphrase: []byte(passphrase), params: params}
+}
+
+// LoadKeyfile - Returns the UserKey for the keyfile, the key is the hash of its content
+func LoadKeyfile(filename string) (*UserKey, error) {
+
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b) < MinKeyfileSize {
+		return nil, NewInvalidKDFParamsError(fmt.Sprintf("keyfile shorter than %d bytes", MinKeyfileSize))
+	}
+
+	key := sha256.Sum256(b)
+
+	return &UserKey{keyfile: key[:], params: KDFParams{Name: KDFKeyfile}}, nil
+}
+
+// MARK: Encryption utils exported
+
+// NewFileKey - Returns a new random file key
+func NewFileKey() ([]byte, error) {
+
+	key := make([]byte, fileKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// ConvergentChunkKey - Returns the convergent key of the chunk, derived from its plaintext hash and the tenant secret.
+// Whoever holds the same chunk, and the same tenant secret, derives the same key: see EncryptChunkConvergent
+func ConvergentChunkKey(hash Hash, tenantSecret []byte) []byte {
+
+	mac := hmac.New(sha256.New, tenantSecret)
+	mac.Write([]byte(convergentKeyDerivation))
+	mac.Write(hash[:])
+
+	return mac.Sum(nil)
+}
+
+// EncryptChunk - Returns the chunk encrypted with the key, a random nonce is prepended
+func EncryptChunk(key, data []byte) ([]byte, error) {
+	return seal(key, nil, data, chunkAdditionalData)
+}
+
+// EncryptChunkConvergent - Returns the chunk encrypted with its convergent key, see ConvergentChunkKey.
+// The encryption is deterministic, identical chunks produce identical ciphertext and are stored once.
+// The nonce is fixed: every convergent key only ever encrypts the chunk it has been derived from
+func EncryptChunkConvergent(key, data []byte) ([]byte, error) {
+	return seal(key, make([]byte, ChunkCiphertextOverhead-16), data, chunkAdditionalData)
+}
+
+// DecryptChunk - Returns the chunk decrypted with the key, fails if the chunk has been tampered
+func DecryptChunk(key, data []byte) ([]byte, error) {
+	return open(key, data, chunkAdditionalData)
+}
+
+// SealManifest - Returns the sealed Manifest: the Manifest encrypted with the file key, the file key wrapped with the user key
+func SealManifest(manifest *Manifest, fileKey []byte, user *UserKey) (*Manifest, error) {
+
+	params := user.params
+	if params.Name == KDFScrypt {
+
+		params.Salt = make([]byte, saltSize)
+		if _, err := io.ReadFull(rand.Reader, params.Salt); err != nil {
+			return nil, err
+		}
+	}
+
+	wrapKey, err := user.derive(params)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped, err := seal(wrapKey, nil, fileKey, fileKeyAdditionalData)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := manifest.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := seal(fileKey, nil, b, manifestAdditionalData)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Manifest{
+		Version:    ManifestVersion,
+		Encryption: &Encryption{Cipher: CipherAES256GCM, KDF: params, WrappedKey: wrapped},
+		Sealed:     sealed,
+		Chunks:     make([]ChunkRef, 0),
+	}, nil
+}
+
+// OpenManifest - Returns the Manifest sealed by SealManifest and the file key, fails with a wrong user key or tampered data
+func OpenManifest(sealed *Manifest, user *UserKey) (*Manifest, []byte, error) {
+
+	if sealed.Encryption == nil {
+		return nil, nil, NewInvalidManifestError("not encrypted")
+	}
+
+	if sealed.Encryption.Cipher != CipherAES256GCM {
+		return nil, nil, NewInvalidManifestError("unsupported cipher " + sealed.Encryption.Cipher)
+	}
+
+	if sealed.Encryption.KDF.Name != user.params.Name {
+		return nil, nil, NewDecryptionError(fmt.Sprintf("file encrypted with a %s key", sealed.Encryption.KDF.Name))
+	}
+
+	wrapKey, err := user.derive(sealed.Encryption.KDF)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fileKey, err := open(wrapKey, sealed.Encryption.WrappedKey, fileKeyAdditionalData)
+	if err != nil {
+		return nil, nil, NewDecryptionError("wrong passphrase or keyfile")
+	}
+
+	b, err := open(fileKey, sealed.Sealed, manifestAdditionalData)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		return nil, nil, NewInvalidManifestError(err.Error())
+	}
+
+	if err := manifest.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	return &manifest, fileKey, nil
+}
+
+// MARK: UserKey unexported
+
+// derive - Returns the key wrapping the file keys
+func (k *UserKey) derive(params KDFParams) ([]byte, error) {
+
+	switch params.Name {
+	case KDFKeyfile:
+		return k.keyfile, nil
+	case KDFScrypt:
+		if err := params.validateScrypt(); err != nil {
+			return nil, err
+		}
+		return scrypt.Key(k.passphrase, params.Salt, params.N, params.R, params.P, fileKeySize)
+	}
+
+	return nil, NewInvalidKDFParamsError("unknown function " + params.Name)
+}
+
+// MARK: KDFParams unexported
+
+// validateScrypt - Returns an error if the scrypt cost is not usable or above MaxScryptN, MaxScryptR and MaxScryptP.
+// Checked before deriving, a crafted manifest can not make the derivation exhaust memory or CPU
+func (p KDFParams) validateScrypt() error {
+
+	if p.N <= 1 || p.N&(p.N-1) != 0 {
+		return NewInvalidKDFParamsError("N must be a power of two greater than 1")
+	}
+
+	if p.R <= 0 || p.P <= 0 {
+		return NewInvalidKDFParamsError("r and p must be positive")
+	}
+
+	if p.N > MaxScryptN || p.R > MaxScryptR || p.P > MaxScryptP {
+		return NewInvalidKDFParamsError(fmt.Sprintf("cost above N=%d, r=%d, p=%d", MaxScryptN, MaxScryptR, MaxScryptP))
+	}
+
+	return nil
+}
+
+// MARK: Encryption utils unexported
+
+// seal - Returns nonce and ciphertext of data encrypted with AES-256-GCM, a random nonce is used if nil
+func seal(key, nonce, data []byte, additionalData string) ([]byte, error) {
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if nonce == nil {
+
+		nonce = make([]byte, aead.NonceSize())
+		if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+			return nil, err
+		}
+	}
+
+	out := make([]byte, len(nonce), len(nonce)+len(data)+aead.Overhead())
+	copy(out, nonce)
+
+	return aead.Seal(out, nonce, data, []byte(additionalData)), nil
+}
+
+// open - Returns the data sealed by seal, fails with a DecryptionError if the key is wrong or the data tampered
+func open(key, data []byte, additionalData string) ([]byte, error) {
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < aead.NonceSize()+aead.Overhead() {
+		return nil, NewDecryptionError("ciphertext too short")
+	}
+
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(additionalData))
+	if err != nil {
+		return nil, NewDecryptionError("authentication failed")
+	}
+
+	return plain, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// MARK: DecryptionError
+
+// DecryptionError - Defines error for a ciphertext failing authentication: wrong key or tampered data
+type DecryptionError struct {
+	reason string
+}
+
+// NewDecryptionError - Returns a new instance of DecryptionError
+func NewDecryptionError(reason string) error {
+	return &DecryptionError{reason: reason}
+}
+
+// Error - Implements error interface
+func (e *DecryptionError) Error() string {
+	return fmt.Sprintf("Decryption failed: %s", e.reason)
+}
+
+// MARK: InvalidKDFParamsError
+
+// InvalidKDFParamsError - Defines error for unusable key derivation params
+type InvalidKDFParamsError struct {
+	reason string
+}
+
+// NewInvalidKDFParamsError - Returns a new instance of InvalidKDFParamsError
+func NewInvalidKDFParamsError(reason string) error {
+	return &InvalidKDFParamsError{reason: reason}
+}
+
+// Error - Implements error interface
+func (e *InvalidKDFParamsError) Error() string {
+	return fmt.Sprintf("Invalid key derivation params: %s", e.reason)
+}
@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fast scrypt cost for tests
+var testKDFParams = KDFParams{N: 1 << 4, R: 1, P: 1}
+
+func TestEncryptChunk(t *testing.T) {
+
+	fileKey, err := NewFileKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("plaintext chunk")
+
+	ciphertext, err := EncryptChunk(fileKey, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(ciphertext, data) {
+		t.Fatal("Expected ciphertext not to contain the plaintext")
+	}
+
+	plain, err := DecryptChunk(fileKey, ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(plain, data) {
+		t.Fatal("Expected decrypted chunk to match")
+	}
+
+	ciphertext[len(ciphertext)-1] ^= 0xff
+
+	if _, err := DecryptChunk(fileKey, ciphertext); err == nil {
+		t.Fatal("Expected error decrypting a tampered chunk")
+	}
+
+	if _, err := DecryptChunk(fileKey, ciphertext[:4]); err == nil {
+		t.Fatal("Expected error decrypting a truncated chunk")
+	}
+}
+
+func TestEncryptChunkConvergent(t *testing.T) {
+
+	data := []byte("plaintext chunk")
+	hash := HashOf(data)
+
+	encrypt := func(secret []byte) []byte {
+
+		ciphertext, err := EncryptChunkConvergent(ConvergentChunkKey(hash, secret), data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return ciphertext
+	}
+
+	if !bytes.Equal(encrypt(nil), encrypt(nil)) || !bytes.Equal(encrypt([]byte("tenant")), encrypt([]byte("tenant"))) {
+		t.Fatal("Expected identical chunks to produce identical ciphertext")
+	}
+
+	if bytes.Equal(encrypt([]byte("tenant")), encrypt([]byte("other"))) || bytes.Equal(encrypt(nil), encrypt([]byte("tenant"))) {
+		t.Fatal("Expected distinct tenant secrets to produce distinct ciphertext")
+	}
+
+	plain, err := DecryptChunk(ConvergentChunkKey(hash, []byte("tenant")), encrypt([]byte("tenant")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(plain, data) {
+		t.Fatal("Expected decrypted chunk to match")
+	}
+
+	if _, err := DecryptChunk(ConvergentChunkKey(hash, []byte("other")), encrypt([]byte("tenant"))); err == nil {
+		t.Fatal("Expected error decrypting with another tenant secret")
+	}
+}
+
+func TestSealManifest(t *testing.T) {
+
+	manifest, err := Split(bytes.NewReader([]byte("secret file")), ChunkerConfig{}, func(*Chunk) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest.Name = "secret.txt"
+
+	fileKey, err := NewFileKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := NewPassphraseKeyWithParams("passphrase", testKDFParams)
+
+	sealed, err := SealManifest(manifest, fileKey, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := sealed.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(b, []byte("secret.txt")) || bytes.Contains(b, []byte(manifest.Hash.String())) {
+		t.Fatal("Expected sealed manifest not to leak the file")
+	}
+
+	parsed, err := ParseManifest(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opened, openedKey, err := OpenManifest(parsed, NewPassphraseKeyWithParams("passphrase", testKDFParams))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opened.Name != manifest.Name || opened.Hash != manifest.Hash || !bytes.Equal(openedKey, fileKey) {
+		t.Fatal("Expected opened manifest to match")
+	}
+
+	if _, _, err := OpenManifest(parsed, NewPassphraseKeyWithParams("wrong", testKDFParams)); err == nil {
+		t.Fatal("Expected error opening with a wrong passphrase")
+	} else if _, ok := err.(*DecryptionError); !ok {
+		t.Fatalf("Expected DecryptionError, got %v", err)
+	}
+
+	parsed.Sealed[len(parsed.Sealed)-1] ^= 0xff
+
+	if _, _, err := OpenManifest(parsed, NewPassphraseKeyWithParams("passphrase", testKDFParams)); err == nil {
+		t.Fatal("Expected error opening a tampered manifest")
+	}
+}
+
+func TestOpenManifestKDFCost(t *testing.T) {
+
+	if err := NewPassphraseKey("passphrase").params.validateScrypt(); err != nil {
+		t.Fatalf("Expected the default cost accepted, got %v", err)
+	}
+
+	fileKey, err := NewFileKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := SealManifest(&Manifest{Version: ManifestVersion}, fileKey, NewPassphraseKeyWithParams("passphrase", testKDFParams))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the params of a manifest are untrusted, a crafted cost is rejected before deriving
+	for _, params := range []KDFParams{
+		{N: 1 << 20, R: 8, P: 1},
+		{N: 1 << 14, R: 1 << 10, P: 1},
+		{N: 1 << 14, R: 8, P: 1 << 10},
+		{N: 1000, R: 8, P: 1},
+		{N: 1 << 14, R: 0, P: 1},
+	} {
+
+		crafted := *sealed
+		encryption := *sealed.Encryption
+		encryption.KDF.N, encryption.KDF.R, encryption.KDF.P = params.N, params.R, params.P
+		crafted.Encryption = &encryption
+
+		if _, _, err := OpenManifest(&crafted, NewPassphraseKeyWithParams("passphrase", testKDFParams)); err == nil {
+			t.Fatalf("Expected error opening a manifest with cost %+v", params)
+		} else if _, ok := err.(*InvalidKDFParamsError); !ok {
+			t.Fatalf("Expected InvalidKDFParamsError with cost %+v, got %v", params, err)
+		}
+	}
+}
+
+func TestKeyfile(t *testing.T) {
+
+	dir := t.TempDir()
+
+	filename := filepath.Join(dir, "keyfile")
+	if err := os.WriteFile(filename, bytes.Repeat([]byte{0x42}, MinKeyfileSize), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := LoadKeyfile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fileKey, err := NewFileKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := SealManifest(&Manifest{Version: ManifestVersion}, fileKey, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := OpenManifest(sealed, user); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := OpenManifest(sealed, NewPassphraseKeyWithParams("passphrase", testKDFParams)); err == nil {
+		t.Fatal("Expected error opening a keyfile manifest with a passphrase")
+	}
+
+	short := filepath.Join(dir, "short")
+	if err := os.WriteFile(short, []byte("short"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadKeyfile(short); err == nil {
+		t.Fatal("Expected error loading a short keyfile")
+	}
+}
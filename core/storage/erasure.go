@@ -0,0 +1,385 @@
+package storage
+
+import (
+	"fmt"
+)
+
+// MARK: consts & vars
+
+const (
+	DefaultErasureDataShards   = 10
+	DefaultErasureParityShards = 4
+
+	// GF(2^8) allows at most 256 distinct rows in the encoding matrix
+	maxErasureShards = 256
+)
+
+// exp and log tables of GF(2^8) with the polynomial x^8 + x^4 + x^3 + x^2 + 1, the exp table is doubled to skip a modulo
+var (
+	gfExp [510]byte
+	gfLog [256]byte
+)
+
+func init() {
+
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfLog[x] = byte(i)
+
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+
+	for i := 255; i < len(gfExp); i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+}
+
+// MARK: Erasure, ErasureConfig & constructors
+
+// Erasure - Defines a systematic Reed-Solomon code splitting a stripe in data shards and computing parity shards.
+// Any Data shards out of Data+Parity are enough to rebuild the stripe
+type Erasure struct {
+	dataShards   int
+	parityShards int
+	matrix       [][]byte
+}
+
+// ErasureConfig - Defines the Erasure config for constructor
+type ErasureConfig struct {
+	Data   int `json:"data"`
+	Parity int `json:"parity"`
+}
+
+// NewErasure - Returns a new instance of Erasure with DefaultErasureDataShards and DefaultErasureParityShards
+func NewErasure() *Erasure {
+
+	e, _ := NewErasureWithConfig(ErasureConfig{Data: DefaultErasureDataShards, Parity: DefaultErasureParityShards})
+
+	return e
+}
+
+// NewErasureWithConfig - Returns a new instance of Erasure with config param, see ErasureConfig
+func NewErasureWithConfig(config ErasureConfig) (*Erasure, error) {
+
+	if config.Data <= 0 || config.Parity <= 0 {
+		return nil, NewInvalidErasureConfigError("data and parity shards must be positive")
+	}
+
+	if config.Data+config.Parity > maxErasureShards {
+		return nil, NewInvalidErasureConfigError(fmt.Sprintf("at most %d shards", maxErasureShards))
+	}
+
+	// the Vandermonde matrix is made systematic so that the first rows copy the data shards,
+	// every square matrix of its rows stays invertible
+	vandermonde := make([][]byte, config.Data+config.Parity)
+	for r := range vandermonde {
+		vandermonde[r] = make([]byte, config.Data)
+		for c := range vandermonde[r] {
+			vandermonde[r][c] = gfPow(byte(r), c)
+		}
+	}
+
+	top, err := gfInvertMatrix(vandermonde[:config.Data])
+	if err != nil {
+		return nil, err
+	}
+
+	return &Erasure{
+		dataShards:   config.Data,
+		parityShards: config.Parity,
+		matrix:       gfMulMatrix(vandermonde, top),
+	}, nil
+}
+
+// MARK: Erasure exported
+
+// Config - Returns the number of data and parity shards
+func (e *Erasure) Config() ErasureConfig {
+	return ErasureConfig{Data: e.dataShards, Parity: e.parityShards}
+}
+
+// Encode - Splits the stripe in data shards of the same size, the last one zero padded, followed by the parity shards
+func (e *Erasure) Encode(stripe []byte) [][]byte {
+
+	shardSize := (len(stripe) + e.dataShards - 1) / e.dataShards
+	if shardSize == 0 {
+		shardSize = 1
+	}
+
+	padded := make([]byte, shardSize*e.dataShards)
+	copy(padded, stripe)
+
+	shards := make([][]byte, e.dataShards+e.parityShards)
+	for i := 0; i < e.dataShards; i++ {
+		shards[i] = padded[i*shardSize : (i+1)*shardSize]
+	}
+
+	for i := e.dataShards; i < len(shards); i++ {
+		shards[i] = e.codeShard(e.matrix[i], shards[:e.dataShards], shardSize)
+	}
+
+	return shards
+}
+
+// Join - Returns the stripe of size bytes from the data shards, see Reconstruct
+func (e *Erasure) Join(shards [][]byte, size int) ([]byte, error) {
+
+	if len(shards) < e.dataShards {
+		return nil, NewInsufficientShardsError(len(shards), e.dataShards)
+	}
+
+	stripe := make([]byte, 0, size)
+	for _, shard := range shards[:e.dataShards] {
+
+		if shard == nil {
+			return nil, NewInsufficientShardsError(0, e.dataShards)
+		}
+
+		stripe = append(stripe, shard...)
+	}
+
+	if len(stripe) < size {
+		return nil, NewInvalidShardsError("shards smaller than the stripe")
+	}
+
+	return stripe[:size], nil
+}
+
+// Reconstruct - Rebuilds the missing shards, nil entries, from any Data shards available
+func (e *Erasure) Reconstruct(shards [][]byte) error {
+
+	if len(shards) != e.dataShards+e.parityShards {
+		return NewInvalidShardsError(fmt.Sprintf("expected %d shards, got %d", e.dataShards+e.parityShards, len(shards)))
+	}
+
+	shardSize := 0
+	rows := make([]int, 0, e.dataShards)
+
+	for i, shard := range shards {
+
+		if shard == nil {
+			continue
+		}
+
+		if shardSize == 0 {
+			shardSize = len(shard)
+		}
+
+		if len(shard) != shardSize {
+			return NewInvalidShardsError("shards of different size")
+		}
+
+		if len(rows) < e.dataShards {
+			rows = append(rows, i)
+		}
+	}
+
+	if len(rows) < e.dataShards {
+		return NewInsufficientShardsError(len(rows), e.dataShards)
+	}
+
+	// the rows of the shards available map the data shards to them, their inverse maps them back
+	sub := make([][]byte, e.dataShards)
+	available := make([][]byte, e.dataShards)
+	for i, r := range rows {
+		sub[i] = e.matrix[r]
+		available[i] = shards[r]
+	}
+
+	decode, err := gfInvertMatrix(sub)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < e.dataShards; i++ {
+		if shards[i] == nil {
+			shards[i] = e.codeShard(decode[i], available, shardSize)
+		}
+	}
+
+	for i := e.dataShards; i < len(shards); i++ {
+		if shards[i] == nil {
+			shards[i] = e.codeShard(e.matrix[i], shards[:e.dataShards], shardSize)
+		}
+	}
+
+	return nil
+}
+
+// MARK: Erasure unexported
+
+// codeShard - Returns the linear combination of the inputs with the coefficients of row
+func (e *Erasure) codeShard(row []byte, inputs [][]byte, shardSize int) []byte {
+
+	out := make([]byte, shardSize)
+
+	for c, input := range inputs {
+
+		coefficient := row[c]
+		if coefficient == 0 {
+			continue
+		}
+
+		for j, b := range input {
+			out[j] ^= gfMul(coefficient, b)
+		}
+	}
+
+	return out
+}
+
+// MARK: GF(2^8) utils unexported
+
+func gfMul(a, b byte) byte {
+
+	if a == 0 || b == 0 {
+		return 0
+	}
+
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfInv(a byte) byte {
+	return gfExp[255-int(gfLog[a])]
+}
+
+func gfPow(a byte, n int) byte {
+
+	if n == 0 {
+		return 1
+	}
+
+	if a == 0 {
+		return 0
+	}
+
+	return gfExp[(int(gfLog[a])*n)%255]
+}
+
+func gfMulMatrix(a, b [][]byte) [][]byte {
+
+	out := make([][]byte, len(a))
+	for r := range a {
+
+		out[r] = make([]byte, len(b[0]))
+		for c := range out[r] {
+
+			var v byte
+			for i := range b {
+				v ^= gfMul(a[r][i], b[i][c])
+			}
+
+			out[r][c] = v
+		}
+	}
+
+	return out
+}
+
+// gfInvertMatrix - Returns the inverse of the square matrix with Gauss-Jordan elimination
+func gfInvertMatrix(m [][]byte) ([][]byte, error) {
+
+	n := len(m)
+
+	// m augmented with the identity
+	work := make([][]byte, n)
+	for r := range m {
+		work[r] = make([]byte, 2*n)
+		copy(work[r], m[r])
+		work[r][n+r] = 1
+	}
+
+	for c := 0; c < n; c++ {
+
+		pivot := c
+		for pivot < n && work[pivot][c] == 0 {
+			pivot++
+		}
+
+		if pivot == n {
+			return nil, NewInvalidShardsError("singular matrix")
+		}
+
+		work[c], work[pivot] = work[pivot], work[c]
+
+		inv := gfInv(work[c][c])
+		for j := range work[c] {
+			work[c][j] = gfMul(work[c][j], inv)
+		}
+
+		for r := 0; r < n; r++ {
+
+			factor := work[r][c]
+			if r == c || factor == 0 {
+				continue
+			}
+
+			for j := range work[r] {
+				work[r][j] ^= gfMul(factor, work[c][j])
+			}
+		}
+	}
+
+	inverse := make([][]byte, n)
+	for r := range work {
+		inverse[r] = work[r][n:]
+	}
+
+	return inverse, nil
+}
+
+// MARK: InvalidErasureConfigError
+
+// InvalidErasureConfigError - Defines error for an unusable ErasureConfig
+type InvalidErasureConfigError struct {
+	reason string
+}
+
+// NewInvalidErasureConfigError - Returns a new instance of InvalidErasureConfigError
+func NewInvalidErasureConfigError(reason string) error {
+	return &InvalidErasureConfigError{reason: reason}
+}
+
+// Error - Implements error interface
+func (e *InvalidErasureConfigError) Error() string {
+	return fmt.Sprintf("Invalid erasure config: %s", e.reason)
+}
+
+// MARK: InsufficientShardsError
+
+// InsufficientShardsError - Defines error for a stripe with too many shards missing to be rebuilt
+type InsufficientShardsError struct {
+	available int
+	required  int
+}
+
+// NewInsufficientShardsError - Returns a new instance of InsufficientShardsError
+func NewInsufficientShardsError(available, required int) error {
+	return &InsufficientShardsError{available: available, required: required}
+}
+
+// Error - Implements error interface
+func (e *InsufficientShardsError) Error() string {
+	return fmt.Sprintf("Insufficient shards: %d available, %d required", e.available, e.required)
+}
+
+// MARK: InvalidShardsError
+
+// InvalidShardsError - Defines error for shards that can not belong to the same stripe
+type InvalidShardsError struct {
+	reason string
+}
+
+// NewInvalidShardsError - Returns a new instance of InvalidShardsError
+func NewInvalidShardsError(reason string) error {
+	return &InvalidShardsError{reason: reason}
+}
+
+// Error - Implements error interface
+func (e *InvalidShardsError) Error() string {
+	return fmt.Sprintf("Invalid shards: %s", e.reason)
+}
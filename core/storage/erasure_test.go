@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestErasureReconstruct(t *testing.T) {
+
+	e := NewErasure()
+	config := e.Config()
+
+	stripe := randomData(t, 100*1024+3, 5)
+	shards := e.Encode(stripe)
+
+	if len(shards) != config.Data+config.Parity {
+		t.Fatalf("Expected %d shards, got %d", config.Data+config.Parity, len(shards))
+	}
+
+	original := make([][]byte, len(shards))
+	for i, shard := range shards {
+		original[i] = append([]byte(nil), shard...)
+	}
+
+	rnd := rand.New(rand.NewSource(6))
+
+	for round := 0; round < 20; round++ {
+
+		damaged := make([][]byte, len(shards))
+		copy(damaged, original)
+
+		// any Parity shards can be lost
+		for _, i := range rnd.Perm(len(damaged))[:config.Parity] {
+			damaged[i] = nil
+		}
+
+		if err := e.Reconstruct(damaged); err != nil {
+			t.Fatal(err)
+		}
+
+		for i := range damaged {
+			if !bytes.Equal(damaged[i], original[i]) {
+				t.Fatalf("Round %d: shard %d not rebuilt", round, i)
+			}
+		}
+
+		joined, err := e.Join(damaged, len(stripe))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(joined, stripe) {
+			t.Fatalf("Round %d: joined stripe differs", round)
+		}
+	}
+
+	damaged := make([][]byte, len(shards))
+	copy(damaged, original)
+	for i := 0; i <= config.Parity; i++ {
+		damaged[i] = nil
+	}
+
+	if _, ok := e.Reconstruct(damaged).(*InsufficientShardsError); !ok {
+		t.Fatal("Expected InsufficientShardsError losing more than the parity shards")
+	}
+}
+
+func TestErasureSmallStripes(t *testing.T) {
+
+	e, err := NewErasureWithConfig(ErasureConfig{Data: 3, Parity: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, size := range []int{0, 1, 2, 3, 4} {
+
+		stripe := randomData(t, size, int64(size))
+		shards := e.Encode(stripe)
+
+		shards[0], shards[2] = nil, nil
+
+		if err := e.Reconstruct(shards); err != nil {
+			t.Fatal(err)
+		}
+
+		joined, err := e.Join(shards, size)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(joined, stripe) {
+			t.Fatalf("Stripe of %d bytes not rebuilt", size)
+		}
+	}
+}
+
+func TestErasureConfig(t *testing.T) {
+
+	invalid := []ErasureConfig{{Data: 0, Parity: 4}, {Data: 10, Parity: 0}, {Data: 200, Parity: 57}}
+
+	for _, config := range invalid {
+		if _, err := NewErasureWithConfig(config); err == nil {
+			t.Fatalf("Expected error with config %+v", config)
+		}
+	}
+
+	e := NewErasure()
+	if err := e.Reconstruct(make([][]byte, 3)); err == nil {
+		t.Fatal("Expected error reconstructing a wrong number of shards")
+	}
+}
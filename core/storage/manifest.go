@@ -0,0 +1,215 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// MARK: consts
+
+const (
+	// current Manifest format version
+	ManifestVersion = 1
+)
+
+// MARK: Manifest & ChunkRef
+
+// Manifest - Defines the description of a chunked file: its chunks in order, their sizes and the hash of the whole file.
+// Chunks are either replicated on Replicas nodes or, with Erasure, split in shards spread over distinct nodes.
+// An encrypted file has a sealed Manifest, only holding Encryption and the Sealed Manifest describing the file, see SealManifest
+type Manifest struct {
+	Version    int            `json:"version"`
+	Name       string         `json:"name,omitempty"`
+	Size       int64          `json:"size"`
+	Hash       Hash           `json:"hash"`
+	Replicas   int            `json:"replicas,omitempty"`
+	Erasure    *ErasureConfig `json:"erasure,omitempty"`
+	Encryption *Encryption    `json:"encryption,omitempty"`
+	Sealed     []byte         `json:"sealed,omitempty"`
+	Chunks     []ChunkRef     `json:"chunks"`
+}
+
+// ChunkRef - Defines the position of a chunk in the file described by a Manifest and the IDs of the nodes holding it.
+// Encrypted chunks are stored by the hash of their Ciphertext, convergent encrypted ones have their own Key.
+// Erasure coded chunks are held as Shards, data shards first
+type ChunkRef struct {
+	Hash       Hash       `json:"hash"`
+	Offset     int64      `json:"offset"`
+	Size       int64      `json:"size"`
+	Ciphertext *Hash      `json:"ciphertext,omitempty"`
+	Key        []byte     `json:"key,omitempty"`
+	Nodes      []string   `json:"nodes,omitempty"`
+	Shards     []ShardRef `json:"shards,omitempty"`
+}
+
+// ShardRef - Defines an erasure coded shard of a chunk and the IDs of the nodes holding it
+type ShardRef struct {
+	Hash  Hash     `json:"hash"`
+	Nodes []string `json:"nodes,omitempty"`
+}
+
+// Split - Splits the stream with the chunker, fn is called for every chunk in order.
+// Returns the Manifest describing the stream
+func Split(r io.Reader, config ChunkerConfig, fn func(chunk *Chunk) error) (*Manifest, error) {
+
+	fileHash := sha256.New()
+
+	chunker, err := NewChunkerWithConfig(io.TeeReader(r, fileHash), config)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &Manifest{Version: ManifestVersion, Chunks: make([]ChunkRef, 0)}
+
+	for {
+		chunk, err := chunker.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if err := fn(chunk); err != nil {
+			return nil, err
+		}
+
+		manifest.Chunks = append(manifest.Chunks, ChunkRef{
+			Hash:   chunk.Hash,
+			Offset: manifest.Size,
+			Size:   int64(len(chunk.Data)),
+		})
+		manifest.Size += int64(len(chunk.Data))
+	}
+
+	copy(manifest.Hash[:], fileHash.Sum(nil))
+
+	return manifest, nil
+}
+
+// ParseManifest - Decodes and validates a Manifest encoded with Manifest.Encode
+func ParseManifest(b []byte) (*Manifest, error) {
+
+	var manifest Manifest
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		return nil, NewInvalidManifestError(err.Error())
+	}
+
+	if err := manifest.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &manifest, nil
+}
+
+// MARK: Manifest & ChunkRef exported
+
+// Address - Returns the hash the chunk is stored by, the Ciphertext hash for encrypted chunks
+func (r ChunkRef) Address() Hash {
+
+	if r.Ciphertext != nil {
+		return *r.Ciphertext
+	}
+
+	return r.Hash
+}
+
+// StoredSize - Returns the size of the chunk as stored, encrypted chunks are larger than the plaintext
+func (r ChunkRef) StoredSize() int64 {
+
+	if r.Ciphertext != nil {
+		return r.Size + ChunkCiphertextOverhead
+	}
+
+	return r.Size
+}
+
+// Encode - Returns the Manifest JSON encoded
+func (m *Manifest) Encode() ([]byte, error) {
+	return json.Marshal(m)
+}
+
+// ID - Returns the content address of the encoded Manifest
+func (m *Manifest) ID() (Hash, error) {
+
+	b, err := m.Encode()
+	if err != nil {
+		return Hash{}, err
+	}
+
+	return HashOf(b), nil
+}
+
+// Validate - Returns an error if the chunks are not contiguous or do not sum up to the file size
+func (m *Manifest) Validate() error {
+
+	if m.Version != ManifestVersion {
+		return NewInvalidManifestError(fmt.Sprintf("unsupported version %d", m.Version))
+	}
+
+	if m.Encryption != nil {
+
+		if len(m.Sealed) == 0 || len(m.Chunks) != 0 {
+			return NewInvalidManifestError("sealed manifest describing chunks")
+		}
+
+		return nil
+	}
+
+	var offset int64
+	for i, ref := range m.Chunks {
+
+		if ref.Offset != offset || ref.Size <= 0 {
+			return NewInvalidManifestError(fmt.Sprintf("chunk %d out of order", i))
+		}
+
+		if m.Erasure != nil && len(ref.Shards) != m.Erasure.Data+m.Erasure.Parity {
+			return NewInvalidManifestError(fmt.Sprintf("chunk %d has %d shards", i, len(ref.Shards)))
+		}
+
+		offset += ref.Size
+	}
+
+	if offset != m.Size {
+		return NewInvalidManifestError(fmt.Sprintf("chunks size %d differs from file size %d", offset, m.Size))
+	}
+
+	return nil
+}
+
+// MARK: InvalidManifestError
+
+// InvalidManifestError - Defines error for a Manifest that can not be decoded or is inconsistent
+type InvalidManifestError struct {
+	reason string
+}
+
+// NewInvalidManifestError - Returns a new instance of InvalidManifestError
+func NewInvalidManifestError(reason string) error {
+	return &InvalidManifestError{reason: reason}
+}
+
+// Error - Implements error interface
+func (e *InvalidManifestError) Error() string {
+	return fmt.Sprintf("Invalid manifest: %s", e.reason)
+}
+
+// MARK: ManifestTooLargeError
+
+// ManifestTooLargeError - Defines error for a Manifest exceeding the size storable as a single chunk
+type ManifestTooLargeError struct {
+	Size  int
+	Limit int
+}
+
+// NewManifestTooLargeError - Returns a new instance of ManifestTooLargeError
+func NewManifestTooLargeError(size, limit int) error {
+	return &ManifestTooLargeError{Size: size, Limit: limit}
+}
+
+// Error - Implements error interface
+func (e *ManifestTooLargeError) Error() string {
+	return fmt.Sprintf("Manifest of %d bytes exceeds the limit of %d bytes, use larger chunks", e.Size, e.Limit)
+}
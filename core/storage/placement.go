@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+)
+
+// MARK: consts
+
+const (
+	DefaultReplicas = 3
+)
+
+// MARK: Placement, PlacementConfig & constructors
+
+// Placement - Defines the engine choosing the nodes storing a chunk.
+// Nodes are ranked by rendezvous hashing of the chunk hash and the node ID: the same chunk over the same nodes always
+// gets the same placement, and losing a node only moves the chunks it held
+type Placement struct {
+	replicas      int
+	allowDegraded bool
+}
+
+// PlacementConfig - Defines the Placement config for constructor.
+// Every chunk is stored on Replicas distinct nodes, DefaultReplicas if zero.
+// With AllowDegraded chunks are placed on all the nodes available when they are fewer than Replicas
+type PlacementConfig struct {
+	Replicas      int  `json:"replicas,omitempty"`
+	AllowDegraded bool `json:"allow_degraded,omitempty"`
+}
+
+// NewPlacement - Returns a new instance of Placement with DefaultReplicas
+func NewPlacement() *Placement {
+	return &Placement{replicas: DefaultReplicas}
+}
+
+// NewPlacementWithConfig - Returns a new instance of Placement with config param, see PlacementConfig
+func NewPlacementWithConfig(config PlacementConfig) (*Placement, error) {
+
+	if config.Replicas < 0 {
+		return nil, NewInvalidPlacementConfigError("replicas must be positive")
+	}
+
+	p := NewPlacement()
+	if config.Replicas != 0 {
+		p.replicas = config.Replicas
+	}
+	p.allowDegraded = config.AllowDegraded
+
+	return p, nil
+}
+
+// MARK: Placement exported
+
+// Check - Returns an InsufficientNodesError if the nodes available are fewer than the replicas, nil if degraded placement is allowed
+func (p *Placement) Check(nodes int) error {
+
+	if nodes == 0 || (nodes < p.replicas && !p.allowDegraded) {
+		return NewInsufficientNodesError(nodes, p.replicas)
+	}
+
+	return nil
+}
+
+// Degraded - Returns true if the nodes available are fewer than the replicas
+func (p *Placement) Degraded(nodes int) bool {
+	return nodes < p.replicas
+}
+
+// Place - Returns the IDs of the nodes that must store the chunk, in order of preference, see Check
+func (p *Placement) Place(hash Hash, nodes []string) ([]string, error) {
+
+	ranked := Rank(hash, nodes)
+
+	if err := p.Check(len(ranked)); err != nil {
+		return nil, err
+	}
+
+	if len(ranked) > p.replicas {
+		ranked = ranked[:p.replicas]
+	}
+
+	return ranked, nil
+}
+
+// Replicas - Returns the number of nodes every chunk is stored on
+func (p *Placement) Replicas() int {
+	return p.replicas
+}
+
+// MARK: Placement utils exported
+
+// Rank - Returns the distinct node IDs sorted by their rendezvous score for the chunk, highest first
+func Rank(hash Hash, nodes []string) []string {
+
+	type scored struct {
+		id    string
+		score [sha256.Size]byte
+	}
+
+	seen := make(map[string]struct{}, len(nodes))
+	scores := make([]scored, 0, len(nodes))
+
+	for _, id := range nodes {
+
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+
+		scores = append(scores, scored{id: id, score: sha256.Sum256(append(hash[:], id...))})
+	}
+
+	sort.Slice(scores, func(i, j int) bool {
+		return string(scores[i].score[:]) > string(scores[j].score[:])
+	})
+
+	ranked := make([]string, len(scores))
+	for i, s := range scores {
+		ranked[i] = s.id
+	}
+
+	return ranked
+}
+
+// MARK: InsufficientNodesError
+
+// InsufficientNodesError - Defines error for a network with fewer nodes than the replicas requested
+type InsufficientNodesError struct {
+	nodes    int
+	replicas int
+}
+
+// NewInsufficientNodesError - Returns a new instance of InsufficientNodesError
+func NewInsufficientNodesError(nodes, replicas int) error {
+	return &InsufficientNodesError{nodes: nodes, replicas: replicas}
+}
+
+// Error - Implements error interface
+func (e *InsufficientNodesError) Error() string {
+	return fmt.Sprintf("Insufficient nodes: %d available, %d replicas requested", e.nodes, e.replicas)
+}
+
+// MARK: InvalidPlacementConfigError
+
+// InvalidPlacementConfigError - Defines error for an unusable PlacementConfig
+type InvalidPlacementConfigError struct {
+	reason string
+}
+
+// NewInvalidPlacementConfigError - Returns a new instance of InvalidPlacementConfigError
+func NewInvalidPlacementConfigError(reason string) error {
+	return &InvalidPlacementConfigError{reason: reason}
+}
+
+// Error - Implements error interface
+func (e *InvalidPlacementConfigError) Error() string {
+	return fmt.Sprintf("Invalid placement config: %s", e.reason)
+}
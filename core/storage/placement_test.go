@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func fakeNodes(n int) []string {
+
+	nodes := make([]string, n)
+	for i := range nodes {
+		nodes[i] = fmt.Sprintf("node-%02d", i)
+	}
+
+	return nodes
+}
+
+func TestPlacementPlace(t *testing.T) {
+
+	placement, err := NewPlacementWithConfig(PlacementConfig{Replicas: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nodes := fakeNodes(10)
+	hash := HashOf([]byte("chunk"))
+
+	targets, err := placement.Place(hash, nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(targets) != 3 {
+		t.Fatalf("Expected 3 targets, got %v", targets)
+	}
+
+	seen := map[string]bool{}
+	for _, id := range targets {
+		if seen[id] {
+			t.Fatalf("Expected distinct targets, got %v", targets)
+		}
+		seen[id] = true
+	}
+
+	// the order of the nodes and duplicates do not change the placement
+	shuffled := append([]string{nodes[3]}, nodes...)
+	for i, j := 0, len(shuffled)-1; i < j; i, j = i+1, j-1 {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	}
+
+	again, err := placement.Place(hash, shuffled)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(targets, again) {
+		t.Fatalf("Expected deterministic placement, got %v and %v", targets, again)
+	}
+}
+
+func TestPlacementNodeLoss(t *testing.T) {
+
+	placement := NewPlacement()
+	nodes := fakeNodes(10)
+	lost := nodes[4]
+
+	remaining := append(append([]string{}, nodes[:4]...), nodes[5:]...)
+
+	for i := 0; i < 200; i++ {
+
+		hash := HashOf([]byte(fmt.Sprintf("chunk-%d", i)))
+
+		before, err := placement.Place(hash, nodes)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		after, err := placement.Place(hash, remaining)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// only the chunks held by the lost node move, and only to one new node
+		moved := 0
+		for _, id := range after {
+			if !contains(before, id) {
+				moved++
+			}
+		}
+
+		if !contains(before, lost) && moved != 0 {
+			t.Fatalf("Chunk %d moved without losing a replica: %v -> %v", i, before, after)
+		}
+
+		if contains(before, lost) && moved != 1 {
+			t.Fatalf("Chunk %d expected 1 new replica, got %v -> %v", i, before, after)
+		}
+	}
+}
+
+func TestPlacementInsufficientNodes(t *testing.T) {
+
+	placement := NewPlacement()
+	hash := HashOf([]byte("chunk"))
+
+	if _, err := placement.Place(hash, fakeNodes(2)); err == nil {
+		t.Fatal("Expected error placing on fewer nodes than the replicas")
+	}
+
+	if _, ok := placement.Check(0).(*InsufficientNodesError); !ok {
+		t.Fatal("Expected InsufficientNodesError without nodes")
+	}
+
+	degraded, err := NewPlacementWithConfig(PlacementConfig{AllowDegraded: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	targets, err := degraded.Place(hash, fakeNodes(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(targets) != 2 || !degraded.Degraded(2) {
+		t.Fatalf("Expected degraded placement on 2 nodes, got %v", targets)
+	}
+
+	if _, err := degraded.Place(hash, nil); err == nil {
+		t.Fatal("Expected error placing without nodes")
+	}
+
+	if _, err := NewPlacementWithConfig(PlacementConfig{Replicas: -1}); err == nil {
+		t.Fatal("Expected error with negative replicas")
+	}
+}
+
+func TestChunkIndex(t *testing.T) {
+
+	index := NewChunkIndex()
+	a, b := HashOf([]byte("a")), HashOf([]byte("b"))
+
+	index.Record(a, "node-01")
+	index.Record(a, "node-00")
+	index.Record(a, "node-00")
+	index.Record(b, "node-01")
+
+	if locations := index.Locations(a); !reflect.DeepEqual(locations, []string{"node-00", "node-01"}) {
+		t.Fatalf("Unexpected locations %v", locations)
+	}
+
+	if under := index.UnderReplicated(2); len(under) != 1 || under[0] != b {
+		t.Fatalf("Expected only b under-replicated, got %v", under)
+	}
+
+	if forgotten := index.Forget("node-01"); len(forgotten) != 2 {
+		t.Fatalf("Expected 2 chunks held by the forgotten node, got %v", forgotten)
+	}
+
+	if len(index.Locations(b)) != 0 || len(index.Chunks("node-01")) != 0 {
+		t.Fatal("Expected the forgotten node to be removed from the index")
+	}
+
+	index.Remove(a, "node-00")
+
+	if len(index.Locations(a)) != 0 {
+		t.Fatal("Expected the removed location to be missing")
+	}
+}
+
+func contains(ids []string, id string) bool {
+
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+)
+
+// MARK: ChunkSource, Reassembler & constructors
+
+// ChunkSource - Defines a generic interface for fetching chunks by their content address
+type ChunkSource interface {
+	// GetChunk - Returns the data of the chunk with the hash passed
+	GetChunk(hash Hash) ([]byte, error)
+}
+
+// Reassembler - Defines the struct rebuilding a file from the chunks described by its Manifest
+type Reassembler struct {
+	manifest *Manifest
+	source   ChunkSource
+}
+
+// NewReassembler - Returns a new instance of Reassembler fetching the chunks from source
+func NewReassembler(manifest *Manifest, source ChunkSource) *Reassembler {
+	return &Reassembler{manifest: manifest, source: source}
+}
+
+// MARK: Reassembler exported
+
+// WriteTo - Writes the file to w verifying every chunk and the whole file hash, implements io.WriterTo.
+// On a verification error the data already written must be discarded
+func (r *Reassembler) WriteTo(w io.Writer) (int64, error) {
+
+	if err := r.manifest.Validate(); err != nil {
+		return 0, err
+	}
+
+	fileHash := sha256.New()
+	out := io.MultiWriter(w, fileHash)
+
+	var written int64
+
+	for _, ref := range r.manifest.Chunks {
+
+		data, err := r.source.GetChunk(ref.Hash)
+		if err != nil {
+			return written, err
+		}
+
+		if int64(len(data)) != ref.Size || HashOf(data) != ref.Hash {
+			return written, NewCorruptedChunkError(ref.Hash)
+		}
+
+		n, err := out.Write(data)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+
+	var sum Hash
+	copy(sum[:], fileHash.Sum(nil))
+
+	if sum != r.manifest.Hash {
+		return written, NewCorruptedFileError(r.manifest.Hash, sum)
+	}
+
+	return written, nil
+}
+
+// MARK: CorruptedChunkError
+
+// CorruptedChunkError - Defines error for a chunk whose data does not match its content address
+type CorruptedChunkError struct {
+	hash Hash
+}
+
+// NewCorruptedChunkError - Returns a new instance of CorruptedChunkError
+func NewCorruptedChunkError(hash Hash) error {
+	return &CorruptedChunkError{hash: hash}
+}
+
+// Error - Implements error interface
+func (e *CorruptedChunkError) Error() string {
+	return fmt.Sprintf("Chunk %s corrupted", e.hash)
+}
+
+// MARK: CorruptedFileError
+
+// CorruptedFileError - Defines error for a reassembled file whose hash differs from the Manifest one
+type CorruptedFileError struct {
+	expected Hash
+	actual   Hash
+}
+
+// NewCorruptedFileError - Returns a new instance of CorruptedFileError
+func NewCorruptedFileError(expected, actual Hash) error {
+	return &CorruptedFileError{expected: expected, actual: actual}
+}
+
+// Error - Implements error interface
+func (e *CorruptedFileError) Error() string {
+	return fmt.Sprintf("File corrupted: expected hash %s, got %s", e.expected, e.actual)
+}
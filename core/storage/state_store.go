@@ -0,0 +1,422 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/IacopoMelani/vortex/utils"
+)
+
+// MARK: consts & vars
+
+const (
+	// name of the state directory in the node data directory
+	StateDirName = "state"
+	// name of the append-only log of the changes since the last snapshot
+	StateLogFileName = "state.log"
+	// name of the snapshot of the whole state
+	StateSnapshotFileName = "state.snapshot"
+
+	// number of records appended to the log triggering a snapshot
+	DefaultStateSnapshotThreshold = 1024
+
+	// a record is framed by its payload length and the CRC-32 checksum of the payload
+	stateRecordHeaderSize = 8
+	// records larger than this are treated as corrupted, no state value is expected to get close
+	stateRecordMaxSize = 64 << 20
+
+	stateOpPut    = "put"
+	stateOpDelete = "delete"
+)
+
+var (
+	// ErrStateStoreClosed - Returned changing a StateStore after Close
+	ErrStateStoreClosed = errors.New("state store closed")
+)
+
+// MARK: StateStore, StateStoreConfig & constructors
+
+// StateStore - Defines a key value store persisting the state of a node in a directory.
+// Every change is appended to a log as a checksummed record, the log is replaced by a snapshot of the whole state
+// every SnapshotThreshold records. Records of the log tail corrupted by a crash are truncated on open
+type StateStore struct {
+	sync.RWMutex
+	dir       string
+	log       *os.File
+	entries   stateEntries
+	records   int
+	threshold int
+	truncated int64
+}
+
+// StateStoreConfig - Defines the config of a StateStore, zero values are replaced by the defaults
+type StateStoreConfig struct {
+	SnapshotThreshold int
+}
+
+// stateRecord - Defines a change of the state, the snapshot is a single record per entry
+type stateRecord struct {
+	Op    string          `json:"op"`
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// OpenStateStore - Returns the StateStore in dir with the default config, see OpenStateStoreWithConfig
+func OpenStateStore(dir string) (*StateStore, error) {
+	return OpenStateStoreWithConfig(dir, StateStoreConfig{})
+}
+
+// OpenStateStoreWithConfig - Returns the StateStore in dir, the directory is created if missing.
+// The state is recovered loading the snapshot and replaying the log, a corrupted log tail is truncated
+func OpenStateStoreWithConfig(dir string, config StateStoreConfig) (*StateStore, error) {
+
+	if config.SnapshotThreshold <= 0 {
+		config.SnapshotThreshold = DefaultStateSnapshotThreshold
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	store := &StateStore{
+		dir:       dir,
+		entries:   make(stateEntries),
+		threshold: config.SnapshotThreshold,
+	}
+
+	if err := store.loadSnapshot(); err != nil {
+		return nil, err
+	}
+
+	if err := store.replayLog(); err != nil {
+		return nil, err
+	}
+
+	log, err := os.OpenFile(filepath.Join(dir, StateLogFileName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	store.log = log
+
+	return store, nil
+}
+
+// MARK: StateStore exported
+
+// Close - Snapshots the state and closes the log, the store can not be used anymore
+func (s *StateStore) Close() error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.log == nil {
+		return nil
+	}
+
+	err := s.snapshotLocked()
+
+	if cerr := s.log.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+
+	s.log = nil
+
+	return err
+}
+
+// Delete - Removes the value stored at key, deleting a missing key is a no-op
+func (s *StateStore) Delete(key string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.entries[key]; !ok {
+		return nil
+	}
+
+	return s.applyLocked(stateRecord{Op: stateOpDelete, Key: key})
+}
+
+// Get - Decodes the value stored at key into value, returns false if the key is missing
+func (s *StateStore) Get(key string, value interface{}) (bool, error) {
+	s.RLock()
+	raw, ok := s.entries[key]
+	s.RUnlock()
+
+	if !ok {
+		return false, nil
+	}
+
+	return true, json.Unmarshal(raw, value)
+}
+
+// Keys - Returns the keys starting with prefix, sorted
+func (s *StateStore) Keys(prefix string) []string {
+	s.RLock()
+	defer s.RUnlock()
+
+	keys := make([]string, 0)
+	for key := range s.entries {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// Put - Stores value encoded as JSON at key, replacing the previous value
+func (s *StateStore) Put(key string, value interface{}) error {
+
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if current, ok := s.entries[key]; ok && bytes.Equal(current, raw) {
+		return nil
+	}
+
+	return s.applyLocked(stateRecord{Op: stateOpPut, Key: key, Value: raw})
+}
+
+// Snapshot - Writes the whole state to the snapshot and empties the log
+func (s *StateStore) Snapshot() error {
+	s.Lock()
+	defer s.Unlock()
+	return s.snapshotLocked()
+}
+
+// Truncated - Returns the bytes of corrupted log tail truncated on open
+func (s *StateStore) Truncated() int64 {
+	s.RLock()
+	defer s.RUnlock()
+	return s.truncated
+}
+
+// MARK: StateStore unexported
+
+// applyLocked - Appends the record to the log and applies it, the lock must be held
+func (s *StateStore) applyLocked(record stateRecord) error {
+
+	if s.log == nil {
+		return ErrStateStoreClosed
+	}
+
+	frame, err := encodeStateRecord(record)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.log.Write(frame); err != nil {
+		return err
+	}
+
+	if err := s.log.Sync(); err != nil {
+		return err
+	}
+
+	s.entries.apply(record)
+	s.records++
+
+	if s.records >= s.threshold {
+		return s.snapshotLocked()
+	}
+
+	return nil
+}
+
+// loadSnapshot - Loads the entries of the snapshot, a missing snapshot is an empty state.
+// The snapshot is replaced atomically, a corrupted one is not truncated but reported
+func (s *StateStore) loadSnapshot() error {
+
+	data, err := os.ReadFile(filepath.Join(s.dir, StateSnapshotFileName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for offset := 0; offset < len(data); {
+
+		record, size, err := decodeStateRecord(data[offset:])
+		if err != nil {
+			return NewCorruptedStateError(StateSnapshotFileName, int64(offset))
+		}
+
+		s.entries.apply(*record)
+		offset += size
+	}
+
+	return nil
+}
+
+// replayLog - Applies the records of the log, the log is truncated at the first corrupted record
+func (s *StateStore) replayLog() error {
+
+	filename := filepath.Join(s.dir, StateLogFileName)
+
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	offset := 0
+	for offset < len(data) {
+
+		record, size, err := decodeStateRecord(data[offset:])
+		if err != nil {
+			break
+		}
+
+		s.entries.apply(*record)
+		s.records++
+		offset += size
+	}
+
+	if offset == len(data) {
+		return nil
+	}
+
+	s.truncated = int64(len(data) - offset)
+
+	return os.Truncate(filename, int64(offset))
+}
+
+// snapshotLocked - Writes the entries to the snapshot and truncates the log, the lock must be held.
+// Replaying a log left by a crash after the snapshot is written applies the same changes again
+func (s *StateStore) snapshotLocked() error {
+
+	if s.log == nil {
+		return ErrStateStoreClosed
+	}
+
+	keys := make([]string, 0, len(s.entries))
+	for key := range s.entries {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	for _, key := range keys {
+
+		frame, err := encodeStateRecord(stateRecord{Op: stateOpPut, Key: key, Value: s.entries[key]})
+		if err != nil {
+			return err
+		}
+
+		buf.Write(frame)
+	}
+
+	if err := utils.WriteFileAtomic(filepath.Join(s.dir, StateSnapshotFileName), buf.Bytes(), 0600); err != nil {
+		return err
+	}
+
+	if err := s.log.Truncate(0); err != nil {
+		return err
+	}
+
+	s.records = 0
+
+	return nil
+}
+
+// MARK: stateEntries
+
+// stateEntries - Defines the values of the state by key, encoded as JSON
+type stateEntries map[string]json.RawMessage
+
+// apply - Applies the change of the record to the entries
+func (e stateEntries) apply(record stateRecord) {
+	switch record.Op {
+	case stateOpPut:
+		e[record.Key] = record.Value
+	case stateOpDelete:
+		delete(e, record.Key)
+	}
+}
+
+// MARK: state records utils unexported
+
+// encodeStateRecord - Returns the record framed by its length and checksum
+func encodeStateRecord(record stateRecord) ([]byte, error) {
+
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+
+	frame := make([]byte, stateRecordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(payload))
+	copy(frame[stateRecordHeaderSize:], payload)
+
+	return frame, nil
+}
+
+// decodeStateRecord - Decodes the first record framed in data, returns the record and the size of its frame.
+// A short frame, a checksum mismatch or an unknown operation are reported as io.ErrUnexpectedEOF
+func decodeStateRecord(data []byte) (*stateRecord, int, error) {
+
+	if len(data) < stateRecordHeaderSize {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+
+	length := binary.BigEndian.Uint32(data[0:4])
+	if length > stateRecordMaxSize || int(length) > len(data)-stateRecordHeaderSize {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+
+	payload := data[stateRecordHeaderSize : stateRecordHeaderSize+int(length)]
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(data[4:8]) {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+
+	var record stateRecord
+	if err := json.Unmarshal(payload, &record); err != nil {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+
+	if record.Op != stateOpPut && record.Op != stateOpDelete {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+
+	return &record, stateRecordHeaderSize + int(length), nil
+}
+
+// MARK: CorruptedStateError
+
+// CorruptedStateError - Defines error for a state file that can not be recovered
+type CorruptedStateError struct {
+	file   string
+	offset int64
+}
+
+// NewCorruptedStateError - Returns a new instance of CorruptedStateError
+func NewCorruptedStateError(file string, offset int64) error {
+	return &CorruptedStateError{file: file, offset: offset}
+}
+
+// Error - Implements error interface
+func (e *CorruptedStateError) Error() string {
+	return fmt.Sprintf("Corrupted state %s at offset %d", e.file, e.offset)
+}
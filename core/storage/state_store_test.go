@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStateStoreRecovery(t *testing.T) {
+
+	dir := t.TempDir()
+
+	store, err := OpenStateStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for key, value := range map[string]string{"neighbor/a": "a", "neighbor/b": "b", "config": "c"} {
+		if err := store.Put(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := store.Delete("neighbor/b"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the log is left as by a crash, without snapshot
+	reopened, err := OpenStateStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if keys := reopened.Keys("neighbor/"); len(keys) != 1 || keys[0] != "neighbor/a" {
+		t.Fatalf("Expected recovered neighbor/a only, got %v", keys)
+	}
+
+	var value string
+	if ok, err := reopened.Get("config", &value); err != nil || !ok || value != "c" {
+		t.Fatalf("Expected recovered config c, got %q %v", value, err)
+	}
+
+	if err := reopened.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := reopened.Put("config", "d"); err != ErrStateStoreClosed {
+		t.Fatalf("Expected ErrStateStoreClosed, got %v", err)
+	}
+
+	// the closed store is recovered from the snapshot alone
+	if info, err := os.Stat(filepath.Join(dir, StateLogFileName)); err != nil || info.Size() != 0 {
+		t.Fatalf("Expected empty log after close, got %v", err)
+	}
+
+	snapshotted, err := OpenStateStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snapshotted.Close()
+
+	if keys := snapshotted.Keys(""); len(keys) != 2 {
+		t.Fatalf("Expected 2 keys recovered from the snapshot, got %v", keys)
+	}
+}
+
+func TestStateStoreTruncatesCorruptedTail(t *testing.T) {
+
+	dir := t.TempDir()
+
+	store, err := OpenStateStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Put("first", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	filename := filepath.Join(dir, StateLogFileName)
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Put("second", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	// flip a byte of the last record and append a torn record
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data[len(data)-3] ^= 0xff
+	data = append(data, 0, 0, 0, 42, 1, 2)
+
+	if err := os.WriteFile(filename, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	recovered, err := OpenStateStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recovered.Close()
+
+	if keys := recovered.Keys(""); len(keys) != 1 || keys[0] != "first" {
+		t.Fatalf("Expected only the first record recovered, got %v", keys)
+	}
+
+	if truncated := recovered.Truncated(); truncated != int64(len(data))-info.Size() {
+		t.Fatalf("Expected %d bytes truncated, got %d", int64(len(data))-info.Size(), truncated)
+	}
+
+	// records appended after the truncation are recovered
+	if err := recovered.Put("third", 3); err != nil {
+		t.Fatal(err)
+	}
+
+	again, err := OpenStateStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer again.Close()
+
+	if keys := again.Keys(""); len(keys) != 2 || again.Truncated() != 0 {
+		t.Fatalf("Expected first and third recovered without truncation, got %v", keys)
+	}
+}
+
+func TestStateStoreSnapshotThreshold(t *testing.T) {
+
+	dir := t.TempDir()
+
+	store, err := OpenStateStoreWithConfig(dir, StateStoreConfig{SnapshotThreshold: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 5; i++ {
+		if err := store.Put("counter", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the fourth record triggered a snapshot, only the fifth one is in the log
+	if store.records != 1 {
+		t.Fatalf("Expected 1 record in the log after the snapshot, got %d", store.records)
+	}
+
+	reopened, err := OpenStateStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	var counter int
+	if ok, err := reopened.Get("counter", &counter); err != nil || !ok || counter != 4 {
+		t.Fatalf("Expected counter 4, got %d %v", counter, err)
+	}
+
+	// a corrupted snapshot is reported, not truncated
+	if err := os.WriteFile(filepath.Join(dir, StateSnapshotFileName), []byte("garbage!garbage!"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenStateStore(dir); err == nil {
+		t.Fatal("Expected error opening a corrupted snapshot")
+	}
+}
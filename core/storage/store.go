@@ -0,0 +1,443 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/IacopoMelani/vortex/utils"
+)
+
+// MARK: consts
+
+const (
+	// name of the chunks directory in the node data directory
+	ChunkStoreDirName = "chunks"
+
+	DefaultChunkStoreCapacity = 10 << 30 // 10 GiB
+)
+
+// MARK: ChunkStore, ChunkStoreUsage & ChunkSourceFunc
+
+// ChunkStore - Defines a generic interface for a store of chunks addressed by their content
+type ChunkStore interface {
+	// Delete - Removes the chunk with the hash passed
+	Delete(hash Hash) error
+	// Get - Returns the data of the chunk with the hash passed, the data is verified against the hash
+	Get(hash Hash) ([]byte, error)
+	// Has - Returns true if the store holds the chunk with the hash passed
+	Has(hash Hash) (bool, error)
+	// List - Returns the hashes of the chunks held by the store, sorted
+	List() ([]Hash, error)
+	// Put - Stores the data of the chunk with the hash passed, storing a chunk twice is a no-op
+	Put(hash Hash, data []byte) error
+	// SetCapacity - Changes the capacity of the store, chunks already stored are kept even above the capacity
+	SetCapacity(capacity int64) error
+	// Usage - Returns the capacity of the store and the bytes used
+	Usage() ChunkStoreUsage
+}
+
+// ChunkStoreUsage - Defines the capacity of a ChunkStore, in bytes
+type ChunkStoreUsage struct {
+	Capacity int64 `json:"capacity"`
+	Used     int64 `json:"used"`
+	Free     int64 `json:"free"`
+}
+
+// ChunkSourceFunc - Defines an adapter to use a function as ChunkSource, e.g. ChunkSourceFunc(store.Get)
+type ChunkSourceFunc func(hash Hash) ([]byte, error)
+
+// GetChunk - Implements ChunkSource interface
+func (f ChunkSourceFunc) GetChunk(hash Hash) ([]byte, error) {
+	return f(hash)
+}
+
+// MARK: DiskChunkStore & constructors
+
+// DiskChunkStore - Defines a ChunkStore persisting every chunk in a file named by its hash.
+// Files are fanned out in two levels of directories named by the first bytes of the hash, e.g. ab/cd/abcd...
+type DiskChunkStore struct {
+	sync.RWMutex
+	dir      string
+	capacity int64
+	used     int64
+}
+
+// OpenDiskChunkStore - Returns the DiskChunkStore in dir, the directory is created if missing.
+// The used space is computed from the chunks already stored
+func OpenDiskChunkStore(dir string, capacity int64) (*DiskChunkStore, error) {
+
+	if capacity <= 0 {
+		return nil, NewInvalidChunkStoreError("capacity must be positive")
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	store := &DiskChunkStore{dir: dir, capacity: capacity}
+
+	err := store.walk(func(_ Hash, info os.FileInfo) {
+		store.used += info.Size()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// MARK: DiskChunkStore ChunkStore implementation
+
+// Delete - Removes the chunk with the hash passed
+func (s *DiskChunkStore) Delete(hash Hash) error {
+	s.Lock()
+	defer s.Unlock()
+
+	info, err := os.Stat(s.path(hash))
+	if os.IsNotExist(err) {
+		return NewChunkNotFoundError(hash)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(s.path(hash)); err != nil {
+		return err
+	}
+
+	s.used -= info.Size()
+
+	return nil
+}
+
+// Get - Returns the data of the chunk with the hash passed, the data is verified against the hash
+func (s *DiskChunkStore) Get(hash Hash) ([]byte, error) {
+
+	data, err := os.ReadFile(s.path(hash))
+	if os.IsNotExist(err) {
+		return nil, NewChunkNotFoundError(hash)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if HashOf(data) != hash {
+		return nil, NewCorruptedChunkError(hash)
+	}
+
+	return data, nil
+}
+
+// Has - Returns true if the store holds the chunk with the hash passed
+func (s *DiskChunkStore) Has(hash Hash) (bool, error) {
+
+	_, err := os.Stat(s.path(hash))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// List - Returns the hashes of the chunks held by the store, sorted
+func (s *DiskChunkStore) List() ([]Hash, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	hashes := make([]Hash, 0)
+
+	err := s.walk(func(hash Hash, _ os.FileInfo) {
+		hashes = append(hashes, hash)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sortHashes(hashes)
+
+	return hashes, nil
+}
+
+// Put - Stores the data of the chunk with the hash passed, the file is written to a temp file and renamed
+func (s *DiskChunkStore) Put(hash Hash, data []byte) error {
+
+	if HashOf(data) != hash {
+		return NewCorruptedChunkError(hash)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	filename := s.path(hash)
+
+	if _, err := os.Stat(filename); err == nil {
+		return nil
+	}
+
+	if s.used+int64(len(data)) > s.capacity {
+		return NewChunkStoreFullError(int64(len(data)), s.capacity-s.used)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return err
+	}
+
+	if err := utils.WriteFileAtomic(filename, data, 0600); err != nil {
+		return err
+	}
+
+	s.used += int64(len(data))
+
+	return nil
+}
+
+// SetCapacity - Changes the capacity of the store, chunks already stored are kept even above the capacity
+func (s *DiskChunkStore) SetCapacity(capacity int64) error {
+
+	if capacity <= 0 {
+		return NewInvalidChunkStoreError("capacity must be positive")
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.capacity = capacity
+
+	return nil
+}
+
+// Usage - Returns the capacity of the store and the bytes used
+func (s *DiskChunkStore) Usage() ChunkStoreUsage {
+	s.RLock()
+	defer s.RUnlock()
+	return newChunkStoreUsage(s.capacity, s.used)
+}
+
+// MARK: DiskChunkStore unexported
+
+// path - Returns the file of the chunk, e.g. <dir>/ab/cd/abcd...
+func (s *DiskChunkStore) path(hash Hash) string {
+	name := hash.String()
+	return filepath.Join(s.dir, name[0:2], name[2:4], name)
+}
+
+// walk - Calls fn for every chunk file in the store, temp files and unknown files are skipped
+func (s *DiskChunkStore) walk(fn func(hash Hash, info os.FileInfo)) error {
+
+	return filepath.Walk(s.dir, func(path string, info os.FileInfo, err error) error {
+
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		hash, err := ParseHash(info.Name())
+		if err != nil {
+			return nil
+		}
+
+		fn(hash, info)
+
+		return nil
+	})
+}
+
+// MARK: MemoryChunkStore & constructors
+
+// MemoryChunkStore - Defines a ChunkStore holding the chunks in memory
+type MemoryChunkStore struct {
+	sync.RWMutex
+	chunks   map[Hash][]byte
+	capacity int64
+	used     int64
+}
+
+// NewMemoryChunkStore - Returns a new instance of MemoryChunkStore
+func NewMemoryChunkStore(capacity int64) *MemoryChunkStore {
+	return &MemoryChunkStore{chunks: make(map[Hash][]byte), capacity: capacity}
+}
+
+// MARK: MemoryChunkStore ChunkStore implementation
+
+// Delete - Removes the chunk with the hash passed
+func (s *MemoryChunkStore) Delete(hash Hash) error {
+	s.Lock()
+	defer s.Unlock()
+
+	data, ok := s.chunks[hash]
+	if !ok {
+		return NewChunkNotFoundError(hash)
+	}
+
+	delete(s.chunks, hash)
+	s.used -= int64(len(data))
+
+	return nil
+}
+
+// Get - Returns the data of the chunk with the hash passed, the data is verified against the hash
+func (s *MemoryChunkStore) Get(hash Hash) ([]byte, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	data, ok := s.chunks[hash]
+	if !ok {
+		return nil, NewChunkNotFoundError(hash)
+	}
+
+	if HashOf(data) != hash {
+		return nil, NewCorruptedChunkError(hash)
+	}
+
+	return append([]byte(nil), data...), nil
+}
+
+// Has - Returns true if the store holds the chunk with the hash passed
+func (s *MemoryChunkStore) Has(hash Hash) (bool, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	_, ok := s.chunks[hash]
+
+	return ok, nil
+}
+
+// List - Returns the hashes of the chunks held by the store, sorted
+func (s *MemoryChunkStore) List() ([]Hash, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	hashes := make([]Hash, 0, len(s.chunks))
+	for hash := range s.chunks {
+		hashes = append(hashes, hash)
+	}
+
+	sortHashes(hashes)
+
+	return hashes, nil
+}
+
+// Put - Stores the data of the chunk with the hash passed, storing a chunk twice is a no-op
+func (s *MemoryChunkStore) Put(hash Hash, data []byte) error {
+
+	if HashOf(data) != hash {
+		return NewCorruptedChunkError(hash)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.chunks[hash]; ok {
+		return nil
+	}
+
+	if s.used+int64(len(data)) > s.capacity {
+		return NewChunkStoreFullError(int64(len(data)), s.capacity-s.used)
+	}
+
+	s.chunks[hash] = append([]byte(nil), data...)
+	s.used += int64(len(data))
+
+	return nil
+}
+
+// SetCapacity - Changes the capacity of the store, chunks already stored are kept even above the capacity
+func (s *MemoryChunkStore) SetCapacity(capacity int64) error {
+
+	if capacity <= 0 {
+		return NewInvalidChunkStoreError("capacity must be positive")
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.capacity = capacity
+
+	return nil
+}
+
+// Usage - Returns the capacity of the store and the bytes used
+func (s *MemoryChunkStore) Usage() ChunkStoreUsage {
+	s.RLock()
+	defer s.RUnlock()
+	return newChunkStoreUsage(s.capacity, s.used)
+}
+
+// MARK: ChunkStore utils unexported
+
+func newChunkStoreUsage(capacity, used int64) ChunkStoreUsage {
+
+	free := capacity - used
+	if free < 0 {
+		free = 0
+	}
+
+	return ChunkStoreUsage{Capacity: capacity, Used: used, Free: free}
+}
+
+func sortHashes(hashes []Hash) {
+	sort.Slice(hashes, func(i, j int) bool {
+		return string(hashes[i][:]) < string(hashes[j][:])
+	})
+}
+
+// MARK: ChunkNotFoundError
+
+// ChunkNotFoundError - Defines error for a chunk missing from a ChunkStore
+type ChunkNotFoundError struct {
+	hash Hash
+}
+
+// NewChunkNotFoundError - Returns a new instance of ChunkNotFoundError
+func NewChunkNotFoundError(hash Hash) error {
+	return &ChunkNotFoundError{hash: hash}
+}
+
+// Error - Implements error interface
+func (e *ChunkNotFoundError) Error() string {
+	return fmt.Sprintf("Chunk %s not found", e.hash)
+}
+
+// MARK: ChunkStoreFullError
+
+// ChunkStoreFullError - Defines error for a chunk exceeding the free space of a ChunkStore
+type ChunkStoreFullError struct {
+	size int64
+	free int64
+}
+
+// NewChunkStoreFullError - Returns a new instance of ChunkStoreFullError
+func NewChunkStoreFullError(size, free int64) error {
+	return &ChunkStoreFullError{size: size, free: free}
+}
+
+// Error - Implements error interface
+func (e *ChunkStoreFullError) Error() string {
+	return fmt.Sprintf("Chunk store full: %d bytes needed, %d free", e.size, e.free)
+}
+
+// MARK: InvalidChunkStoreError
+
+// InvalidChunkStoreError - Defines error for a ChunkStore that can not be opened
+type InvalidChunkStoreError struct {
+	reason string
+}
+
+// NewInvalidChunkStoreError - Returns a new instance of InvalidChunkStoreError
+func NewInvalidChunkStoreError(reason string) error {
+	return &InvalidChunkStoreError{reason: reason}
+}
+
+// Error - Implements error interface
+func (e *InvalidChunkStoreError) Error() string {
+	return fmt.Sprintf("Invalid chunk store: %s", e.reason)
+}
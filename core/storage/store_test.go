@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testChunkStore(t *testing.T, store ChunkStore) {
+
+	a, b := []byte("first chunk"), []byte("second chunk")
+	hashA, hashB := HashOf(a), HashOf(b)
+
+	if err := store.Put(hashA, b); err == nil {
+		t.Fatal("Expected error putting a chunk with a wrong hash")
+	}
+
+	for _, data := range [][]byte{a, b, a} {
+		if err := store.Put(HashOf(data), data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if usage := store.Usage(); usage.Used != int64(len(a)+len(b)) || usage.Free != usage.Capacity-usage.Used {
+		t.Fatalf("Unexpected usage %+v", usage)
+	}
+
+	data, err := store.Get(hashA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, a) {
+		t.Fatal("Expected stored data")
+	}
+
+	hashes, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(hashes) != 2 {
+		t.Fatalf("Expected 2 chunks, got %d", len(hashes))
+	}
+
+	if err := store.Delete(hashB); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := store.Has(hashB); err != nil || ok {
+		t.Fatalf("Expected deleted chunk to be missing, got %v %v", ok, err)
+	}
+
+	if _, err := store.Get(hashB); err == nil {
+		t.Fatal("Expected error getting a deleted chunk")
+	}
+
+	if _, ok := store.Delete(hashB).(*ChunkNotFoundError); !ok {
+		t.Fatal("Expected ChunkNotFoundError deleting a missing chunk")
+	}
+
+	if usage := store.Usage(); usage.Used != int64(len(a)) {
+		t.Fatalf("Unexpected usage after delete %+v", usage)
+	}
+
+	big := make([]byte, store.Usage().Free+1)
+	if _, ok := store.Put(HashOf(big), big).(*ChunkStoreFullError); !ok {
+		t.Fatal("Expected ChunkStoreFullError exceeding the capacity")
+	}
+
+	if err := store.SetCapacity(0); err == nil {
+		t.Fatal("Expected error setting a non positive capacity")
+	}
+
+	capacity := store.Usage().Capacity
+	if err := store.SetCapacity(capacity + int64(len(big))); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Put(HashOf(big), big); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Delete(HashOf(big)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.SetCapacity(capacity); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemoryChunkStore(t *testing.T) {
+	testChunkStore(t, NewMemoryChunkStore(1024))
+}
+
+func TestDiskChunkStore(t *testing.T) {
+
+	dir := t.TempDir()
+
+	store, err := OpenDiskChunkStore(dir, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testChunkStore(t, store)
+
+	data := []byte("persisted chunk")
+	hash := HashOf(data)
+
+	if err := store.Put(hash, data); err != nil {
+		t.Fatal(err)
+	}
+
+	name := hash.String()
+	filename := filepath.Join(dir, name[0:2], name[2:4], name)
+
+	if _, err := os.Stat(filename); err != nil {
+		t.Fatalf("Expected chunk file in the fan-out directory: %v", err)
+	}
+
+	reopened, err := OpenDiskChunkStore(dir, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reopened.Usage() != store.Usage() {
+		t.Fatalf("Expected usage %+v after reopening, got %+v", store.Usage(), reopened.Usage())
+	}
+
+	if err := os.WriteFile(filename, []byte("tampered chunk!"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := reopened.Get(hash); err == nil {
+		t.Fatal("Expected error reading a tampered chunk")
+	}
+
+	if _, err := OpenDiskChunkStore(dir, 0); err == nil {
+		t.Fatal("Expected error opening a store without capacity")
+	}
+}
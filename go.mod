@@ -5,4 +5,5 @@ go 1.16
 require (
 	github.com/fatih/color v1.12.0
 	github.com/google/uuid v1.2.0
+	golang.org/x/crypto v0.0.0-20220214200702-86341886e292
 )
@@ -0,0 +1,216 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// MARK: consts & vars
+
+// defines the rank of the candidate addresses, lower is preferred
+const (
+	ipRankRoutable = iota
+	ipRankVirtual
+	ipRankLinkLocal
+	ipRankLoopback
+)
+
+// virtualInterfacePrefixes - Defines the name prefixes of the interfaces created by container and VM runtimes,
+// their addresses are not reachable from other hosts
+var virtualInterfacePrefixes = []string{"docker", "veth", "br-", "virbr", "cni", "flannel", "vmnet"}
+
+// MARK: PrimaryIPConfig & InterfaceAddrs
+
+// PrimaryIPConfig - Defines the preferences selecting the primary IP, see SelectPrimaryIP.
+// Interface and CIDR restrict the candidates, PreferIPv6 prefers IPv6 addresses on dual-stack hosts
+type PrimaryIPConfig struct {
+	Interface  string
+	CIDR       string
+	PreferIPv6 bool
+}
+
+// InterfaceAddrs - Defines the addresses of an up network interface
+type InterfaceAddrs struct {
+	Name     string
+	Loopback bool
+	IPs      []net.IP
+}
+
+// MARK: primary IP exported
+
+// GetPrimaryIP - Returns primary host IP, see GetPrimaryIPWithConfig
+func GetPrimaryIP() (net.IP, error) {
+	return GetPrimaryIPWithConfig(PrimaryIPConfig{})
+}
+
+// GetPrimaryIPWithConfig - Returns the primary IP of the host selected among the addresses of the local interfaces.
+// No connection is opened, the selection works on hosts without outbound connectivity
+func GetPrimaryIPWithConfig(config PrimaryIPConfig) (net.IP, error) {
+
+	interfaces, err := LocalInterfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	return SelectPrimaryIP(interfaces, config)
+}
+
+// LocalInterfaces - Returns the addresses of the local interfaces that are up, in the order of the system
+func LocalInterfaces() ([]InterfaceAddrs, error) {
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	interfaces := make([]InterfaceAddrs, 0, len(ifaces))
+	for _, iface := range ifaces {
+
+		if iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+
+		ips := make([]net.IP, 0, len(addrs))
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				ips = append(ips, ipNet.IP)
+			}
+		}
+
+		interfaces = append(interfaces, InterfaceAddrs{
+			Name:     iface.Name,
+			Loopback: iface.Flags&net.FlagLoopback != 0,
+			IPs:      ips,
+		})
+	}
+
+	return interfaces, nil
+}
+
+// SelectPrimaryIP - Returns the primary IP among the addresses of the interfaces, the selection is deterministic:
+// routable addresses are preferred to the ones of virtual interfaces, then to link-local and loopback addresses.
+// Within the same rank IPv4 is preferred unless PreferIPv6 is set, then the order of the interfaces is kept.
+// IPv6 link-local addresses are never selected, they can not be reached without a zone
+func SelectPrimaryIP(interfaces []InterfaceAddrs, config PrimaryIPConfig) (net.IP, error) {
+
+	var network *net.IPNet
+	if config.CIDR != "" {
+
+		_, ipNet, err := net.ParseCIDR(config.CIDR)
+		if err != nil {
+			return nil, NewNoPrimaryIPError(fmt.Sprintf("invalid CIDR %s", config.CIDR))
+		}
+
+		network = ipNet
+	}
+
+	type candidate struct {
+		ip   net.IP
+		rank int
+		v6   bool
+	}
+
+	candidates := make([]candidate, 0)
+	for _, iface := range interfaces {
+
+		if config.Interface != "" && iface.Name != config.Interface {
+			continue
+		}
+
+		for _, ip := range iface.IPs {
+
+			if ip.IsLinkLocalUnicast() && ip.To4() == nil || ip.IsUnspecified() || ip.IsMulticast() {
+				continue
+			}
+
+			if network != nil && !network.Contains(ip) {
+				continue
+			}
+
+			candidates = append(candidates, candidate{ip: ip, rank: ipRank(iface, ip), v6: ip.To4() == nil})
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil, NewNoPrimaryIPError(describePrimaryIPConfig(config))
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+
+		if candidates[i].rank != candidates[j].rank {
+			return candidates[i].rank < candidates[j].rank
+		}
+
+		if candidates[i].v6 != candidates[j].v6 {
+			return candidates[i].v6 == config.PreferIPv6
+		}
+
+		return false
+	})
+
+	if ip4 := candidates[0].ip.To4(); ip4 != nil {
+		return ip4, nil
+	}
+
+	return candidates[0].ip, nil
+}
+
+// MARK: primary IP unexported
+
+// ipRank - Returns the rank of the address of the interface, see ipRank*
+func ipRank(iface InterfaceAddrs, ip net.IP) int {
+
+	switch {
+	case iface.Loopback || ip.IsLoopback():
+		return ipRankLoopback
+	case ip.IsLinkLocalUnicast():
+		return ipRankLinkLocal
+	}
+
+	for _, prefix := range virtualInterfacePrefixes {
+		if strings.HasPrefix(iface.Name, prefix) {
+			return ipRankVirtual
+		}
+	}
+
+	return ipRankRoutable
+}
+
+// describePrimaryIPConfig - Returns the preferences restricting the candidates, for error messages
+func describePrimaryIPConfig(config PrimaryIPConfig) string {
+
+	switch {
+	case config.Interface != "" && config.CIDR != "":
+		return fmt.Sprintf("no address of interface %s in %s", config.Interface, config.CIDR)
+	case config.Interface != "":
+		return fmt.Sprintf("no address on interface %s", config.Interface)
+	case config.CIDR != "":
+		return fmt.Sprintf("no address in %s", config.CIDR)
+	}
+
+	return "no address on the local interfaces"
+}
+
+// MARK: NoPrimaryIPError
+
+// NoPrimaryIPError - Defines error for a host without an address matching the preferences
+type NoPrimaryIPError struct {
+	reason string
+}
+
+// NewNoPrimaryIPError - Returns a new instance of NoPrimaryIPError
+func NewNoPrimaryIPError(reason string) error {
+	return &NoPrimaryIPError{reason: reason}
+}
+
+// Error - Implements error interface
+func (e *NoPrimaryIPError) Error() string {
+	return fmt.Sprintf("No primary IP: %s", e.reason)
+}
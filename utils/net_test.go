@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"net"
+	"testing"
+)
+
+func TestGetPrimaryIp(t *testing.T) {
+
+	ip, err := GetPrimaryIP()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ip.String() == "" {
+		t.Fatal("Invalid ip addr")
+	}
+}
+
+func testInterfaces() []InterfaceAddrs {
+	return []InterfaceAddrs{
+		{Name: "lo", Loopback: true, IPs: []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")}},
+		{Name: "docker0", IPs: []net.IP{net.ParseIP("172.17.0.1")}},
+		{Name: "eth0", IPs: []net.IP{net.ParseIP("fe80::1"), net.ParseIP("2001:db8::10"), net.ParseIP("192.168.1.10")}},
+		{Name: "eth1", IPs: []net.IP{net.ParseIP("10.0.0.10"), net.ParseIP("169.254.1.1")}},
+	}
+}
+
+func TestSelectPrimaryIP(t *testing.T) {
+
+	cases := []struct {
+		name       string
+		interfaces []InterfaceAddrs
+		config     PrimaryIPConfig
+		expected   string
+	}{
+		{"routable IPv4 of the first interface", testInterfaces(), PrimaryIPConfig{}, "192.168.1.10"},
+		{"IPv6 preferred on dual-stack", testInterfaces(), PrimaryIPConfig{PreferIPv6: true}, "2001:db8::10"},
+		{"preferred interface", testInterfaces(), PrimaryIPConfig{Interface: "eth1"}, "10.0.0.10"},
+		{"preferred CIDR", testInterfaces(), PrimaryIPConfig{CIDR: "10.0.0.0/8"}, "10.0.0.10"},
+		{"IPv6 CIDR", testInterfaces(), PrimaryIPConfig{CIDR: "2001:db8::/32"}, "2001:db8::10"},
+		{"virtual interface before loopback", testInterfaces()[:2], PrimaryIPConfig{}, "172.17.0.1"},
+		{"loopback as last resort", testInterfaces()[:1], PrimaryIPConfig{}, "127.0.0.1"},
+		{"IPv6-only host", []InterfaceAddrs{{Name: "eth0", IPs: []net.IP{net.ParseIP("fe80::1"), net.ParseIP("2001:db8::10")}}}, PrimaryIPConfig{}, "2001:db8::10"},
+	}
+
+	for _, c := range cases {
+
+		ip, err := SelectPrimaryIP(c.interfaces, c.config)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+
+		if ip.String() != c.expected {
+			t.Fatalf("%s: expected %s, got %s", c.name, c.expected, ip)
+		}
+	}
+
+	for _, config := range []PrimaryIPConfig{{Interface: "wlan0"}, {CIDR: "192.0.2.0/24"}, {CIDR: "invalid"}} {
+		if _, err := SelectPrimaryIP(testInterfaces(), config); err == nil {
+			t.Fatalf("Expected error selecting with %+v", config)
+		}
+	}
+
+	// only an IPv6 link-local address is not reachable
+	if _, err := SelectPrimaryIP([]InterfaceAddrs{{Name: "eth0", IPs: []net.IP{net.ParseIP("fe80::1")}}}, PrimaryIPConfig{}); err == nil {
+		t.Fatal("Expected error selecting an IPv6 link-local address")
+	}
+}
@@ -1,19 +1,41 @@
 package utils
 
 import (
-	"net"
+	"os"
+	"path/filepath"
 )
 
-// GetPrimaryIP - Returns primary host IP
-func GetPrimaryIP() (net.IP, error) {
+// WriteFileAtomic - Writes data to a temp file in the same directory and renames it to filename
+func WriteFileAtomic(filename string, data []byte, perm os.FileMode) error {
 
-	conn, err := net.Dial("udp", "8.8.8.8:80")
+	tmp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".tmp-*")
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	defer conn.Close()
-	localAddr := conn.LocalAddr().(*net.UDPAddr)
+	tmpName := tmp.Name()
 
-	return localAddr.IP, nil
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+
+	if err := os.Chmod(tmpName, perm); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+
+	return os.Rename(tmpName, filename)
 }
@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// byteSizeUnits - Defines the multipliers of the byte size suffixes, binary and decimal
+var byteSizeUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"TiB", 1 << 40},
+	{"GiB", 1 << 30},
+	{"MiB", 1 << 20},
+	{"KiB", 1 << 10},
+	{"TB", 1e12},
+	{"GB", 1e9},
+	{"MB", 1e6},
+	{"KB", 1e3},
+	{"B", 1},
+}
+
+// ParseByteSize - Parses a size in bytes with an optional unit suffix, e.g. 512, 10GiB or 1.5GB.
+// Negative, non-finite and overflowing sizes are invalid
+func ParseByteSize(s string) (int64, error) {
+
+	value := strings.TrimSpace(s)
+	multiplier := int64(1)
+
+	for _, unit := range byteSizeUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	invalid := fmt.Errorf("Invalid byte size %q", s)
+
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil && n >= 0 {
+
+		if n > math.MaxInt64/multiplier {
+			return 0, invalid
+		}
+
+		return n * multiplier, nil
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f < 0 || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, invalid
+	}
+
+	// MaxInt64 is not exact as float64, rounded up to 2^63: sizes from there on overflow
+	size := f * float64(multiplier)
+	if size >= math.MaxInt64 {
+		return 0, invalid
+	}
+
+	return int64(size), nil
+}
+
+// FormatByteSize - Returns the size with the largest binary unit keeping a value of at least 1, e.g. 1.5 GiB
+func FormatByteSize(size int64) string {
+
+	for _, unit := range byteSizeUnits {
+		if strings.HasSuffix(unit.suffix, "iB") && size >= unit.multiplier {
+			return strconv.FormatFloat(float64(size)/float64(unit.multiplier), 'f', 1, 64) + " " + unit.suffix
+		}
+	}
+
+	return strconv.FormatInt(size, 10) + " B"
+}
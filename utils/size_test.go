@@ -0,0 +1,50 @@
+package utils
+
+import "testing"
+
+func TestParseByteSize(t *testing.T) {
+
+	cases := map[string]int64{
+		"512":        512,
+		"10GiB":      10 << 30,
+		"1.5 KiB":    1536,
+		"2MB":        2e6,
+		"7B":         7,
+		"8388607TiB": 8388607 << 40,
+	}
+
+	for s, expected := range cases {
+
+		size, err := ParseByteSize(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if size != expected {
+			t.Fatalf("Expected %d for %s, got %d", expected, s, size)
+		}
+	}
+
+	for _, s := range []string{"", "GiB", "ten", "-1", "-1.5GB", "8388608TiB", "9000000000TiB", "9223372036854775808", "1e30", "9.3e18", "NaN", "NaNGiB", "Inf", "+InfKiB", "-Inf"} {
+		if _, err := ParseByteSize(s); err == nil {
+			t.Fatalf("Expected error parsing %q", s)
+		}
+	}
+}
+
+func TestFormatByteSize(t *testing.T) {
+
+	cases := map[int64]string{
+		0:               "0 B",
+		512:             "512 B",
+		1536:            "1.5 KiB",
+		10 << 30:        "10.0 GiB",
+		3<<40 + 512<<30: "3.5 TiB",
+	}
+
+	for size, expected := range cases {
+		if s := FormatByteSize(size); s != expected {
+			t.Fatalf("Expected %s for %d, got %s", expected, size, s)
+		}
+	}
+}